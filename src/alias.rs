@@ -1,4 +1,5 @@
 use std::path::Path;
+use std::time::SystemTime;
 
 use regex::Regex;
 
@@ -13,9 +14,13 @@ pub struct AliasMapping {
 
 pub fn load_aliases(root: &Path) -> Vec<AliasMapping> {
     let mut aliases = parse_tsconfig(root);
-    let vite = parse_vite_config(root);
+    let mut others = parse_tsconfig_references(root);
+    others.extend(parse_vite_config(root));
+    others.extend(parse_webpack_config(root));
+    others.extend(parse_jest_config(root));
+    others.extend(parse_babel_config(root));
 
-    for va in vite {
+    for va in others {
         if !aliases.iter().any(|a| a.prefix == va.prefix) {
             aliases.push(va);
         }
@@ -83,10 +88,36 @@ fn probe_extensions(base: &str) -> Vec<String> {
     candidates
 }
 
+/// Root-level config files `load_aliases` may read.
+const CONFIG_FILES: &[&str] = &[
+    "tsconfig.json", "tsconfig.app.json", "tsconfig.base.json", "jsconfig.json",
+    "vite.config.ts", "vite.config.js", "vite.config.mts", "vite.config.mjs",
+    "webpack.config.js", "webpack.config.ts", "webpack.config.cjs", "webpack.config.mjs",
+    "jest.config.js", "jest.config.ts", "jest.config.cjs", "jest.config.mjs", "jest.config.json", "package.json",
+    ".babelrc", ".babelrc.json", ".babelrc.js", "babel.config.js", "babel.config.json", "babel.config.cjs",
+];
+
+/// Modification times of the alias config files under `root`, so callers that
+/// cache `load_aliases` can tell when to reload.
+pub fn config_stamps(root: &Path) -> Vec<Option<SystemTime>> {
+    CONFIG_FILES
+        .iter()
+        .map(|name| std::fs::metadata(root.join(name)).and_then(|m| m.modified()).ok())
+        .collect()
+}
+
 // ── tsconfig.json parsing ──
 
+/// The root config `parse_tsconfig` starts from: `tsconfig.json`, or
+/// `jsconfig.json` in JavaScript projects.
+fn root_tsconfig(root: &Path) -> std::path::PathBuf {
+    let tsconfig = root.join("tsconfig.json");
+    let jsconfig = root.join("jsconfig.json");
+    if !tsconfig.is_file() && jsconfig.is_file() { jsconfig } else { tsconfig }
+}
+
 fn parse_tsconfig(root: &Path) -> Vec<AliasMapping> {
-    let tsconfig_path = root.join("tsconfig.json");
+    let tsconfig_path = root_tsconfig(root);
     let (base_url, mut aliases) = parse_tsconfig_file(&tsconfig_path);
 
     if aliases.is_empty() {
@@ -94,7 +125,7 @@ fn parse_tsconfig(root: &Path) -> Vec<AliasMapping> {
             let alt_path = root.join(alt);
             let (alt_base, alt_aliases) = parse_tsconfig_file(&alt_path);
             if !alt_aliases.is_empty() {
-                return finalize_tsconfig_aliases(alt_aliases, alt_base, root);
+                return finalize_tsconfig_aliases(alt_aliases, alt_base, Path::new(""));
             }
             let _ = alt_base;
         }
@@ -102,7 +133,7 @@ fn parse_tsconfig(root: &Path) -> Vec<AliasMapping> {
 
     let content = match read_text_file(&tsconfig_path) {
         Some(c) => c,
-        None => return finalize_tsconfig_aliases(aliases, base_url, root),
+        None => return finalize_tsconfig_aliases(aliases, base_url, Path::new("")),
     };
 
     if let Some(extends_path) = extract_extends(&content) {
@@ -113,16 +144,19 @@ fn parse_tsconfig(root: &Path) -> Vec<AliasMapping> {
             aliases = parent_aliases;
         }
         let base_url = base_url.or(parent_base);
-        return finalize_tsconfig_aliases(aliases, base_url, root);
+        return finalize_tsconfig_aliases(aliases, base_url, Path::new(""));
     }
 
-    finalize_tsconfig_aliases(aliases, base_url, root)
+    finalize_tsconfig_aliases(aliases, base_url, Path::new(""))
 }
 
+/// Makes targets relative to the project root. `dir` is the directory of
+/// the config, relative to the root as well, so targets match scanned paths
+/// wherever the root is.
 fn finalize_tsconfig_aliases(
     aliases: Vec<AliasMapping>,
     base_url: Option<String>,
-    root: &Path,
+    dir: &Path,
 ) -> Vec<AliasMapping> {
     if aliases.is_empty() {
         return Vec::new();
@@ -130,10 +164,10 @@ fn finalize_tsconfig_aliases(
 
     let base_dir = match base_url {
         Some(ref bu) => {
-            let p = root.join(bu);
+            let p = dir.join(bu);
             normalize_path(&p)
         }
-        None => normalize_path(root),
+        None => normalize_path(dir),
     };
 
     aliases
@@ -152,6 +186,28 @@ fn finalize_tsconfig_aliases(
         .collect()
 }
 
+/// `paths` of the projects the root config lists under `references`, each
+/// relative to its own directory.
+fn parse_tsconfig_references(root: &Path) -> Vec<AliasMapping> {
+    let content = match read_text_file(&root_tsconfig(root)) {
+        Some(c) => c,
+        None => return Vec::new(),
+    };
+
+    let mut aliases = Vec::new();
+    for reference in extract_references(&strip_json_comments(&content)) {
+        let reference = reference.trim_end_matches('/');
+        let (dir, config) = if reference.ends_with(".json") {
+            (reference.rsplit_once('/').map_or(".", |(d, _)| d).to_owned(), reference.to_owned())
+        } else {
+            (reference.to_owned(), format!("{}/tsconfig.json", reference))
+        };
+        let (base_url, project_aliases) = parse_tsconfig_file(&root.join(config));
+        aliases.extend(finalize_tsconfig_aliases(project_aliases, base_url, Path::new(&dir)));
+    }
+    aliases
+}
+
 fn parse_tsconfig_file(path: &Path) -> (Option<String>, Vec<AliasMapping>) {
     let content = match read_text_file(path) {
         Some(c) => c,
@@ -243,6 +299,21 @@ fn extract_extends(content: &str) -> Option<String> {
     re.captures(content).map(|c| c[1].to_owned())
 }
 
+fn extract_references(content: &str) -> Vec<String> {
+    let re = match Regex::new(r#""references"\s*:\s*\[([^\]]*)\]"#) {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
+    };
+    let path_re = match Regex::new(r#""path"\s*:\s*"([^"]+)""#) {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
+    };
+    match re.captures(content) {
+        Some(cap) => path_re.captures_iter(&cap[1]).map(|c| c[1].to_owned()).collect(),
+        None => Vec::new(),
+    }
+}
+
 fn extract_paths(content: &str) -> Vec<AliasMapping> {
     let paths_re = match Regex::new(r#""paths"\s*:\s*\{"#) {
         Ok(r) => r,
@@ -408,6 +479,209 @@ fn normalize_vite_target(raw: &str) -> String {
     }
 }
 
+// ── webpack config parsing ──
+
+fn parse_webpack_config(root: &Path) -> Vec<AliasMapping> {
+    let candidates = ["webpack.config.js", "webpack.config.ts", "webpack.config.cjs", "webpack.config.mjs"];
+    for name in &candidates {
+        let content = match read_text_file(&root.join(name)) {
+            Some(c) => c,
+            None => continue,
+        };
+        let aliases = extract_webpack_aliases(&content);
+        if !aliases.is_empty() {
+            return aliases;
+        }
+    }
+    Vec::new()
+}
+
+/// Entries of every `alias: { ... }` object. Keys ending in `$` match the
+/// specifier exactly and cannot become prefix rules.
+fn extract_webpack_aliases(content: &str) -> Vec<AliasMapping> {
+    let re = match Regex::new(r#"\balias\s*:\s*\{"#) {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
+    };
+
+    let mut aliases = Vec::new();
+    for m in re.find_iter(content) {
+        if let Some(block) = extract_brace_block(content, m.end() - 1) {
+            aliases.extend(extract_object_aliases(&block));
+        }
+    }
+    aliases
+}
+
+/// `key: 'dir'` and `key: path.resolve(__dirname, 'dir')` entries of an
+/// alias object whose key is quoted or a plain identifier. Values that name
+/// a package rather than a path are skipped.
+fn extract_object_aliases(block: &str) -> Vec<AliasMapping> {
+    let entry_re = match Regex::new(
+        r#"(?:['"]([^'"]+)['"]|([A-Za-z_$][\w$]*))\s*:\s*(?:path\.(?:resolve|join)\s*\(([^)]*)\)|['"]([^'"]+)['"])"#
+    ) {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
+    };
+    let string_re = match Regex::new(r#"['"]([^'"]*)['"]"#) {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
+    };
+
+    let mut aliases = Vec::new();
+    for cap in entry_re.captures_iter(block) {
+        let key = cap.get(1).or_else(|| cap.get(2)).map_or("", |m| m.as_str());
+        if key.is_empty() || key.ends_with('$') {
+            continue;
+        }
+        let target = match (cap.get(3), cap.get(4)) {
+            (Some(args), _) => string_re
+                .captures_iter(args.as_str())
+                .map(|c| c[1].to_owned())
+                .collect::<Vec<_>>()
+                .join("/"),
+            (None, Some(value)) if value.as_str().starts_with('.') || value.as_str().starts_with('/') => value.as_str().to_owned(),
+            _ => continue,
+        };
+        let prefix = if key.ends_with('/') { key.to_owned() } else { format!("{}/", key) };
+        aliases.push(AliasMapping { prefix, targets: vec![normalize_vite_target(&target)] });
+    }
+    aliases
+}
+
+// ── Jest config parsing ──
+
+fn parse_jest_config(root: &Path) -> Vec<AliasMapping> {
+    let candidates = ["jest.config.js", "jest.config.ts", "jest.config.cjs", "jest.config.mjs", "jest.config.json", "package.json"];
+    for name in &candidates {
+        let content = match read_text_file(&root.join(name)) {
+            Some(c) => c,
+            None => continue,
+        };
+        let aliases = extract_jest_mappers(&content);
+        if !aliases.is_empty() {
+            return aliases;
+        }
+    }
+    Vec::new()
+}
+
+/// `moduleNameMapper` entries shaped like `'^@/(.*)$': '<rootDir>/src/$1'`,
+/// which are prefix rules in disguise. Other patterns are skipped.
+fn extract_jest_mappers(content: &str) -> Vec<AliasMapping> {
+    let re = match Regex::new(r#"moduleNameMapper['"]?\s*:\s*\{"#) {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
+    };
+    let start = match re.find(content) {
+        Some(m) => m.end() - 1,
+        None => return Vec::new(),
+    };
+    let block = match extract_brace_block(content, start) {
+        Some(b) => b,
+        None => return Vec::new(),
+    };
+    let entry_re = match Regex::new(r#"['"]([^'"]+)['"]\s*:\s*(\[[^\]]*\]|'[^']*'|"[^"]*")"#) {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
+    };
+    let string_re = match Regex::new(r#"['"]([^'"]*)['"]"#) {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
+    };
+
+    let mut aliases = Vec::new();
+    for cap in entry_re.captures_iter(&block) {
+        let prefix = match jest_mapper_prefix(&cap[1]) {
+            Some(p) => p,
+            None => continue,
+        };
+        let targets: Vec<String> = string_re
+            .captures_iter(&cap[2])
+            .filter_map(|c| jest_mapper_target(&c[1]))
+            .collect();
+        if !targets.is_empty() {
+            aliases.push(AliasMapping { prefix, targets });
+        }
+    }
+    aliases
+}
+
+/// The literal prefix of an anchored pattern ending in `(.*)` or `(.+)`.
+/// Escaped characters are taken literally; any other regex syntax means
+/// the pattern is not a prefix rule.
+fn jest_mapper_prefix(pattern: &str) -> Option<String> {
+    let pattern = pattern.strip_prefix('^')?;
+    let pattern = pattern.strip_suffix('$').unwrap_or(pattern);
+    let literal = pattern.strip_suffix("(.*)").or_else(|| pattern.strip_suffix("(.+)"))?;
+
+    let mut prefix = String::new();
+    let mut chars = literal.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '\\' => {
+                while chars.peek() == Some(&'\\') {
+                    chars.next();
+                }
+                prefix.push(chars.next()?);
+            }
+            '.' | '*' | '+' | '?' | '(' | ')' | '[' | ']' | '{' | '}' | '|' | '^' | '$' => return None,
+            c => prefix.push(c),
+        }
+    }
+    if prefix.is_empty() { None } else { Some(prefix) }
+}
+
+/// `<rootDir>/src/$1` is `src/`; targets that do not end in the captured
+/// remainder are not prefix rules.
+fn jest_mapper_target(value: &str) -> Option<String> {
+    let value = value.strip_prefix("<rootDir>").unwrap_or(value);
+    let target = value.strip_suffix("$1")?;
+    Some(target.trim_start_matches('/').trim_start_matches("./").to_owned())
+}
+
+// ── Babel config parsing ──
+
+fn parse_babel_config(root: &Path) -> Vec<AliasMapping> {
+    let candidates = [".babelrc", ".babelrc.json", ".babelrc.js", "babel.config.js", "babel.config.json", "babel.config.cjs"];
+    for name in &candidates {
+        let content = match read_text_file(&root.join(name)) {
+            Some(c) => c,
+            None => continue,
+        };
+        let aliases = extract_module_resolver(&content);
+        if !aliases.is_empty() {
+            return aliases;
+        }
+    }
+    Vec::new()
+}
+
+/// The `alias` and `root` options of `babel-plugin-module-resolver`. A root
+/// directory lets any specifier resolve beneath it.
+fn extract_module_resolver(content: &str) -> Vec<AliasMapping> {
+    let options = match content.find("module-resolver") {
+        Some(i) => &content[i..],
+        None => return Vec::new(),
+    };
+
+    let mut aliases = Vec::new();
+    if let Ok(alias_re) = Regex::new(r#"alias['"]?\s*:\s*\{"#) {
+        if let Some(block) = alias_re.find(options).and_then(|m| extract_brace_block(options, m.end() - 1)) {
+            aliases.extend(extract_object_aliases(&block));
+        }
+    }
+    if let (Ok(root_re), Ok(string_re)) = (Regex::new(r#"root['"]?\s*:\s*\[([^\]]*)\]"#), Regex::new(r#"['"]([^'"]*)['"]"#)) {
+        if let Some(cap) = root_re.captures(options) {
+            let targets: Vec<String> = string_re.captures_iter(&cap[1]).map(|c| normalize_vite_target(&c[1])).collect();
+            if !targets.is_empty() {
+                aliases.push(AliasMapping { prefix: String::new(), targets });
+            }
+        }
+    }
+    aliases
+}
+
 // ── Helpers ──
 
 fn read_text_file(path: &Path) -> Option<String> {
@@ -668,6 +942,19 @@ mod tests {
         assert_eq!(extract_extends(content), None);
     }
 
+    // ── extract_references ──
+
+    #[test]
+    fn references_extracted() {
+        let content = r#"{ "files": [], "references": [ { "path": "./packages/app" }, { "path": "tools/tsconfig.build.json", "prepend": false } ] }"#;
+        assert_eq!(extract_references(content), vec!["./packages/app", "tools/tsconfig.build.json"]);
+    }
+
+    #[test]
+    fn references_absent() {
+        assert!(extract_references(r#"{ "compilerOptions": {} }"#).is_empty());
+    }
+
     // ── extract_brace_block ──
 
     #[test]
@@ -763,4 +1050,92 @@ export default {
     fn normalize_target_trailing_slash() {
         assert_eq!(normalize_vite_target("src/"), "src/");
     }
+
+    // ── webpack alias extraction ──
+
+    #[test]
+    fn webpack_resolve_alias() {
+        let content = r#"
+module.exports = {
+  resolve: {
+    alias: {
+      Utilities: path.resolve(__dirname, 'src/utilities/'),
+      '@components': path.join(__dirname, 'src', 'components'),
+      "~styles": './assets/styles',
+      react: 'preact/compat',
+      vue$: 'vue/dist/vue.esm.js',
+      config$: path.resolve(__dirname, 'src/config.js'),
+    },
+  },
+};
+"#;
+        let aliases = extract_webpack_aliases(content);
+        let pairs: Vec<(&str, &str)> = aliases.iter().map(|a| (a.prefix.as_str(), a.targets[0].as_str())).collect();
+        assert_eq!(pairs, vec![
+            ("Utilities/", "src/utilities/"),
+            ("@components/", "src/components/"),
+            ("~styles/", "assets/styles/"),
+        ]);
+    }
+
+    // ── Jest moduleNameMapper extraction ──
+
+    #[test]
+    fn jest_prefix_mappers() {
+        let content = r#"
+module.exports = {
+  moduleNameMapper: {
+    '^@/(.*)$': '<rootDir>/src/$1',
+    '^@shared\\/(.*)$': ['<rootDir>/libs/shared/$1', '<rootDir>/vendor/shared/$1'],
+    '^~(.+)': '<rootDir>/src$1',
+    '\\.(css|less)$': 'identity-obj-proxy',
+    '^lodash-es$': 'lodash',
+    '^app.config/(.*)$': '<rootDir>/config/$1',
+  },
+};
+"#;
+        let aliases = extract_jest_mappers(content);
+        let rules: Vec<(&str, Vec<&str>)> = aliases.iter().map(|a| (a.prefix.as_str(), a.targets.iter().map(String::as_str).collect())).collect();
+        assert_eq!(rules, vec![
+            ("@/", vec!["src/"]),
+            ("@shared/", vec!["libs/shared/", "vendor/shared/"]),
+            ("~", vec!["src"]),
+        ]);
+    }
+
+    #[test]
+    fn jest_mappers_in_package_json() {
+        let content = r#"{ "name": "web", "jest": { "moduleNameMapper": { "^components/(.*)$": "<rootDir>/src/components/$1" } } }"#;
+        let aliases = extract_jest_mappers(content);
+        assert_eq!(aliases.len(), 1);
+        assert_eq!(aliases[0].prefix, "components/");
+        assert_eq!(aliases[0].targets, vec!["src/components/"]);
+    }
+
+    #[test]
+    fn jest_mapper_prefix_rejects_regex_syntax() {
+        assert_eq!(jest_mapper_prefix("^@app\\.io/(.*)$").as_deref(), Some("@app.io/"));
+        assert_eq!(jest_mapper_prefix("^@app.io/(.*)$"), None);
+        assert_eq!(jest_mapper_prefix("@/(.*)$"), None);
+        assert_eq!(jest_mapper_prefix("^(.*)$"), None);
+    }
+
+    // ── Babel module-resolver extraction ──
+
+    #[test]
+    fn babel_module_resolver_alias_and_root() {
+        let content = r#"{
+  "presets": ["@babel/preset-env"],
+  "plugins": [
+    ["module-resolver", {
+      "root": ["./src"],
+      "alias": { "@components": "./src/components", "underscore": "lodash" }
+    }]
+  ]
+}"#;
+        let aliases = extract_module_resolver(content);
+        let pairs: Vec<(&str, &str)> = aliases.iter().map(|a| (a.prefix.as_str(), a.targets[0].as_str())).collect();
+        assert_eq!(pairs, vec![("@components/", "src/components/"), ("", "src/")]);
+        assert!(extract_module_resolver(r#"{ "alias": { "@x": "./x" } }"#).is_empty());
+    }
 }
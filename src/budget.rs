@@ -0,0 +1,434 @@
+use std::collections::HashSet;
+use std::path::Path;
+
+use crate::models::{BudgetReport, FileChunk, FileEntry, SymbolFile};
+
+/// Approximate cost of a record's keys, path and punctuation, on top of the
+/// estimated content.
+const ENTRY_OVERHEAD: usize = 8;
+const ITEM_OVERHEAD: usize = 4;
+
+/// Longest vocabulary entry tried during greedy matching.
+const MAX_TOKEN_BYTES: usize = 64;
+
+/// Estimates how many model tokens a piece of text costs.
+pub trait TokenEstimator: Sync {
+    fn estimate(&self, text: &str) -> usize;
+}
+
+/// The default heuristic: about four bytes per token, which holds up well for
+/// source code and English prose.
+pub struct ByteEstimator;
+
+impl TokenEstimator for ByteEstimator {
+    fn estimate(&self, text: &str) -> usize {
+        (text.len() + 3) / 4
+    }
+}
+
+/// Counts tokens by greedy longest match against a BPE vocabulary in
+/// tiktoken format (`<base64 token> <rank>` per line). This does not replay
+/// the merge order, so it can differ slightly from the real tokenizer, but it
+/// tracks it far more closely than the byte heuristic.
+pub struct VocabEstimator {
+    tokens: HashSet<Vec<u8>>,
+    max_len: usize,
+}
+
+impl VocabEstimator {
+    pub fn load(path: &Path) -> Result<Self, String> {
+        let text = std::fs::read_to_string(path)
+            .map_err(|e| format!("Failed to read tokenizer vocabulary {}: {}", path.display(), e))?;
+        Self::parse(&text).map_err(|e| format!("Invalid tokenizer vocabulary {}: {}", path.display(), e))
+    }
+
+    fn parse(text: &str) -> Result<Self, String> {
+        let mut tokens = HashSet::new();
+        let mut max_len = 0;
+        for (n, line) in text.lines().enumerate() {
+            let line = line.trim();
+            if line.is_empty() {
+                continue;
+            }
+            let encoded = line.split_whitespace().next().unwrap_or("");
+            let token = decode_base64(encoded).ok_or_else(|| format!("line {} is not base64", n + 1))?;
+            if token.is_empty() {
+                continue;
+            }
+            max_len = max_len.max(token.len());
+            tokens.insert(token);
+        }
+        if tokens.is_empty() {
+            return Err("no tokens".into());
+        }
+        Ok(Self { tokens, max_len: max_len.min(MAX_TOKEN_BYTES) })
+    }
+}
+
+impl TokenEstimator for VocabEstimator {
+    fn estimate(&self, text: &str) -> usize {
+        let bytes = text.as_bytes();
+        let mut count = 0;
+        let mut i = 0;
+        while i < bytes.len() {
+            let longest = self.max_len.min(bytes.len() - i);
+            let step = (1..=longest)
+                .rev()
+                .find(|&len| self.tokens.contains(&bytes[i..i + len]))
+                .unwrap_or(1);
+            i += step;
+            count += 1;
+        }
+        count
+    }
+}
+
+fn decode_base64(s: &str) -> Option<Vec<u8>> {
+    fn value(c: u8) -> Option<u32> {
+        match c {
+            b'A'..=b'Z' => Some((c - b'A') as u32),
+            b'a'..=b'z' => Some((c - b'a' + 26) as u32),
+            b'0'..=b'9' => Some((c - b'0' + 52) as u32),
+            b'+' | b'-' => Some(62),
+            b'/' | b'_' => Some(63),
+            _ => None,
+        }
+    }
+
+    let data = s.trim_end_matches('=').as_bytes();
+    let mut out = Vec::with_capacity(data.len() * 3 / 4);
+    let mut acc = 0u32;
+    let mut bits = 0;
+    for &c in data {
+        acc = (acc << 6) | value(c)?;
+        bits += 6;
+        if bits >= 8 {
+            bits -= 8;
+            out.push((acc >> bits) as u8);
+            acc &= (1 << bits) - 1;
+        }
+    }
+    Some(out)
+}
+
+/// Picks the estimator for `--tokenizer`, or the byte heuristic without one.
+pub fn estimator(tokenizer: Option<&str>) -> Result<Box<dyn TokenEstimator>, String> {
+    match tokenizer {
+        Some(path) => Ok(Box::new(VocabEstimator::load(Path::new(path))?)),
+        None => Ok(Box::new(ByteEstimator)),
+    }
+}
+
+/// Fills a token budget greedily, in output order. An entry that does not fit
+/// whole is cut at a line (or symbol) boundary; one that cannot fit even a
+/// single line is dropped.
+pub struct Budget<'a> {
+    max: usize,
+    used: usize,
+    estimator: &'a dyn TokenEstimator,
+    dropped: Vec<String>,
+    partial: Vec<String>,
+}
+
+impl<'a> Budget<'a> {
+    pub fn new(max: usize, estimator: &'a dyn TokenEstimator) -> Self {
+        Self { max, used: 0, estimator, dropped: Vec::new(), partial: Vec::new() }
+    }
+
+    fn remaining(&self) -> usize {
+        self.max.saturating_sub(self.used)
+    }
+
+    pub fn fit_files(&mut self, entries: Vec<FileEntry>) -> Vec<FileEntry> {
+        entries.into_iter().filter_map(|e| self.fit_file(e)).collect()
+    }
+
+    fn fit_file(&mut self, mut entry: FileEntry) -> Option<FileEntry> {
+        let header = self.estimator.estimate(&entry.path) + ENTRY_OVERHEAD;
+        if let Some(ref error) = entry.error {
+            return self.take_whole(entry.path.clone(), header + self.estimator.estimate(error)).then_some(entry);
+        }
+        if header > self.remaining() {
+            self.dropped.push(entry.path);
+            return None;
+        }
+
+        let available = self.remaining() - header;
+        let (cost, cut) = if let Some(contents) = entry.contents.take() {
+            let (kept, cost, cut) = self.fit_lines(contents, available);
+            entry.contents = Some(kept);
+            (cost, cut)
+        } else if let Some(chunks) = entry.chunks.take() {
+            let (kept, cost, cut) = self.fit_chunks(chunks, available);
+            entry.chunks = Some(kept);
+            (cost, cut)
+        } else {
+            (0, false)
+        };
+
+        let empty = entry.contents.as_deref() == Some("") || entry.chunks.as_ref().map_or(false, |c| c.is_empty());
+        if cut && empty {
+            self.dropped.push(entry.path);
+            return None;
+        }
+        if cut {
+            self.partial.push(entry.path.clone());
+        }
+        self.used += header + cost;
+        Some(entry)
+    }
+
+    fn take_whole(&mut self, path: String, cost: usize) -> bool {
+        if cost > self.remaining() {
+            self.dropped.push(path);
+            return false;
+        }
+        self.used += cost;
+        true
+    }
+
+    /// Returns the longest line prefix of `text` within `available`, its
+    /// cost, and whether anything was cut.
+    fn fit_lines(&self, text: String, available: usize) -> (String, usize, bool) {
+        let whole = self.estimator.estimate(&text);
+        if whole <= available {
+            return (text, whole, false);
+        }
+        let mut kept = String::new();
+        let mut cost = 0;
+        for line in text.split_inclusive('\n') {
+            let line_cost = self.estimator.estimate(line);
+            if cost + line_cost > available {
+                break;
+            }
+            cost += line_cost;
+            kept.push_str(line);
+        }
+        (kept, cost, true)
+    }
+
+    fn fit_chunks(&self, chunks: Vec<FileChunk>, available: usize) -> (Vec<FileChunk>, usize, bool) {
+        let mut kept = Vec::new();
+        let mut cost = 0;
+        for mut chunk in chunks {
+            if cost + ITEM_OVERHEAD > available {
+                return (kept, cost, true);
+            }
+            let left = available - cost - ITEM_OVERHEAD;
+            let (content, content_cost, cut) = self.fit_lines(std::mem::take(&mut chunk.content), left);
+            chunk.content = content;
+            if cut {
+                let lines = chunk.content.lines().count();
+                if lines > 0 {
+                    chunk.end_line = chunk.start_line + lines - 1;
+                    kept.push(chunk);
+                    cost += content_cost + ITEM_OVERHEAD;
+                }
+                return (kept, cost, true);
+            }
+            cost += content_cost + ITEM_OVERHEAD;
+            kept.push(chunk);
+        }
+        (kept, cost, false)
+    }
+
+    /// Keeps leading symbols of each file while they fit. Compact output
+    /// omits signatures, so they are not charged for.
+    pub fn fit_symbols(&mut self, files: Vec<SymbolFile>, compact: bool) -> Vec<SymbolFile> {
+        files.into_iter().filter_map(|sf| self.fit_symbol_file(sf, compact)).collect()
+    }
+
+    fn fit_symbol_file(&mut self, mut sf: SymbolFile, compact: bool) -> Option<SymbolFile> {
+        let header = self.estimator.estimate(&sf.path) + ENTRY_OVERHEAD;
+        if let Some(ref error) = sf.error {
+            return self.take_whole(sf.path.clone(), header + self.estimator.estimate(error)).then_some(sf);
+        }
+        if header > self.remaining() {
+            self.dropped.push(sf.path);
+            return None;
+        }
+
+        let available = self.remaining() - header;
+        let mut cost = 0;
+        let mut keep = 0;
+        for sym in &sf.symbols {
+            let mut sym_cost = ITEM_OVERHEAD + self.estimator.estimate(sym.kind) + self.estimator.estimate(&sym.name);
+            if !compact {
+                sym_cost += self.estimator.estimate(&sym.signature);
+            }
+            if let Some(ref comment) = sym.comment {
+                sym_cost += self.estimator.estimate(comment);
+            }
+            if cost + sym_cost > available {
+                break;
+            }
+            cost += sym_cost;
+            keep += 1;
+        }
+
+        if keep == 0 && !sf.symbols.is_empty() {
+            self.dropped.push(sf.path);
+            return None;
+        }
+        if keep < sf.symbols.len() {
+            sf.symbols.truncate(keep);
+            self.partial.push(sf.path.clone());
+        }
+        self.used += header + cost;
+        Some(sf)
+    }
+
+    pub fn report(self) -> BudgetReport {
+        BudgetReport {
+            max_tokens: self.max,
+            used_tokens: self.used,
+            truncated: !self.dropped.is_empty() || !self.partial.is_empty(),
+            dropped: self.dropped,
+            partial: self.partial,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::models::SymbolInfo;
+
+    /// One token per character, so budgets in tests are easy to reason about.
+    struct CharEstimator;
+
+    impl TokenEstimator for CharEstimator {
+        fn estimate(&self, text: &str) -> usize {
+            text.chars().count()
+        }
+    }
+
+    fn file(path: &str, contents: &str) -> FileEntry {
+        FileEntry { path: path.into(), contents: Some(contents.into()), error: None, chunks: None }
+    }
+
+    fn symbol(name: &str) -> SymbolInfo {
+        SymbolInfo {
+            kind: "fn",
+            name: name.into(),
+            line: 1,
+            end_line: 1,
+            signature: format!("fn {}()", name),
+            visibility: None,
+            parent: None,
+            comment: None,
+        }
+    }
+
+    // ── estimators ──
+
+    #[test]
+    fn byte_estimator_rounds_up() {
+        assert_eq!(ByteEstimator.estimate(""), 0);
+        assert_eq!(ByteEstimator.estimate("abc"), 1);
+        assert_eq!(ByteEstimator.estimate("abcde"), 2);
+    }
+
+    #[test]
+    fn vocab_estimator_prefers_longest_match() {
+        // "fn", " main", "()" in tiktoken format.
+        let vocab = VocabEstimator::parse("Zm4= 0\nIG1haW4= 1\nKCk= 2\n").unwrap();
+        assert_eq!(vocab.estimate("fn main()"), 3);
+        assert_eq!(vocab.estimate("fn x"), 3);
+    }
+
+    #[test]
+    fn vocab_estimator_rejects_garbage() {
+        assert!(VocabEstimator::parse("not*base64 0\n").is_err());
+        assert!(VocabEstimator::parse("\n\n").is_err());
+    }
+
+    #[test]
+    fn decode_base64_handles_padding() {
+        assert_eq!(decode_base64("aGk=").unwrap(), b"hi");
+        assert_eq!(decode_base64("aGVsbG8").unwrap(), b"hello");
+    }
+
+    // ── files ──
+
+    #[test]
+    fn files_within_budget_are_untouched() {
+        let mut budget = Budget::new(1000, &CharEstimator);
+        let kept = budget.fit_files(vec![file("a.rs", "one\ntwo\n")]);
+        assert_eq!(kept[0].contents.as_deref(), Some("one\ntwo\n"));
+        let report = budget.report();
+        assert!(!report.truncated);
+        assert!(report.used_tokens > 0 && report.used_tokens <= 1000);
+    }
+
+    #[test]
+    fn contents_cut_at_line_boundary() {
+        let header = "a.rs".len() + ENTRY_OVERHEAD;
+        let mut budget = Budget::new(header + 9, &CharEstimator);
+        let kept = budget.fit_files(vec![file("a.rs", "one\ntwo\nthree\n"), file("b.rs", "x\n")]);
+        assert_eq!(kept.len(), 1);
+        assert_eq!(kept[0].contents.as_deref(), Some("one\ntwo\n"));
+        let report = budget.report();
+        assert!(report.truncated);
+        assert_eq!(report.partial, vec!["a.rs"]);
+        assert_eq!(report.dropped, vec!["b.rs"]);
+    }
+
+    #[test]
+    fn later_small_files_still_fill_the_budget() {
+        let header = "a.rs".len() + ENTRY_OVERHEAD;
+        let mut budget = Budget::new(header + 2 + header + 2, &CharEstimator);
+        let big = "x".repeat(100) + "\n";
+        let kept = budget.fit_files(vec![file("a.rs", "a\n"), file("b.rs", &big), file("c.rs", "c\n")]);
+        let paths: Vec<&str> = kept.iter().map(|e| e.path.as_str()).collect();
+        assert_eq!(paths, vec!["a.rs", "c.rs"]);
+        assert_eq!(budget.report().dropped, vec!["b.rs"]);
+    }
+
+    #[test]
+    fn chunks_cut_and_end_line_adjusted() {
+        let entry = FileEntry {
+            path: "a.rs".into(),
+            contents: None,
+            error: None,
+            chunks: Some(vec![
+                FileChunk { start_line: 1, end_line: 2, content: "1. a\n2. b\n".into() },
+                FileChunk { start_line: 10, end_line: 12, content: "10. x\n11. y\n12. z\n".into() },
+            ]),
+        };
+        let header = "a.rs".len() + ENTRY_OVERHEAD;
+        let mut budget = Budget::new(header + ITEM_OVERHEAD + 10 + ITEM_OVERHEAD + 6, &CharEstimator);
+        let kept = budget.fit_files(vec![entry]);
+        let chunks = kept[0].chunks.as_ref().unwrap();
+        assert_eq!(chunks.len(), 2);
+        assert_eq!(chunks[1].start_line, 10);
+        assert_eq!(chunks[1].end_line, 10);
+        assert_eq!(chunks[1].content, "10. x\n");
+        assert_eq!(budget.report().partial, vec!["a.rs"]);
+    }
+
+    // ── symbols ──
+
+    #[test]
+    fn symbols_truncated_per_file() {
+        let sf = SymbolFile { path: "a.rs".into(), symbols: vec![symbol("alpha"), symbol("beta")], error: None };
+        let header = "a.rs".len() + ENTRY_OVERHEAD;
+        let first = ITEM_OVERHEAD + 2 + 5 + "fn alpha()".len();
+        let mut budget = Budget::new(header + first, &CharEstimator);
+        let kept = budget.fit_symbols(vec![sf], false);
+        assert_eq!(kept[0].symbols.len(), 1);
+        assert_eq!(kept[0].symbols[0].name, "alpha");
+        assert_eq!(budget.report().partial, vec!["a.rs"]);
+    }
+
+    #[test]
+    fn compact_symbols_cost_less() {
+        let make = || vec![SymbolFile { path: "a.rs".into(), symbols: vec![symbol("alpha"), symbol("beta")], error: None }];
+        let header = "a.rs".len() + ENTRY_OVERHEAD;
+        let limit = header + 2 * (ITEM_OVERHEAD + 2 + 5);
+        let mut full = Budget::new(limit, &CharEstimator);
+        let mut compact = Budget::new(limit, &CharEstimator);
+        assert_eq!(full.fit_symbols(make(), false)[0].symbols.len(), 1);
+        assert_eq!(compact.fit_symbols(make(), true)[0].symbols.len(), 2);
+    }
+}
@@ -1,24 +1,193 @@
-use std::collections::HashMap;
+use std::collections::{HashMap, HashSet};
 use std::path::Path;
 use std::sync::atomic::{AtomicBool, Ordering};
 
 use rayon::prelude::*;
+use regex::{Regex, RegexBuilder};
 
 use crate::file_reader;
-use crate::models::{CallerDeclaration, CallerEntry, CallerFile, CallersOutput};
+use crate::index::Index;
+use crate::lang::{self, lexer::{self, Syntax}};
+use crate::models::{CallNode, CallTreeOutput, CallerDeclaration, CallerEntry, CallerFile, CallersOutput, SymbolFile};
+use crate::ndjson::OnItem;
 use crate::path_helper;
-use crate::searcher::Matcher;
 use crate::symbols;
 
+/// Symbol kinds whose uses are type usages rather than plain references.
+const TYPE_KINDS: &[&str] = &["class", "struct", "interface", "enum", "trait", "type", "record"];
+
+/// Words after which a name is used as a type.
+const TYPE_KEYWORDS: &[&str] = &["impl", "dyn", "extends", "implements", "instanceof", "as", "is", "include", "extend", "prepend"];
+
+/// Callbacks for streaming callers output: declarations once they are known,
+/// then each file with call sites as it is scanned.
+#[derive(Clone, Copy, Default)]
+pub struct CallerStream<'a> {
+    pub on_declaration: OnItem<'a, CallerDeclaration>,
+    pub on_file: OnItem<'a, CallerFile>,
+}
+
+/// Which way a call tree walks from a symbol.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Direction {
+    /// The symbols whose bodies reference it, recursively.
+    Callers,
+    /// The symbols its body references, recursively.
+    Callees,
+}
+
+impl Direction {
+    pub fn name(self) -> &'static str {
+        match self {
+            Direction::Callers => "callers",
+            Direction::Callees => "callees",
+        }
+    }
+
+    /// Levels walked when `--depth` is not given.
+    pub fn default_depth(self) -> usize {
+        match self {
+            Direction::Callers => 3,
+            Direction::Callees => 1,
+        }
+    }
+}
+
+/// Matches symbol names against whole identifiers, case-sensitively unless
+/// asked otherwise. A regex must match the entire identifier.
+pub enum NameMatcher {
+    Name { name: String, ignore_case: bool },
+    Regex(Regex),
+}
+
+impl NameMatcher {
+    pub fn build(pattern: &str, is_regex: bool, ignore_case: bool) -> Result<Self, String> {
+        if is_regex {
+            RegexBuilder::new(&format!("^(?:{})$", pattern))
+                .case_insensitive(ignore_case)
+                .build()
+                .map(NameMatcher::Regex)
+                .map_err(|e| format!("Invalid regex: {}", e))
+        } else if pattern.is_empty() {
+            Err("Empty symbol name".into())
+        } else {
+            let name = if ignore_case { pattern.to_ascii_lowercase() } else { pattern.to_owned() };
+            Ok(NameMatcher::Name { name, ignore_case })
+        }
+    }
+
+    /// Whether a declared symbol's name matches.
+    fn is_match(&self, name: &str) -> bool {
+        match self {
+            NameMatcher::Name { name: n, ignore_case: true } => name.eq_ignore_ascii_case(n),
+            NameMatcher::Name { name: n, ignore_case: false } => name == n,
+            NameMatcher::Regex(re) => re.is_match(name),
+        }
+    }
+
+    /// Byte ranges of the matching identifiers in `line`.
+    fn find_in(&self, line: &str) -> Vec<(usize, usize)> {
+        match self {
+            NameMatcher::Name { name, ignore_case: true } => mention_spans(&line.to_ascii_lowercase(), name),
+            NameMatcher::Name { name, ignore_case: false } => mention_spans(line, name),
+            NameMatcher::Regex(re) => identifier_spans(line)
+                .filter(|(_, word)| re.is_match(word))
+                .map(|(at, word)| (at, at + word.len()))
+                .collect(),
+        }
+    }
+}
+
 pub fn find_callers(
+    file_paths: &[String],
+    root: &Path,
+    matcher: &NameMatcher,
+    include_tests: bool,
+    cancelled: &AtomicBool,
+    stream: CallerStream<'_>,
+) -> CallersOutput {
+    let file_contents = read_contents(file_paths, root, cancelled);
+
+    let content_map: HashMap<&str, &str> = file_contents
+        .iter()
+        .map(|(rel, content)| (rel.as_str(), content.as_str()))
+        .collect();
+
+    let symbol_files = symbols::extract_symbols_from_cache(&content_map, root, cancelled, include_tests);
+    collect_callers(&symbol_files, &file_contents, matcher, cancelled, stream)
+}
+
+/// Same as `find_callers`, but takes declarations from the on-disk index
+/// instead of re-extracting symbols for every file.
+pub fn find_callers_indexed(
+    file_paths: &[String],
+    root: &Path,
+    matcher: &NameMatcher,
+    include_tests: bool,
+    cancelled: &AtomicBool,
+    index: &Index,
+    stream: CallerStream<'_>,
+) -> CallersOutput {
+    let file_contents = read_contents(file_paths, root, cancelled);
+    let symbol_files = index.symbol_files(file_paths, root, false, include_tests);
+    collect_callers(&symbol_files, &file_contents, matcher, cancelled, stream)
+}
+
+/// Builds a call tree for every declaration of `name`, `depth` levels deep.
+/// Call sites are mapped back to the innermost symbol enclosing them.
+pub fn call_tree(
     file_paths: &[String],
     root: &Path,
     name: &str,
-    is_regex: bool,
+    direction: Direction,
+    depth: usize,
     include_tests: bool,
     cancelled: &AtomicBool,
-) -> Result<CallersOutput, String> {
-    let file_contents: Vec<(String, String)> = file_paths
+) -> CallTreeOutput {
+    let file_contents = read_contents(file_paths, root, cancelled);
+    let content_map: HashMap<&str, &str> = file_contents
+        .iter()
+        .map(|(rel, content)| (rel.as_str(), content.as_str()))
+        .collect();
+    let symbol_files = symbols::extract_symbols_from_cache(&content_map, root, cancelled, include_tests);
+    let code = mask_contents(&file_contents);
+    CallGraph::new(&symbol_files, &code, direction, cancelled).tree(name, depth)
+}
+
+/// Same as `call_tree`, with declarations from the on-disk index.
+pub fn call_tree_indexed(
+    file_paths: &[String],
+    root: &Path,
+    name: &str,
+    direction: Direction,
+    depth: usize,
+    include_tests: bool,
+    cancelled: &AtomicBool,
+    index: &Index,
+) -> CallTreeOutput {
+    let file_contents = read_contents(file_paths, root, cancelled);
+    let symbol_files = index.symbol_files(file_paths, root, false, include_tests);
+    let code = mask_contents(&file_contents);
+    CallGraph::new(&symbol_files, &code, direction, cancelled).tree(name, depth)
+}
+
+/// The code of each file with comments and string literals blanked.
+fn mask_contents(file_contents: &[(String, String)]) -> Vec<(String, String)> {
+    file_contents
+        .par_iter()
+        .map(|(relative, content)| (relative.clone(), mask_code(relative, content)))
+        .collect()
+}
+
+fn mask_code(relative: &str, content: &str) -> String {
+    match lang::syntax_for(relative) {
+        Some(syntax) => lexer::mask(content, syntax),
+        None => content.to_owned(),
+    }
+}
+
+fn read_contents(file_paths: &[String], root: &Path, cancelled: &AtomicBool) -> Vec<(String, String)> {
+    file_paths
         .par_iter()
         .filter_map(|file_path| {
             if cancelled.load(Ordering::Relaxed) {
@@ -31,29 +200,36 @@ pub fn find_callers(
                 _ => None,
             }
         })
-        .collect();
-
-    let content_map: HashMap<&str, &str> = file_contents
-        .iter()
-        .map(|(rel, content)| (rel.as_str(), content.as_str()))
-        .collect();
-
-    let symbol_files = symbols::extract_symbols_from_cache(&content_map, root, cancelled, include_tests);
+        .collect()
+}
 
+fn collect_callers(
+    symbol_files: &[SymbolFile],
+    file_contents: &[(String, String)],
+    matcher: &NameMatcher,
+    cancelled: &AtomicBool,
+    stream: CallerStream<'_>,
+) -> CallersOutput {
     let mut declarations: Vec<CallerDeclaration> = Vec::new();
-    for sf in &symbol_files {
+    let mut type_names: HashSet<&str> = HashSet::new();
+    for sf in symbol_files {
         for sym in &sf.symbols {
-            if sym.name == name {
+            if matcher.is_match(&sym.name) {
                 declarations.push(CallerDeclaration {
                     path: sf.path.clone(),
                     line: sym.line,
                     signature: sym.signature.clone(),
                 });
+                if TYPE_KINDS.contains(&sym.kind) {
+                    type_names.insert(&sym.name);
+                }
             }
         }
     }
 
-    let matcher = Matcher::build(name, is_regex)?;
+    if let Some(emit) = stream.on_declaration {
+        declarations.iter().for_each(emit);
+    }
 
     let decl_set: Vec<(&str, usize)> = declarations
         .iter()
@@ -67,33 +243,510 @@ pub fn find_callers(
                 return None;
             }
 
+            // Comments and strings are blanked, so only code can match.
+            let syntax = lang::syntax_for(relative);
+            let code = mask_code(relative, content);
+            let imports = syntax.map(|syntax| import_lines(&code, syntax)).unwrap_or_default();
+
             let mut sites: Vec<CallerEntry> = Vec::new();
-            for (i, line) in content.lines().enumerate() {
+            for (i, (line, code_line)) in content.lines().zip(code.lines()).enumerate() {
                 let line_num = i + 1;
-                if matcher.is_match(line) {
-                    let is_decl = decl_set.iter().any(|(dp, dl)| *dp == relative.as_str() && *dl == line_num);
-                    if !is_decl {
-                        sites.push(CallerEntry {
-                            line: line_num,
-                            content: line.trim().to_owned(),
-                        });
-                    }
+                let spans = matcher.find_in(code_line);
+                if spans.is_empty() || decl_set.iter().any(|(dp, dl)| *dp == relative.as_str() && *dl == line_num) {
+                    continue;
                 }
+                let kind = if imports.get(i).copied().unwrap_or(false) {
+                    "import"
+                } else {
+                    classify(code_line, &spans, &type_names, syntax.map_or(false, |s| s.paren_free_calls))
+                };
+                sites.push(CallerEntry {
+                    line: line_num,
+                    kind,
+                    content: line.trim().to_owned(),
+                });
             }
 
             if sites.is_empty() {
-                None
-            } else {
-                Some(CallerFile { path: relative.clone(), sites })
+                return None;
+            }
+            let caller = CallerFile { path: relative.clone(), sites };
+            if let Some(emit) = stream.on_file {
+                emit(&caller);
             }
+            Some(caller)
         })
         .collect();
 
     let mut results = results;
     results.sort_unstable_by(|a, b| a.path.to_ascii_lowercase().cmp(&b.path.to_ascii_lowercase()));
 
-    Ok(CallersOutput {
+    CallersOutput {
         declarations,
         files: results,
+    }
+}
+
+/// Which lines of masked `code` belong to import statements.
+fn import_lines(code: &str, syntax: &Syntax) -> Vec<bool> {
+    let mut lines = vec![false; code.lines().count()];
+    for statement in lexer::import_statements(code, syntax) {
+        lines[statement].iter_mut().for_each(|l| *l = true);
+    }
+    lines
+}
+
+/// The strongest use among the matches on a line: a call, then a type
+/// usage, then a plain reference.
+fn classify(line: &str, spans: &[(usize, usize)], type_names: &HashSet<&str>, paren_free_calls: bool) -> &'static str {
+    let mut kind = "reference";
+    for &(start, end) in spans {
+        if is_call(line, start, end, paren_free_calls) {
+            return "call";
+        }
+        let name = &line[start..end];
+        if type_names.contains(name) || is_type_context(&line[..start], name) {
+            kind = "type";
+        }
+    }
+    kind
+}
+
+/// `name(`, `name!(`, `name::<T>(` or `name<T>(`; in Ruby also `recv.name`.
+fn is_call(line: &str, start: usize, end: usize, paren_free_calls: bool) -> bool {
+    let after = line[end..].trim_start();
+    let after = after.strip_prefix('!').unwrap_or(after);
+    if after.starts_with('(') {
+        return true;
+    }
+    if let Some(generic) = after.strip_prefix("::<").or_else(|| after.strip_prefix('<')) {
+        let mut depth = 1;
+        for (i, c) in generic.char_indices() {
+            match c {
+                '<' => depth += 1,
+                '>' => {
+                    depth -= 1;
+                    if depth == 0 {
+                        return generic[i + 1..].trim_start().starts_with('(');
+                    }
+                }
+                _ => {}
+            }
+        }
+        return false;
+    }
+    paren_free_calls && line[..start].ends_with('.')
+}
+
+/// Whether the code before a name marks it as a type: a return arrow, a
+/// type keyword, or an annotation colon before a capitalized name.
+fn is_type_context(before: &str, name: &str) -> bool {
+    // `fmt::Display` and `models.User` are judged by what precedes the path.
+    let mut before = before;
+    loop {
+        let trimmed = before.strip_suffix("::").or_else(|| before.strip_suffix('.'));
+        match trimmed {
+            Some(rest) => before = rest.trim_end_matches(|c: char| c.is_ascii() && is_ident_byte(c as u8)),
+            None => break,
+        }
+    }
+    let before = before.trim_end();
+    let capitalized = name.starts_with(|c: char| c.is_ascii_uppercase());
+    if before.ends_with("->") {
+        return true;
+    }
+    if (before.ends_with(':') && !before.ends_with("::")) || before.ends_with('<') {
+        return capitalized;
+    }
+    let word = before.rsplit(|c: char| !(c.is_ascii() && is_ident_byte(c as u8))).next().unwrap_or("");
+    TYPE_KEYWORDS.contains(&word)
+}
+
+/// A node of the call graph: a symbol, as indexes into the symbol files and
+/// their symbols, or the top-level code of a file, as an index into the file
+/// contents.
+#[derive(Clone, Copy, PartialEq, Eq, Hash)]
+enum Node {
+    Symbol(usize, usize),
+    TopLevel(usize),
+}
+
+/// A node reached from its parent, with the lines where the two meet.
+type Edge = (Node, Vec<usize>);
+
+struct CallGraph<'a> {
+    symbol_files: &'a [SymbolFile],
+    /// Files with comments and strings masked.
+    files: &'a [(String, String)],
+    direction: Direction,
+    cancelled: &'a AtomicBool,
+    /// Symbol file index by path.
+    symbols_of: HashMap<&'a str, usize>,
+    /// Lines of each file by path.
+    lines: HashMap<&'a str, Vec<&'a str>>,
+    /// Every declaration of each name.
+    declared: HashMap<&'a str, Vec<Node>>,
+    /// Callers depend only on the name, callees on the declaration.
+    callers: HashMap<&'a str, Vec<Edge>>,
+    callees: HashMap<Node, Vec<Edge>>,
+}
+
+impl<'a> CallGraph<'a> {
+    fn new(symbol_files: &'a [SymbolFile], files: &'a [(String, String)], direction: Direction, cancelled: &'a AtomicBool) -> Self {
+        let mut declared: HashMap<&str, Vec<Node>> = HashMap::new();
+        for (fi, sf) in symbol_files.iter().enumerate() {
+            for (si, sym) in sf.symbols.iter().enumerate() {
+                declared.entry(sym.name.as_str()).or_default().push(Node::Symbol(fi, si));
+            }
+        }
+        CallGraph {
+            symbol_files,
+            files,
+            direction,
+            cancelled,
+            symbols_of: symbol_files.iter().enumerate().map(|(i, sf)| (sf.path.as_str(), i)).collect(),
+            lines: files.iter().map(|(path, content)| (path.as_str(), content.lines().collect())).collect(),
+            declared,
+            callers: HashMap::new(),
+            callees: HashMap::new(),
+        }
+    }
+
+    fn tree(mut self, name: &str, depth: usize) -> CallTreeOutput {
+        let roots = self.declared.get(name).cloned().unwrap_or_default();
+        let roots = roots.into_iter().map(|node| self.expand(node, Vec::new(), depth, &mut Vec::new())).collect();
+        CallTreeOutput { direction: self.direction.name(), depth, roots }
+    }
+
+    fn expand(&mut self, node: Node, sites: Vec<usize>, depth: usize, path: &mut Vec<Node>) -> CallNode {
+        let mut out = self.describe(node, sites);
+        if path.contains(&node) {
+            out.recursive = true;
+            return out;
+        }
+        let Node::Symbol(fi, si) = node else { return out };
+        if depth == 0 || self.cancelled.load(Ordering::Relaxed) {
+            return out;
+        }
+        let edges = match self.direction {
+            Direction::Callers => {
+                let name = self.symbol_files[fi].symbols[si].name.as_str();
+                if !self.callers.contains_key(name) {
+                    let edges = self.find_callers(name);
+                    self.callers.insert(name, edges);
+                }
+                self.callers[name].clone()
+            }
+            Direction::Callees => {
+                if !self.callees.contains_key(&node) {
+                    let edges = self.find_callees(fi, si);
+                    self.callees.insert(node, edges);
+                }
+                self.callees[&node].clone()
+            }
+        };
+        path.push(node);
+        out.children = edges.into_iter().map(|(child, sites)| self.expand(child, sites, depth - 1, path)).collect();
+        path.pop();
+        out
+    }
+
+    fn describe(&self, node: Node, sites: Vec<usize>) -> CallNode {
+        let (name, kind, path, line) = match node {
+            Node::Symbol(fi, si) => {
+                let sf = &self.symbol_files[fi];
+                let sym = &sf.symbols[si];
+                (sym.name.clone(), sym.kind, sf.path.clone(), sym.line)
+            }
+            Node::TopLevel(fi) => {
+                let path = self.files[fi].0.clone();
+                (path.clone(), "file", path, sites.first().copied().unwrap_or(1))
+            }
+        };
+        CallNode { name, kind, path, line, sites, recursive: false, children: Vec::new() }
+    }
+
+    /// Every symbol, or file top level, with code that mentions `name`.
+    /// Declarations of `name` are not references to it.
+    fn find_callers(&self, name: &str) -> Vec<Edge> {
+        let declarations: HashSet<(&str, usize)> = self
+            .declared
+            .get(name)
+            .into_iter()
+            .flatten()
+            .filter_map(|node| match *node {
+                Node::Symbol(fi, si) => Some((self.symbol_files[fi].path.as_str(), self.symbol_files[fi].symbols[si].line)),
+                Node::TopLevel(_) => None,
+            })
+            .collect();
+
+        let mut edges: Vec<Edge> = self
+            .files
+            .par_iter()
+            .enumerate()
+            .flat_map_iter(|(file, (path, content))| {
+                let mut found: Vec<Edge> = Vec::new();
+                if self.cancelled.load(Ordering::Relaxed) {
+                    return found;
+                }
+                let symbols = self.symbols_of.get(path.as_str()).copied();
+                for (i, line) in content.lines().enumerate() {
+                    let n = i + 1;
+                    if mention_spans(line, name).is_empty() || declarations.contains(&(path.as_str(), n)) {
+                        continue;
+                    }
+                    let node = symbols
+                        .and_then(|fi| symbols::innermost_symbol(&self.symbol_files[fi].symbols, n).map(|si| Node::Symbol(fi, si)))
+                        .unwrap_or(Node::TopLevel(file));
+                    add_site(&mut found, node, n);
+                }
+                found
+            })
+            .collect();
+        edges.sort_by_cached_key(|(node, sites)| (self.path_of(*node).to_ascii_lowercase(), sites[0]));
+        edges
+    }
+
+    /// Every declared symbol named on the lines of a symbol's body, in order
+    /// of first reference. A name declared in the same file resolves there;
+    /// otherwise to each of its declarations.
+    fn find_callees(&self, fi: usize, si: usize) -> Vec<Edge> {
+        let sf = &self.symbol_files[fi];
+        let sym = &sf.symbols[si];
+        let lines = match self.lines.get(sf.path.as_str()) {
+            Some(lines) => lines,
+            None => return Vec::new(),
+        };
+        let mut found: Vec<Edge> = Vec::new();
+        for n in sym.line..=sym.end_line.min(lines.len()) {
+            for ident in identifiers(lines[n - 1]) {
+                if sf.symbols.iter().any(|s| s.line == n && s.name == ident) {
+                    continue;
+                }
+                let targets = match self.declared.get(ident) {
+                    Some(targets) => targets,
+                    None => continue,
+                };
+                let local: Vec<Node> = targets.iter().copied().filter(|t| matches!(t, Node::Symbol(f, _) if *f == fi)).collect();
+                for &target in if local.is_empty() { targets } else { &local } {
+                    add_site(&mut found, target, n);
+                }
+            }
+        }
+        found
+    }
+
+    fn path_of(&self, node: Node) -> &str {
+        match node {
+            Node::Symbol(fi, _) => &self.symbol_files[fi].path,
+            Node::TopLevel(fi) => &self.files[fi].0,
+        }
+    }
+}
+
+fn add_site(edges: &mut Vec<Edge>, node: Node, line: usize) {
+    match edges.iter_mut().find(|(n, _)| *n == node) {
+        Some((_, sites)) => {
+            if sites.last() != Some(&line) {
+                sites.push(line);
+            }
+        }
+        None => edges.push((node, vec![line])),
+    }
+}
+
+fn is_ident_byte(b: u8) -> bool {
+    b.is_ascii_alphanumeric() || b == b'_' || b == b'$'
+}
+
+/// Byte ranges where `line` holds `name` as a whole identifier.
+fn mention_spans(line: &str, name: &str) -> Vec<(usize, usize)> {
+    let bytes = line.as_bytes();
+    let (first, last) = match (name.bytes().next(), name.bytes().last()) {
+        (Some(f), Some(l)) => (f, l),
+        _ => return Vec::new(),
+    };
+    line.match_indices(name)
+        .map(|(at, _)| (at, at + name.len()))
+        .filter(|&(at, end)| {
+            (!is_ident_byte(first) || at == 0 || !is_ident_byte(bytes[at - 1]))
+                && (!is_ident_byte(last) || end == bytes.len() || !is_ident_byte(bytes[end]))
+        })
+        .collect()
+}
+
+/// Identifiers in `line` with their byte offsets.
+fn identifier_spans(line: &str) -> impl Iterator<Item = (usize, &str)> {
+    let bytes = line.as_bytes();
+    let mut i = 0;
+    std::iter::from_fn(move || {
+        while i < bytes.len() {
+            let start = i;
+            while i < bytes.len() && is_ident_byte(bytes[i]) {
+                i += 1;
+            }
+            if i == start {
+                i += 1;
+            } else if !bytes[start].is_ascii_digit() {
+                return Some((start, &line[start..i]));
+            }
+        }
+        None
     })
 }
+
+fn identifiers(line: &str) -> impl Iterator<Item = &str> {
+    identifier_spans(line).map(|(_, word)| word)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::models::SymbolInfo;
+
+    fn sym(kind: &'static str, name: &str, line: usize, end_line: usize) -> SymbolInfo {
+        SymbolInfo {
+            kind,
+            name: name.to_owned(),
+            line,
+            end_line,
+            visibility: None,
+            parent: None,
+            signature: String::new(),
+            comment: None,
+        }
+    }
+
+    fn symbol_file(path: &str, symbols: Vec<SymbolInfo>) -> SymbolFile {
+        SymbolFile { path: path.to_owned(), symbols, error: None }
+    }
+
+    fn fixture() -> (Vec<SymbolFile>, Vec<(String, String)>) {
+        let files = vec![
+            ("src/main.rs".to_owned(), "fn main() {\n    run();\n}\n\nfn run() {\n    load();\n    save();\n}\n".to_owned()),
+            ("src/store.rs".to_owned(), "fn load() {\n    parse_row();\n}\n\nfn save() {\n    save();\n}\n\nfn parse_row() {}\n".to_owned()),
+            ("scripts/seed.rs".to_owned(), "load();\n".to_owned()),
+        ];
+        let symbol_files = vec![
+            symbol_file("src/main.rs", vec![sym("fn", "main", 1, 3), sym("fn", "run", 5, 8)]),
+            symbol_file("src/store.rs", vec![sym("fn", "load", 1, 3), sym("fn", "save", 5, 7), sym("fn", "parse_row", 9, 9)]),
+        ];
+        (symbol_files, files)
+    }
+
+    fn outline(node: &CallNode, depth: usize, out: &mut Vec<String>) {
+        let mark = if node.recursive { " (recursive)" } else { "" };
+        out.push(format!("{}{} {}:{} {:?}{}", "  ".repeat(depth), node.name, node.path, node.line, node.sites, mark));
+        node.children.iter().for_each(|c| outline(c, depth + 1, out));
+    }
+
+    fn tree(direction: Direction, name: &str, depth: usize) -> Vec<String> {
+        let (symbol_files, files) = fixture();
+        let cancelled = AtomicBool::new(false);
+        let output = CallGraph::new(&symbol_files, &files, direction, &cancelled).tree(name, depth);
+        let mut out = Vec::new();
+        output.roots.iter().for_each(|r| outline(r, 0, &mut out));
+        out
+    }
+
+    // ── matching ──
+
+    #[test]
+    fn mentions_whole_identifiers_only() {
+        assert_eq!(mention_spans("    load();", "load"), vec![(4, 8)]);
+        assert_eq!(mention_spans("x = load", "load"), vec![(4, 8)]);
+        assert!(mention_spans("    preload();", "load").is_empty());
+        assert!(mention_spans("    load_all();", "load").is_empty());
+        assert_eq!(mention_spans("if user.valid? then", "valid?"), vec![(8, 14)]);
+    }
+
+    #[test]
+    fn identifiers_skip_numbers_and_punctuation() {
+        let words: Vec<&str> = identifiers("let x2 = foo(3, $el, bar_baz::qux);").collect();
+        assert_eq!(words, vec!["let", "x2", "foo", "$el", "bar_baz", "qux"]);
+    }
+
+    #[test]
+    fn name_matcher_is_case_sensitive_unless_asked() {
+        let exact = NameMatcher::build("run", false, false).unwrap();
+        assert_eq!(exact.find_in("run(); Run(); runner(); rerun();"), vec![(0, 3)]);
+        let any_case = NameMatcher::build("run", false, true).unwrap();
+        assert_eq!(any_case.find_in("run(); Run(); runner();"), vec![(0, 3), (7, 10)]);
+        assert!(any_case.is_match("RUN"));
+        assert!(!exact.is_match("RUN"));
+    }
+
+    #[test]
+    fn name_matcher_regex_matches_whole_identifiers() {
+        let re = NameMatcher::build("get_.*", true, false).unwrap();
+        assert_eq!(re.find_in("x = get_user(forget_it)"), vec![(4, 12)]);
+        assert!(re.is_match("get_id"));
+        assert!(!re.is_match("forget_id"));
+        assert!(NameMatcher::build("(", true, false).is_err());
+    }
+
+    // ── classification ──
+
+    fn kind_of(line: &str, name: &str, types: &[&str], paren_free_calls: bool) -> &'static str {
+        let types: HashSet<&str> = types.iter().copied().collect();
+        classify(line, &mention_spans(line, name), &types, paren_free_calls)
+    }
+
+    #[test]
+    fn sites_are_classified() {
+        assert_eq!(kind_of("    run();", "run", &[], false), "call");
+        assert_eq!(kind_of("    vec![run ()]", "run", &[], false), "call");
+        assert_eq!(kind_of("    format!(\"{}\", x)", "format", &[], false), "call");
+        assert_eq!(kind_of("    parse::<u32>(s)", "parse", &[], false), "call");
+        assert_eq!(kind_of("    handlers.push(run);", "run", &[], false), "reference");
+        assert_eq!(kind_of("    job.run", "run", &[], true), "call");
+        assert_eq!(kind_of("    job.run", "run", &[], false), "reference");
+        assert_eq!(kind_of("fn build() -> Config {", "Config", &[], false), "type");
+        assert_eq!(kind_of("impl fmt::Display for Config {", "Display", &[], false), "type");
+        assert_eq!(kind_of("    let c: Config = x;", "Config", &[], false), "type");
+        assert_eq!(kind_of("class Admin extends User {", "User", &[], false), "type");
+        assert_eq!(kind_of("    users: Vec<User>,", "User", &[], false), "type");
+        assert_eq!(kind_of("    let u = User { id };", "User", &["User"], false), "type");
+        assert_eq!(kind_of("    let u = User::new();", "User", &[], false), "reference");
+        assert_eq!(kind_of("    x ? a : b", "b", &[], false), "reference");
+    }
+
+    #[test]
+    fn import_lines_follow_brackets() {
+        let code = "use crate::a::{\n    run,\n};\nfn f() { run(); }\nimport (\n    \"fmt\"\n)\n";
+        assert_eq!(import_lines(code, &TEST_SYNTAX), vec![true, true, true, false, true, true, true]);
+        assert_eq!(import_lines("import(\"./lazy\")\n", &TEST_SYNTAX), vec![false]);
+    }
+
+    const TEST_SYNTAX: Syntax = Syntax { imports: &["use ", "import "], ..lexer::C_LIKE };
+
+    // ── call trees ──
+
+    #[test]
+    fn caller_tree_walks_up_to_depth() {
+        assert_eq!(tree(Direction::Callers, "parse_row", 3), vec![
+            "parse_row src/store.rs:9 []",
+            "  load src/store.rs:1 [2]",
+            "    scripts/seed.rs scripts/seed.rs:1 [1]",
+            "    run src/main.rs:5 [6]",
+            "      main src/main.rs:1 [2]",
+        ]);
+        assert_eq!(tree(Direction::Callers, "parse_row", 1).len(), 2);
+    }
+
+    #[test]
+    fn callee_tree_marks_recursion() {
+        assert_eq!(tree(Direction::Callees, "run", 2), vec![
+            "run src/main.rs:5 []",
+            "  load src/store.rs:1 [6]",
+            "    parse_row src/store.rs:9 [2]",
+            "  save src/store.rs:5 [7]",
+            "    save src/store.rs:5 [6] (recursive)",
+        ]);
+    }
+
+    #[test]
+    fn undeclared_name_has_no_roots() {
+        assert!(tree(Direction::Callers, "missing", 3).is_empty());
+    }
+}
@@ -1,3 +1,6 @@
+use crate::callers::Direction;
+use crate::rollup::Grouping;
+
 #[derive(Debug)]
 pub struct CliArgs {
     pub root: String,
@@ -6,10 +9,17 @@ pub struct CliArgs {
     pub timeout: Option<u64>,
     pub excludes: Vec<String>,
     pub no_defaults: bool,
+    pub no_ignore: bool,
     pub is_regex: bool,
     pub line_numbers: bool,
     pub lines: Vec<String>,
     pub graph: bool,
+    pub dependents: Option<String>,
+    pub impact: Vec<String>,
+    pub cycles: bool,
+    pub by: Option<Grouping>,
+    pub external: bool,
+    pub orphans: bool,
     pub symbols: bool,
     pub count: bool,
     pub stats: bool,
@@ -17,22 +27,86 @@ pub struct CliArgs {
     pub format: OutputFormatArg,
     pub output: Option<String>,
     pub callers: Option<String>,
+    /// Match `--callers` names regardless of case.
+    pub ignore_case: bool,
+    /// `--call-tree` or `--callees`: the symbol and which way to walk.
+    pub call_tree: Option<(String, Direction)>,
+    pub depth: Option<usize>,
     pub compact: bool,
     pub with_comments: bool,
     pub with_tests: bool,
     pub auto_expand: bool,
     pub context: Option<usize>,
+    pub watch: bool,
+    pub max_tokens: Option<usize>,
+    pub tokenizer: Option<String>,
+    pub collapse_depth: Option<usize>,
+    pub edge_labels: bool,
+    pub rules: Option<String>,
 }
 
 #[derive(Debug, Clone, Copy, PartialEq)]
 pub enum OutputFormatArg {
     Yaml,
     Json,
+    Ndjson,
+    Dot,
+    Mermaid,
+}
+
+/// Value shape of a query option, used to describe options to protocol clients.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum OptionKind {
+    Flag,
+    Integer,
+    Text,
+    TextList,
+}
+
+/// A per-query option as exposed to protocol clients. `name` is the long
+/// flag without its dashes and maps onto a `CliArgs` field.
+pub struct OptionSpec {
+    pub name: &'static str,
+    pub kind: OptionKind,
+    pub description: &'static str,
+}
+
+pub const QUERY_OPTIONS: &[OptionSpec] = &[
+    OptionSpec { name: "dir", kind: OptionKind::Text, description: "Directory to scan, relative to the project root" },
+    OptionSpec { name: "glob", kind: OptionKind::TextList, description: "File glob patterns; supports **, {a,b}, [abc] and !negation" },
+    OptionSpec { name: "exclude", kind: OptionKind::TextList, description: "Additional exclusions: names or gitignore-style patterns" },
+    OptionSpec { name: "regex", kind: OptionKind::Flag, description: "Treat the pattern as a regular expression" },
+    OptionSpec { name: "context", kind: OptionKind::Integer, description: "Context lines around matches instead of full files" },
+    OptionSpec { name: "limit", kind: OptionKind::Integer, description: "Max number of files in the output" },
+    OptionSpec { name: "timeout", kind: OptionKind::Integer, description: "Max execution time in seconds" },
+    OptionSpec { name: "max-tokens", kind: OptionKind::Integer, description: "Estimated token budget; content is cut at line boundaries to fit" },
+    OptionSpec { name: "compact", kind: OptionKind::Flag, description: "Ultra-compact symbol output" },
+    OptionSpec { name: "with-comments", kind: OptionKind::Flag, description: "Include doc comments in symbol output" },
+    OptionSpec { name: "with-tests", kind: OptionKind::Flag, description: "Include test files (excluded by default)" },
+    OptionSpec { name: "auto-expand", kind: OptionKind::Flag, description: "Expand line ranges to the full enclosing symbol" },
+    OptionSpec { name: "line-numbers", kind: OptionKind::Flag, description: "Prefix content lines with line numbers (default true)" },
+    OptionSpec { name: "no-defaults", kind: OptionKind::Flag, description: "Disable built-in exclusions (node_modules, .git, etc.)" },
+    OptionSpec { name: "no-ignore", kind: OptionKind::Flag, description: "Do not honor .gitignore, .ignore or .srcignore" },
+];
+
+pub fn query_option(name: &str) -> Option<&'static OptionSpec> {
+    QUERY_OPTIONS.iter().find(|o| o.name == name)
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum IndexCommand {
+    Build,
+    Status,
+    Clear,
 }
 
 #[derive(Debug)]
 pub enum CliAction {
     Run(CliArgs),
+    Index(IndexCommand, CliArgs),
+    Serve(CliArgs),
+    Mcp(CliArgs),
+    CheckDeps(CliArgs),
     Help,
     Version,
 }
@@ -57,16 +131,126 @@ fn collect_option_values(args: &[String], i: &mut usize, flag: &str) -> Result<V
 }
 
 pub fn parse_args(args: &[String]) -> Result<CliAction, String> {
+    match args.first().map(String::as_str) {
+        Some("index") => parse_index_args(&args[1..]),
+        Some("serve") => parse_serve_args(&args[1..]),
+        Some("mcp") => match parse_service_args("mcp", &args[1..])? {
+            CliAction::Run(a) => Ok(CliAction::Mcp(a)),
+            other => Ok(other),
+        },
+        Some("check-deps") => parse_check_deps_args(&args[1..]),
+        _ => match parse_options(args)? {
+            CliAction::Run(a) if a.rules.is_some() => Err("--rules is only used by src check-deps.".into()),
+            other => Ok(other),
+        },
+    }
+}
+
+fn has_mode(a: &CliArgs) -> bool {
+    !a.globs.is_empty() || a.find.is_some() || !a.lines.is_empty()
+        || a.graph || a.symbols || a.stats || a.callers.is_some() || a.call_tree.is_some()
+}
+
+fn parse_serve_args(args: &[String]) -> Result<CliAction, String> {
+    let stdio = args.iter().any(|a| a == "--stdio");
+    let rest: Vec<String> = args.iter().filter(|a| *a != "--stdio").cloned().collect();
+
+    match parse_service_args("serve", &rest)? {
+        CliAction::Run(a) => {
+            if !stdio {
+                return Err("src serve requires a transport; only --stdio is supported.".into());
+            }
+            Ok(CliAction::Serve(a))
+        }
+        other => Ok(other),
+    }
+}
+
+/// Options for long-running commands: scan options only, since modes are
+/// chosen per request and responses always go to stdout.
+fn parse_service_args(command: &str, args: &[String]) -> Result<CliAction, String> {
+    match parse_options(args)? {
+        CliAction::Run(a) => {
+            if has_mode(&a) {
+                return Err(format!("src {} does not take mode options; send them per request.", command));
+            }
+            if a.watch {
+                return Err(format!("src {} does not support --watch.", command));
+            }
+            if a.output.is_some() {
+                return Err(format!("src {} writes responses to stdout; --output is not supported.", command));
+            }
+            if a.rules.is_some() {
+                return Err("--rules is only used by src check-deps.".into());
+            }
+            Ok(CliAction::Run(a))
+        }
+        other => Ok(other),
+    }
+}
+
+fn parse_index_args(args: &[String]) -> Result<CliAction, String> {
+    let command = match args.first().map(String::as_str) {
+        Some("build") => IndexCommand::Build,
+        Some("status") => IndexCommand::Status,
+        Some("clear") => IndexCommand::Clear,
+        Some("--help") | Some("-h") | Some("-?") => return Ok(CliAction::Help),
+        Some(other) => return Err(format!("Unknown index command: {}. Expected build, status or clear.", other)),
+        None => return Err("Missing index command. Expected build, status or clear.".into()),
+    };
+
+    match parse_options(&args[1..])? {
+        CliAction::Run(a) => {
+            if has_mode(&a) {
+                return Err("src index does not take mode options (--glob, --find, --graph, ...).".into());
+            }
+            if a.watch {
+                return Err("src index does not support --watch.".into());
+            }
+            if a.rules.is_some() {
+                return Err("--rules is only used by src check-deps.".into());
+            }
+            Ok(CliAction::Index(command, a))
+        }
+        other => Ok(other),
+    }
+}
+
+/// `src check-deps` builds the graph itself, so it takes scan options and
+/// `--rules` but no mode.
+fn parse_check_deps_args(args: &[String]) -> Result<CliAction, String> {
+    match parse_options(args)? {
+        CliAction::Run(a) => {
+            if a.find.is_some() || !a.lines.is_empty() || a.graph || a.symbols || a.stats || a.callers.is_some() || a.call_tree.is_some() {
+                return Err("src check-deps does not take mode options; use --glob to narrow the files checked.".into());
+            }
+            if a.watch {
+                return Err("src check-deps does not support --watch.".into());
+            }
+            Ok(CliAction::CheckDeps(a))
+        }
+        other => Ok(other),
+    }
+}
+
+fn parse_options(args: &[String]) -> Result<CliAction, String> {
     let mut root: Option<String> = None;
     let mut globs = Vec::new();
     let mut find: Option<String> = None;
     let mut timeout: Option<u64> = None;
     let mut excludes = Vec::new();
     let mut no_defaults = false;
+    let mut no_ignore = false;
     let mut is_regex = false;
     let mut line_numbers = true;
     let mut lines: Vec<String> = Vec::new();
     let mut graph = false;
+    let mut dependents: Option<String> = None;
+    let mut impact: Vec<String> = Vec::new();
+    let mut cycles = false;
+    let mut by: Option<Grouping> = None;
+    let mut external = false;
+    let mut orphans = false;
     let mut symbols = false;
     let mut count = false;
     let mut stats = false;
@@ -74,11 +258,21 @@ pub fn parse_args(args: &[String]) -> Result<CliAction, String> {
     let mut format = OutputFormatArg::Yaml;
     let mut output: Option<String> = None;
     let mut callers: Option<String> = None;
+    let mut ignore_case = false;
+    let mut call_tree: Option<String> = None;
+    let mut callees: Option<String> = None;
+    let mut depth: Option<usize> = None;
     let mut compact = false;
     let mut with_comments = false;
     let mut with_tests = false;
     let mut auto_expand = false;
     let mut context: Option<usize> = None;
+    let mut watch = false;
+    let mut max_tokens: Option<usize> = None;
+    let mut tokenizer: Option<String> = None;
+    let mut collapse_depth: Option<usize> = None;
+    let mut edge_labels = false;
+    let mut rules: Option<String> = None;
 
     let mut i = 0;
     while i < args.len() {
@@ -134,10 +328,44 @@ pub fn parse_args(args: &[String]) -> Result<CliAction, String> {
                 }
             }
             "--graph" => graph = true,
+            "--dependents" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --dependents".into()); }
+                dependents = Some(args[i].clone());
+            }
+            "--cycles" => cycles = true,
+            "--external" => external = true,
+            "--orphans" => orphans = true,
+            "--by" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --by".into()); }
+                by = Some(match args[i].to_ascii_lowercase().as_str() {
+                    "dir" | "directory" => Grouping::Dir,
+                    "package" | "pkg" => Grouping::Package,
+                    other => return Err(format!("Unknown value for --by: '{}'. Supported: dir, package", other)),
+                });
+            }
+            "--impact" => {
+                let values = collect_option_values(args, &mut i, "--impact")?;
+                impact.extend(values);
+            }
             "--symbols" | "--s" | "-s" => symbols = true,
             "--count" | "-c" => count = true,
             "--stats" | "--st" | "-S" => stats = true,
             "--no-defaults" => no_defaults = true,
+            "--no-ignore" => no_ignore = true,
+            "--watch" | "-w" => watch = true,
+            "--max-tokens" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --max-tokens".into()); }
+                max_tokens = Some(args[i].parse::<usize>()
+                    .map_err(|_| format!("Invalid integer for --max-tokens: {}", args[i]))?);
+            }
+            "--tokenizer" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --tokenizer".into()); }
+                tokenizer = Some(args[i].clone());
+            }
             "--regex" | "-E" => is_regex = true,
             "--limit" | "-L" => {
                 i += 1;
@@ -151,15 +379,47 @@ pub fn parse_args(args: &[String]) -> Result<CliAction, String> {
                 format = match args[i].to_ascii_lowercase().as_str() {
                     "json" => OutputFormatArg::Json,
                     "yaml" | "yml" => OutputFormatArg::Yaml,
-                    other => return Err(format!("Unknown format: '{}'. Supported: yaml, json", other)),
+                    "ndjson" | "jsonl" => OutputFormatArg::Ndjson,
+                    "dot" | "graphviz" => OutputFormatArg::Dot,
+                    "mermaid" => OutputFormatArg::Mermaid,
+                    other => return Err(format!("Unknown format: '{}'. Supported: yaml, json, ndjson, dot, mermaid", other)),
                 };
             }
             "--json" => format = OutputFormatArg::Json,
+            "--collapse-depth" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --collapse-depth".into()); }
+                collapse_depth = Some(args[i].parse::<usize>().ok().filter(|&n| n > 0)
+                    .ok_or_else(|| format!("Invalid value for --collapse-depth: {}. Expected a positive integer.", args[i]))?);
+            }
+            "--edge-labels" => edge_labels = true,
+            "--rules" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --rules".into()); }
+                rules = Some(args[i].clone());
+            }
             "--callers" => {
                 i += 1;
                 if i >= args.len() { return Err("Missing value for --callers".into()); }
                 callers = Some(args[i].clone());
             }
+            "--ignore-case" | "-i" => ignore_case = true,
+            "--call-tree" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --call-tree".into()); }
+                call_tree = Some(args[i].clone());
+            }
+            "--callees" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --callees".into()); }
+                callees = Some(args[i].clone());
+            }
+            "--depth" => {
+                i += 1;
+                if i >= args.len() { return Err("Missing value for --depth".into()); }
+                depth = Some(args[i].parse::<usize>().ok().filter(|&n| n > 0)
+                    .ok_or_else(|| format!("Invalid value for --depth: {}. Expected a positive integer.", args[i]))?);
+            }
             "--compact" => compact = true,
             "--with-comments" => with_comments = true,
             "--with-tests" => with_tests = true,
@@ -189,6 +449,41 @@ pub fn parse_args(args: &[String]) -> Result<CliAction, String> {
     if auto_expand && lines.is_empty() {
         return Err("--auto-expand requires --lines".into());
     }
+    if ignore_case && callers.is_none() {
+        return Err("--ignore-case requires --callers".into());
+    }
+    if depth.is_some() && call_tree.is_none() && callees.is_none() {
+        return Err("--depth requires --call-tree or --callees".into());
+    }
+
+    let mut graph_queries = Vec::new();
+    if dependents.is_some() { graph_queries.push("--dependents"); }
+    if !impact.is_empty() { graph_queries.push("--impact"); }
+    if cycles { graph_queries.push("--cycles"); }
+    if by.is_some() { graph_queries.push("--by"); }
+    if external { graph_queries.push("--external"); }
+    if orphans { graph_queries.push("--orphans"); }
+    if let Some(first) = graph_queries.first().filter(|_| !graph) {
+        return Err(format!("{} requires --graph", first));
+    }
+    if graph_queries.len() > 1 {
+        return Err(format!("{} are mutually exclusive and cannot be combined.", graph_queries.join(" and ")));
+    }
+
+    let diagram = matches!(format, OutputFormatArg::Dot | OutputFormatArg::Mermaid);
+    if diagram && (!graph || !graph_queries.is_empty()) {
+        return Err("--format dot and --format mermaid require --graph (without --dependents, --impact, --cycles, --by, --external or --orphans)".into());
+    }
+    if collapse_depth.is_some() && !diagram {
+        return Err("--collapse-depth requires --format dot or --format mermaid".into());
+    }
+    if edge_labels && !diagram {
+        return Err("--edge-labels requires --format dot or --format mermaid".into());
+    }
+
+    if max_tokens.is_some() && !((find.is_some() && !count) || !lines.is_empty() || symbols) {
+        return Err("--max-tokens requires --find, --lines or --symbols".into());
+    }
 
     if symbols && find.is_some() && count {
         return Err("--symbols --find --count are mutually exclusive and cannot be combined.".into());
@@ -203,10 +498,16 @@ pub fn parse_args(args: &[String]) -> Result<CliAction, String> {
     if symbols { exclusive_count += 1; exclusive_names.push("--symbols"); }
     if stats { exclusive_count += 1; exclusive_names.push("--stats"); }
     if callers.is_some() { exclusive_count += 1; exclusive_names.push("--callers"); }
+    if call_tree.is_some() { exclusive_count += 1; exclusive_names.push("--call-tree"); }
+    if callees.is_some() { exclusive_count += 1; exclusive_names.push("--callees"); }
     if exclusive_count > 1 {
         return Err(format!("{} are mutually exclusive and cannot be combined.", exclusive_names.join(" and ")));
     }
 
+    let call_tree = call_tree
+        .map(|name| (name, Direction::Callers))
+        .or_else(|| callees.map(|name| (name, Direction::Callees)));
+
     let root = root.unwrap_or_else(|| std::env::current_dir()
         .map(|p| p.to_string_lossy().into_owned())
         .unwrap_or_else(|_| ".".into()));
@@ -218,10 +519,17 @@ pub fn parse_args(args: &[String]) -> Result<CliAction, String> {
         timeout,
         excludes,
         no_defaults,
+        no_ignore,
         is_regex,
         line_numbers,
         lines,
         graph,
+        dependents,
+        impact,
+        cycles,
+        by,
+        external,
+        orphans,
         symbols,
         count,
         stats,
@@ -229,11 +537,20 @@ pub fn parse_args(args: &[String]) -> Result<CliAction, String> {
         format,
         output,
         callers,
+        ignore_case,
+        call_tree,
+        depth,
         compact,
         with_comments,
         with_tests,
         auto_expand,
         context,
+        watch,
+        max_tokens,
+        tokenizer,
+        collapse_depth,
+        edge_labels,
+        rules,
     }))
 }
 
@@ -243,6 +560,26 @@ r#"src — fast source code interrogation tool
 
 Usage:
   src [options]
+  src index <build|status|clear> [options]
+  src serve --stdio [options]
+  src mcp [options]
+  src check-deps [--rules <path>] [options]
+
+Commands:
+  index build             Build or refresh the on-disk index in .src/
+  index status            Report whether the index matches the files on disk
+  index clear             Delete the on-disk index
+  serve --stdio           Answer line-delimited JSON-RPC requests on stdin, keeping
+                          file contents, symbols and aliases warm between requests.
+                          Methods: tree, files, search, count, lines, symbols, graph,
+                          callers, call-tree, callees, stats, shutdown. Params use
+                          the long flag names, e.g.
+                          {{"pattern": "TODO", "glob": ["*.rs"], "context": 2}}
+  mcp                     Run a Model Context Protocol server on stdio exposing the
+                          modes as tools; --dir is the sandbox root
+  check-deps              Check the import graph against layering rules in
+                          .src/layers.toml (or --rules <path>); exits 3 when any
+                          import breaks a rule
 
 Modes:
   (default)               Show directory hierarchy containing source files
@@ -252,16 +589,40 @@ Modes:
   --graph                 Show project-internal dependency graph
   --symbols, -s           Extract symbol declarations from source files
   --callers <name>        Find all references/call sites for a symbol
+  --call-tree <name>      Walk the callers of a symbol recursively, as a tree
+  --callees <name>        List the symbols referenced inside a symbol's body
   --stats, -S             Show codebase statistics (files, lines, bytes by language)
 
 Options:
   --dir, -d <path>        Root directory (default: current directory)
   --glob, -g <glob>       File glob pattern (repeatable; -g *.ts *.tsx also works)
+                          Supports **, {{a,b}}, [abc] and !negation; patterns with / match paths
   --find, -f <pattern>    Search pattern (use | for OR, e.g. Payment|Invoice)
   --lines <specs>         Line specs: file:start:end file2:start:end (repeatable)
   --graph                 Emit source dependency graph
+  --dependents <file>     With --graph: files that import <file>, directly or
+                          transitively, grouped by depth
+  --impact <files>        With --graph: union of the files affected by changing
+                          any of <files>, grouped by distance
+  --cycles                With --graph: report import cycles with the shortest path
+                          through each and the import line behind every edge
+  --by <dir|package>      With --graph: roll file imports up into weighted edges
+                          between directories or packages (Go modules, crates, npm
+                          packages, C# projects, Python packages)
+  --external              With --graph: also list third-party packages per file and
+                          an inventory of which packages are used where
+  --orphans               With --graph: files no other file imports that are not
+                          entrypoints (main.rs, main.go, index.ts, package.json bin...)
   --symbols, -s           Extract symbol declarations (compact: signature :start:end)
-  --callers <name>        Find declaration and all call sites for a symbol name
+  --callers <name>        Find declaration and all call sites for a symbol name, each
+                          classified as call, reference, import or type; matches whole
+                          identifiers in code, skipping comments and strings
+  --ignore-case, -i       Match the --callers name regardless of case
+  --call-tree <name>      Tree of the symbols that reference <name>, then the symbols
+                          that reference those, with file:line at each node
+  --callees <name>        Tree of the declared symbols referenced in <name>'s body
+  --depth <n>             Levels to walk for --call-tree (default: 3) or --callees
+                          (default: 1)
   --count, -c             Show match counts per file (requires --find)
   --stats, -S             File counts, line counts, byte sizes by extension
   --compact               Ultra-compact symbol output: kind name :line:end (requires --symbols)
@@ -270,12 +631,25 @@ Options:
   --auto-expand           Expand --lines ranges to full enclosing symbol (requires --lines)
   --context, -C <n>       Context lines around matches in --find output (default: full file)
   --limit, -L <n>         Max number of files in the output
+  --max-tokens <n>        Fit --find, --lines and --symbols output into an estimated
+                          token budget, cutting at line/symbol boundaries
+  --tokenizer <path>      BPE vocabulary (tiktoken format) for --max-tokens estimates;
+                          defaults to ~4 bytes per token
   --no-line-numbers       Suppress per-line number prefixes in content output
-  --timeout <secs>        Max execution time in seconds
-  --exclude <name>        Additional exclusions (repeatable)
+  --timeout <secs>        Max execution time in seconds (per run with --watch)
+  --watch, -w             Re-run the query and emit a new result whenever a watched
+                          file changes (honors exclusions and ignore files)
+  --exclude <pattern>     Additional exclusions: names or gitignore-style patterns (repeatable);
+                          a later !pattern re-includes, also over ignore files
   --no-defaults           Disable built-in exclusions (node_modules, .git, etc.)
+  --no-ignore             Do not honor .gitignore, .ignore, .srcignore or .git/info/exclude
   --regex, -E             Treat --find pattern as a regular expression
-  --format, -F <fmt>      Output format: yaml (default) or json
+  --format, -F <fmt>      Output format: yaml (default), json, or ndjson (one JSON
+                          object per file as soon as it is ready, then a meta record);
+                          dot and mermaid render --graph as a diagram
+  --collapse-depth <n>    Fold files into their directory <n> levels below the root
+                          in dot/mermaid diagrams
+  --edge-labels           Label diagram edges with the number of imports behind them
   --json                  Shorthand for --format json
   --output, -o <path>     Write output to file instead of stdout
   --help, -h              Show this help
@@ -289,17 +663,29 @@ Examples:
   src                                             Show directory tree
   src -g *.rs                                     List all Rust files
   src -g *.ts -f "import"                         Search TypeScript files for imports
+  src -g "src/**/*.{{ts,tsx}}" "!**/*.test.ts"      List sources under src, skipping tests
   src -f "TODO|FIXME"                             Find TODOs (full file content returned)
   src -f "pub fn" --no-line-numbers               Search without line number prefixes
   src --lines "src/main.rs:1:20 src/cli.rs:18:40" Pull exact line ranges
   src --graph                                     Show dependency graph
   src --graph -g *.rs                             Rust-only dependency graph
+  src --graph --dependents src/models.rs          Everything that imports models.rs
+  src --graph --impact src/a.rs src/b.rs          Files affected by changing a.rs or b.rs
+  src --graph --cycles -g *.py                    Find circular Python imports
+  src --graph --by package                        Coupling between packages
+  src --graph --external                          Third-party packages and their users
+  src --graph --orphans                           Candidate dead files
+  src --graph -F dot --collapse-depth 2 | dot -Tsvg > deps.svg
+                                                  Architecture diagram by directory
   src -s -g *.rs                                  Extract Rust symbol declarations
   src -s -f "handle" -g *.rs                      Find symbols named "handle" in Rust files
   src -s --compact                                Ultra-compact symbol listing
   src -s --with-comments                          Symbols with doc comments
   src --callers process_file                      Find all call sites of process_file
   src --callers main -g *.rs                      Find callers scoped to Rust files
+  src --callers "get_.*" -E -i                    Callers of every get_ function, any case
+  src --call-tree save_user --depth 4             Who calls save_user, up to 4 levels up
+  src --callees handle_request                    What handle_request calls
   src --lines "src/main.rs:105:105" --auto-expand Auto-expand to full enclosing function
   src -g *.ts -f "import" -c                      Count import occurrences per file
   src --stats                                     Codebase statistics overview
@@ -308,6 +694,9 @@ Examples:
   src --symbols --json                            Symbols in JSON format
   src --stats -o stats.yaml                       Write stats to file
   src -s --with-tests                             Include test files in symbol output
+  src -g *.ts --no-ignore                         Include files hidden by .gitignore
+  src index build                                 Cache symbols and imports in .src/ for fast queries
+  src check-deps                                  Fail when imports break .src/layers.toml
 "#);
 }
 
@@ -504,6 +893,22 @@ mod tests {
         }
     }
 
+    #[test]
+    fn no_ignore_flag() {
+        match parse_args(&args(&["--no-ignore"])).unwrap() {
+            CliAction::Run(a) => assert!(a.no_ignore),
+            _ => panic!("Expected Run"),
+        }
+    }
+
+    #[test]
+    fn ignore_files_honored_by_default() {
+        match parse_args(&args(&[])).unwrap() {
+            CliAction::Run(a) => assert!(!a.no_ignore),
+            _ => panic!("Expected Run"),
+        }
+    }
+
     #[test]
     fn regex_flag() {
         match parse_args(&args(&["--f", "test", "--regex"])).unwrap() {
@@ -580,6 +985,42 @@ mod tests {
         }
     }
 
+    #[test]
+    fn graph_dependents_and_impact() {
+        match parse_args(&args(&["--graph", "--dependents", "src/models.rs"])).unwrap() {
+            CliAction::Run(a) => assert_eq!(a.dependents, Some("src/models.rs".to_owned())),
+            _ => panic!("Expected Run"),
+        }
+        match parse_args(&args(&["--graph", "--impact", "a.rs", "b.rs", "-g", "*.rs"])).unwrap() {
+            CliAction::Run(a) => {
+                assert_eq!(a.impact, vec!["a.rs", "b.rs"]);
+                assert_eq!(a.globs, vec!["*.rs"]);
+            }
+            _ => panic!("Expected Run"),
+        }
+    }
+
+    #[test]
+    fn dependents_and_impact_require_graph() {
+        assert_eq!(parse_args(&args(&["--dependents", "a.rs"])).unwrap_err(), "--dependents requires --graph");
+        assert_eq!(parse_args(&args(&["--impact", "a.rs"])).unwrap_err(), "--impact requires --graph");
+        assert!(parse_args(&args(&["--graph", "--dependents", "a.rs", "--impact", "b.rs"])).is_err());
+        assert!(parse_args(&args(&["--graph", "--impact"])).is_err());
+    }
+
+    #[test]
+    fn cycles_requires_graph_and_stands_alone() {
+        match parse_args(&args(&["--graph", "--cycles"])).unwrap() {
+            CliAction::Run(a) => assert!(a.graph && a.cycles),
+            _ => panic!("Expected Run"),
+        }
+        assert_eq!(parse_args(&args(&["--cycles"])).unwrap_err(), "--cycles requires --graph");
+        assert_eq!(
+            parse_args(&args(&["--graph", "--cycles", "--dependents", "a.rs"])).unwrap_err(),
+            "--dependents and --cycles are mutually exclusive and cannot be combined."
+        );
+    }
+
     #[test]
     fn symbols_flag() {
         match parse_args(&args(&["--symbols"])).unwrap() {
@@ -869,6 +1310,79 @@ mod tests {
         }
     }
 
+    #[test]
+    fn format_ndjson() {
+        for name in ["ndjson", "jsonl"] {
+            match parse_args(&args(&["--format", name])).unwrap() {
+                CliAction::Run(a) => assert_eq!(a.format, OutputFormatArg::Ndjson),
+                _ => panic!("Expected Run"),
+            }
+        }
+    }
+
+    #[test]
+    fn graph_by_grouping() {
+        match parse_args(&args(&["--graph", "--by", "dir"])).unwrap() {
+            CliAction::Run(a) => assert_eq!(a.by, Some(Grouping::Dir)),
+            _ => panic!("Expected Run"),
+        }
+        match parse_args(&args(&["--graph", "--by", "Package"])).unwrap() {
+            CliAction::Run(a) => assert_eq!(a.by, Some(Grouping::Package)),
+            _ => panic!("Expected Run"),
+        }
+        assert!(parse_args(&args(&["--graph", "--by", "crate"])).unwrap_err().contains("Supported: dir, package"));
+        assert_eq!(parse_args(&args(&["--by", "dir"])).unwrap_err(), "--by requires --graph");
+        assert!(parse_args(&args(&["--graph", "--by", "dir", "--cycles"])).is_err());
+    }
+
+    #[test]
+    fn external_requires_graph() {
+        match parse_args(&args(&["--graph", "--external"])).unwrap() {
+            CliAction::Run(a) => assert!(a.graph && a.external),
+            _ => panic!("Expected Run"),
+        }
+        assert_eq!(parse_args(&args(&["--external"])).unwrap_err(), "--external requires --graph");
+        assert!(parse_args(&args(&["--graph", "--external", "--cycles"])).is_err());
+    }
+
+    #[test]
+    fn orphans_requires_graph() {
+        match parse_args(&args(&["--graph", "--orphans"])).unwrap() {
+            CliAction::Run(a) => assert!(a.graph && a.orphans),
+            _ => panic!("Expected Run"),
+        }
+        assert_eq!(parse_args(&args(&["--orphans"])).unwrap_err(), "--orphans requires --graph");
+        assert_eq!(
+            parse_args(&args(&["--graph", "--external", "--orphans"])).unwrap_err(),
+            "--external and --orphans are mutually exclusive and cannot be combined."
+        );
+    }
+
+    #[test]
+    fn format_diagrams_with_graph() {
+        match parse_args(&args(&["--graph", "-F", "dot", "--collapse-depth", "2", "--edge-labels"])).unwrap() {
+            CliAction::Run(a) => {
+                assert_eq!(a.format, OutputFormatArg::Dot);
+                assert_eq!(a.collapse_depth, Some(2));
+                assert!(a.edge_labels);
+            }
+            _ => panic!("Expected Run"),
+        }
+        match parse_args(&args(&["--graph", "--format", "mermaid"])).unwrap() {
+            CliAction::Run(a) => assert_eq!(a.format, OutputFormatArg::Mermaid),
+            _ => panic!("Expected Run"),
+        }
+    }
+
+    #[test]
+    fn format_diagrams_rejected_without_plain_graph() {
+        assert!(parse_args(&args(&["-s", "-F", "dot"])).unwrap_err().contains("require --graph"));
+        assert!(parse_args(&args(&["--graph", "--cycles", "-F", "mermaid"])).unwrap_err().contains("require --graph"));
+        assert!(parse_args(&args(&["--graph", "--collapse-depth", "1"])).unwrap_err().contains("--collapse-depth requires"));
+        assert!(parse_args(&args(&["--graph", "--edge-labels", "--json"])).unwrap_err().contains("--edge-labels requires"));
+        assert!(parse_args(&args(&["--graph", "-F", "dot", "--collapse-depth", "0"])).is_err());
+    }
+
     #[test]
     fn format_short_flag() {
         match parse_args(&args(&["-F", "json"])).unwrap() {
@@ -951,6 +1465,19 @@ mod tests {
         }
     }
 
+    #[test]
+    fn ignore_case_flag() {
+        match parse_args(&args(&["--callers", "run", "-i"])).unwrap() {
+            CliAction::Run(a) => assert!(a.ignore_case),
+            _ => panic!("Expected Run"),
+        }
+        match parse_args(&args(&["--callers", "run"])).unwrap() {
+            CliAction::Run(a) => assert!(!a.ignore_case),
+            _ => panic!("Expected Run"),
+        }
+        assert_eq!(parse_args(&args(&["-f", "run", "--ignore-case"])).unwrap_err(), "--ignore-case requires --callers");
+    }
+
     #[test]
     fn missing_value_for_callers() {
         let result = parse_args(&args(&["--callers"]));
@@ -1027,6 +1554,42 @@ mod tests {
         assert!(result.unwrap_err().contains("--auto-expand requires --lines"));
     }
 
+    #[test]
+    fn call_tree_and_callees_flags() {
+        match parse_args(&args(&["--call-tree", "save", "--depth", "5"])).unwrap() {
+            CliAction::Run(a) => {
+                assert_eq!(a.call_tree, Some(("save".to_owned(), Direction::Callers)));
+                assert_eq!(a.depth, Some(5));
+            }
+            _ => panic!("Expected Run"),
+        }
+        match parse_args(&args(&["--callees", "run"])).unwrap() {
+            CliAction::Run(a) => {
+                assert_eq!(a.call_tree, Some(("run".to_owned(), Direction::Callees)));
+                assert_eq!(a.depth, None);
+            }
+            _ => panic!("Expected Run"),
+        }
+        assert_eq!(parse_args(&args(&["--call-tree"])).unwrap_err(), "Missing value for --call-tree");
+    }
+
+    #[test]
+    fn depth_requires_call_tree() {
+        assert_eq!(parse_args(&args(&["--depth", "2"])).unwrap_err(), "--depth requires --call-tree or --callees");
+        assert!(parse_args(&args(&["--call-tree", "x", "--depth", "0"])).unwrap_err().contains("positive integer"));
+        assert!(parse_args(&args(&["--callees", "x", "--depth", "two"])).unwrap_err().contains("positive integer"));
+    }
+
+    #[test]
+    fn call_tree_exclusive_with_other_modes() {
+        assert_eq!(
+            parse_args(&args(&["--call-tree", "x", "--callees", "x"])).unwrap_err(),
+            "--call-tree and --callees are mutually exclusive and cannot be combined."
+        );
+        assert!(parse_args(&args(&["--callers", "x", "--call-tree", "x"])).unwrap_err().contains("mutually exclusive"));
+        assert!(parse_args(&args(&["--callees", "x", "--symbols"])).unwrap_err().contains("mutually exclusive"));
+    }
+
     #[test]
     fn callers_exclusive_with_find() {
         let result = parse_args(&args(&["--callers", "foo", "-f", "bar"]));
@@ -1111,4 +1674,187 @@ mod tests {
             _ => panic!("Expected Run"),
         }
     }
+
+    // ── index subcommand ──
+
+    #[test]
+    fn index_build_command() {
+        match parse_args(&args(&["index", "build"])).unwrap() {
+            CliAction::Index(cmd, a) => {
+                assert_eq!(cmd, IndexCommand::Build);
+                assert!(!a.graph);
+            }
+            _ => panic!("Expected Index"),
+        }
+    }
+
+    #[test]
+    fn index_status_with_options() {
+        match parse_args(&args(&["index", "status", "-d", "/tmp", "--json", "--exclude", "vendor"])).unwrap() {
+            CliAction::Index(cmd, a) => {
+                assert_eq!(cmd, IndexCommand::Status);
+                assert_eq!(a.root, "/tmp");
+                assert_eq!(a.format, OutputFormatArg::Json);
+                assert_eq!(a.excludes, vec!["vendor"]);
+            }
+            _ => panic!("Expected Index"),
+        }
+    }
+
+    #[test]
+    fn index_clear_command() {
+        assert!(matches!(parse_args(&args(&["index", "clear"])).unwrap(), CliAction::Index(IndexCommand::Clear, _)));
+    }
+
+    #[test]
+    fn index_requires_known_command() {
+        assert!(parse_args(&args(&["index"])).unwrap_err().contains("Missing index command"));
+        assert!(parse_args(&args(&["index", "rebuild"])).unwrap_err().contains("Unknown index command"));
+    }
+
+    #[test]
+    fn index_rejects_mode_options() {
+        assert!(parse_args(&args(&["index", "build", "--graph"])).is_err());
+        assert!(parse_args(&args(&["index", "status", "-g", "*.rs"])).is_err());
+    }
+
+    #[test]
+    fn index_help() {
+        assert!(matches!(parse_args(&args(&["index", "--help"])).unwrap(), CliAction::Help));
+    }
+
+    // ── serve subcommand ──
+
+    #[test]
+    fn serve_stdio_command() {
+        match parse_args(&args(&["serve", "--stdio", "-d", "/tmp", "--exclude", "vendor"])).unwrap() {
+            CliAction::Serve(a) => {
+                assert_eq!(a.root, "/tmp");
+                assert_eq!(a.excludes, vec!["vendor"]);
+            }
+            _ => panic!("Expected Serve"),
+        }
+    }
+
+    #[test]
+    fn serve_requires_stdio() {
+        assert!(parse_args(&args(&["serve"])).unwrap_err().contains("--stdio"));
+    }
+
+    #[test]
+    fn serve_rejects_modes_and_output() {
+        assert!(parse_args(&args(&["serve", "--stdio", "--symbols"])).is_err());
+        assert!(parse_args(&args(&["serve", "--stdio", "-o", "out.json"])).is_err());
+    }
+
+    // ── mcp subcommand ──
+
+    #[test]
+    fn mcp_command() {
+        match parse_args(&args(&["mcp", "-d", "/tmp", "--no-ignore"])).unwrap() {
+            CliAction::Mcp(a) => {
+                assert_eq!(a.root, "/tmp");
+                assert!(a.no_ignore);
+            }
+            _ => panic!("Expected Mcp"),
+        }
+    }
+
+    #[test]
+    fn mcp_rejects_modes() {
+        assert!(parse_args(&args(&["mcp", "--graph"])).unwrap_err().contains("src mcp"));
+    }
+
+    // ── check-deps subcommand ──
+
+    #[test]
+    fn check_deps_command() {
+        match parse_args(&args(&["check-deps", "--rules", "layers.toml", "-g", "src/**", "--json"])).unwrap() {
+            CliAction::CheckDeps(a) => {
+                assert_eq!(a.rules.as_deref(), Some("layers.toml"));
+                assert_eq!(a.globs, vec!["src/**"]);
+                assert_eq!(a.format, OutputFormatArg::Json);
+            }
+            _ => panic!("Expected CheckDeps"),
+        }
+        assert!(matches!(parse_args(&args(&["check-deps"])).unwrap(), CliAction::CheckDeps(_)));
+    }
+
+    #[test]
+    fn check_deps_rejects_modes_and_rules_elsewhere() {
+        assert!(parse_args(&args(&["check-deps", "--graph"])).unwrap_err().contains("src check-deps"));
+        assert!(parse_args(&args(&["check-deps", "--watch"])).is_err());
+        assert!(parse_args(&args(&["--graph", "--rules", "x.toml"])).unwrap_err().contains("--rules"));
+        assert!(parse_args(&args(&["index", "build", "--rules", "x.toml"])).is_err());
+    }
+
+    // ── watch ──
+
+    #[test]
+    fn watch_flag() {
+        match parse_args(&args(&["--graph", "--watch"])).unwrap() {
+            CliAction::Run(a) => assert!(a.watch && a.graph),
+            _ => panic!("Expected Run"),
+        }
+        match parse_args(&args(&["-w"])).unwrap() {
+            CliAction::Run(a) => assert!(a.watch),
+            _ => panic!("Expected Run"),
+        }
+    }
+
+    #[test]
+    fn watch_accepts_output_but_not_subcommands() {
+        match parse_args(&args(&["--watch", "-o", "out.yaml"])).unwrap() {
+            CliAction::Run(a) => assert!(a.watch && a.output.as_deref() == Some("out.yaml")),
+            _ => panic!("Expected Run"),
+        }
+        assert!(parse_args(&args(&["serve", "--stdio", "--watch"])).is_err());
+        assert!(parse_args(&args(&["index", "build", "--watch"])).is_err());
+    }
+
+    // ── max-tokens ──
+
+    #[test]
+    fn max_tokens_with_modes() {
+        match parse_args(&args(&["-f", "x", "--max-tokens", "500", "--tokenizer", "vocab.tiktoken"])).unwrap() {
+            CliAction::Run(a) => {
+                assert_eq!(a.max_tokens, Some(500));
+                assert_eq!(a.tokenizer.as_deref(), Some("vocab.tiktoken"));
+            }
+            _ => panic!("Expected Run"),
+        }
+        assert!(parse_args(&args(&["--lines", "a.rs:1:2", "--max-tokens", "10"])).is_ok());
+        assert!(parse_args(&args(&["-s", "--max-tokens", "10"])).is_ok());
+    }
+
+    #[test]
+    fn max_tokens_rejected_elsewhere() {
+        assert!(parse_args(&args(&["--graph", "--max-tokens", "10"])).unwrap_err().contains("--max-tokens"));
+        assert!(parse_args(&args(&["-f", "x", "-c", "--max-tokens", "10"])).is_err());
+        assert!(parse_args(&args(&["-s", "--max-tokens", "lots"])).is_err());
+    }
+
+    #[test]
+    fn query_options_are_unique_and_parseable() {
+        let mut seen = std::collections::HashSet::new();
+        for opt in QUERY_OPTIONS {
+            assert!(seen.insert(opt.name), "duplicate option {}", opt.name);
+            if opt.name == "line-numbers" {
+                continue;
+            }
+            let mut argv = vec![format!("--{}", opt.name)];
+            match opt.kind {
+                OptionKind::Flag => {}
+                OptionKind::Integer => argv.push("1".into()),
+                OptionKind::Text | OptionKind::TextList => argv.push("x".into()),
+            }
+            if opt.name == "compact" || opt.name == "with-comments" || opt.name == "max-tokens" {
+                argv.push("--symbols".into());
+            }
+            if opt.name == "auto-expand" {
+                argv.extend(["--lines".to_owned(), "a.rs:1:1".to_owned()]);
+            }
+            assert!(parse_args(&argv).is_ok(), "--{} not accepted", opt.name);
+        }
+    }
 }
@@ -0,0 +1,216 @@
+use std::collections::{BTreeMap, BTreeSet};
+use std::io::{self, Write};
+
+use crate::models::GraphEntry;
+use crate::path_helper;
+
+/// Rendering options shared by the DOT and Mermaid writers.
+#[derive(Clone, Copy, Debug, Default, PartialEq)]
+pub struct DiagramOptions {
+    /// Fold files below this many directory levels into their ancestor
+    /// directory at that level.
+    pub collapse_depth: Option<usize>,
+    /// Label each edge with the number of file imports it stands for.
+    pub edge_labels: bool,
+}
+
+/// The graph after collapsing: nodes grouped by the directory that clusters
+/// them, and weighted edges between node keys.
+struct Diagram {
+    clusters: BTreeMap<String, BTreeSet<String>>,
+    edges: BTreeMap<(String, String), usize>,
+}
+
+impl Diagram {
+    fn new(graph: &[GraphEntry], options: DiagramOptions) -> Self {
+        let node = |file: &str| match options.collapse_depth {
+            Some(depth) => collapse(file, depth),
+            None => file.to_owned(),
+        };
+
+        let mut clusters: BTreeMap<String, BTreeSet<String>> = BTreeMap::new();
+        let mut edges: BTreeMap<(String, String), usize> = BTreeMap::new();
+        for entry in graph {
+            let from = node(&entry.file);
+            clusters.entry(path_helper::parent_dir(&from).to_owned()).or_default().insert(from.clone());
+            for imp in &entry.imports {
+                let to = node(imp);
+                if to != from {
+                    *edges.entry((from.clone(), to)).or_default() += 1;
+                }
+            }
+        }
+        // Imports can point at scanned files that have no entry of their own
+        // (no import parser for their language); draw those too.
+        for (_, to) in edges.keys() {
+            clusters.entry(path_helper::parent_dir(to).to_owned()).or_default().insert(to.clone());
+        }
+        Self { clusters, edges }
+    }
+}
+
+fn edge_label(weight: usize, options: DiagramOptions) -> Option<String> {
+    options.edge_labels.then(|| weight.to_string())
+}
+
+/// `src/lang/rust.rs` at depth 1 becomes `src/`; files whose directory is
+/// shallower than `depth` are kept as they are.
+fn collapse(file: &str, depth: usize) -> String {
+    let parts: Vec<&str> = file.split('/').collect();
+    if depth == 0 || parts.len() - 1 < depth {
+        return file.to_owned();
+    }
+    format!("{}/", parts[..depth].join("/"))
+}
+
+/// The node's name within its cluster.
+fn short_name(node: &str) -> &str {
+    let dir = path_helper::parent_dir(node);
+    if dir.is_empty() { node } else { &node[dir.len() + 1..] }
+}
+
+pub fn write_dot(w: &mut impl Write, graph: &[GraphEntry], options: DiagramOptions) -> io::Result<()> {
+    let diagram = Diagram::new(graph, options);
+    write!(w, "digraph dependencies {{\n")?;
+    write!(w, "  rankdir=LR;\n")?;
+    write!(w, "  node [shape=box, fontsize=10];\n")?;
+    for (dir, nodes) in &diagram.clusters {
+        let indent = if dir.is_empty() { "  " } else { "    " };
+        if !dir.is_empty() {
+            write!(w, "  subgraph {} {{\n", dot_id(&format!("cluster_{}", dir)))?;
+            write!(w, "    label={};\n", dot_id(dir))?;
+        }
+        for node in nodes {
+            write!(w, "{}{} [label={}];\n", indent, dot_id(node), dot_id(short_name(node)))?;
+        }
+        if !dir.is_empty() {
+            write!(w, "  }}\n")?;
+        }
+    }
+    for ((from, to), &weight) in &diagram.edges {
+        write!(w, "  {} -> {}", dot_id(from), dot_id(to))?;
+        if let Some(label) = edge_label(weight, options) {
+            write!(w, " [label={}]", dot_id(&label))?;
+        }
+        write!(w, ";\n")?;
+    }
+    write!(w, "}}\n")
+}
+
+pub fn write_mermaid(w: &mut impl Write, graph: &[GraphEntry], options: DiagramOptions) -> io::Result<()> {
+    let diagram = Diagram::new(graph, options);
+    // Mermaid ids cannot contain most path characters, so nodes are numbered.
+    let mut ids: BTreeMap<&str, usize> = BTreeMap::new();
+    write!(w, "flowchart LR\n")?;
+    for (n, (dir, nodes)) in diagram.clusters.iter().enumerate() {
+        let indent = if dir.is_empty() { "  " } else { "    " };
+        if !dir.is_empty() {
+            write!(w, "  subgraph d{}[{}]\n", n, mermaid_text(dir))?;
+        }
+        for node in nodes {
+            let id = ids.len();
+            ids.insert(node, id);
+            write!(w, "{}n{}[{}]\n", indent, id, mermaid_text(short_name(node)))?;
+        }
+        if !dir.is_empty() {
+            write!(w, "  end\n")?;
+        }
+    }
+    for ((from, to), &weight) in &diagram.edges {
+        match edge_label(weight, options) {
+            Some(label) => write!(w, "  n{} -->|{}| n{}\n", ids[from.as_str()], mermaid_text(&label), ids[to.as_str()])?,
+            None => write!(w, "  n{} --> n{}\n", ids[from.as_str()], ids[to.as_str()])?,
+        }
+    }
+    Ok(())
+}
+
+fn dot_id(s: &str) -> String {
+    format!("\"{}\"", s.replace('\\', "\\\\").replace('"', "\\\""))
+}
+
+fn mermaid_text(s: &str) -> String {
+    format!("\"{}\"", s.replace('"', "#quot;"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::graph_entry;
+
+    fn sample() -> Vec<GraphEntry> {
+        vec![
+            graph_entry("main.rs", &["src/cli.rs", "src/lang/mod.rs"]),
+            graph_entry("src/cli.rs", &["src/lang/mod.rs", "src/lang/rust.rs"]),
+            graph_entry("src/lang/mod.rs", &["src/lang/rust.rs"]),
+            graph_entry("src/lang/rust.rs", &[]),
+        ]
+    }
+
+    fn render(f: fn(&mut Vec<u8>, &[GraphEntry], DiagramOptions) -> io::Result<()>, options: DiagramOptions) -> String {
+        let mut buf = Vec::new();
+        f(&mut buf, &sample(), options).unwrap();
+        String::from_utf8(buf).unwrap()
+    }
+
+    // ── collapsing ──
+
+    #[test]
+    fn collapse_folds_deep_files_only() {
+        assert_eq!(collapse("src/lang/rust.rs", 1), "src/");
+        assert_eq!(collapse("src/lang/rust.rs", 2), "src/lang/");
+        assert_eq!(collapse("src/cli.rs", 2), "src/cli.rs");
+        assert_eq!(collapse("main.rs", 1), "main.rs");
+    }
+
+    #[test]
+    fn short_name_within_cluster() {
+        assert_eq!(short_name("src/lang/"), "lang/");
+        assert_eq!(short_name("main.rs"), "main.rs");
+    }
+
+    #[test]
+    fn collapsed_edges_are_weighted_and_skip_self_loops() {
+        let diagram = Diagram::new(&sample(), DiagramOptions { collapse_depth: Some(1), edge_labels: true });
+        let edges: Vec<(&str, &str, usize)> =
+            diagram.edges.iter().map(|((f, t), &n)| (f.as_str(), t.as_str(), n)).collect();
+        assert_eq!(edges, vec![("main.rs", "src/", 2)]);
+    }
+
+    // ── DOT ──
+
+    #[test]
+    fn dot_clusters_by_directory() {
+        let s = render(write_dot, DiagramOptions::default());
+        assert!(s.starts_with("digraph dependencies {\n"));
+        assert!(s.contains("  \"main.rs\" [label=\"main.rs\"];\n"));
+        assert!(s.contains("  subgraph \"cluster_src/lang\" {\n    label=\"src/lang\";\n    \"src/lang/mod.rs\" [label=\"mod.rs\"];\n"));
+        assert!(s.contains("  \"src/cli.rs\" -> \"src/lang/rust.rs\";\n"));
+        assert!(s.ends_with("}\n"));
+    }
+
+    #[test]
+    fn dot_edge_labels_after_collapse() {
+        let s = render(write_dot, DiagramOptions { collapse_depth: Some(2), edge_labels: true });
+        assert!(s.contains("    \"src/lang/\" [label=\"lang/\"];\n"));
+        assert!(s.contains("  \"src/cli.rs\" -> \"src/lang/\" [label=\"2\"];\n"));
+        assert!(s.contains("  \"main.rs\" -> \"src/lang/\" [label=\"1\"];\n"));
+    }
+
+    // ── Mermaid ──
+
+    #[test]
+    fn mermaid_numbers_nodes_inside_subgraphs() {
+        let s = render(write_mermaid, DiagramOptions::default());
+        assert_eq!(
+            s,
+            "flowchart LR\n  n0[\"main.rs\"]\n  subgraph d1[\"src\"]\n    n1[\"cli.rs\"]\n  end\n  subgraph d2[\"src/lang\"]\n    n2[\"mod.rs\"]\n    n3[\"rust.rs\"]\n  end\n  n0 --> n1\n  n0 --> n2\n  n1 --> n2\n  n1 --> n3\n  n2 --> n3\n"
+        );
+    }
+
+    #[test]
+    fn mermaid_edge_labels() {
+        let s = render(write_mermaid, DiagramOptions { collapse_depth: Some(1), edge_labels: true });
+        assert!(s.contains("  n0 -->|\"2\"| n1\n"));
+    }
+}
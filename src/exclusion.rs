@@ -1,14 +1,139 @@
 use std::collections::HashSet;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+
+use crate::glob;
 
 const DEFAULT_EXCLUSIONS: &[&str] = &[
     "node_modules", ".git", "bin", "obj", "dist", ".vs",
     "__pycache__", ".idea", ".vscode", ".svn", ".hg",
     "coverage", ".next", ".nuxt", "target", "build",
-    "packages", ".cache", ".output", ".parcel-cache",
+    "packages", ".cache", ".output", ".parcel-cache", ".src",
 ];
 
+/// Ignore files read from every scanned directory, lowest precedence first.
+const IGNORE_FILES: &[&str] = &[".gitignore", ".ignore", ".srcignore"];
+
+/// A single gitignore-style rule.
+struct IgnoreRule {
+    pattern: String,
+    negated: bool,
+    dir_only: bool,
+    /// Rules from ignore files compare case like git does; `--exclude`
+    /// patterns stay case-insensitive.
+    exact_case: bool,
+}
+
+impl IgnoreRule {
+    fn parse(line: &str) -> Option<Self> {
+        let line = line.trim_end();
+        if line.is_empty() || line.starts_with('#') {
+            return None;
+        }
+
+        let (negated, body) = match line.strip_prefix('!') {
+            Some(rest) => (true, rest),
+            None => (false, line),
+        };
+        let body = body.strip_prefix('\\').filter(|b| b.starts_with('#') || b.starts_with('!')).unwrap_or(body);
+
+        let (dir_only, body) = match body.strip_suffix('/') {
+            Some(rest) => (true, rest),
+            None => (false, body),
+        };
+        if body.is_empty() {
+            return None;
+        }
+
+        let pattern = if let Some(anchored) = body.strip_prefix('/') {
+            anchored.to_owned()
+        } else if body.contains('/') {
+            body.to_owned()
+        } else {
+            format!("**/{}", body)
+        };
+
+        Some(Self { pattern, negated, dir_only, exact_case: false })
+    }
+
+    fn matches(&self, path: &str, is_dir: bool) -> bool {
+        if self.dir_only && !is_dir {
+            return false;
+        }
+        if self.exact_case {
+            glob::matches_path_exact(path, &self.pattern)
+        } else {
+            glob::matches_path(path, &self.pattern)
+        }
+    }
+}
+
+/// Rules contributed by the ignore files of one directory.
+struct IgnoreLayer {
+    /// Directory (relative to the scan root) the rules were read from.
+    base: String,
+    /// For ignore files above the scan root: the path from their directory
+    /// down to the scan root, prepended before matching.
+    outer: String,
+    rules: Vec<IgnoreRule>,
+    parent: Option<Arc<IgnoreLayer>>,
+}
+
+/// The ignore rules in effect for one directory of the walk. Cheap to clone;
+/// child directories share their ancestors' layers.
+#[derive(Clone, Default)]
+pub struct IgnoreStack {
+    top: Option<Arc<IgnoreLayer>>,
+}
+
+impl IgnoreStack {
+    fn push(&self, base: String, outer: String, rules: Vec<IgnoreRule>) -> Self {
+        if rules.is_empty() {
+            return self.clone();
+        }
+        Self {
+            top: Some(Arc::new(IgnoreLayer { base, outer, rules, parent: self.top.clone() })),
+        }
+    }
+
+    /// Last matching rule wins, with deeper ignore files taking precedence
+    /// over shallower ones. Returns `None` when no rule mentions the path.
+    fn decide(&self, rel: &str, is_dir: bool) -> Option<bool> {
+        let mut layer = self.top.as_deref();
+        while let Some(l) = layer {
+            let candidate: std::borrow::Cow<str> = if !l.outer.is_empty() {
+                format!("{}/{}", l.outer, rel).into()
+            } else if l.base.is_empty() {
+                rel.into()
+            } else {
+                match rel.strip_prefix(l.base.as_str()).and_then(|r| r.strip_prefix('/')) {
+                    Some(r) => r.into(),
+                    None => {
+                        layer = l.parent.as_deref();
+                        continue;
+                    }
+                }
+            };
+            for rule in l.rules.iter().rev() {
+                if rule.matches(&candidate, is_dir) {
+                    return Some(!rule.negated);
+                }
+            }
+            layer = l.parent.as_deref();
+        }
+        None
+    }
+}
+
+/// Whether a file named `name` holds ignore rules for its directory.
+pub fn is_ignore_file(name: &str) -> bool {
+    IGNORE_FILES.contains(&name)
+}
+
 pub struct ExclusionFilter {
     exclusions: HashSet<Box<str>>,
+    patterns: Vec<IgnoreRule>,
+    use_ignore_files: bool,
 }
 
 impl ExclusionFilter {
@@ -19,21 +144,119 @@ impl ExclusionFilter {
                 exclusions.insert(name.to_ascii_lowercase().into_boxed_str());
             }
         }
+        let mut patterns = Vec::new();
         for name in additional {
-            exclusions.insert(name.to_ascii_lowercase().into_boxed_str());
+            if !name.starts_with('!') {
+                exclusions.insert(name.to_ascii_lowercase().into_boxed_str());
+            }
+            if let Some(rule) = IgnoreRule::parse(name) {
+                patterns.push(rule);
+            }
         }
-        Self { exclusions }
+        Self { exclusions, patterns, use_ignore_files: false }
+    }
+
+    /// Enables `.gitignore`, `.ignore`, `.srcignore` and `.git/info/exclude`
+    /// handling during directory walks.
+    pub fn with_ignore_files(mut self, enabled: bool) -> Self {
+        self.use_ignore_files = enabled;
+        self
     }
 
     pub fn is_excluded(&self, name: &str) -> bool {
         let lowered = name.to_ascii_lowercase();
         self.exclusions.contains(lowered.as_str())
     }
+
+    /// Returns true when `rel` (relative to the scan root, `/`-separated)
+    /// is removed by `--exclude` patterns or by the ignore files in `stack`.
+    /// `--exclude` patterns act as the deepest layer: the last matching one
+    /// wins, so `!pattern` re-includes what an ignore file drops.
+    pub fn is_ignored(&self, stack: &IgnoreStack, rel: &str, is_dir: bool) -> bool {
+        if let Some(rule) = self.patterns.iter().rev().find(|p| p.matches(rel, is_dir)) {
+            return !rule.negated;
+        }
+        stack.decide(rel, is_dir).unwrap_or(false)
+    }
+
+    /// Builds the ignore stack for the scan root: ignore files in directories
+    /// between the enclosing repository root and the scan root (including
+    /// that repository's `.git/info/exclude`), then the scan root's own files.
+    pub fn root_stack(&self, root: &Path) -> IgnoreStack {
+        let mut stack = IgnoreStack::default();
+        if !self.use_ignore_files {
+            return stack;
+        }
+
+        let abs_root = root.canonicalize().unwrap_or_else(|_| root.to_path_buf());
+        for dir in repository_ancestors(&abs_root) {
+            let outer = crate::path_helper::normalized_relative(&dir, &abs_root);
+            stack = stack.push(String::new(), outer, read_dir_rules(&dir));
+        }
+        stack.push(String::new(), String::new(), read_dir_rules(root))
+    }
+
+    /// Extends `parent` with the ignore files found in `dir`.
+    pub fn enter_dir(&self, parent: &IgnoreStack, dir: &Path, rel: &str) -> IgnoreStack {
+        if !self.use_ignore_files {
+            return parent.clone();
+        }
+        parent.push(rel.to_owned(), String::new(), read_dir_rules(dir))
+    }
+}
+
+/// Directories strictly above `root` whose ignore files apply to it, outermost
+/// first. Empty unless `root` sits below a directory containing `.git`.
+fn repository_ancestors(root: &Path) -> Vec<PathBuf> {
+    if root.join(".git").is_dir() {
+        return vec![];
+    }
+    let mut dirs = Vec::new();
+    let mut current = root.parent();
+    while let Some(dir) = current {
+        dirs.push(dir.to_path_buf());
+        if dir.join(".git").is_dir() {
+            dirs.reverse();
+            return dirs;
+        }
+        current = dir.parent();
+    }
+    Vec::new()
+}
+
+fn read_dir_rules(dir: &Path) -> Vec<IgnoreRule> {
+    let mut rules = Vec::new();
+    for name in IGNORE_FILES {
+        rules.extend(read_rules(&dir.join(name)));
+    }
+    if dir.join(".git").is_dir() {
+        let mut exclude = read_rules(&dir.join(".git").join("info").join("exclude"));
+        exclude.extend(rules);
+        return exclude;
+    }
+    rules
+}
+
+fn read_rules(path: &Path) -> Vec<IgnoreRule> {
+    match std::fs::read_to_string(path) {
+        Ok(content) => content
+            .lines()
+            .filter_map(IgnoreRule::parse)
+            .map(|rule| IgnoreRule { exact_case: true, ..rule })
+            .collect(),
+        Err(_) => Vec::new(),
+    }
 }
 
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::test_support::temp_project;
+
+    fn stack_from(lines: &[&str]) -> IgnoreStack {
+        let rules = lines.iter().filter_map(|l| IgnoreRule::parse(l)).collect();
+        IgnoreStack::default().push(String::new(), String::new(), rules)
+    }
 
     #[test]
     fn default_exclusions_are_applied() {
@@ -92,4 +315,113 @@ mod tests {
         assert!(filter.is_excluded(&long_name));
         assert!(filter.is_excluded(&long_name.to_uppercase()));
     }
+
+    #[test]
+    fn exclude_accepts_patterns() {
+        let filter = ExclusionFilter::new(&["*.generated.cs".to_owned(), "docs/legacy/".to_owned()], true);
+        let stack = IgnoreStack::default();
+        assert!(filter.is_ignored(&stack, "src/Models.generated.cs", false));
+        assert!(filter.is_ignored(&stack, "docs/legacy", true));
+        assert!(!filter.is_ignored(&stack, "docs/legacy", false));
+        assert!(!filter.is_ignored(&stack, "src/Models.cs", false));
+    }
+
+    #[test]
+    fn negated_exclude_reincludes() {
+        let filter = ExclusionFilter::new(&["*.gen.ts".to_owned(), "!keep.gen.ts".to_owned(), "!*.tmp".to_owned()], true);
+        assert!(!filter.is_excluded("!keep.gen.ts"));
+        let stack = stack_from(&["*.tmp"]);
+        assert!(filter.is_ignored(&stack, "src/a.gen.ts", false));
+        assert!(!filter.is_ignored(&stack, "src/keep.gen.ts", false));
+        assert!(!filter.is_ignored(&stack, "scratch.tmp", false));
+    }
+
+    #[test]
+    fn ignore_file_rules_match_case_exactly() {
+        let root = temp_project("exclusion_case", &[(".gitignore", "TAGS\nBuild/\n")]);
+        let filter = ExclusionFilter::new(&["*.LOG".to_owned()], true).with_ignore_files(true);
+        let stack = filter.root_stack(&root);
+        assert!(filter.is_ignored(&stack, "TAGS", false));
+        assert!(filter.is_ignored(&stack, "Build", true));
+        assert!(!filter.is_ignored(&stack, "tags", true));
+        assert!(!filter.is_ignored(&stack, "build", true));
+        assert!(filter.is_ignored(&stack, "debug.log", false));
+        std::fs::remove_dir_all(&root).ok();
+    }
+
+    #[test]
+    fn rule_skips_comments_and_blanks() {
+        assert!(IgnoreRule::parse("# comment").is_none());
+        assert!(IgnoreRule::parse("   ").is_none());
+        assert!(IgnoreRule::parse("\\#literal").is_some());
+    }
+
+    #[test]
+    fn unanchored_rule_matches_any_depth() {
+        let stack = stack_from(&["*.log"]);
+        assert_eq!(stack.decide("debug.log", false), Some(true));
+        assert_eq!(stack.decide("a/b/debug.log", false), Some(true));
+        assert_eq!(stack.decide("a/b/debug.txt", false), None);
+    }
+
+    #[test]
+    fn anchored_rule_matches_from_base() {
+        let stack = stack_from(&["/generated", "docs/out"]);
+        assert_eq!(stack.decide("generated", true), Some(true));
+        assert_eq!(stack.decide("src/generated", true), None);
+        assert_eq!(stack.decide("docs/out", true), Some(true));
+        assert_eq!(stack.decide("x/docs/out", true), None);
+    }
+
+    #[test]
+    fn dir_only_rule_ignores_files() {
+        let stack = stack_from(&["scratch/"]);
+        assert_eq!(stack.decide("scratch", true), Some(true));
+        assert_eq!(stack.decide("scratch", false), None);
+    }
+
+    #[test]
+    fn negation_reincludes() {
+        let stack = stack_from(&["*.gen.ts", "!keep.gen.ts"]);
+        assert_eq!(stack.decide("a.gen.ts", false), Some(true));
+        assert_eq!(stack.decide("keep.gen.ts", false), Some(false));
+    }
+
+    #[test]
+    fn double_star_rules() {
+        let stack = stack_from(&["**/fixtures/**/*.snap", "vendor/**"]);
+        assert_eq!(stack.decide("tests/fixtures/a/b.snap", false), Some(true));
+        assert_eq!(stack.decide("fixtures/b.snap", false), Some(true));
+        assert_eq!(stack.decide("vendor/lib/x.go", false), Some(true));
+    }
+
+    #[test]
+    fn nested_layer_overrides_parent() {
+        let root = stack_from(&["*.tmp"]);
+        let child = root.push("sub".to_owned(), String::new(), vec![IgnoreRule::parse("!keep.tmp").unwrap()]);
+        assert_eq!(child.decide("sub/keep.tmp", false), Some(false));
+        assert_eq!(child.decide("sub/other.tmp", false), Some(true));
+        assert_eq!(child.decide("keep.tmp", false), Some(true));
+    }
+
+    #[test]
+    fn nested_layer_rules_are_relative_to_their_dir() {
+        let child = IgnoreStack::default().push("pkg".to_owned(), String::new(), vec![IgnoreRule::parse("/out").unwrap()]);
+        assert_eq!(child.decide("pkg/out", true), Some(true));
+        assert_eq!(child.decide("out", true), None);
+    }
+
+    #[test]
+    fn outer_layer_prefixes_root_path() {
+        let stack = IgnoreStack::default().push(String::new(), "services/api".to_owned(), vec![IgnoreRule::parse("services/api/tmp").unwrap()]);
+        assert_eq!(stack.decide("tmp", true), Some(true));
+        assert_eq!(stack.decide("src", true), None);
+    }
+
+    #[test]
+    fn ignore_files_disabled_yields_empty_stack() {
+        let filter = ExclusionFilter::new(&[], false);
+        let stack = filter.root_stack(Path::new("."));
+        assert!(stack.top.is_none());
+    }
 }
@@ -0,0 +1,161 @@
+use std::collections::BTreeMap;
+
+use crate::graph::Project;
+use crate::models::{ExternalFile, ExternalOutput, ExternalPackage, GraphEntry};
+
+/// Marks a raw import of a third-party package:
+/// `external:<ecosystem>:<package>`, optionally followed by `|`-separated
+/// candidates. When one of those resolves the import was internal after all
+/// (a local module shadowing the package name, an alias, a workspace
+/// module) and is dropped.
+pub const EXTERNAL_PREFIX: &str = "external:";
+
+/// Builds the marker for `package`. Candidates are alias or layout-relative
+/// imports (`py:utils/`, `jvm:com/acme/util/`) and resolve the way graph
+/// imports do; a candidate ending in `/` stands for a directory.
+pub fn marker(ecosystem: &str, package: &str, candidates: &[String]) -> String {
+    let mut s = format!("{}{}:{}", EXTERNAL_PREFIX, ecosystem, package);
+    for c in candidates {
+        s.push('|');
+        s.push_str(c);
+    }
+    s
+}
+
+struct Marker<'a> {
+    ecosystem: &'a str,
+    package: &'a str,
+    candidates: Vec<&'a str>,
+}
+
+fn parse(raw: &str) -> Option<Marker<'_>> {
+    let rest = raw.strip_prefix(EXTERNAL_PREFIX)?;
+    let mut parts = rest.split('|');
+    let (ecosystem, package) = parts.next()?.split_once(':')?;
+    Some(Marker { ecosystem, package, candidates: parts.collect() })
+}
+
+/// Classifies the external markers of every graph entry and builds the
+/// per-file lists next to a repo-wide inventory, most used packages first.
+pub fn report(graph: Vec<GraphEntry>, project: &Project) -> ExternalOutput {
+    let mut packages: BTreeMap<(String, String), Vec<String>> = BTreeMap::new();
+    let mut files = Vec::with_capacity(graph.len());
+    for entry in graph {
+        let mut used: Vec<String> = Vec::new();
+        for m in entry.external.iter().filter_map(|raw| parse(raw)) {
+            if m.candidates.iter().any(|c| project.provides(&entry.file, c)) {
+                continue;
+            }
+            let users = packages.entry((m.ecosystem.to_owned(), m.package.to_owned())).or_default();
+            if users.last() != Some(&entry.file) {
+                users.push(entry.file.clone());
+            }
+            if !used.iter().any(|p| p == m.package) {
+                used.push(m.package.to_owned());
+            }
+        }
+        used.sort_unstable();
+        files.push(ExternalFile { file: entry.file, imports: entry.imports, packages: used });
+    }
+
+    let mut packages: Vec<ExternalPackage> = packages
+        .into_iter()
+        .map(|((ecosystem, name), files)| ExternalPackage { name, ecosystem, files })
+        .collect();
+    packages.sort_by(|a, b| b.files.len().cmp(&a.files.len()).then_with(|| a.name.cmp(&b.name)));
+    ExternalOutput { files, packages }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::path::Path;
+
+    use super::*;
+    use crate::alias::AliasMapping;
+    use crate::test_support::graph_entry;
+
+    fn project<'a>(graph: &[GraphEntry], aliases: &'a [AliasMapping]) -> Project<'a> {
+        Project::new(Path::new("."), graph.iter().map(|e| e.file.clone()).collect(), aliases)
+    }
+
+    fn entry(file: &str, imports: &[&str], external: &[String]) -> GraphEntry {
+        GraphEntry { external: external.to_vec(), ..graph_entry(file, imports) }
+    }
+
+    #[test]
+    fn marker_round_trips() {
+        let raw = marker("pypi", "requests", &["requests.py".to_owned(), "requests/".to_owned()]);
+        assert_eq!(raw, "external:pypi:requests|requests.py|requests/");
+        let m = parse(&raw).unwrap();
+        assert_eq!((m.ecosystem, m.package), ("pypi", "requests"));
+        assert_eq!(m.candidates, vec!["requests.py", "requests/"]);
+        assert!(parse("src/a.rs").is_none());
+    }
+
+    #[test]
+    fn report_builds_inventory_most_used_first() {
+        let graph = vec![
+            entry("src/a.ts", &["src/b.ts"], &[marker("npm", "react", &[]), marker("npm", "lodash", &[]), marker("npm", "react", &[])]),
+            entry("src/b.ts", &[], &[marker("npm", "react", &[])]),
+            entry("src/c.ts", &[], &[]),
+        ];
+        let project = project(&graph, &[]);
+        let out = report(graph, &project);
+        let files: Vec<(&str, Vec<String>)> = out.files.iter().map(|f| (f.file.as_str(), f.packages.clone())).collect();
+        assert_eq!(files, vec![
+            ("src/a.ts", vec!["lodash".to_owned(), "react".to_owned()]),
+            ("src/b.ts", vec!["react".to_owned()]),
+            ("src/c.ts", vec![]),
+        ]);
+        assert_eq!(out.files[0].imports, vec!["src/b.ts"]);
+        let packages: Vec<(&str, &str, usize)> = out.packages.iter().map(|p| (p.name.as_str(), p.ecosystem.as_str(), p.files.len())).collect();
+        assert_eq!(packages, vec![("react", "npm", 2), ("lodash", "npm", 1)]);
+    }
+
+    #[test]
+    fn project_modules_shadow_package_names() {
+        let graph = vec![
+            entry("app/main.py", &[], &[
+                marker("pypi", "utils", &["py:utils.py".to_owned(), "py:utils/".to_owned()]),
+                marker("pypi", "requests", &["py:requests.py".to_owned(), "py:requests/".to_owned()]),
+            ]),
+            entry("app/utils/__init__.py", &[], &[]),
+            entry("lib/models/user.rb", &[], &[marker("rubygems", "models", &["rb:models/user.rb".to_owned()])]),
+        ];
+        let project = project(&graph, &[]);
+        let out = report(graph, &project);
+        let names: Vec<&str> = out.packages.iter().map(|p| p.name.as_str()).collect();
+        assert_eq!(names, vec!["requests"]);
+    }
+
+    #[test]
+    fn jvm_packages_with_sources_under_a_source_root_are_local() {
+        let graph = vec![
+            entry("src/main/java/com/acme/App.java", &[], &[
+                marker("maven", "com.acme.util", &["jvm:com/acme/util/".to_owned()]),
+                marker("maven", "org.slf4j", &["jvm:org/slf4j/".to_owned()]),
+            ]),
+            entry("src/main/java/com/acme/util/Strings.java", &[], &[]),
+        ];
+        let project = project(&graph, &[]);
+        let out = report(graph, &project);
+        let names: Vec<&str> = out.packages.iter().map(|p| p.name.as_str()).collect();
+        assert_eq!(names, vec!["org.slf4j"]);
+    }
+
+    #[test]
+    fn alias_candidates_resolve_through_aliases() {
+        let aliases = vec![AliasMapping { prefix: "@app/".to_owned(), targets: vec!["src/".to_owned()] }];
+        let graph = vec![
+            entry("src/main.ts", &[], &[
+                marker("npm", "@app/core", &["alias:@app/core".to_owned()]),
+                marker("npm", "@tanstack/query", &["alias:@tanstack/query".to_owned()]),
+            ]),
+            entry("src/core.ts", &[], &[]),
+        ];
+        let project = project(&graph, &aliases);
+        let out = report(graph, &project);
+        let names: Vec<&str> = out.packages.iter().map(|p| p.name.as_str()).collect();
+        assert_eq!(names, vec!["@tanstack/query"]);
+    }
+}
@@ -1,18 +1,68 @@
+use std::collections::HashMap;
 use std::fs::File;
 use std::io::{BufReader, Read};
-use std::path::Path;
+use std::path::{Path, PathBuf};
+use std::sync::{Mutex, OnceLock};
+use std::time::SystemTime;
 
 use memmap2::Mmap;
 
 pub const MMAP_THRESHOLD: u64 = 64 * 1024;
 pub const BINARY_CHECK_SIZE: usize = 8192;
 
+struct CachedFile {
+    modified: Option<SystemTime>,
+    len: u64,
+    content: String,
+}
+
+struct ContentCache {
+    files: HashMap<PathBuf, CachedFile>,
+    bytes: usize,
+    max_bytes: usize,
+}
+
+static CACHE: OnceLock<Mutex<ContentCache>> = OnceLock::new();
+
+/// Keeps up to `max_bytes` of file contents in memory for long-running modes.
+/// Cached entries are revalidated against size and mtime on every read.
+pub fn enable_cache(max_bytes: usize) {
+    let _ = CACHE.set(Mutex::new(ContentCache { files: HashMap::new(), bytes: 0, max_bytes }));
+}
+
 pub fn read_file(path: &Path) -> Result<Option<String>, String> {
     let metadata = std::fs::metadata(path).map_err(|e| e.to_string())?;
     if metadata.len() == 0 {
         return Ok(None);
     }
-    if metadata.len() >= MMAP_THRESHOLD {
+
+    let cache = match CACHE.get() {
+        Some(c) => c,
+        None => return read_uncached(path, metadata.len()),
+    };
+    let modified = metadata.modified().ok();
+    if let Some(hit) = cache.lock().unwrap().files.get(path) {
+        if hit.modified == modified && hit.len == metadata.len() {
+            return Ok(Some(hit.content.clone()));
+        }
+    }
+
+    let result = read_uncached(path, metadata.len())?;
+    if let Some(ref content) = result {
+        let mut cache = cache.lock().unwrap();
+        if let Some(old) = cache.files.remove(path) {
+            cache.bytes -= old.content.len();
+        }
+        if cache.bytes + content.len() <= cache.max_bytes {
+            cache.bytes += content.len();
+            cache.files.insert(path.to_path_buf(), CachedFile { modified, len: metadata.len(), content: content.clone() });
+        }
+    }
+    Ok(result)
+}
+
+fn read_uncached(path: &Path, len: u64) -> Result<Option<String>, String> {
+    if len >= MMAP_THRESHOLD {
         read_file_mmap(path)
     } else {
         read_file_buffered(path)
@@ -1,12 +1,7 @@
-/// Simple glob matching (case-insensitive) supporting `*` and `?`.
-/// This matches the behavior of .NET's `FileSystemName.MatchesSimpleExpression`.
+/// Glob matching (case-insensitive) against a single file name, supporting
+/// `*`, `?` and `[...]` character classes.
 pub fn matches(name: &str, pattern: &str) -> bool {
-    glob_match(
-        name.as_bytes(),
-        pattern.as_bytes(),
-        0,
-        0,
-    )
+    path_match(name.as_bytes(), pattern.as_bytes(), false)
 }
 
 pub fn matches_any(name: &str, patterns: &[String]) -> bool {
@@ -18,44 +13,251 @@ pub fn matches_any(name: &str, patterns: &[String]) -> bool {
     false
 }
 
-fn glob_match(name: &[u8], pattern: &[u8], mut ni: usize, mut pi: usize) -> bool {
-    let mut star_pi = usize::MAX;
-    let mut star_ni = 0;
+/// A compiled set of `--glob` patterns.
+///
+/// Patterns without a `/` match the file name; patterns with a `/` match the
+/// path relative to the scan root, where `*` stays within one directory and
+/// `**` crosses directories. `{a,b}` alternation is expanded up front and a
+/// leading `!` turns a pattern into an exclusion. A pattern that equals the
+/// path literally always matches, so bracketed names like `[slug].astro`
+/// keep working.
+pub struct GlobSet {
+    name_patterns: Vec<String>,
+    path_patterns: Vec<String>,
+    excludes: Vec<String>,
+}
+
+impl GlobSet {
+    pub fn new(patterns: &[String]) -> Self {
+        let mut name_patterns = Vec::new();
+        let mut path_patterns = Vec::new();
+        let mut excludes = Vec::new();
+
+        for raw in patterns {
+            let (negated, body) = match raw.strip_prefix('!') {
+                Some(rest) => (true, rest),
+                None => (false, raw.as_str()),
+            };
+            for expanded in expand_braces(body) {
+                let pattern = normalize_pattern(&expanded);
+                if pattern.is_empty() {
+                    continue;
+                }
+                if negated {
+                    excludes.push(pattern);
+                } else if pattern.contains('/') {
+                    path_patterns.push(pattern);
+                } else {
+                    name_patterns.push(pattern);
+                }
+            }
+        }
+
+        // Only exclusions: everything else is included.
+        if name_patterns.is_empty() && path_patterns.is_empty() {
+            name_patterns.push("*".to_owned());
+        }
 
-    while ni < name.len() {
+        Self { name_patterns, path_patterns, excludes }
+    }
+
+    /// `name` is the file name, `rel` the `/`-separated path relative to the root.
+    pub fn is_match(&self, name: &str, rel: &str) -> bool {
+        let included = matches_any(name, &self.name_patterns)
+            || self.name_patterns.iter().any(|p| p.eq_ignore_ascii_case(name))
+            || self.path_patterns.iter().any(|p| path_or_literal(rel, p));
+        included && !self.excludes.iter().any(|p| {
+            if p.contains('/') { path_or_literal(rel, p) } else { matches(name, p) }
+        })
+    }
+}
+
+fn path_or_literal(rel: &str, pattern: &str) -> bool {
+    matches_path(rel, pattern) || rel.eq_ignore_ascii_case(pattern)
+}
+
+fn normalize_pattern(pattern: &str) -> String {
+    let pattern = if cfg!(windows) { pattern.replace('\\', "/") } else { pattern.to_owned() };
+    match pattern.strip_prefix("./") {
+        Some(rest) => rest.to_owned(),
+        None => pattern.trim_start_matches('/').to_owned(),
+    }
+}
+
+/// Expands `{a,b}` alternation (nesting allowed) into plain patterns.
+/// Unbalanced braces are kept literally.
+pub fn expand_braces(pattern: &str) -> Vec<String> {
+    let bytes = pattern.as_bytes();
+    let open = match bytes.iter().position(|&b| b == b'{') {
+        Some(p) => p,
+        None => return vec![pattern.to_owned()],
+    };
+
+    let mut depth = 0;
+    let mut splits = Vec::new();
+    let mut close = None;
+    for (i, &b) in bytes.iter().enumerate().skip(open) {
+        match b {
+            b'{' => depth += 1,
+            b'}' => {
+                depth -= 1;
+                if depth == 0 {
+                    close = Some(i);
+                    break;
+                }
+            }
+            b',' if depth == 1 => splits.push(i),
+            _ => {}
+        }
+    }
+
+    let close = match close {
+        Some(c) => c,
+        None => return vec![pattern.to_owned()],
+    };
+
+    let prefix = &pattern[..open];
+    let suffix = &pattern[close + 1..];
+    let mut bounds = vec![open];
+    bounds.extend(splits);
+    bounds.push(close);
+
+    let mut results = Vec::new();
+    for pair in bounds.windows(2) {
+        let alternative = &pattern[pair[0] + 1..pair[1]];
+        for expanded in expand_braces(&format!("{}{}{}", prefix, alternative, suffix)) {
+            results.push(expanded);
+        }
+    }
+    results
+}
+
+/// Path-aware glob matching (case-insensitive) in gitignore style.
+/// `*` and `?` never cross `/`, `**` spans any number of directories and
+/// `[...]` matches a character class (`[!...]` / `[^...]` negate).
+pub fn matches_path(path: &str, pattern: &str) -> bool {
+    path_match(path.as_bytes(), pattern.as_bytes(), false)
+}
+
+/// [`matches_path`] with case-sensitive comparison, the way git applies
+/// ignore-file rules.
+pub fn matches_path_exact(path: &str, pattern: &str) -> bool {
+    path_match(path.as_bytes(), pattern.as_bytes(), true)
+}
+
+/// Iterative matcher with one backtrack point for the last `*`, which only
+/// grows within its path segment, and one for the last `**/`, which grows a
+/// whole directory at a time. Earlier wildcards never need revisiting, so
+/// the cost stays linear in the pattern times the path.
+fn path_match(name: &[u8], pattern: &[u8], exact: bool) -> bool {
+    let (mut ni, mut pi) = (0, 0);
+    // (pattern index after the wildcard, name index it currently resumes at)
+    let mut star: Option<(usize, usize)> = None;
+    let mut globstar: Option<(usize, usize)> = None;
+
+    while ni < name.len() || pi < pattern.len() {
         if pi < pattern.len() {
-            let p = pattern[pi];
-            let n = name[ni];
-            if p == b'?' || eq_ci(p, n) {
-                pi += 1;
+            let step = match pattern[pi] {
+                b'*' if pattern.get(pi + 1) == Some(&b'*') => {
+                    let after = pi + 2;
+                    if after == pattern.len() {
+                        return true;
+                    }
+                    if pattern[after] == b'/' {
+                        globstar = Some((after + 1, ni));
+                        star = None;
+                        pi = after + 1;
+                    } else {
+                        star = Some((after, ni));
+                        pi = after;
+                    }
+                    continue;
+                }
+                b'*' => {
+                    star = Some((pi + 1, ni));
+                    pi += 1;
+                    continue;
+                }
+                b'?' => (ni < name.len() && name[ni] != b'/').then(|| pi + 1),
+                b'[' => match match_class(pattern, pi, name.get(ni).copied(), exact) {
+                    Some((matched, next_pi)) => matched.then(|| next_pi),
+                    None => (ni < name.len() && name[ni] == b'[').then(|| pi + 1),
+                },
+                b'\\' if pi + 1 < pattern.len() => (ni < name.len() && same(pattern[pi + 1], name[ni], exact)).then(|| pi + 2),
+                p => (ni < name.len() && same(p, name[ni], exact)).then(|| pi + 1),
+            };
+            if let Some(next_pi) = step {
+                pi = next_pi;
                 ni += 1;
                 continue;
             }
-            if p == b'*' {
-                star_pi = pi;
-                star_ni = ni;
-                pi += 1;
+        }
+
+        if let Some((spi, sni)) = star {
+            if sni < name.len() && name[sni] != b'/' {
+                star = Some((spi, sni + 1));
+                pi = spi;
+                ni = sni + 1;
                 continue;
             }
         }
-        if star_pi != usize::MAX {
-            pi = star_pi + 1;
-            star_ni += 1;
-            ni = star_ni;
-            continue;
+        if let Some((gpi, gni)) = globstar {
+            if let Some(slash) = name[gni..].iter().position(|&b| b == b'/') {
+                globstar = Some((gpi, gni + slash + 1));
+                star = None;
+                pi = gpi;
+                ni = gni + slash + 1;
+                continue;
+            }
         }
         return false;
     }
+    true
+}
+
+/// Evaluates the `[...]` class starting at `pattern[open]` against `c`.
+/// Returns `(matched, index after the closing bracket)`, or `None` when the
+/// bracket is unterminated and should be treated as a literal.
+fn match_class(pattern: &[u8], open: usize, c: Option<u8>, exact: bool) -> Option<(bool, usize)> {
+    let mut i = open + 1;
+    let negated = matches!(pattern.get(i), Some(b'!') | Some(b'^'));
+    if negated {
+        i += 1;
+    }
 
-    while pi < pattern.len() && pattern[pi] == b'*' {
-        pi += 1;
+    let start = i;
+    let mut matched = false;
+    while i < pattern.len() {
+        let p = pattern[i];
+        if p == b']' && i > start {
+            let hit = match c {
+                Some(ch) if ch != b'/' => matched != negated,
+                _ => false,
+            };
+            return Some((hit, i + 1));
+        }
+        if let Some(ch) = c {
+            if i + 2 < pattern.len() && pattern[i + 1] == b'-' && pattern[i + 2] != b']' {
+                let fold = |b: u8| if exact { b } else { b.to_ascii_lowercase() };
+                let (lo, hi, lc) = (fold(p), fold(pattern[i + 2]), fold(ch));
+                if lo <= lc && lc <= hi {
+                    matched = true;
+                }
+                i += 3;
+                continue;
+            }
+            if same(p, ch, exact) {
+                matched = true;
+            }
+        }
+        i += 1;
     }
-    pi == pattern.len()
+    None
 }
 
 #[inline(always)]
-fn eq_ci(a: u8, b: u8) -> bool {
-    a.to_ascii_lowercase() == b.to_ascii_lowercase()
+fn same(a: u8, b: u8, exact: bool) -> bool {
+    if exact { a == b } else { a.eq_ignore_ascii_case(&b) }
 }
 
 #[cfg(test)]
@@ -137,6 +339,143 @@ mod tests {
         assert!(!matches_any("file.rs", &patterns));
     }
 
+    #[test]
+    fn path_star_does_not_cross_slash() {
+        assert!(matches_path("src/main.rs", "src/*.rs"));
+        assert!(!matches_path("src/lang/rust.rs", "src/*.rs"));
+    }
+
+    #[test]
+    fn path_double_star_crosses_directories() {
+        assert!(matches_path("src/lang/rust.rs", "src/**/*.rs"));
+        assert!(matches_path("src/main.rs", "src/**/*.rs"));
+        assert!(matches_path("a/b/c/generated", "**/generated"));
+        assert!(matches_path("generated", "**/generated"));
+        assert!(matches_path("vendor/x/y.go", "vendor/**"));
+    }
+
+    #[test]
+    fn path_double_star_backtracks_across_segments() {
+        assert!(matches_path("a/b/x/c/d/x/e.rs", "**/x/**/e.rs"));
+        assert!(matches_path("a/xb/x/c.ts", "**/x*/c.ts"));
+        assert!(!matches_path("a/b/c.ts", "**/b/*/c.ts"));
+        assert!(matches_path("src/a.test.ts", "**/*.test.*"));
+        assert!(!matches_path("src/a.ts/b", "**/*.ts"));
+    }
+
+    #[test]
+    fn path_many_wildcards_stay_fast() {
+        let path = format!("{}/b", "a/".repeat(40).trim_end_matches('/'));
+        let pattern = "**/a*a*a*/**/a*a*a*/**/a*a*a*/**/c";
+        let start = std::time::Instant::now();
+        assert!(!matches_path(&path, pattern));
+        assert!(!matches(&"a".repeat(60), "*a*a*a*a*a*a*a*a*b"));
+        assert!(start.elapsed() < std::time::Duration::from_millis(200));
+    }
+
+    #[test]
+    fn path_exact_match_keeps_case() {
+        assert!(matches_path_exact("src/Build", "**/Build"));
+        assert!(!matches_path_exact("src/build", "**/Build"));
+        assert!(!matches_path_exact("B.rs", "[a-z].rs"));
+        assert!(matches_path("src/build", "**/Build"));
+    }
+
+    #[test]
+    fn path_character_class() {
+        assert!(matches_path("bin", "[Bb]in"));
+        assert!(matches_path("a.go", "[a-c].go"));
+        assert!(!matches_path("d.go", "[a-c].go"));
+        assert!(matches_path("d.go", "[!a-c].go"));
+    }
+
+    #[test]
+    fn path_unterminated_class_is_literal() {
+        assert!(matches_path("[abc", "[abc"));
+    }
+
+    #[test]
+    fn path_escaped_characters() {
+        assert!(matches_path("#notes", "\\#notes"));
+        assert!(!matches_path("xnotes", "\\#notes"));
+    }
+
+    #[test]
+    fn name_character_class() {
+        assert!(matches("main.go", "[a-z]*.go"));
+        assert!(!matches("Main.go", "[!a-z]*.go"));
+        assert!(!matches("_main.go", "[a-z]*.go"));
+    }
+
+    #[test]
+    fn braces_expand_alternatives() {
+        assert_eq!(expand_braces("*.{ts,tsx}"), vec!["*.ts", "*.tsx"]);
+        assert_eq!(expand_braces("{a,b}/{c,d}"), vec!["a/c", "a/d", "b/c", "b/d"]);
+        assert_eq!(expand_braces("x.{a,{b,c}}"), vec!["x.a", "x.b", "x.c"]);
+        assert_eq!(expand_braces("no-braces"), vec!["no-braces"]);
+        assert_eq!(expand_braces("broken{a,b"), vec!["broken{a,b"]);
+    }
+
+    fn set(patterns: &[&str]) -> GlobSet {
+        let owned: Vec<String> = patterns.iter().map(|s| s.to_string()).collect();
+        GlobSet::new(&owned)
+    }
+
+    #[test]
+    fn glob_set_name_patterns_match_anywhere() {
+        let gs = set(&["*.rs"]);
+        assert!(gs.is_match("main.rs", "src/main.rs"));
+        assert!(gs.is_match("rust.rs", "src/lang/rust.rs"));
+        assert!(!gs.is_match("app.ts", "src/app.ts"));
+    }
+
+    #[test]
+    fn glob_set_path_patterns_respect_directories() {
+        let gs = set(&["src/**/handlers/*.{ts,tsx}"]);
+        assert!(gs.is_match("a.ts", "src/handlers/a.ts"));
+        assert!(gs.is_match("b.tsx", "src/api/v1/handlers/b.tsx"));
+        assert!(!gs.is_match("c.ts", "src/handlers/nested/c.ts"));
+        assert!(!gs.is_match("d.ts", "lib/handlers/d.ts"));
+    }
+
+    #[test]
+    fn glob_set_single_star_stays_in_directory() {
+        let gs = set(&["src/*.rs"]);
+        assert!(gs.is_match("main.rs", "src/main.rs"));
+        assert!(!gs.is_match("rust.rs", "src/lang/rust.rs"));
+    }
+
+    #[test]
+    fn glob_set_negated_patterns_exclude() {
+        let gs = set(&["*.cs", "!**/*.generated.cs"]);
+        assert!(gs.is_match("Service.cs", "src/Service.cs"));
+        assert!(!gs.is_match("Models.generated.cs", "src/Models.generated.cs"));
+        assert!(!gs.is_match("Root.generated.cs", "Root.generated.cs"));
+    }
+
+    #[test]
+    fn glob_set_only_negations_includes_everything_else() {
+        let gs = set(&["!*.md"]);
+        assert!(gs.is_match("main.rs", "main.rs"));
+        assert!(!gs.is_match("README.md", "README.md"));
+        assert!(gs.is_match("Makefile", "Makefile"));
+        assert!(gs.is_match("Dockerfile", "deploy/Dockerfile"));
+    }
+
+    #[test]
+    fn glob_set_literal_brackets_still_match() {
+        let gs = set(&["pages/[slug].astro"]);
+        assert!(gs.is_match("[slug].astro", "pages/[slug].astro"));
+        let gs = set(&["[slug].astro"]);
+        assert!(gs.is_match("[slug].astro", "pages/[slug].astro"));
+    }
+
+    #[test]
+    fn glob_set_strips_leading_dot_slash() {
+        let gs = set(&["./src/*.rs"]);
+        assert!(gs.is_match("main.rs", "src/main.rs"));
+    }
+
     #[test]
     fn complex_glob_patterns() {
         assert!(matches("test_file.spec.ts", "*.ts"));
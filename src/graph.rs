@@ -1,33 +1,108 @@
-use std::collections::HashSet;
+use std::collections::{HashMap, HashSet, VecDeque};
 use std::path::Path;
 use std::sync::atomic::{AtomicBool, Ordering};
 
 use rayon::prelude::*;
 
 use crate::alias::{self, AliasMapping};
+use crate::external;
 use crate::file_reader;
+use crate::index::Index;
 use crate::lang;
-use crate::models::GraphEntry;
+use crate::models::{DependentLevel, GraphEntry, ImportCycle, ImportEdge};
+use crate::ndjson::OnItem;
 use crate::path_helper;
 
+/// What raw imports are resolved against: the scanned files relative to the
+/// root, path aliases and the project layout some languages need.
+pub struct Project<'a> {
+    pub files: HashSet<String>,
+    aliases: &'a [AliasMapping],
+    layout: lang::Layout,
+}
+
+impl<'a> Project<'a> {
+    pub fn new(root: &Path, files: HashSet<String>, aliases: &'a [AliasMapping]) -> Self {
+        let layout = lang::Layout::load(root, &files);
+        Self { files, aliases, layout }
+    }
+
+    /// The project a scan found: `file_paths` as the scanner returns them.
+    /// Build it once and share it between the graph and the reports on it,
+    /// since loading the layout reads every manifest.
+    pub fn scanned(root: &Path, file_paths: &[String], aliases: &'a [AliasMapping]) -> Self {
+        let files = file_paths.iter().map(|f| path_helper::normalized_relative(root, Path::new(f))).collect();
+        Self::new(root, files, aliases)
+    }
+
+    /// Whether a raw candidate of `from` that is not a plain path (an alias
+    /// or a layout-relative import) resolves to anything in the project.
+    pub fn provides(&self, from: &str, candidate: &str) -> bool {
+        let aliased = |specifier: &str| alias::resolve_alias(specifier, self.aliases)
+            .iter()
+            .any(|c| self.files.contains(&normalize_candidate(c)));
+        match candidate.strip_prefix(alias::ALIAS_PREFIX) {
+            Some(specifier) => aliased(specifier),
+            None => self.layout.resolve(from, candidate).is_some()
+                || candidate.strip_prefix(lang::JS_PREFIX).map_or(false, aliased),
+        }
+    }
+}
+
 pub fn build_graph(
     file_paths: &[String],
     root: &Path,
     cancelled: &AtomicBool,
-    aliases: &[AliasMapping],
+    project: &Project,
+    on_file: OnItem<GraphEntry>,
 ) -> Vec<GraphEntry> {
-    let project_files: HashSet<String> = file_paths
-        .iter()
-        .map(|f| path_helper::normalized_relative(root, Path::new(f)))
+    let mut entries: Vec<GraphEntry> = file_paths
+        .par_iter()
+        .filter_map(|file_path| {
+            if cancelled.load(Ordering::Relaxed) {
+                return None;
+            }
+            let entry = process_file(file_path, root, project)?;
+            if let Some(emit) = on_file {
+                emit(&entry);
+            }
+            Some(entry)
+        })
         .collect();
 
+    entries.sort_unstable_by(|a, b| a.file.to_ascii_lowercase().cmp(&b.file.to_ascii_lowercase()));
+    entries
+}
+
+/// Same as `build_graph`, but takes raw import candidates from the on-disk
+/// index and only reads files the index does not cover.
+pub fn build_graph_indexed(
+    file_paths: &[String],
+    root: &Path,
+    cancelled: &AtomicBool,
+    project: &Project,
+    index: &Index,
+    on_file: OnItem<GraphEntry>,
+) -> Vec<GraphEntry> {
     let mut entries: Vec<GraphEntry> = file_paths
         .par_iter()
         .filter_map(|file_path| {
             if cancelled.load(Ordering::Relaxed) {
                 return None;
             }
-            process_file(file_path, root, &project_files, aliases)
+            let path = Path::new(file_path);
+            let relative = path_helper::normalized_relative(root, path);
+            let entry = match index.imports(&relative) {
+                Some(raw_imports) => {
+                    let imports = resolve_imports(&relative, raw_imports, project);
+                    GraphEntry { file: relative, imports, external: external_markers(raw_imports) }
+                }
+                None => process_file(file_path, root, project)?,
+            };
+            if let Some(emit) = on_file {
+                emit(&entry);
+            }
+            Some(entry)
         })
         .collect();
 
@@ -35,12 +110,256 @@ pub fn build_graph(
     entries
 }
 
-fn process_file(
-    file_path: &str,
-    root: &Path,
-    project_files: &HashSet<String>,
-    aliases: &[AliasMapping],
-) -> Option<GraphEntry> {
+/// Inverts `graph` and walks it breadth-first from `targets`, returning every
+/// file that imports one of them directly or transitively. Each file appears
+/// once, at its shortest distance from any target; targets themselves are
+/// never listed.
+pub fn dependents(graph: &[GraphEntry], targets: &[String]) -> Vec<DependentLevel> {
+    let mut importers: HashMap<&str, Vec<&str>> = HashMap::new();
+    for entry in graph {
+        for imp in &entry.imports {
+            importers.entry(imp.as_str()).or_default().push(entry.file.as_str());
+        }
+    }
+
+    let mut seen: HashSet<&str> = targets.iter().map(String::as_str).collect();
+    let mut frontier: Vec<&str> = targets.iter().map(String::as_str).collect();
+    let mut levels = Vec::new();
+    while !frontier.is_empty() {
+        let mut next: Vec<&str> = frontier
+            .iter()
+            .filter_map(|file| importers.get(file))
+            .flatten()
+            .copied()
+            .filter(|file| seen.insert(file))
+            .collect();
+        if next.is_empty() {
+            break;
+        }
+        next.sort_unstable_by(|a, b| a.to_ascii_lowercase().cmp(&b.to_ascii_lowercase()));
+        levels.push(DependentLevel {
+            depth: levels.len() + 1,
+            files: next.iter().map(|f| (*f).to_owned()).collect(),
+        });
+        frontier = next;
+    }
+    levels
+}
+
+/// Finds every import cycle in `graph`: one per strongly connected component
+/// of two or more files, reported with the shortest cycle through it. Each
+/// edge is traced back to the import line in its source file under `root`.
+pub fn cycles(graph: &[GraphEntry], root: &Path, project: &Project) -> Vec<ImportCycle> {
+    let index: HashMap<&str, usize> = graph.iter().enumerate().map(|(i, e)| (e.file.as_str(), i)).collect();
+    let edges: Vec<Vec<usize>> = graph
+        .iter()
+        .map(|e| e.imports.iter().filter_map(|imp| index.get(imp.as_str()).copied()).collect())
+        .collect();
+    let mut locator = ImportLocator::new(root, project);
+
+    strongly_connected(&edges)
+        .into_iter()
+        .filter(|component| component.len() > 1)
+        .map(|mut component| {
+            component.sort_unstable();
+            let nodes = shortest_cycle(&edges, &component);
+            let path = nodes
+                .iter()
+                .zip(nodes.iter().cycle().skip(1))
+                .map(|(&from, &to)| {
+                    let (from, to) = (&graph[from].file, &graph[to].file);
+                    let (line, content) = match locator.find(from, to) {
+                        Some((line, content)) => (Some(line), Some(content)),
+                        None => (None, None),
+                    };
+                    ImportEdge { from: from.clone(), to: to.clone(), line, content }
+                })
+                .collect();
+            ImportCycle { files: component.iter().map(|&i| graph[i].file.clone()).collect(), path }
+        })
+        .collect()
+}
+
+/// Tarjan's algorithm, iterative so deep import chains cannot overflow the
+/// stack.
+fn strongly_connected(edges: &[Vec<usize>]) -> Vec<Vec<usize>> {
+    const UNVISITED: usize = usize::MAX;
+    let n = edges.len();
+    let mut order = vec![UNVISITED; n];
+    let mut low = vec![0; n];
+    let mut on_stack = vec![false; n];
+    let mut stack = Vec::new();
+    let mut components = Vec::new();
+    let mut counter = 0;
+
+    for start in 0..n {
+        if order[start] != UNVISITED {
+            continue;
+        }
+        let mut work: Vec<(usize, usize)> = vec![(start, 0)];
+        while let Some(&(node, next)) = work.last() {
+            if next == 0 {
+                order[node] = counter;
+                low[node] = counter;
+                counter += 1;
+                stack.push(node);
+                on_stack[node] = true;
+            }
+            if let Some(&child) = edges[node].get(next) {
+                work.last_mut().unwrap().1 += 1;
+                if order[child] == UNVISITED {
+                    work.push((child, 0));
+                } else if on_stack[child] {
+                    low[node] = low[node].min(order[child]);
+                }
+                continue;
+            }
+            work.pop();
+            if let Some(&(parent, _)) = work.last() {
+                low[parent] = low[parent].min(low[node]);
+            }
+            if low[node] == order[node] {
+                let mut component = Vec::new();
+                while let Some(member) = stack.pop() {
+                    on_stack[member] = false;
+                    component.push(member);
+                    if member == node {
+                        break;
+                    }
+                }
+                components.push(component);
+            }
+        }
+    }
+    components
+}
+
+/// The shortest cycle inside `component` (sorted), found by a breadth-first
+/// search from each member back to itself. Ties go to the earliest member.
+fn shortest_cycle(edges: &[Vec<usize>], component: &[usize]) -> Vec<usize> {
+    let members: HashSet<usize> = component.iter().copied().collect();
+    let mut best: Vec<usize> = Vec::new();
+    for &start in component {
+        let mut parent: HashMap<usize, usize> = HashMap::new();
+        let mut queue = VecDeque::from([start]);
+        let mut closing = None;
+        while let Some(node) = queue.pop_front() {
+            if edges[node].contains(&start) {
+                closing = Some(node);
+                break;
+            }
+            for &child in &edges[node] {
+                if members.contains(&child) && child != start && !parent.contains_key(&child) {
+                    parent.insert(child, node);
+                    queue.push_back(child);
+                }
+            }
+        }
+        let mut node = match closing {
+            Some(node) => node,
+            None => continue,
+        };
+        let mut cycle = vec![node];
+        while node != start {
+            node = parent[&node];
+            cycle.push(node);
+        }
+        cycle.reverse();
+        if best.is_empty() || cycle.len() < best.len() {
+            best = cycle;
+        }
+    }
+    best
+}
+
+/// Traces import edges back to the statements behind them. Each source
+/// file is read and resolved once, however many edges start there.
+pub struct ImportLocator<'a> {
+    root: &'a Path,
+    project: &'a Project<'a>,
+    files: HashMap<String, Vec<ImportSite>>,
+}
+
+/// An import statement: its first line, its text with line breaks folded,
+/// and the project files it resolves to.
+struct ImportSite {
+    line: usize,
+    content: String,
+    targets: Vec<String>,
+}
+
+impl<'a> ImportLocator<'a> {
+    pub fn new(root: &'a Path, project: &'a Project<'a>) -> Self {
+        Self { root, project, files: HashMap::new() }
+    }
+
+    /// The line and text of the first statement in `from` that imports `to`.
+    pub fn find(&mut self, from: &str, to: &str) -> Option<(usize, String)> {
+        let sites = self
+            .files
+            .entry(from.to_owned())
+            .or_insert_with(|| import_sites(self.root, from, self.project));
+        sites
+            .iter()
+            .find(|site| site.targets.iter().any(|t| t == to))
+            .map(|site| (site.line, site.content.clone()))
+    }
+}
+
+/// Runs the language handler over each import statement of `from` (a
+/// multi-line `import { ... } from`, `use a::{ ... }` or `import ( ... )`
+/// counts as one) and over every other line on its own, then adds the
+/// candidates that need the whole file, at the lines the handler reports.
+fn import_sites(root: &Path, from: &str, project: &Project) -> Vec<ImportSite> {
+    let path = root.join(from);
+    let handler = match path.extension().and_then(|e| e.to_str()).and_then(lang::get_handler) {
+        Some(h) => h,
+        None => return Vec::new(),
+    };
+    let content = match file_reader::read_file(&path) {
+        Ok(Some(c)) => c,
+        _ => return Vec::new(),
+    };
+    let lines: Vec<&str> = content.lines().collect();
+    let statements = lang::syntax_for(from)
+        .map(|syntax| lang::lexer::import_statements(&lang::lexer::mask(&content, syntax), syntax))
+        .unwrap_or_default();
+
+    let mut chunks = Vec::new();
+    let mut next = 0;
+    for statement in statements {
+        chunks.extend((next..statement.start).map(|i| i..i + 1));
+        next = statement.end;
+        chunks.push(statement);
+    }
+    chunks.extend((next..lines.len()).map(|i| i..i + 1));
+
+    let mut sites: Vec<ImportSite> = chunks
+        .into_iter()
+        .filter_map(|chunk| {
+            let text = &lines[chunk.clone()];
+            let raw_imports = handler.extract_imports(&text.join("\n"), Path::new(from));
+            let targets = resolve_imports(from, &raw_imports, project);
+            if targets.is_empty() {
+                return None;
+            }
+            let content = text.iter().map(|l| l.trim()).filter(|l| !l.is_empty()).collect::<Vec<_>>().join(" ");
+            Some(ImportSite { line: chunk.start + 1, content, targets })
+        })
+        .collect();
+
+    for (idx, raw) in handler.contextual_imports(&content, Path::new(from)) {
+        let targets = resolve_imports(from, &[raw], project);
+        if targets.is_empty() || idx >= lines.len() {
+            continue;
+        }
+        sites.push(ImportSite { line: idx + 1, content: lines[idx].trim().to_owned(), targets });
+    }
+    sites.sort_by_key(|site| site.line);
+    sites
+}
+
+fn process_file(file_path: &str, root: &Path, project: &Project) -> Option<GraphEntry> {
     let path = Path::new(file_path);
     let relative = path_helper::normalized_relative(root, path);
 
@@ -52,22 +371,52 @@ fn process_file(
         Ok(None) => return Some(GraphEntry {
             file: relative,
             imports: Vec::new(),
+            external: Vec::new(),
         }),
         Err(_) => return Some(GraphEntry {
             file: relative,
             imports: Vec::new(),
+            external: Vec::new(),
         }),
     };
 
     let rel_path = Path::new(&relative);
-    let raw_imports = handler.extract_imports(&content, rel_path);
+    let mut raw_imports = handler.extract_imports(&content, rel_path);
+    raw_imports.extend(handler.extract_external(&content, rel_path));
+    let imports = resolve_imports(&relative, &raw_imports, project);
 
+    Some(GraphEntry {
+        file: relative,
+        imports,
+        external: external_markers(&raw_imports),
+    })
+}
+
+fn external_markers(raw_imports: &[String]) -> Vec<String> {
+    raw_imports.iter().filter(|c| c.starts_with(external::EXTERNAL_PREFIX)).cloned().collect()
+}
+
+fn resolve_imports(relative: &str, raw_imports: &[String], project: &Project) -> Vec<String> {
+    let project_files = &project.files;
     let mut resolved: Vec<String> = Vec::new();
     let mut seen = HashSet::new();
 
-    for candidate in &raw_imports {
-        if let Some(specifier) = candidate.strip_prefix(alias::ALIAS_PREFIX) {
-            let alias_candidates = alias::resolve_alias(specifier, aliases);
+    for candidate in raw_imports {
+        if candidate.starts_with(external::EXTERNAL_PREFIX) {
+            continue;
+        }
+        if let Some(files) = project.layout.resolve(relative, candidate) {
+            for file in files {
+                if file != relative && seen.insert(file.clone()) {
+                    resolved.push(file);
+                }
+            }
+        } else if let Some(specifier) = candidate
+            .strip_prefix(alias::ALIAS_PREFIX)
+            // A bare specifier no package provides may be an alias without `@` or `~`.
+            .or_else(|| candidate.strip_prefix(lang::JS_PREFIX))
+        {
+            let alias_candidates = alias::resolve_alias(specifier, project.aliases);
             for ac in &alias_candidates {
                 let normalized = normalize_candidate(ac);
                 if normalized == relative {
@@ -95,14 +444,10 @@ fn process_file(
     }
 
     resolved.sort_unstable_by(|a, b| a.to_ascii_lowercase().cmp(&b.to_ascii_lowercase()));
-
-    Some(GraphEntry {
-        file: relative,
-        imports: resolved,
-    })
+    resolved
 }
 
-fn normalize_candidate(candidate: &str) -> String {
+pub fn normalize_candidate(candidate: &str) -> String {
     let s = if cfg!(windows) {
         candidate.replace('\\', "/")
     } else {
@@ -120,3 +465,153 @@ fn normalize_candidate(candidate: &str) -> String {
     }
     normalized.join("/")
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{graph_entry, temp_project};
+
+    fn targets(files: &[&str]) -> Vec<String> {
+        files.iter().map(|s| (*s).to_owned()).collect()
+    }
+
+    fn sample() -> Vec<GraphEntry> {
+        vec![
+            graph_entry("api.rs", &["service.rs"]),
+            graph_entry("cli.rs", &["models.rs", "service.rs"]),
+            graph_entry("main.rs", &["api.rs", "cli.rs"]),
+            graph_entry("models.rs", &[]),
+            graph_entry("service.rs", &["models.rs"]),
+            graph_entry("util.rs", &[]),
+        ]
+    }
+
+    // ── dependents ──
+
+    #[test]
+    fn dependents_groups_by_shortest_depth() {
+        let levels = dependents(&sample(), &targets(&["models.rs"]));
+        let files: Vec<(usize, Vec<String>)> = levels.into_iter().map(|l| (l.depth, l.files)).collect();
+        assert_eq!(files, vec![
+            (1, targets(&["cli.rs", "service.rs"])),
+            (2, targets(&["api.rs", "main.rs"])),
+        ]);
+    }
+
+    #[test]
+    fn dependents_of_leaf_consumer_is_empty() {
+        assert!(dependents(&sample(), &targets(&["main.rs"])).is_empty());
+        assert!(dependents(&sample(), &targets(&["missing.rs"])).is_empty());
+    }
+
+    #[test]
+    fn impact_unions_targets_and_never_lists_them() {
+        let levels = dependents(&sample(), &targets(&["service.rs", "api.rs"]));
+        assert_eq!(levels.len(), 1);
+        assert_eq!(levels[0].files, targets(&["cli.rs", "main.rs"]));
+    }
+
+    #[test]
+    fn dependents_terminates_on_cycles() {
+        let graph = vec![graph_entry("a.rs", &["b.rs"]), graph_entry("b.rs", &["a.rs"]), graph_entry("c.rs", &["a.rs"])];
+        let levels = dependents(&graph, &targets(&["a.rs"]));
+        assert_eq!(levels.len(), 1);
+        assert_eq!(levels[0].files, targets(&["b.rs", "c.rs"]));
+    }
+
+    // ── cycles ──
+
+    #[test]
+    fn strongly_connected_finds_components() {
+        let edges = vec![vec![1], vec![2], vec![0, 3], vec![], vec![4]];
+        let mut components: Vec<Vec<usize>> = strongly_connected(&edges)
+            .into_iter()
+            .map(|mut c| { c.sort_unstable(); c })
+            .collect();
+        components.sort();
+        assert_eq!(components, vec![vec![0, 1, 2], vec![3], vec![4]]);
+    }
+
+    #[test]
+    fn shortest_cycle_prefers_fewest_edges() {
+        // 0 -> 1 -> 2 -> 0 and 1 <-> 3
+        let edges = vec![vec![1], vec![2, 3], vec![0], vec![1]];
+        assert_eq!(shortest_cycle(&edges, &[0, 1, 2, 3]), vec![1, 3]);
+    }
+
+    fn project(root: &Path, graph: &[GraphEntry]) -> Project<'static> {
+        Project::new(root, graph.iter().map(|e| e.file.clone()).collect(), &[])
+    }
+
+    #[test]
+    fn acyclic_graph_has_no_cycles() {
+        assert!(cycles(&sample(), Path::new("."), &project(Path::new("."), &sample())).is_empty());
+    }
+
+    #[test]
+    fn cycles_report_import_lines() {
+        let root = temp_project("graph_cycles", &[
+            ("pkg/a.py", "import os\nfrom .b import helper\n"),
+            ("pkg/b.py", "\n\nfrom .a import thing\n"),
+            ("pkg/c.py", "from .a import thing\n"),
+        ]);
+        let graph = vec![
+            graph_entry("pkg/a.py", &["pkg/b.py"]),
+            graph_entry("pkg/b.py", &["pkg/a.py"]),
+            graph_entry("pkg/c.py", &["pkg/a.py"]),
+        ];
+        let found = cycles(&graph, &root, &project(&root, &graph));
+        assert_eq!(found.len(), 1);
+        assert_eq!(found[0].files, targets(&["pkg/a.py", "pkg/b.py"]));
+        let path: Vec<(&str, &str, Option<usize>)> =
+            found[0].path.iter().map(|e| (e.from.as_str(), e.to.as_str(), e.line)).collect();
+        assert_eq!(path, vec![("pkg/a.py", "pkg/b.py", Some(2)), ("pkg/b.py", "pkg/a.py", Some(3))]);
+        assert_eq!(found[0].path[1].content.as_deref(), Some("from .a import thing"));
+        std::fs::remove_dir_all(&root).ok();
+    }
+
+    #[test]
+    fn cycles_locate_multi_line_imports() {
+        let root = temp_project("graph_cycles_multi", &[
+            ("go.mod", "module example.com/m\n"),
+            ("a/a.go", "package a\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/m/b\"\n)\n"),
+            ("b/b.go", "package b\n\nimport (\n\t\"example.com/m/a\"\n)\n"),
+        ]);
+        let graph = vec![graph_entry("a/a.go", &["b/b.go"]), graph_entry("b/b.go", &["a/a.go"])];
+        let found = cycles(&graph, &root, &project(&root, &graph));
+        assert_eq!(found.len(), 1);
+        let path: Vec<(Option<usize>, Option<&str>)> =
+            found[0].path.iter().map(|e| (e.line, e.content.as_deref())).collect();
+        assert_eq!(path, vec![
+            (Some(3), Some("import ( \"fmt\" \"example.com/m/b\" )")),
+            (Some(3), Some("import ( \"example.com/m/a\" )")),
+        ]);
+        std::fs::remove_dir_all(&root).ok();
+    }
+
+    #[test]
+    fn cycles_locate_references_that_need_the_whole_file() {
+        let root = temp_project("graph_cycles_context", &[
+            ("com/x/Order.java", "package com.x;\n\npublic class Order {\n    Invoice invoice;\n}\n"),
+            ("com/x/Invoice.java", "package com.x;\n\nclass Invoice {\n\n    Order order;\n}\n"),
+            ("app/models/billing/invoice.rb", "module Billing\n  class Invoice\n    def send\n      Mailer.deliver\n    end\n  end\nend\n"),
+            ("app/models/billing/mailer.rb", "module Billing\n  class Mailer\n    def self.deliver\n      Invoice.new\n    end\n  end\nend\n"),
+        ]);
+        let graph = vec![
+            graph_entry("com/x/Order.java", &["com/x/Invoice.java"]),
+            graph_entry("com/x/Invoice.java", &["com/x/Order.java"]),
+            graph_entry("app/models/billing/invoice.rb", &["app/models/billing/mailer.rb"]),
+            graph_entry("app/models/billing/mailer.rb", &["app/models/billing/invoice.rb"]),
+        ];
+        let found = cycles(&graph, &root, &project(&root, &graph));
+        let located: Vec<(Option<usize>, Option<&str>)> =
+            found.iter().flat_map(|c| &c.path).map(|e| (e.line, e.content.as_deref())).collect();
+        assert_eq!(located, vec![
+            (Some(4), Some("Invoice invoice;")),
+            (Some(5), Some("Order order;")),
+            (Some(4), Some("Mailer.deliver")),
+            (Some(4), Some("Invoice.new")),
+        ]);
+        std::fs::remove_dir_all(&root).ok();
+    }
+}
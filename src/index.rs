@@ -0,0 +1,758 @@
+use std::collections::{HashMap, HashSet};
+use std::fs;
+use std::io::{BufWriter, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Mutex, OnceLock};
+use std::time::UNIX_EPOCH;
+
+use rayon::prelude::*;
+
+use crate::file_reader;
+use crate::lang::{self, SymbolInfo};
+use crate::models::SymbolFile;
+use crate::path_helper;
+use crate::symbols;
+
+/// Directory (relative to the scan root) holding the on-disk index.
+pub const INDEX_DIR: &str = ".src";
+const INDEX_FILE: &str = "index";
+const INDEX_HEADER: &str = "src-index";
+
+/// Bumped whenever the record layout or any extractor changes its output.
+pub const INDEX_VERSION: u32 = 9;
+
+/// Everything the index remembers about one source file.
+struct IndexedFile {
+    mtime: u128,
+    size: u64,
+    hash: u64,
+    error: Option<String>,
+    /// Symbols as extracted with tests and doc comments included.
+    symbols: Vec<SymbolInfo>,
+    /// Parallel to `symbols`: true when the symbol only exists in test code.
+    test_only: Vec<bool>,
+    /// Raw import candidates and external markers, resolved against the file
+    /// set at query time.
+    imports: Vec<String>,
+}
+
+/// How a refresh changed the index, or would change it for `diff`.
+#[derive(Default, Debug, PartialEq)]
+pub struct RefreshStats {
+    pub changed: usize,
+    pub added: usize,
+    pub removed: usize,
+}
+
+impl RefreshStats {
+    pub fn is_fresh(&self) -> bool {
+        self.changed == 0 && self.added == 0 && self.removed == 0
+    }
+}
+
+enum Update {
+    Touch { mtime: u128, size: u64 },
+    Replace(IndexedFile),
+    Remove,
+}
+
+pub struct Index {
+    root: PathBuf,
+    files: HashMap<String, IndexedFile>,
+    dirty: bool,
+}
+
+impl Index {
+    pub fn new(root: &Path) -> Self {
+        Self { root: root.to_path_buf(), files: HashMap::new(), dirty: true }
+    }
+
+    pub fn path(root: &Path) -> PathBuf {
+        root.join(INDEX_DIR).join(INDEX_FILE)
+    }
+
+    /// Loads the index under `root`. Errors when it is missing, unreadable or
+    /// written by an incompatible version.
+    pub fn load(root: &Path) -> Result<Self, String> {
+        let path = Self::path(root);
+        let content = fs::read_to_string(&path).map_err(|e| e.to_string())?;
+        let files = parse(&content)?;
+        Ok(Self { root: root.to_path_buf(), files, dirty: false })
+    }
+
+    /// Loads the index if one exists and brings the entries for `file_paths`
+    /// up to date, writing the result back. Returns `None` when there is no
+    /// usable index so callers fall back to scanning.
+    pub fn open(root: &Path, file_paths: &[String], cancelled: &AtomicBool) -> Option<Self> {
+        let mut index = Self::load(root).ok()?;
+        index.refresh(file_paths, cancelled);
+        if cancelled.load(Ordering::Relaxed) {
+            return None;
+        }
+        if index.dirty {
+            let _ = index.save();
+        }
+        Some(index)
+    }
+
+    pub fn len(&self) -> usize {
+        self.files.len()
+    }
+
+    pub fn symbol_count(&self) -> usize {
+        self.files.values().map(|f| f.symbols.len()).sum()
+    }
+
+    /// Re-extracts every file in `file_paths` whose size or mtime changed.
+    /// Files whose content hash is unchanged only get their stamp updated.
+    pub fn refresh(&mut self, file_paths: &[String], cancelled: &AtomicBool) -> RefreshStats {
+        let root = self.root.clone();
+        let known = &self.files;
+        let updates: Vec<(String, Update)> = file_paths
+            .par_iter()
+            .filter_map(|file_path| {
+                if cancelled.load(Ordering::Relaxed) {
+                    return None;
+                }
+                let path = Path::new(file_path);
+                if !is_indexable(path) {
+                    return None;
+                }
+                let relative = path_helper::normalized_relative(&root, path);
+                let update = check_file(path, &relative, known.get(&relative))?;
+                Some((relative, update))
+            })
+            .collect();
+
+        let mut stats = RefreshStats::default();
+        for (relative, update) in updates {
+            match update {
+                Update::Touch { mtime, size } => {
+                    if let Some(entry) = self.files.get_mut(&relative) {
+                        entry.mtime = mtime;
+                        entry.size = size;
+                    }
+                }
+                Update::Replace(entry) => {
+                    if self.files.insert(relative, entry).is_some() {
+                        stats.changed += 1;
+                    } else {
+                        stats.added += 1;
+                    }
+                }
+                Update::Remove => {
+                    if self.files.remove(&relative).is_some() {
+                        stats.removed += 1;
+                    }
+                }
+            }
+            self.dirty = true;
+        }
+        stats
+    }
+
+    /// Drops entries for files that are no longer part of `file_paths`.
+    pub fn retain(&mut self, file_paths: &[String]) -> usize {
+        let keep: HashSet<String> = file_paths
+            .iter()
+            .map(|f| path_helper::normalized_relative(&self.root, Path::new(f)))
+            .collect();
+        let before = self.files.len();
+        self.files.retain(|rel, _| keep.contains(rel));
+        let removed = before - self.files.len();
+        if removed > 0 {
+            self.dirty = true;
+        }
+        removed
+    }
+
+    /// Reports what `refresh` plus `retain` would do, using stat calls only.
+    pub fn diff(&self, file_paths: &[String]) -> RefreshStats {
+        let mut stats = RefreshStats::default();
+        let mut seen = HashSet::new();
+        for file_path in file_paths {
+            let path = Path::new(file_path);
+            if !is_indexable(path) {
+                continue;
+            }
+            let relative = path_helper::normalized_relative(&self.root, path);
+            match self.files.get(&relative) {
+                Some(entry) => match stamp(path) {
+                    Some((mtime, size)) if mtime == entry.mtime && size == entry.size => {}
+                    _ => stats.changed += 1,
+                },
+                None => stats.added += 1,
+            }
+            seen.insert(relative);
+        }
+        stats.removed = self.files.keys().filter(|rel| !seen.contains(*rel)).count();
+        stats
+    }
+
+    /// Raw import candidates for a root-relative path.
+    pub fn imports(&self, relative: &str) -> Option<&[String]> {
+        self.files.get(relative).map(|f| f.imports.as_slice())
+    }
+
+    /// Builds the `--symbols` view for `file_paths`, mirroring
+    /// `symbols::extract_symbols`. Files missing from the index are extracted
+    /// directly.
+    pub fn symbol_files(
+        &self,
+        file_paths: &[String],
+        root: &Path,
+        with_comments: bool,
+        include_tests: bool,
+    ) -> Vec<SymbolFile> {
+        let mut missing = Vec::new();
+        let mut results: Vec<SymbolFile> = Vec::new();
+
+        for file_path in file_paths {
+            let relative = path_helper::normalized_relative(root, Path::new(file_path));
+            let entry = match self.files.get(&relative) {
+                Some(e) => e,
+                None => {
+                    missing.push(file_path.clone());
+                    continue;
+                }
+            };
+            if let Some(ref err) = entry.error {
+                results.push(SymbolFile { path: relative, symbols: Vec::new(), error: Some(err.clone()) });
+                continue;
+            }
+            let symbols: Vec<SymbolInfo> = entry
+                .symbols
+                .iter()
+                .zip(&entry.test_only)
+                .filter(|(_, &test_only)| include_tests || !test_only)
+                .map(|(sym, _)| {
+                    let mut sym = sym.clone();
+                    if !with_comments {
+                        sym.comment = None;
+                    }
+                    sym
+                })
+                .collect();
+            if !symbols.is_empty() {
+                results.push(SymbolFile { path: relative, symbols, error: None });
+            }
+        }
+
+        if !missing.is_empty() {
+            let never = AtomicBool::new(false);
+            results.extend(symbols::extract_symbols(&missing, root, &never, with_comments, include_tests, None));
+        }
+
+        results.sort_unstable_by(|a, b| a.path.to_ascii_lowercase().cmp(&b.path.to_ascii_lowercase()));
+        results
+    }
+
+    /// Writes the index atomically (temp file + rename).
+    pub fn save(&mut self) -> Result<(), String> {
+        let dir = self.root.join(INDEX_DIR);
+        fs::create_dir_all(&dir).map_err(|e| e.to_string())?;
+        let tmp = dir.join(format!("{}.tmp", INDEX_FILE));
+        {
+            let file = fs::File::create(&tmp).map_err(|e| e.to_string())?;
+            let mut w = BufWriter::with_capacity(64 * 1024, file);
+            write_index(&mut w, &self.files).map_err(|e| e.to_string())?;
+            w.flush().map_err(|e| e.to_string())?;
+        }
+        fs::rename(&tmp, Self::path(&self.root)).map_err(|e| e.to_string())?;
+        self.dirty = false;
+        Ok(())
+    }
+
+    /// Removes the index file, and the index directory when left empty.
+    /// Returns whether there was anything to remove.
+    pub fn clear(root: &Path) -> Result<bool, String> {
+        let path = Self::path(root);
+        if !path.is_file() {
+            return Ok(false);
+        }
+        fs::remove_file(&path).map_err(|e| e.to_string())?;
+        let _ = fs::remove_dir(root.join(INDEX_DIR));
+        Ok(true)
+    }
+}
+
+fn is_indexable(path: &Path) -> bool {
+    match path.extension().and_then(|e| e.to_str()) {
+        Some(ext) => lang::get_handler(ext).is_some() || lang::get_symbol_handler(ext).is_some(),
+        None => false,
+    }
+}
+
+fn stamp(path: &Path) -> Option<(u128, u64)> {
+    let meta = fs::metadata(path).ok()?;
+    let mtime = meta
+        .modified()
+        .ok()
+        .and_then(|t| t.duration_since(UNIX_EPOCH).ok())
+        .map(|d| d.as_nanos())
+        .unwrap_or(0);
+    Some((mtime, meta.len()))
+}
+
+fn check_file(path: &Path, relative: &str, known: Option<&IndexedFile>) -> Option<Update> {
+    let (mtime, size) = match stamp(path) {
+        Some(s) => s,
+        None => return known.map(|_| Update::Remove),
+    };
+    if let Some(entry) = known {
+        if entry.mtime == mtime && entry.size == size {
+            return None;
+        }
+    }
+
+    let mut fresh = extract_file(path, relative);
+    fresh.mtime = mtime;
+    fresh.size = size;
+    match known {
+        Some(entry) if entry.hash == fresh.hash && entry.error == fresh.error => {
+            Some(Update::Touch { mtime, size })
+        }
+        _ => Some(Update::Replace(fresh)),
+    }
+}
+
+fn extract_file(path: &Path, relative: &str) -> IndexedFile {
+    let mut entry = IndexedFile {
+        mtime: 0,
+        size: 0,
+        hash: 0,
+        error: None,
+        symbols: Vec::new(),
+        test_only: Vec::new(),
+        imports: Vec::new(),
+    };
+
+    let content = match file_reader::read_file(path) {
+        Ok(Some(c)) => c,
+        Ok(None) => return entry,
+        Err(e) => {
+            entry.error = Some(e);
+            return entry;
+        }
+    };
+    entry.hash = fnv1a(content.as_bytes());
+
+    let ext = match path.extension().and_then(|e| e.to_str()) {
+        Some(e) => e,
+        None => return entry,
+    };
+
+    if let Some(handler) = lang::get_symbol_handler(ext) {
+        let all = symbols::symbols_from_content(handler, ext, &content, true, true);
+        let base: HashSet<(usize, String)> = handler
+            .extract_symbols_with_tests(&content, false)
+            .into_iter()
+            .map(|s| (s.line, s.name))
+            .collect();
+        entry.test_only = all.iter().map(|s| !base.contains(&(s.line, s.name.clone()))).collect();
+        entry.symbols = all;
+    }
+
+    if let Some(handler) = lang::get_handler(ext) {
+        entry.imports = handler.extract_imports(&content, Path::new(relative));
+        entry.imports.extend(handler.extract_external(&content, Path::new(relative)));
+    }
+
+    entry
+}
+
+fn fnv1a(data: &[u8]) -> u64 {
+    let mut hash: u64 = 0xcbf29ce484222325;
+    for &b in data {
+        hash ^= b as u64;
+        hash = hash.wrapping_mul(0x100000001b3);
+    }
+    hash
+}
+
+// ── On-disk format ──
+//
+// A header line `src-index <version>`, then one `F` record per file followed
+// by its `E` (error), `S` (symbol) and `I` (import) records. Fields are
+// tab-separated and escaped so they never contain tabs or newlines.
+
+fn write_index(w: &mut impl Write, files: &HashMap<String, IndexedFile>) -> std::io::Result<()> {
+    write!(w, "{} {}\n", INDEX_HEADER, INDEX_VERSION)?;
+
+    let mut paths: Vec<&String> = files.keys().collect();
+    paths.sort_unstable();
+
+    for rel in paths {
+        let f = &files[rel];
+        write!(w, "F\t{}\t{}\t{}\t{:016x}\n", escape(rel), f.mtime, f.size, f.hash)?;
+        if let Some(ref err) = f.error {
+            write!(w, "E\t{}\n", escape(err))?;
+        }
+        for (sym, &test_only) in f.symbols.iter().zip(&f.test_only) {
+            write!(
+                w,
+                "S\t{}\t{}\t{}\t{}\t{}\t{}\t{}\t{}\t{}\n",
+                if test_only { "t" } else { "-" },
+                escape(sym.kind),
+                escape(&sym.name),
+                sym.line,
+                sym.end_line,
+                escape(sym.visibility.unwrap_or("")),
+                escape(sym.parent.as_deref().unwrap_or("")),
+                escape(&sym.signature),
+                escape(sym.comment.as_deref().unwrap_or("")),
+            )?;
+        }
+        for import in &f.imports {
+            write!(w, "I\t{}\n", escape(import))?;
+        }
+    }
+    Ok(())
+}
+
+fn parse(content: &str) -> Result<HashMap<String, IndexedFile>, String> {
+    let mut lines = content.lines();
+    let header = lines.next().unwrap_or("");
+    let version = header
+        .strip_prefix(INDEX_HEADER)
+        .map(str::trim)
+        .and_then(|v| v.parse::<u32>().ok())
+        .ok_or_else(|| "Not an index file".to_string())?;
+    if version != INDEX_VERSION {
+        return Err(format!("Index version {} is not supported (expected {})", version, INDEX_VERSION));
+    }
+
+    let mut files = HashMap::new();
+    let mut current: Option<(String, IndexedFile)> = None;
+
+    for (i, line) in lines.enumerate() {
+        let bad = || format!("Corrupt index record on line {}", i + 2);
+        let fields: Vec<&str> = line.split('\t').collect();
+        match fields[0] {
+            "F" if fields.len() == 5 => {
+                if let Some((rel, f)) = current.take() {
+                    files.insert(rel, f);
+                }
+                let entry = IndexedFile {
+                    mtime: fields[2].parse().map_err(|_| bad())?,
+                    size: fields[3].parse().map_err(|_| bad())?,
+                    hash: u64::from_str_radix(fields[4], 16).map_err(|_| bad())?,
+                    error: None,
+                    symbols: Vec::new(),
+                    test_only: Vec::new(),
+                    imports: Vec::new(),
+                };
+                current = Some((unescape(fields[1]), entry));
+            }
+            "E" if fields.len() == 2 => {
+                let (_, f) = current.as_mut().ok_or_else(bad)?;
+                f.error = Some(unescape(fields[1]));
+            }
+            "S" if fields.len() == 10 => {
+                let (_, f) = current.as_mut().ok_or_else(bad)?;
+                let optional = |s: &str| if s.is_empty() { None } else { Some(unescape(s)) };
+                f.symbols.push(SymbolInfo {
+                    kind: intern(&unescape(fields[2])),
+                    name: unescape(fields[3]),
+                    line: fields[4].parse().map_err(|_| bad())?,
+                    end_line: fields[5].parse().map_err(|_| bad())?,
+                    visibility: optional(fields[6]).map(|v| intern(&v)),
+                    parent: optional(fields[7]),
+                    signature: unescape(fields[8]),
+                    comment: optional(fields[9]),
+                });
+                f.test_only.push(fields[1] == "t");
+            }
+            "I" if fields.len() == 2 => {
+                let (_, f) = current.as_mut().ok_or_else(bad)?;
+                f.imports.push(unescape(fields[1]));
+            }
+            "" if line.is_empty() => {}
+            _ => return Err(bad()),
+        }
+    }
+    if let Some((rel, f)) = current.take() {
+        files.insert(rel, f);
+    }
+    Ok(files)
+}
+
+/// `SymbolInfo` keeps kinds and visibilities as `&'static str`; loaded values
+/// are leaked once per distinct string.
+fn intern(s: &str) -> &'static str {
+    static INTERNED: OnceLock<Mutex<HashSet<&'static str>>> = OnceLock::new();
+    let mut set = INTERNED.get_or_init(|| Mutex::new(HashSet::new())).lock().unwrap();
+    if let Some(&existing) = set.get(s) {
+        return existing;
+    }
+    let leaked: &'static str = Box::leak(s.to_owned().into_boxed_str());
+    set.insert(leaked);
+    leaked
+}
+
+fn escape(s: &str) -> String {
+    if !s.contains(['\\', '\t', '\n', '\r']) {
+        return s.to_owned();
+    }
+    let mut out = String::with_capacity(s.len() + 8);
+    for c in s.chars() {
+        match c {
+            '\\' => out.push_str("\\\\"),
+            '\t' => out.push_str("\\t"),
+            '\n' => out.push_str("\\n"),
+            '\r' => out.push_str("\\r"),
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+fn unescape(s: &str) -> String {
+    if !s.contains('\\') {
+        return s.to_owned();
+    }
+    let mut out = String::with_capacity(s.len());
+    let mut chars = s.chars();
+    while let Some(c) = chars.next() {
+        if c != '\\' {
+            out.push(c);
+            continue;
+        }
+        match chars.next() {
+            Some('t') => out.push('\t'),
+            Some('n') => out.push('\n'),
+            Some('r') => out.push('\r'),
+            Some(other) => out.push(other),
+            None => out.push('\\'),
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::temp_project;
+
+    fn temp_root(name: &str) -> PathBuf {
+        temp_project(&format!("index_{}", name), &[])
+    }
+
+    fn write(root: &Path, rel: &str, content: &str) -> String {
+        let path = root.join(rel);
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(&path, content).unwrap();
+        path.to_string_lossy().into_owned()
+    }
+
+    // ── escaping ──
+
+    #[test]
+    fn escape_round_trips() {
+        for s in ["plain", "tab\there", "line\nbreak", "back\\slash", "\r\n\t\\"] {
+            assert_eq!(unescape(&escape(s)), s);
+        }
+        assert!(!escape("a\tb\nc").contains(['\t', '\n']));
+    }
+
+    #[test]
+    fn intern_reuses_strings() {
+        let a = intern("struct");
+        let b = intern(&String::from("struct"));
+        assert!(std::ptr::eq(a, b));
+    }
+
+    // ── format ──
+
+    #[test]
+    fn save_and_load_round_trip() {
+        let root = temp_root("roundtrip");
+        let files = vec![write(&root, "src/lib.rs", "/// Adds.\npub fn add(a: i32) -> i32 {\n    a\n}\n")];
+        let cancelled = AtomicBool::new(false);
+
+        let mut index = Index::new(&root);
+        let stats = index.refresh(&files, &cancelled);
+        assert_eq!(stats.added, 1);
+        index.save().unwrap();
+
+        let loaded = Index::load(&root).unwrap();
+        assert_eq!(loaded.len(), 1);
+        let sf = loaded.symbol_files(&files, &root, true, false);
+        assert_eq!(sf.len(), 1);
+        assert_eq!(sf[0].symbols[0].name, "add");
+        assert_eq!(sf[0].symbols[0].kind, "fn");
+        assert_eq!(sf[0].symbols[0].comment.as_deref(), Some("Adds."));
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn load_rejects_other_versions() {
+        let root = temp_root("version");
+        fs::create_dir_all(root.join(INDEX_DIR)).unwrap();
+        fs::write(Index::path(&root), "src-index 999\n").unwrap();
+        match Index::load(&root) {
+            Err(e) => assert!(e.contains("999")),
+            Ok(_) => panic!("Expected version error"),
+        }
+        let cancelled = AtomicBool::new(false);
+        assert!(Index::open(&root, &[], &cancelled).is_none());
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn load_rejects_corrupt_records() {
+        let root = temp_root("corrupt");
+        fs::create_dir_all(root.join(INDEX_DIR)).unwrap();
+        fs::write(Index::path(&root), format!("{} {}\nS\tbroken\n", INDEX_HEADER, INDEX_VERSION)).unwrap();
+        assert!(Index::load(&root).is_err());
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn open_without_index_returns_none() {
+        let root = temp_root("none");
+        let cancelled = AtomicBool::new(false);
+        assert!(Index::open(&root, &[], &cancelled).is_none());
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    // ── refresh ──
+
+    #[test]
+    fn refresh_detects_changes_and_skips_fresh_files() {
+        let root = temp_root("refresh");
+        let file = write(&root, "a.py", "def one():\n    pass\n");
+        let files = vec![file.clone()];
+        let cancelled = AtomicBool::new(false);
+
+        let mut index = Index::new(&root);
+        index.refresh(&files, &cancelled);
+        assert!(index.diff(&files).is_fresh());
+        assert_eq!(index.refresh(&files, &cancelled), RefreshStats::default());
+
+        fs::write(&file, "def one():\n    pass\n\ndef two():\n    pass\n").unwrap();
+        assert_eq!(index.diff(&files).changed, 1);
+        let stats = index.refresh(&files, &cancelled);
+        assert_eq!(stats.changed, 1);
+        let sf = index.symbol_files(&files, &root, false, false);
+        assert_eq!(sf[0].symbols.len(), 2);
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn refresh_removes_deleted_files() {
+        let root = temp_root("deleted");
+        let file = write(&root, "gone.ts", "export function gone() {}\n");
+        let files = vec![file.clone()];
+        let cancelled = AtomicBool::new(false);
+
+        let mut index = Index::new(&root);
+        index.refresh(&files, &cancelled);
+        fs::remove_file(&file).unwrap();
+        assert_eq!(index.refresh(&files, &cancelled).removed, 1);
+        assert_eq!(index.len(), 0);
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn retain_and_diff_report_unlisted_files() {
+        let root = temp_root("retain");
+        let a = write(&root, "a.go", "package main\n\nfunc A() {}\n");
+        let b = write(&root, "b.go", "package main\n\nfunc B() {}\n");
+        let cancelled = AtomicBool::new(false);
+
+        let mut index = Index::new(&root);
+        index.refresh(&[a.clone(), b], &cancelled);
+        assert_eq!(index.diff(&[a.clone()]).removed, 1);
+        assert_eq!(index.retain(&[a]), 1);
+        assert_eq!(index.len(), 1);
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn unsupported_extensions_are_not_indexed() {
+        let root = temp_root("unsupported");
+        let files = vec![write(&root, "notes.txt", "hello\n")];
+        let cancelled = AtomicBool::new(false);
+
+        let mut index = Index::new(&root);
+        index.refresh(&files, &cancelled);
+        assert_eq!(index.len(), 0);
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    // ── queries ──
+
+    #[test]
+    fn symbol_files_hide_test_symbols_unless_requested() {
+        let root = temp_root("tests");
+        let files = vec![write(
+            &root,
+            "lib.rs",
+            "pub fn real() {}\n\n#[cfg(test)]\nmod tests {\n    #[test]\n    fn check() {}\n}\n",
+        )];
+        let cancelled = AtomicBool::new(false);
+
+        let mut index = Index::new(&root);
+        index.refresh(&files, &cancelled);
+
+        let without = index.symbol_files(&files, &root, false, false);
+        let with = index.symbol_files(&files, &root, false, true);
+        let direct_without = symbols::extract_symbols(&files, &root, &cancelled, false, false, None);
+        let direct_with = symbols::extract_symbols(&files, &root, &cancelled, false, true, None);
+        assert_eq!(without[0].symbols.len(), direct_without[0].symbols.len());
+        assert_eq!(with[0].symbols.len(), direct_with[0].symbols.len());
+        assert!(with[0].symbols.len() > without[0].symbols.len());
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn symbol_files_strip_comments_unless_requested() {
+        let root = temp_root("comments");
+        let files = vec![write(&root, "lib.rs", "/// Doc.\npub fn documented() {}\n")];
+        let cancelled = AtomicBool::new(false);
+
+        let mut index = Index::new(&root);
+        index.refresh(&files, &cancelled);
+        assert!(index.symbol_files(&files, &root, false, false)[0].symbols[0].comment.is_none());
+        assert!(index.symbol_files(&files, &root, true, false)[0].symbols[0].comment.is_some());
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn symbol_files_fall_back_for_unindexed_files() {
+        let root = temp_root("fallback");
+        let files = vec![write(&root, "late.py", "def late():\n    pass\n")];
+        let index = Index::new(&root);
+        let sf = index.symbol_files(&files, &root, false, false);
+        assert_eq!(sf.len(), 1);
+        assert_eq!(sf[0].symbols[0].name, "late");
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn imports_are_stored_raw() {
+        let root = temp_root("imports");
+        let files = vec![write(&root, "app.ts", "import { x } from './util';\n")];
+        let cancelled = AtomicBool::new(false);
+
+        let mut index = Index::new(&root);
+        index.refresh(&files, &cancelled);
+        assert!(!index.imports("app.ts").unwrap().is_empty());
+        assert!(index.imports("missing.ts").is_none());
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn clear_removes_index_and_directory() {
+        let root = temp_root("clear");
+        let mut index = Index::new(&root);
+        index.save().unwrap();
+        assert!(Index::path(&root).is_file());
+        assert!(Index::clear(&root).unwrap());
+        assert!(!root.join(INDEX_DIR).exists());
+        assert!(!Index::clear(&root).unwrap());
+        let _ = fs::remove_dir_all(&root);
+    }
+}
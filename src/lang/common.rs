@@ -240,10 +240,67 @@ pub fn extract_docstring_after(lines: &[&str], symbol_line_idx: usize) -> Option
     }
 }
 
+/// The package of a Java or Kotlin import: `org.junit.jupiter.api.Test` and
+/// `org.junit.jupiter.api.*` both give `org.junit.jupiter.api`.
+pub fn jvm_package(import_path: &str) -> &str {
+    let mut end = 0;
+    for (i, segment) in import_path.split('.').enumerate() {
+        if segment == "*" || segment.starts_with(|c: char| c.is_ascii_uppercase()) {
+            break;
+        }
+        end += segment.len() + if i > 0 { 1 } else { 0 };
+    }
+    &import_path[..end]
+}
+
+/// Joins a relative `path` onto `dir` (both relative to the root), or `None`
+/// when the result would leave the root.
+pub fn join_dir(dir: &str, path: &str) -> Option<String> {
+    let mut parts: Vec<&str> = dir.split('/').filter(|p| !p.is_empty()).collect();
+    for part in path.split('/') {
+        match part {
+            "" | "." => {}
+            ".." => { parts.pop()?; }
+            _ => parts.push(part),
+        }
+    }
+    Some(parts.join("/"))
+}
+
+/// `path` inside the directory `dir`, which is empty at the root.
+pub fn under(dir: &str, path: &str) -> String {
+    if dir.is_empty() { path.to_owned() } else { format!("{}/{}", dir, path) }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    // ── jvm_package ──
+
+    #[test]
+    fn jvm_package_stops_at_class_or_wildcard() {
+        assert_eq!(jvm_package("org.junit.jupiter.api.Test"), "org.junit.jupiter.api");
+        assert_eq!(jvm_package("org.junit.jupiter.api.Assertions.assertEquals"), "org.junit.jupiter.api");
+        assert_eq!(jvm_package("com.google.common.collect.*"), "com.google.common.collect");
+        assert_eq!(jvm_package("Foo"), "");
+    }
+
+    // ── join_dir ──
+
+    #[test]
+    fn join_dir_stays_inside_root() {
+        assert_eq!(join_dir("svc", "../shared").as_deref(), Some("shared"));
+        assert_eq!(join_dir("", "./svc").as_deref(), Some("svc"));
+        assert_eq!(join_dir("", "../outside"), None);
+    }
+
+    #[test]
+    fn under_joins_onto_the_root_or_a_directory() {
+        assert_eq!(under("", "a.py"), "a.py");
+        assert_eq!(under("src/main/java", "com/x"), "src/main/java/com/x");
+    }
+
     // ── find_brace_end ──
 
     #[test]
@@ -1,6 +1,16 @@
+use std::collections::{HashMap, HashSet};
 use std::path::Path;
+use std::sync::OnceLock;
+
+use rayon::prelude::*;
+use regex::Regex;
+
 use super::{LangImports, LangSymbols, SymbolInfo};
 use super::common::{self, CommentTracker};
+use super::lexer::{self, quote, Syntax};
+use crate::external;
+use crate::file_reader;
+use crate::path_helper;
 
 pub struct CSharpImports;
 
@@ -11,28 +21,309 @@ impl LangImports for CSharpImports {
 
     fn extract_imports(&self, content: &str, _file_path: &Path) -> Vec<String> {
         let mut imports = Vec::new();
+        let mut declares_namespace = false;
 
         for line in content.lines() {
             let trimmed = line.trim();
 
-            if let Some(ns) = extract_using_namespace(trimmed) {
+            if let Some(ns) = extract_using_namespace(trimmed.strip_prefix("global ").unwrap_or(trimmed)) {
                 if is_external_namespace(ns) {
                     continue;
                 }
-                let path = namespace_to_path(ns);
-                imports.push(path);
+                imports.push(format!("{}{}", CS_PREFIX, ns));
+            } else if trimmed.starts_with("namespace ") && !declares_namespace {
+                // The project's global usings apply from the namespace on.
+                declares_namespace = true;
+                imports.push(format!("{}{}", CS_PREFIX, GLOBAL_USINGS));
             }
         }
 
         imports
     }
+
+    fn extract_external(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        let mut external = Vec::new();
+
+        for line in content.lines() {
+            let trimmed = line.trim();
+            let ns = match extract_using_namespace(trimmed.strip_prefix("global ").unwrap_or(trimmed)) {
+                Some(ns) => ns,
+                None => continue,
+            };
+            if ns == "System" || ns.starts_with("System.") {
+                continue;
+            }
+            // Namespaces outside the well-known packages are only external
+            // when nothing in the project declares them.
+            let local = if is_external_namespace(ns) { Vec::new() } else { vec![format!("{}{}", CS_PREFIX, ns)] };
+            external.push(external::marker("nuget", ns, &local));
+        }
+
+        external
+    }
 }
 
+/// Raw candidates for C# using directives carry the namespace after this
+/// prefix; `CSharpProjects` maps it to the files declaring the namespace.
+pub const CS_PREFIX: &str = "cs:";
+
+/// Stands for every `global using` of the importing file's project. `global`
+/// is a keyword, so no namespace can take the name.
+const GLOBAL_USINGS: &str = "global";
+
+/// The namespaces declared across a project's C# files, the `.csproj`
+/// projects those files belong to and the global usings each project
+/// applies.
+#[derive(Default)]
+pub struct CSharpProjects {
+    /// Files declaring each namespace, block or file-scoped.
+    namespaces: HashMap<String, Vec<String>>,
+    projects: Vec<CsProject>,
+    /// The project of each file: the nearest `.csproj` above it.
+    project_of: HashMap<String, usize>,
+    /// Global usings of files outside every project.
+    loose_usings: Vec<String>,
+}
+
+#[derive(Default)]
+struct CsProject {
+    references: Vec<String>,
+    /// This project and every project it references, directly or not.
+    visible: HashSet<usize>,
+    global_usings: Vec<String>,
+}
+
+impl CSharpProjects {
+    pub fn load(root: &Path, files: &HashSet<String>) -> Self {
+        let sources: Vec<&String> = files.iter().filter(|f| f.ends_with(".cs")).collect();
+        if sources.is_empty() {
+            return Self::default();
+        }
+
+        let mut found = ProjectFinder { root, dirs: HashMap::new() };
+        let mut pending: Vec<String> = solution_projects(root);
+        let mut owner: HashMap<String, String> = HashMap::new();
+        for file in &sources {
+            if let Some(csproj) = found.nearest(path_helper::parent_dir(file)) {
+                owner.insert((*file).clone(), csproj.clone());
+                pending.push(csproj);
+            }
+        }
+
+        let mut projects: Vec<CsProject> = Vec::new();
+        let mut index: HashMap<String, usize> = HashMap::new();
+        while let Some(path) = pending.pop() {
+            if index.contains_key(&path) {
+                continue;
+            }
+            let text = std::fs::read_to_string(root.join(&path)).unwrap_or_default();
+            let project = CsProject::parse(&path, &text);
+            pending.extend(project.references.iter().cloned());
+            index.insert(path, projects.len());
+            projects.push(project);
+        }
+        for i in 0..projects.len() {
+            let mut visible = HashSet::from([i]);
+            let mut queue = vec![i];
+            while let Some(p) = queue.pop() {
+                for reference in &projects[p].references {
+                    if let Some(&r) = index.get(reference) {
+                        if visible.insert(r) {
+                            queue.push(r);
+                        }
+                    }
+                }
+            }
+            projects[i].visible = visible;
+        }
+        let project_of: HashMap<String, usize> = owner.into_iter().filter_map(|(f, p)| Some((f, *index.get(&p)?))).collect();
+
+        let contents: Vec<(&String, Option<String>)> = sources
+            .par_iter()
+            .map(|f| (*f, file_reader::read_file(&root.join(f.as_str())).ok().flatten()))
+            .collect();
+        let mut namespaces: HashMap<String, Vec<String>> = HashMap::new();
+        let mut loose_usings = Vec::new();
+        for (file, content) in contents {
+            let content = match content {
+                Some(content) => content,
+                None => continue,
+            };
+            for ns in declared_namespaces(&content) {
+                namespaces.entry(ns).or_default().push(file.clone());
+            }
+            let usings = content.lines().filter_map(|l| extract_using_namespace(l.trim().strip_prefix("global ")?));
+            match project_of.get(file) {
+                Some(&p) => projects[p].global_usings.extend(usings.map(str::to_owned)),
+                None => loose_usings.extend(usings.map(str::to_owned)),
+            }
+        }
+        for files in namespaces.values_mut() {
+            files.sort_unstable();
+            files.dedup();
+        }
+        Self { namespaces, projects, project_of, loose_usings }
+    }
+
+    /// The files declaring `ns` that `from` can see through its project's
+    /// references, or `None` when nothing in the project declares it.
+    pub fn resolve(&self, from: &str, ns: &str) -> Option<Vec<String>> {
+        if ns == GLOBAL_USINGS {
+            let usings = match self.project_of.get(from) {
+                Some(&p) => &self.projects[p].global_usings,
+                None => &self.loose_usings,
+            };
+            let mut files: Vec<String> = usings.iter().filter_map(|u| self.resolve(from, u)).flatten().collect();
+            files.sort_unstable();
+            files.dedup();
+            return Some(files);
+        }
+
+        let files = self.namespaces.get(ns)?;
+        let visible = self.project_of.get(from).map(|&p| &self.projects[p].visible);
+        Some(
+            files
+                .iter()
+                .filter(|f| match (visible, self.project_of.get(*f)) {
+                    (Some(visible), Some(p)) => visible.contains(p),
+                    _ => true,
+                })
+                .cloned()
+                .collect(),
+        )
+    }
+}
+
+impl CsProject {
+    fn parse(path: &str, text: &str) -> Self {
+        static REFERENCE: OnceLock<Regex> = OnceLock::new();
+        let reference = REFERENCE.get_or_init(|| Regex::new(r#"<ProjectReference\s+Include\s*=\s*"([^"]+)""#).unwrap());
+        static USING: OnceLock<Regex> = OnceLock::new();
+        let using = USING.get_or_init(|| Regex::new(r#"<Using\s+Include\s*=\s*"([^"]+)"\s*/>"#).unwrap());
+        let dir = path_helper::parent_dir(path);
+        CsProject {
+            references: reference
+                .captures_iter(text)
+                .filter_map(|c| common::join_dir(dir, &c[1].replace('\\', "/")))
+                .collect(),
+            visible: HashSet::new(),
+            global_usings: using.captures_iter(text).map(|c| c[1].to_owned()).collect(),
+        }
+    }
+}
+
+/// Finds the `.csproj` a directory belongs to, remembering each directory's
+/// own project file.
+struct ProjectFinder<'a> {
+    root: &'a Path,
+    dirs: HashMap<String, Option<String>>,
+}
+
+impl ProjectFinder<'_> {
+    fn nearest(&mut self, dir: &str) -> Option<String> {
+        let mut dir = dir;
+        loop {
+            if let Some(csproj) = self.own(dir) {
+                return Some(csproj);
+            }
+            if dir.is_empty() {
+                return None;
+            }
+            dir = path_helper::parent_dir(dir);
+        }
+    }
+
+    fn own(&mut self, dir: &str) -> Option<String> {
+        if let Some(known) = self.dirs.get(dir) {
+            return known.clone();
+        }
+        let mut names: Vec<String> = std::fs::read_dir(self.root.join(dir))
+            .map(|entries| {
+                entries
+                    .flatten()
+                    .map(|e| e.file_name().to_string_lossy().into_owned())
+                    .filter(|n| n.ends_with(".csproj"))
+                    .collect()
+            })
+            .unwrap_or_default();
+        names.sort_unstable();
+        let csproj = names.first().map(|n| if dir.is_empty() { n.clone() } else { format!("{}/{}", dir, n) });
+        self.dirs.insert(dir.to_owned(), csproj.clone());
+        csproj
+    }
+}
+
+/// The `.csproj` files listed by the solutions at the root.
+fn solution_projects(root: &Path) -> Vec<String> {
+    static PROJECT: OnceLock<Regex> = OnceLock::new();
+    let project = PROJECT.get_or_init(|| Regex::new(r#"(?m)^Project\([^)]*\)\s*=\s*"[^"]*",\s*"([^"]+\.csproj)""#).unwrap());
+    let mut projects = Vec::new();
+    for entry in std::fs::read_dir(root).into_iter().flatten().flatten() {
+        let path = entry.path();
+        if path.extension().map_or(false, |ext| ext == "sln") {
+            if let Ok(text) = std::fs::read_to_string(&path) {
+                projects.extend(project.captures_iter(&text).filter_map(|c| common::join_dir("", &c[1].replace('\\', "/"))));
+            }
+        }
+    }
+    projects
+}
+
+/// Every namespace `content` declares, nested block namespaces joined to
+/// their parents and file-scoped ones taken as they are.
+fn declared_namespaces(content: &str) -> Vec<String> {
+    let mut found: Vec<String> = Vec::new();
+    let mut open: Vec<(String, i32)> = Vec::new();
+    let mut depth: i32 = 0;
+    let mut comment_tracker = CommentTracker::new();
+    for line in content.lines() {
+        let trimmed = line.trim();
+        if comment_tracker.is_comment(trimmed, "//") {
+            continue;
+        }
+        let depth_before = depth;
+        common::update_brace_depth(trimmed.split("//").next().unwrap_or(""), &mut depth);
+        while open.last().map_or(false, |(_, d)| depth <= *d && depth < depth_before) {
+            open.pop();
+        }
+        let rest = match trimmed.strip_prefix("namespace ") {
+            Some(rest) => rest.trim_start(),
+            None => continue,
+        };
+        let end = rest.find(|c: char| c.is_whitespace() || c == '{' || c == ';').unwrap_or(rest.len());
+        let name = &rest[..end];
+        if name.is_empty() {
+            continue;
+        }
+        let full = match open.last() {
+            Some((parent, _)) => format!("{}.{}", parent, name),
+            None => name.to_owned(),
+        };
+        if !rest[end..].trim_start().starts_with(';') {
+            open.push((full.clone(), depth_before));
+        }
+        if !found.contains(&full) {
+            found.push(full);
+        }
+    }
+    found
+}
+
+const SYNTAX: Syntax = Syntax {
+    quotes: &[quote("\"\"\"", false, true).interpolated("{", b"$"), quote("\"", true, false).interpolated("{", b"$")],
+    imports: &["using ", "global using "],
+    ..lexer::C_LIKE
+};
+
 impl LangSymbols for CSharpImports {
     fn extensions(&self) -> &[&str] {
         &["cs"]
     }
 
+    fn syntax(&self) -> &'static Syntax {
+        &SYNTAX
+    }
+
     fn extract_symbols(&self, content: &str) -> Vec<SymbolInfo> {
         let all_lines: Vec<&str> = content.lines().collect();
         let mut symbols = Vec::new();
@@ -289,18 +580,10 @@ fn is_external_namespace(ns: &str) -> bool {
     false
 }
 
-fn namespace_to_path(ns: &str) -> String {
-    let segments: Vec<&str> = ns.split('.').collect();
-    if segments.len() <= 1 {
-        return format!("{}/", ns);
-    }
-    let path_segments = &segments[1..];
-    path_segments.join("/") + "/"
-}
-
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::test_support::temp_project;
 
     fn extract_imports(content: &str) -> Vec<String> {
         CSharpImports.extract_imports(content, Path::new("Foo.cs"))
@@ -317,7 +600,7 @@ mod tests {
         let content = "using MyApp.Services;\n";
         let imports = extract_imports(content);
         assert!(!imports.is_empty());
-        assert!(imports.iter().any(|i| i.contains("Services/")));
+        assert_eq!(imports, vec!["cs:MyApp.Services"]);
     }
 
     #[test]
@@ -359,7 +642,59 @@ mod tests {
     fn single_segment_namespace() {
         let content = "using LocalNamespace;\n";
         let imports = extract_imports(content);
-        assert!(imports.iter().any(|i| i == "LocalNamespace/"));
+        assert!(imports.iter().any(|i| i == "cs:LocalNamespace"));
+    }
+
+    #[test]
+    fn external_namespaces_skip_the_base_library() {
+        let content = "using System;\nusing System.Linq;\nusing Newtonsoft.Json;\nusing MyApp.Services;\n";
+        let external = CSharpImports.extract_external(content, Path::new("Foo.cs"));
+        assert_eq!(external, vec![
+            "external:nuget:Newtonsoft.Json",
+            "external:nuget:MyApp.Services|cs:MyApp.Services",
+        ]);
+    }
+
+    #[test]
+    fn global_usings_and_namespace_declarations() {
+        let content = "global using MyApp.Shared;\nglobal using System.Text;\nusing MyApp.Services;\n\nnamespace MyApp.Web;\n";
+        let imports = extract_imports(content);
+        assert_eq!(imports, vec!["cs:MyApp.Shared", "cs:MyApp.Services", "cs:global"]);
+    }
+
+    // ── Projects ──
+
+    #[test]
+    fn projects_resolve_namespaces_through_references() {
+        let files = [
+            ("Shop.sln", "Project(\"{FAE04EC0}\") = \"Api\", \"src\\Api\\Api.csproj\", \"{1}\"\nEndProject\n"),
+            ("src/Api/Api.csproj", "<Project>\n  <ItemGroup>\n    <ProjectReference Include=\"..\\Domain\\Domain.csproj\" />\n    <Using Include=\"Shop.Domain.Orders\" />\n  </ItemGroup>\n</Project>\n"),
+            ("src/Api/Controllers/OrdersController.cs", "namespace Shop.Api.Controllers;\n"),
+            ("src/Api/GlobalUsings.cs", "global using Shop.Domain.Pricing;\n"),
+            ("src/Domain/Domain.csproj", "<Project>\n  <ItemGroup>\n    <ProjectReference Include=\"../Core/Core.csproj\" />\n  </ItemGroup>\n</Project>\n"),
+            ("src/Domain/Orders/Order.cs", "namespace Shop.Domain.Orders\n{\n    public class Order { }\n}\n"),
+            ("src/Domain/Orders/Line.cs", "namespace Shop.Domain.Orders { public class Line { } }\n"),
+            ("src/Domain/Pricing/Tax.cs", "namespace Shop.Domain.Pricing;\npublic class Tax { }\n"),
+            ("src/Core/Core.csproj", "<Project />\n"),
+            ("src/Core/Clock.cs", "namespace Shop.Core;\n"),
+            ("tools/Seeder/Seeder.csproj", "<Project />\n"),
+            ("tools/Seeder/Orders.cs", "namespace Shop.Domain.Orders;\n"),
+        ];
+        let root = temp_project("cs_projects", &files);
+        let scanned = files.iter().map(|(f, _)| (*f).to_owned()).filter(|f| f.ends_with(".cs")).collect();
+        let projects = CSharpProjects::load(&root, &scanned);
+        let controller = "src/Api/Controllers/OrdersController.cs";
+
+        assert_eq!(projects.resolve(controller, "Shop.Domain.Orders"), Some(vec!["src/Domain/Orders/Line.cs".to_owned(), "src/Domain/Orders/Order.cs".to_owned()]));
+        assert_eq!(projects.resolve(controller, "Shop.Core"), Some(vec!["src/Core/Clock.cs".to_owned()]));
+        assert_eq!(projects.resolve("tools/Seeder/Orders.cs", "Shop.Core"), Some(vec![]));
+        assert_eq!(projects.resolve(controller, "global"), Some(vec![
+            "src/Domain/Orders/Line.cs".to_owned(),
+            "src/Domain/Orders/Order.cs".to_owned(),
+            "src/Domain/Pricing/Tax.cs".to_owned(),
+        ]));
+        assert_eq!(projects.resolve(controller, "Shop.Billing"), None);
+        std::fs::remove_dir_all(&root).ok();
     }
 
     // ── Symbol Tests ──
@@ -489,16 +824,6 @@ mod tests {
         assert_eq!(exts, &["cs"]);
     }
 
-    #[test]
-    fn namespace_to_path_single() {
-        assert_eq!(namespace_to_path("MyApp"), "MyApp/");
-    }
-
-    #[test]
-    fn namespace_to_path_multi() {
-        assert_eq!(namespace_to_path("MyApp.Services.Auth"), "Services/Auth/");
-    }
-
     #[test]
     fn is_external_system() {
         assert!(is_external_namespace("System"));
@@ -606,9 +931,7 @@ using MyApp.Services;
         // System and Microsoft are external
         assert!(!imports.iter().any(|i| i.contains("System")));
         assert!(!imports.iter().any(|i| i.contains("Microsoft")));
-        // MyApp.Models → Models/
-        assert!(imports.iter().any(|i| i == "Models/"));
-        assert!(imports.iter().any(|i| i == "Services/"));
+        assert_eq!(imports, vec!["cs:MyApp.Models", "cs:MyApp.Services"]);
     }
 
     // ── Deep: generic class ──
@@ -809,11 +1132,13 @@ using Dapper;
         assert!(m.signature.contains("GetItems"));
     }
 
-    // ── Deep: namespace path conversion ──
+    // ── Deep: namespace declarations ──
 
     #[test]
-    fn namespace_to_path_deeply_nested() {
-        assert_eq!(namespace_to_path("MyApp.Core.Data.Repositories"), "Core/Data/Repositories/");
+    fn declared_namespaces_join_nested_blocks() {
+        let content = "namespace MyApp.Core\n{\n    namespace Data\n    {\n        class Repo { }\n    }\n    // namespace Commented\n    class Other { }\n}\nnamespace MyApp.Web { class Page { } }\n";
+        assert_eq!(declared_namespaces(content), vec!["MyApp.Core", "MyApp.Core.Data", "MyApp.Web"]);
+        assert_eq!(declared_namespaces("using System;\n\nnamespace MyApp.Api;\n\npublic class Controller { }\n"), vec!["MyApp.Api"]);
     }
 
     // ── Deep: using var (C# 8) inside class should not produce imports ──
@@ -831,7 +1156,6 @@ using Dapper;
     fn deep_project_namespace_import() {
         let content = "using MyCompany.Project.Features.Auth.Services;\n";
         let imports = extract_imports(content);
-        assert_eq!(imports.len(), 1);
-        assert_eq!(imports[0], "Project/Features/Auth/Services/");
+        assert_eq!(imports, vec!["cs:MyCompany.Project.Features.Auth.Services"]);
     }
 }
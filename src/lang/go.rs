@@ -1,58 +1,157 @@
-use std::collections::HashMap;
-use std::path::{Path, PathBuf};
-use std::sync::Mutex;
+use std::collections::{HashMap, HashSet};
+use std::path::Path;
 
 use super::{LangImports, LangSymbols, SymbolInfo};
 use super::common::{self, CommentTracker};
+use super::lexer::{self, quote, Syntax};
+use crate::external;
+use crate::path_helper;
 
 pub struct GoImports;
 
-use std::sync::OnceLock;
-static GO_MODULE_CACHE: OnceLock<Mutex<HashMap<PathBuf, Option<String>>>> = OnceLock::new();
+/// Raw candidates for Go imports carry the import path after this prefix;
+/// `GoModules` maps them to files once the project's modules are known.
+pub const GO_PREFIX: &str = "go:";
+
+/// The Go modules in a project: every `go.mod` above a scanned package, the
+/// members of a root `go.work`, and the local targets of `replace`
+/// directives in either.
+#[derive(Default)]
+pub struct GoModules {
+    /// Module path and its directory relative to the root, longest path first.
+    modules: Vec<(String, String)>,
+    /// Non-test `.go` files by directory.
+    packages: HashMap<String, Vec<String>>,
+}
+
+impl GoModules {
+    pub fn load(root: &Path, files: &HashSet<String>) -> Self {
+        let mut packages: HashMap<String, Vec<String>> = HashMap::new();
+        for file in files.iter().filter(|f| f.ends_with(".go") && !f.ends_with("_test.go")) {
+            packages.entry(path_helper::parent_dir(file).to_owned()).or_default().push(file.clone());
+        }
+        if packages.is_empty() {
+            return Self::default();
+        }
+        for package in packages.values_mut() {
+            package.sort_unstable();
+        }
 
-fn get_module_path_owned(file_path: &Path) -> Option<String> {
-    let cache = GO_MODULE_CACHE.get_or_init(|| Mutex::new(HashMap::new()));
-    let go_mod_dir = find_go_mod_dir(file_path)?;
+        let mut modules: Vec<(String, String)> = Vec::new();
+        let mut pending: Vec<String> = Vec::new();
+        if let Ok(text) = std::fs::read_to_string(root.join("go.work")) {
+            let work = Directives::parse(&text);
+            pending.extend(work.uses.iter().filter_map(|dir| common::join_dir("", dir)));
+            add_replacements(&mut modules, &mut pending, "", &work.replaces);
+        }
+        let mut checked = HashSet::new();
+        for dir in packages.keys() {
+            let mut dir = dir.as_str();
+            while checked.insert(dir) {
+                if root.join(dir).join("go.mod").is_file() {
+                    pending.push(dir.to_owned());
+                    break;
+                }
+                if dir.is_empty() {
+                    break;
+                }
+                dir = path_helper::parent_dir(dir);
+            }
+        }
+
+        let mut read = HashSet::new();
+        while let Some(dir) = pending.pop() {
+            if !read.insert(dir.clone()) {
+                continue;
+            }
+            let text = match std::fs::read_to_string(root.join(&dir).join("go.mod")) {
+                Ok(text) => text,
+                Err(_) => continue,
+            };
+            let gomod = Directives::parse(&text);
+            if let Some(module) = gomod.module {
+                modules.push((module, dir.clone()));
+            }
+            add_replacements(&mut modules, &mut pending, &dir, &gomod.replaces);
+        }
 
-    let mut map = cache.lock().unwrap();
-    if let Some(cached) = map.get(&go_mod_dir) {
-        return cached.clone();
+        modules.sort_by(|a, b| b.0.len().cmp(&a.0.len()).then_with(|| a.cmp(b)));
+        modules.dedup_by(|a, b| a.0 == b.0);
+        Self { modules, packages }
     }
 
-    let result = parse_module_line(&go_mod_dir.join("go.mod"));
-    map.insert(go_mod_dir, result.clone());
-    result
+    /// The non-test files of the package `import` names, or `None` when no
+    /// module in the project provides it.
+    pub fn resolve(&self, import: &str) -> Option<Vec<String>> {
+        let (module, dir) = self.modules.iter().find(|(m, _)| {
+            import.strip_prefix(m.as_str()).map_or(false, |rest| rest.is_empty() || rest.starts_with('/'))
+        })?;
+        let package = common::join_dir(dir, import[module.len()..].trim_start_matches('/'))?;
+        Some(self.packages.get(&package).cloned().unwrap_or_default())
+    }
 }
 
-fn find_go_mod_dir(file_path: &Path) -> Option<PathBuf> {
-    let mut dir = if file_path.is_file() {
-        file_path.parent()?.to_path_buf()
-    } else {
-        file_path.to_path_buf()
-    };
-
-    loop {
-        if dir.join("go.mod").is_file() {
-            return Some(dir);
-        }
-        if !dir.pop() {
-            return None;
+/// Records local `replace` targets and queues their `go.mod` for reading.
+fn add_replacements(modules: &mut Vec<(String, String)>, pending: &mut Vec<String>, dir: &str, replaces: &[(String, String)]) {
+    for (module, target) in replaces {
+        if let Some(target) = common::join_dir(dir, target) {
+            modules.push((module.clone(), target.clone()));
+            pending.push(target);
         }
     }
 }
 
-fn parse_module_line(go_mod_path: &Path) -> Option<String> {
-    let content = std::fs::read_to_string(go_mod_path).ok()?;
-    for line in content.lines() {
-        let trimmed = line.trim();
-        if let Some(rest) = trimmed.strip_prefix("module ") {
-            let module = rest.trim();
-            if !module.is_empty() {
-                return Some(module.to_owned());
+/// The `module`, `use` and local `replace` directives of a `go.mod` or
+/// `go.work` file.
+#[derive(Default)]
+struct Directives {
+    module: Option<String>,
+    uses: Vec<String>,
+    replaces: Vec<(String, String)>,
+}
+
+impl Directives {
+    fn parse(text: &str) -> Self {
+        let mut directives = Directives::default();
+        let mut block: Option<&str> = None;
+        for line in text.lines() {
+            let line = line.split("//").next().unwrap_or("").trim();
+            if line.is_empty() {
+                continue;
+            }
+            if block.is_some() && line == ")" {
+                block = None;
+                continue;
+            }
+            let (verb, rest) = match block {
+                Some(verb) => (verb, line),
+                None => {
+                    let (verb, rest) = line.split_once(char::is_whitespace).unwrap_or((line, ""));
+                    let rest = rest.trim();
+                    if rest == "(" {
+                        block = Some(verb);
+                        continue;
+                    }
+                    (verb, rest)
+                }
+            };
+            match verb {
+                "module" => directives.module = Some(rest.trim_matches('"').to_owned()),
+                "use" => directives.uses.push(rest.trim_matches('"').to_owned()),
+                "replace" => {
+                    if let Some((from, to)) = rest.split_once("=>") {
+                        let module = from.split_whitespace().next().unwrap_or("");
+                        let target = to.trim().trim_matches('"');
+                        if !module.is_empty() && (target.starts_with("./") || target.starts_with("../")) {
+                            directives.replaces.push((module.to_owned(), target.to_owned()));
+                        }
+                    }
+                }
+                _ => {}
             }
         }
+        directives
     }
-    None
 }
 
 impl LangImports for GoImports {
@@ -60,26 +159,40 @@ impl LangImports for GoImports {
         &["go"]
     }
 
-    fn extract_imports(&self, content: &str, file_path: &Path) -> Vec<String> {
-        let module_path = match get_module_path_owned(file_path) {
-            Some(m) => m,
-            None => return Vec::new(),
-        };
-        let module_path = module_path.as_str();
+    fn extract_imports(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        parse_go_imports(content)
+            .iter()
+            .filter(|imp| !is_go_stdlib(imp))
+            .map(|imp| format!("{}{}", GO_PREFIX, imp))
+            .collect()
+    }
 
-        let raw_imports = parse_go_imports(content);
-        let mut results = Vec::new();
+    fn extract_external(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        parse_go_imports(content)
+            .iter()
+            .filter(|imp| !is_go_stdlib(imp))
+            .map(|imp| external::marker("go", go_module_of(imp), &[format!("{}{}", GO_PREFIX, imp)]))
+            .collect()
+    }
+}
 
-        for imp in raw_imports {
-            if let Some(rest) = imp.strip_prefix(module_path) {
-                let rel = rest.strip_prefix('/').unwrap_or(rest);
-                if !rel.is_empty() {
-                    results.push(format!("{}/", rel));
-                }
-            }
-        }
+/// Standard library paths have no dot in their first element.
+fn is_go_stdlib(import: &str) -> bool {
+    !import.split('/').next().unwrap_or("").contains('.')
+}
 
-        results
+/// The module an import path most likely belongs to: the repository on the
+/// well-known hosts, the whole path elsewhere.
+fn go_module_of(import: &str) -> &str {
+    let host = import.split('/').next().unwrap_or("");
+    let segments = match host {
+        "github.com" | "gitlab.com" | "bitbucket.org" | "golang.org" | "go.googlesource.com" => 3,
+        "gopkg.in" => 2,
+        _ => return import,
+    };
+    match import.match_indices('/').nth(segments - 1) {
+        Some((i, _)) => &import[..i],
+        None => import,
     }
 }
 
@@ -132,11 +245,22 @@ fn extract_quoted_path(line: &str) -> Option<&str> {
     if path.is_empty() { None } else { Some(path) }
 }
 
+/// Backquoted strings are raw and may span lines.
+const SYNTAX: Syntax = Syntax {
+    quotes: &[quote("\"", true, false), quote("`", false, true)],
+    imports: &["import "],
+    ..lexer::C_LIKE
+};
+
 impl LangSymbols for GoImports {
     fn extensions(&self) -> &[&str] {
         &["go"]
     }
 
+    fn syntax(&self) -> &'static Syntax {
+        &SYNTAX
+    }
+
     fn extract_symbols(&self, content: &str) -> Vec<SymbolInfo> {
         let all_lines: Vec<&str> = content.lines().collect();
         let mut symbols = Vec::new();
@@ -373,11 +497,69 @@ fn make_go_signature(trimmed: &str) -> String {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::test_support::temp_project;
 
     fn extract_syms(content: &str) -> Vec<SymbolInfo> {
         <GoImports as LangSymbols>::extract_symbols(&GoImports, content)
     }
 
+    // ── External Tests ──
+
+    #[test]
+    fn go_module_of_trims_to_repository() {
+        assert_eq!(go_module_of("github.com/spf13/cobra/doc"), "github.com/spf13/cobra");
+        assert_eq!(go_module_of("golang.org/x/sync/errgroup"), "golang.org/x/sync");
+        assert_eq!(go_module_of("gopkg.in/yaml.v3"), "gopkg.in/yaml.v3");
+        assert_eq!(go_module_of("go.uber.org/zap/zapcore"), "go.uber.org/zap/zapcore");
+    }
+
+    #[test]
+    fn imports_and_externals_skip_stdlib() {
+        let content = "import (\n\t\"fmt\"\n\t\"example.com/app/internal/db\"\n\t\"github.com/spf13/cobra\"\n)\n";
+        assert_eq!(GoImports.extract_imports(content, Path::new("main.go")), vec!["go:example.com/app/internal/db", "go:github.com/spf13/cobra"]);
+        assert_eq!(GoImports.extract_external(content, Path::new("main.go")), vec![
+            "external:go:example.com/app/internal/db|go:example.com/app/internal/db",
+            "external:go:github.com/spf13/cobra|go:github.com/spf13/cobra",
+        ]);
+    }
+
+    // ── Modules ──
+
+    #[test]
+    fn directives_parse_blocks_and_local_replaces() {
+        let text = "module example.com/app // the app\n\ngo 1.22\n\nreplace example.com/shared => ../shared\nreplace (\n\texample.com/tools v1.0.0 => ./tools\n\tgolang.org/x/net => golang.org/x/net v0.1.0\n)\n";
+        let d = Directives::parse(text);
+        assert_eq!(d.module.as_deref(), Some("example.com/app"));
+        assert_eq!(d.replaces, vec![
+            ("example.com/shared".to_owned(), "../shared".to_owned()),
+            ("example.com/tools".to_owned(), "./tools".to_owned()),
+        ]);
+        let work = Directives::parse("go 1.22\n\nuse (\n\t./svc\n\t./lib\n)\nuse ./tools\n");
+        assert_eq!(work.uses, vec!["./svc", "./lib", "./tools"]);
+    }
+
+    #[test]
+    fn modules_resolve_across_go_work_and_replace() {
+        let root = temp_project("go_modules", &[
+            ("go.work", "go 1.22\nuse (\n\t./svc\n)\n"),
+            ("svc/go.mod", "module example.com/svc\nreplace example.com/shared => ../libs/shared\n"),
+            ("libs/shared/go.mod", "module example.com/shared\n"),
+            ("tools/go.mod", "module example.com/tools\n"),
+        ]);
+        let files: HashSet<String> = [
+            "svc/main.go", "svc/api/handler.go", "svc/api/handler_test.go", "svc/api/v2/routes.go",
+            "libs/shared/money/money.go", "tools/gen/gen.go",
+        ].iter().map(|s| (*s).to_owned()).collect();
+        let modules = GoModules::load(&root, &files);
+        assert_eq!(modules.resolve("example.com/svc/api"), Some(vec!["svc/api/handler.go".to_owned()]));
+        assert_eq!(modules.resolve("example.com/shared/money"), Some(vec!["libs/shared/money/money.go".to_owned()]));
+        assert_eq!(modules.resolve("example.com/tools/gen"), Some(vec!["tools/gen/gen.go".to_owned()]));
+        assert_eq!(modules.resolve("example.com/svc/missing"), Some(vec![]));
+        assert_eq!(modules.resolve("example.com/svcx/api"), None);
+        assert_eq!(modules.resolve("github.com/spf13/cobra"), None);
+        std::fs::remove_dir_all(&root).ok();
+    }
+
     // ── Symbol Tests ──
 
     #[test]
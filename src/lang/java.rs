@@ -1,6 +1,14 @@
+use std::collections::{BTreeSet, HashMap, HashSet};
 use std::path::Path;
+use std::sync::OnceLock;
+
+use regex::Regex;
+
 use super::{LangImports, LangSymbols, SymbolInfo};
 use super::common::{self, CommentTracker};
+use super::lexer::{self, quote, Syntax};
+use crate::external;
+use crate::path_helper;
 
 pub struct JavaImports;
 
@@ -21,21 +29,258 @@ impl LangImports for JavaImports {
                     let path = path.trim();
                     if !path.is_empty() && !is_jdk_package(path) {
                         let file_path = java_import_to_path(path);
-                        imports.push(file_path);
+                        imports.push(format!("{}{}", JVM_PREFIX, file_path));
                     }
                 }
             }
         }
 
+        imports.extend(same_package_candidates(content, "java").into_iter().map(|(_, c)| c));
         imports
     }
+
+    fn contextual_imports(&self, content: &str, _file_path: &Path) -> Vec<(usize, String)> {
+        same_package_candidates(content, "java")
+    }
+
+    fn extract_external(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        let mut external = Vec::new();
+
+        for line in content.lines() {
+            let trimmed = line.trim();
+
+            if let Some(rest) = trimmed.strip_prefix("import ") {
+                let rest = rest.strip_prefix("static ").unwrap_or(rest);
+                let path = match rest.strip_suffix(';') {
+                    Some(path) => path.trim(),
+                    None => continue,
+                };
+                let package = common::jvm_package(path);
+                if package.is_empty() || is_jdk_package(path) {
+                    continue;
+                }
+                // Only external when no project source lives in the package,
+                // under any source root.
+                let local = [format!("{}{}/", JVM_PREFIX, package.replace('.', "/"))];
+                external.push(external::marker("maven", package, &local));
+            }
+        }
+
+        external
+    }
+}
+
+/// Raw candidates for Java and Kotlin imports carry the package path after
+/// this prefix; `JvmSources` looks them up under every source root.
+pub const JVM_PREFIX: &str = "jvm:";
+
+/// The source roots of the Java and Kotlin code in a project: the project
+/// root, `src/<set>/java` and `src/<set>/kotlin` wherever files sit in one,
+/// the default roots of every module `settings.gradle(.kts)` includes or a
+/// `pom.xml` lists, and the source directories their build files declare.
+#[derive(Default)]
+pub struct JvmSources {
+    roots: Vec<String>,
+    /// `.java` and `.kt` files by directory.
+    packages: HashMap<String, Vec<String>>,
+}
+
+impl JvmSources {
+    pub fn load(root: &Path, files: &HashSet<String>) -> Self {
+        let mut packages: HashMap<String, Vec<String>> = HashMap::new();
+        for file in files.iter().filter(|f| f.ends_with(".java") || f.ends_with(".kt")) {
+            packages.entry(path_helper::parent_dir(file).to_owned()).or_default().push(file.clone());
+        }
+        if packages.is_empty() {
+            return Self::default();
+        }
+        for package in packages.values_mut() {
+            package.sort_unstable();
+        }
+
+        let mut roots: BTreeSet<String> = BTreeSet::new();
+        roots.insert(String::new());
+        roots.extend(packages.keys().filter_map(|dir| conventional_root(dir)));
+        for module in build_modules(root) {
+            for set in ["main", "test"] {
+                for lang in ["java", "kotlin"] {
+                    roots.extend(common::join_dir(&module, &format!("src/{}/{}", set, lang)));
+                }
+            }
+            for dir in declared_source_dirs(&root.join(&module)) {
+                roots.extend(common::join_dir(&module, &dir));
+            }
+        }
+        Self { roots: roots.into_iter().collect(), packages }
+    }
+
+    /// The files a `jvm:` candidate names. A path ending in `/` is a whole
+    /// package; otherwise the class file, found under any root and in either
+    /// language.
+    pub fn resolve(&self, path: &str) -> Option<Vec<String>> {
+        if let Some(package) = path.strip_suffix('/') {
+            let files: Vec<String> = self
+                .roots
+                .iter()
+                .filter_map(|root| self.packages.get(&common::under(root, package)))
+                .flatten()
+                .cloned()
+                .collect();
+            return if files.is_empty() { None } else { Some(files) };
+        }
+
+        let mut class = path.strip_suffix(".java").or_else(|| path.strip_suffix(".kt")).unwrap_or(path);
+        loop {
+            for root in &self.roots {
+                for ext in ["java", "kt"] {
+                    let file = format!("{}.{}", common::under(root, class), ext);
+                    if self.packages.get(path_helper::parent_dir(&file)).map_or(false, |p| p.binary_search(&file).is_ok()) {
+                        return Some(vec![file]);
+                    }
+                }
+            }
+            // Nested classes and static members live in the enclosing class's file.
+            match class.rfind('/') {
+                Some(i) if class[..i].rsplit('/').next().map_or(false, |s| s.starts_with(|c: char| c.is_ascii_uppercase())) => {
+                    class = &class[..i];
+                }
+                _ => return None,
+            }
+        }
+    }
+}
+
+/// `a/src/main/java/com/x` lies in the source root `a/src/main/java`.
+fn conventional_root(dir: &str) -> Option<String> {
+    let parts: Vec<&str> = dir.split('/').collect();
+    (0..parts.len().saturating_sub(2))
+        .find(|&i| parts[i] == "src" && matches!(parts[i + 2], "java" | "kotlin"))
+        .map(|i| parts[..i + 3].join("/"))
+}
+
+/// The root and every module a root `settings.gradle(.kts)` includes or a
+/// `pom.xml` lists, nested Maven modules included.
+fn build_modules(root: &Path) -> Vec<String> {
+    let mut modules = vec![String::new()];
+    for name in ["settings.gradle", "settings.gradle.kts"] {
+        if let Ok(text) = std::fs::read_to_string(root.join(name)) {
+            modules.extend(gradle_includes(&text));
+        }
+    }
+    let mut i = 0;
+    while i < modules.len() {
+        if let Ok(text) = std::fs::read_to_string(root.join(&modules[i]).join("pom.xml")) {
+            static MODULE: OnceLock<Regex> = OnceLock::new();
+            let module = MODULE.get_or_init(|| Regex::new(r"<module>\s*([^<]+?)\s*</module>").unwrap());
+            for c in module.captures_iter(&text) {
+                if let Some(dir) = common::join_dir(&modules[i], &c[1]) {
+                    if !modules.contains(&dir) {
+                        modules.push(dir);
+                    }
+                }
+            }
+        }
+        i += 1;
+    }
+    modules
+}
+
+/// Module directories from `include(...)` calls, with `:a:b` read as `a/b`
+/// unless a `project(':a:b').projectDir` assignment moves it.
+fn gradle_includes(text: &str) -> Vec<String> {
+    static INCLUDE: OnceLock<Regex> = OnceLock::new();
+    let include = INCLUDE.get_or_init(|| Regex::new(r#"\binclude\s*\(?((?:\s*['"][^'"]+['"]\s*,?)+)"#).unwrap());
+    static QUOTED: OnceLock<Regex> = OnceLock::new();
+    let quoted = QUOTED.get_or_init(|| Regex::new(r#"['"]([^'"]+)['"]"#).unwrap());
+    static PROJECT_DIR: OnceLock<Regex> = OnceLock::new();
+    let project_dir = PROJECT_DIR.get_or_init(|| Regex::new(r#"project\(\s*['"]([^'"]+)['"]\s*\)\.projectDir\s*=\s*(?:file|new\s+File)\(\s*(?:[^,()]*,\s*)?['"]([^'"]+)['"]"#).unwrap());
+
+    let moved: HashMap<String, String> = project_dir
+        .captures_iter(text)
+        .map(|c| (c[1].trim_start_matches(':').to_owned(), c[2].to_owned()))
+        .collect();
+    include
+        .captures_iter(text)
+        .flat_map(|c| quoted.captures_iter(&c[1]).map(|q| q[1].trim_start_matches(':').to_owned()).collect::<Vec<_>>())
+        .map(|name| moved.get(&name).cloned().unwrap_or_else(|| name.replace(':', "/")))
+        .collect()
+}
+
+/// Source directories a module's `build.gradle(.kts)` (`srcDir`, `srcDirs`)
+/// or `pom.xml` (`sourceDirectory`, `testSourceDirectory`) declares.
+fn declared_source_dirs(module: &Path) -> Vec<String> {
+    let mut dirs = Vec::new();
+    static QUOTED: OnceLock<Regex> = OnceLock::new();
+    let quoted = QUOTED.get_or_init(|| Regex::new(r#"['"]([^'"]+)['"]"#).unwrap());
+    for name in ["build.gradle", "build.gradle.kts"] {
+        if let Ok(text) = std::fs::read_to_string(module.join(name)) {
+            for line in text.lines().filter(|l| l.contains("srcDir")) {
+                let after = &line[line.find("srcDir").unwrap_or(0)..];
+                dirs.extend(quoted.captures_iter(after).map(|c| c[1].to_owned()));
+            }
+        }
+    }
+    if let Ok(text) = std::fs::read_to_string(module.join("pom.xml")) {
+        static SOURCE: OnceLock<Regex> = OnceLock::new();
+        let source = SOURCE.get_or_init(|| Regex::new(r"<(?:testS|s)ourceDirectory>\s*([^<]+?)\s*</").unwrap());
+        for c in source.captures_iter(&text) {
+            let dir = c[1].trim_start_matches("${project.basedir}/").trim_start_matches("${basedir}/");
+            dirs.push(dir.to_owned());
+        }
+    }
+    dirs
+}
+
+/// Classes of the file's own package need no import: every capitalized name
+/// the code mentions becomes a candidate in the package directory, with the
+/// 0-based line of its first mention. Files without a `package` line have none.
+pub fn same_package_candidates(content: &str, ext: &str) -> Vec<(usize, String)> {
+    let package = content
+        .lines()
+        .find_map(|line| line.trim().strip_prefix("package "))
+        .map(|rest| rest.trim().trim_end_matches(';').trim());
+    let package = match package {
+        Some(package) => package,
+        None => return Vec::new(),
+    };
+    static NAME: OnceLock<Regex> = OnceLock::new();
+    let name = NAME.get_or_init(|| Regex::new(r"\b[A-Z][A-Za-z0-9_]*[a-z][A-Za-z0-9_]*\b").unwrap());
+    static STRING: OnceLock<Regex> = OnceLock::new();
+    let string = STRING.get_or_init(|| Regex::new(r#""(?:[^"\\]|\\.)*""#).unwrap());
+    let dir = package.replace('.', "/");
+    let mut comment_tracker = CommentTracker::new();
+    let mut seen = HashSet::new();
+    let mut candidates = Vec::new();
+    for (idx, line) in content.lines().enumerate() {
+        let trimmed = line.trim();
+        if comment_tracker.is_comment(trimmed, "//") || trimmed.starts_with("import ") || trimmed.starts_with("package ") {
+            continue;
+        }
+        let code = string.replace_all(trimmed.split("//").next().unwrap_or(""), "");
+        for m in name.find_iter(&code) {
+            if seen.insert(m.as_str().to_owned()) {
+                candidates.push((idx, format!("{}{}/{}.{}", JVM_PREFIX, dir, m.as_str(), ext)));
+            }
+        }
+    }
+    candidates
 }
 
+const SYNTAX: Syntax = Syntax {
+    quotes: &[quote("\"\"\"", true, true), quote("\"", true, false)],
+    imports: &["import "],
+    ..lexer::C_LIKE
+};
+
 impl LangSymbols for JavaImports {
     fn extensions(&self) -> &[&str] {
         &["java"]
     }
 
+    fn syntax(&self) -> &'static Syntax {
+        &SYNTAX
+    }
+
     fn extract_symbols(&self, content: &str) -> Vec<SymbolInfo> {
         let all_lines: Vec<&str> = content.lines().collect();
         let mut symbols = Vec::new();
@@ -330,6 +575,7 @@ fn java_import_to_path(import_path: &str) -> String {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::test_support::temp_project;
 
     fn extract_imports(content: &str) -> Vec<String> {
         JavaImports.extract_imports(content, Path::new("src/Main.java"))
@@ -382,6 +628,68 @@ mod tests {
         assert!(imports.is_empty());
     }
 
+    #[test]
+    fn external_packages_skip_jdk() {
+        let content = "import java.util.List;\nimport static org.junit.jupiter.api.Assertions.assertEquals;\nimport com.google.common.collect.*;\n";
+        let external = JavaImports.extract_external(content, Path::new("src/Main.java"));
+        assert_eq!(external, vec![
+            "external:maven:org.junit.jupiter.api|jvm:org/junit/jupiter/api/",
+            "external:maven:com.google.common.collect|jvm:com/google/common/collect/",
+        ]);
+    }
+
+    #[test]
+    fn package_classes_are_candidates_without_import() {
+        let content = "package com.example.billing;\n\nimport com.example.model.User;\n\n// Uses InvoiceHelper\npublic class Invoice extends BaseDocument {\n    private TaxRate rate = TaxRate.of(\"Flat Rate\");\n    static final int MAX = 3;\n}\n";
+        let imports = extract_imports(content);
+        assert_eq!(imports, vec![
+            "jvm:com/example/model/User.java",
+            "jvm:com/example/billing/Invoice.java",
+            "jvm:com/example/billing/BaseDocument.java",
+            "jvm:com/example/billing/TaxRate.java",
+        ]);
+    }
+
+    // ── Source roots ──
+
+    #[test]
+    fn build_files_name_modules_and_source_dirs() {
+        let settings = "rootProject.name = 'shop'\ninclude 'api', ':billing:core'\ninclude(\n    \":web\",\n)\nproject(':web').projectDir = file('apps/web')\n";
+        assert_eq!(gradle_includes(settings), vec!["api", "billing/core", "apps/web"]);
+        assert_eq!(conventional_root("billing/core/src/test/kotlin/com/shop"), Some("billing/core/src/test/kotlin".to_owned()));
+        assert_eq!(conventional_root("src/com/shop"), None);
+    }
+
+    #[test]
+    fn sources_resolve_classes_and_packages_under_module_roots() {
+        let files = [
+            ("pom.xml", "<project><modules><module>core</module><module>legacy</module></modules></project>"),
+            ("core/pom.xml", "<project/>"),
+            ("core/src/main/java/com/shop/Order.java", ""),
+            ("core/src/main/java/com/shop/Cart.java", ""),
+            ("core/src/main/java/com/shop/util/Money.java", ""),
+            ("core/src/main/kotlin/com/shop/Pricing.kt", ""),
+            ("legacy/build.gradle", "sourceSets {\n    main {\n        java { srcDirs = ['java', 'generated'] }\n    }\n}\n"),
+            ("legacy/java/com/shop/legacy/Importer.java", ""),
+        ];
+        let root = temp_project("jvm_sources", &files);
+        let scanned = files.iter().map(|(f, _)| (*f).to_owned()).filter(|f| !f.ends_with(".xml") && !f.ends_with(".gradle")).collect();
+        let sources = JvmSources::load(&root, &scanned);
+
+        assert_eq!(sources.resolve("com/shop/Order.java"), Some(vec!["core/src/main/java/com/shop/Order.java".to_owned()]));
+        assert_eq!(sources.resolve("com/shop/Pricing.java"), Some(vec!["core/src/main/kotlin/com/shop/Pricing.kt".to_owned()]));
+        assert_eq!(sources.resolve("com/shop/Order/Line.java"), Some(vec!["core/src/main/java/com/shop/Order.java".to_owned()]));
+        assert_eq!(sources.resolve("com/shop/legacy/Importer.java"), Some(vec!["legacy/java/com/shop/legacy/Importer.java".to_owned()]));
+        assert_eq!(sources.resolve("com/shop/"), Some(vec![
+            "core/src/main/java/com/shop/Cart.java".to_owned(),
+            "core/src/main/java/com/shop/Order.java".to_owned(),
+            "core/src/main/kotlin/com/shop/Pricing.kt".to_owned(),
+        ]));
+        assert_eq!(sources.resolve("com/shop/Missing.java"), None);
+        assert_eq!(sources.resolve("org/acme/"), None);
+        std::fs::remove_dir_all(&root).ok();
+    }
+
     // ── Symbol Tests ──
 
     #[test]
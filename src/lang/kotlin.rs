@@ -1,6 +1,9 @@
 use std::path::Path;
 use super::{LangImports, LangSymbols, SymbolInfo};
 use super::common::{self, CommentTracker};
+use super::lexer::{self, quote, Syntax};
+use super::java::{self, JVM_PREFIX};
+use crate::external;
 
 pub struct KotlinImports;
 
@@ -21,19 +24,57 @@ impl LangImports for KotlinImports {
                     continue;
                 }
                 let file_path = kotlin_import_to_path(path);
-                imports.push(file_path);
+                imports.push(format!("{}{}", JVM_PREFIX, file_path));
             }
         }
 
+        imports.extend(java::same_package_candidates(content, "kt").into_iter().map(|(_, c)| c));
         imports
     }
+
+    fn contextual_imports(&self, content: &str, _file_path: &Path) -> Vec<(usize, String)> {
+        java::same_package_candidates(content, "kt")
+    }
+
+    fn extract_external(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        let mut external = Vec::new();
+
+        for line in content.lines() {
+            let trimmed = line.trim();
+
+            if let Some(rest) = trimmed.strip_prefix("import ") {
+                let path = rest.trim().trim_end_matches(';');
+                let path = path.split(" as ").next().unwrap_or(path);
+                let package = common::jvm_package(path);
+                if package.is_empty() || is_kotlin_stdlib(path) {
+                    continue;
+                }
+                // Only external when no project source lives in the package,
+                // under any source root.
+                let local = [format!("{}{}/", JVM_PREFIX, package.replace('.', "/"))];
+                external.push(external::marker("maven", package, &local));
+            }
+        }
+
+        external
+    }
 }
 
+const SYNTAX: Syntax = Syntax {
+    quotes: &[quote("\"\"\"", false, true).interpolated("${", b""), quote("\"", true, false).interpolated("${", b"")],
+    imports: &["import "],
+    ..lexer::C_LIKE
+};
+
 impl LangSymbols for KotlinImports {
     fn extensions(&self) -> &[&str] {
         &["kt", "kts"]
     }
 
+    fn syntax(&self) -> &'static Syntax {
+        &SYNTAX
+    }
+
     fn extract_symbols(&self, content: &str) -> Vec<SymbolInfo> {
         let all_lines: Vec<&str> = content.lines().collect();
         let mut symbols = Vec::new();
@@ -346,6 +387,27 @@ mod tests {
         assert!(imports.is_empty());
     }
 
+    #[test]
+    fn external_packages_skip_stdlib() {
+        let content = "import kotlin.math.max\nimport io.ktor.server.application.*\nimport org.slf4j.LoggerFactory as Lf\n";
+        let external = KotlinImports.extract_external(content, Path::new("src/Main.kt"));
+        assert_eq!(external, vec![
+            "external:maven:io.ktor.server.application|jvm:io/ktor/server/application/",
+            "external:maven:org.slf4j|jvm:org/slf4j/",
+        ]);
+    }
+
+    #[test]
+    fn package_classes_are_candidates_without_import() {
+        let content = "package com.example.billing\n\nimport com.example.model.User\n\nclass Invoice(val rate: TaxRate)\n";
+        let imports = extract_imports(content);
+        assert_eq!(imports, vec![
+            "jvm:com/example/model/User.kt",
+            "jvm:com/example/billing/Invoice.kt",
+            "jvm:com/example/billing/TaxRate.kt",
+        ]);
+    }
+
     // ── Symbol: class ──
 
     #[test]
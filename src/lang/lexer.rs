@@ -0,0 +1,381 @@
+use std::ops::Range;
+
+/// Just enough lexing to tell code from comments and string literals.
+
+/// A string literal delimiter.
+pub struct Quote {
+    pub delim: &'static str,
+    /// Backslash escapes the next character.
+    pub escapes: bool,
+    /// The literal may run past the end of a line; otherwise an unterminated
+    /// literal stops there.
+    pub multiline: bool,
+    pub interpolation: Option<Interpolation>,
+}
+
+/// Code embedded in a string literal, from `open` to the matching `}`.
+pub struct Interpolation {
+    pub open: &'static str,
+    /// One of these must come right before the opening delimiter (Python's
+    /// `f"`, C#'s `$"`); empty when every such literal interpolates.
+    pub prefixes: &'static [u8],
+}
+
+/// The comment and string syntax of a language.
+pub struct Syntax {
+    pub line_comment: &'static str,
+    pub block_comment: Option<(&'static str, &'static str)>,
+    /// Block comment delimiters only count at the start of a line (Ruby's
+    /// `=begin` and `=end`).
+    pub block_at_line_start: bool,
+    /// Longest delimiters first, so `"""` is tried before `"`.
+    pub quotes: &'static [Quote],
+    /// `'` opens a character literal such as `'a'` or `'\n'`; any other `'`
+    /// is code (a Rust lifetime or label).
+    pub char_literals: bool,
+    /// Rust's `r"..."` and `r#"..."#`.
+    pub raw_strings: bool,
+    /// Statement prefixes that bring names into scope.
+    pub imports: &'static [&'static str],
+    /// `obj.name` calls a method even without parentheses (Ruby).
+    pub paren_free_calls: bool,
+}
+
+pub const fn quote(delim: &'static str, escapes: bool, multiline: bool) -> Quote {
+    Quote { delim, escapes, multiline, interpolation: None }
+}
+
+impl Quote {
+    /// The same literal with code embedded from `open` to the matching `}`,
+    /// when one of `prefixes` precedes the delimiter (or always, if empty).
+    pub const fn interpolated(self, open: &'static str, prefixes: &'static [u8]) -> Quote {
+        Quote { interpolation: Some(Interpolation { open, prefixes }), ..self }
+    }
+}
+
+/// C-family defaults for files whose language sets nothing more specific.
+pub const C_LIKE: Syntax = Syntax {
+    line_comment: "//",
+    block_comment: Some(("/*", "*/")),
+    block_at_line_start: false,
+    quotes: &[quote("\"", true, false)],
+    char_literals: true,
+    raw_strings: false,
+    imports: &[],
+    paren_free_calls: false,
+};
+
+/// Blanks the inside of every comment and string literal in `content` with
+/// spaces. Line breaks, string delimiters and byte offsets are kept, so lines
+/// and columns of the result match the source. Code interpolated into a
+/// literal (`${run()}`, `#{run}`, an f-string's `{run()}`) stays.
+pub fn mask(content: &str, syntax: &Syntax) -> String {
+    let bytes = content.as_bytes();
+    let mut out = bytes.to_vec();
+    mask_code(bytes, &mut out, 0, syntax, false);
+    // Only whole literals, which start and end at ASCII delimiters, were
+    // blanked, so the bytes are still UTF-8.
+    String::from_utf8(out).unwrap_or_else(|_| content.to_owned())
+}
+
+/// Masks code from `i` to the end of input or, `nested` in an interpolation,
+/// to the `}` that closes it. Returns the offset where it stopped.
+fn mask_code(bytes: &[u8], out: &mut [u8], mut i: usize, syntax: &Syntax, nested: bool) -> usize {
+    let mut depth = 0;
+    while i < bytes.len() {
+        let rest = &bytes[i..];
+        if let Some((open, close)) = syntax.block_comment {
+            if rest.starts_with(open.as_bytes()) && (!syntax.block_at_line_start || i == 0 || bytes[i - 1] == b'\n') {
+                let end = find_block_end(bytes, i + open.len(), close, syntax.block_at_line_start);
+                blank(out, i, end);
+                i = end;
+                continue;
+            }
+        }
+        if !syntax.line_comment.is_empty() && rest.starts_with(syntax.line_comment.as_bytes()) {
+            let end = line_end(bytes, i);
+            blank(out, i, end);
+            i = end;
+            continue;
+        }
+        if syntax.char_literals && bytes[i] == b'\'' {
+            if let Some(end) = char_literal_end(bytes, i) {
+                blank(out, i + 1, end - 1);
+                i = end;
+                continue;
+            }
+            i += 1;
+            continue;
+        }
+        if syntax.raw_strings && bytes[i] == b'r' && (i == 0 || !is_ident(bytes[i - 1]) || (bytes[i - 1] == b'b' && (i < 2 || !is_ident(bytes[i - 2])))) {
+            if let Some((body, end)) = raw_string_end(bytes, i + 1) {
+                blank(out, body.0, body.1);
+                i = end;
+                continue;
+            }
+        }
+        if let Some(q) = syntax.quotes.iter().find(|q| rest.starts_with(q.delim.as_bytes())) {
+            i = mask_string(bytes, out, i, q, syntax);
+            continue;
+        }
+        if nested {
+            match bytes[i] {
+                b'{' => depth += 1,
+                b'}' if depth == 0 => return i,
+                b'}' => depth -= 1,
+                _ => {}
+            }
+        }
+        i += 1;
+    }
+    i
+}
+
+/// Masks the literal whose delimiter starts at `at`, except for interpolated
+/// code, which is masked as code in turn. Returns the offset just past the
+/// literal.
+fn mask_string(bytes: &[u8], out: &mut [u8], at: usize, q: &Quote, syntax: &Syntax) -> usize {
+    let interpolation = q.interpolation.as_ref().filter(|interp| interp.prefixes.is_empty() || prefixed(bytes, at, interp.prefixes));
+    let mut text = at + q.delim.len();
+    let mut i = text;
+    while i < bytes.len() {
+        if q.escapes && bytes[i] == b'\\' {
+            i += 2;
+            continue;
+        }
+        if bytes[i..].starts_with(q.delim.as_bytes()) {
+            blank(out, text, i);
+            return i + q.delim.len();
+        }
+        if bytes[i] == b'\n' && !q.multiline {
+            blank(out, text, i);
+            return i;
+        }
+        if let Some(interp) = interpolation {
+            // `{{` is a literal brace where `{` alone interpolates.
+            if interp.open == "{" && bytes[i..].starts_with(b"{{") {
+                i += 2;
+                continue;
+            }
+            if bytes[i..].starts_with(interp.open.as_bytes()) {
+                let code = i + interp.open.len();
+                blank(out, text, code);
+                let close = mask_code(bytes, out, code, syntax, true);
+                i = (close + 1).min(bytes.len());
+                blank(out, close, i);
+                text = i;
+                continue;
+            }
+        }
+        i += 1;
+    }
+    blank(out, text, bytes.len());
+    bytes.len()
+}
+
+/// Whether the letters right before the delimiter at `at` (a string prefix
+/// such as `rf` or `$@`) include one of `prefixes`.
+fn prefixed(bytes: &[u8], at: usize, prefixes: &[u8]) -> bool {
+    bytes[..at]
+        .iter()
+        .rev()
+        .take_while(|&&b| b.is_ascii_alphabetic() || b == b'$' || b == b'@')
+        .any(|b| prefixes.contains(b))
+}
+
+/// The import statements of masked `code`, as 0-based line ranges. A
+/// statement starts on a line that begins with one of the syntax's import
+/// prefixes and runs on while its brackets stay open (`use a::{`,
+/// `import (`, `from m import (`).
+pub fn import_statements(code: &str, syntax: &Syntax) -> Vec<Range<usize>> {
+    let mut statements = Vec::new();
+    let mut open: Option<(usize, i32)> = None;
+    let mut count = 0;
+    for (i, line) in code.lines().enumerate() {
+        count = i + 1;
+        let (start, mut depth) = match open.take() {
+            Some(statement) => statement,
+            None if starts_import(line, syntax) => (i, 0),
+            None => continue,
+        };
+        for b in line.bytes() {
+            match b {
+                b'{' | b'(' => depth += 1,
+                b'}' | b')' => depth = (depth - 1).max(0),
+                _ => {}
+            }
+        }
+        if depth > 0 {
+            open = Some((start, depth));
+        } else {
+            statements.push(start..i + 1);
+        }
+    }
+    if let Some((start, _)) = open {
+        statements.push(start..count);
+    }
+    statements
+}
+
+fn starts_import(line: &str, syntax: &Syntax) -> bool {
+    let trimmed = line.trim_start();
+    syntax.imports.iter().any(|prefix| {
+        // C#'s `using var x = ...` and `using (var x = ...)` are not imports.
+        trimmed.strip_prefix(prefix).map_or(false, |rest| !rest.trim_start_matches('(').starts_with("var "))
+    })
+}
+
+fn is_ident(b: u8) -> bool {
+    b.is_ascii_alphanumeric() || b == b'_'
+}
+
+/// Replaces everything but line breaks in `out[from..to]` with spaces.
+fn blank(out: &mut [u8], from: usize, to: usize) {
+    let to = to.min(out.len());
+    for b in &mut out[from..to] {
+        if *b != b'\n' && *b != b'\r' {
+            *b = b' ';
+        }
+    }
+}
+
+fn line_end(bytes: &[u8], from: usize) -> usize {
+    bytes[from..].iter().position(|&b| b == b'\n').map_or(bytes.len(), |p| from + p)
+}
+
+/// The offset just past the closing delimiter, or the end of input.
+fn find_block_end(bytes: &[u8], from: usize, close: &str, at_line_start: bool) -> usize {
+    let mut i = from;
+    while i < bytes.len() {
+        if bytes[i..].starts_with(close.as_bytes()) && (!at_line_start || i == 0 || bytes[i - 1] == b'\n') {
+            return if at_line_start { line_end(bytes, i) } else { i + close.len() };
+        }
+        i += 1;
+    }
+    bytes.len()
+}
+
+/// `'x'` or `'\n'` starting at `at`: the offset just past the closing quote.
+fn char_literal_end(bytes: &[u8], at: usize) -> Option<usize> {
+    let next = *bytes.get(at + 1)?;
+    if next == b'\\' {
+        let close = bytes.get(at + 3..)?.iter().take(10).position(|&b| b == b'\'')?;
+        return Some(at + 3 + close + 1);
+    }
+    // One character, which may take several bytes.
+    let len = match next {
+        b if b < 0x80 => 1,
+        b if b >= 0xF0 => 4,
+        b if b >= 0xE0 => 3,
+        _ => 2,
+    };
+    (next != b'\'' && bytes.get(at + 1 + len) == Some(&b'\'')).then(|| at + len + 2)
+}
+
+/// `r"..."` or `r#"..."#` whose `#`s start at `from`: the body range and the
+/// offset just past the literal.
+fn raw_string_end(bytes: &[u8], from: usize) -> Option<((usize, usize), usize)> {
+    let hashes = bytes[from..].iter().take_while(|&&b| b == b'#').count();
+    if bytes.get(from + hashes) != Some(&b'"') {
+        return None;
+    }
+    let body = from + hashes + 1;
+    let mut i = body;
+    while i < bytes.len() {
+        if bytes[i] == b'"' && bytes[i + 1..].iter().take(hashes).filter(|&&b| b == b'#').count() == hashes {
+            return Some(((body, i), i + 1 + hashes));
+        }
+        i += 1;
+    }
+    Some(((body, bytes.len()), bytes.len()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SCRIPT: Syntax = Syntax {
+        line_comment: "#",
+        block_comment: Some(("=begin", "=end")),
+        block_at_line_start: true,
+        quotes: &[quote("\"\"\"", true, true), quote("\"", true, false), quote("'", true, false)],
+        char_literals: false,
+        raw_strings: false,
+        imports: &[],
+        paren_free_calls: false,
+    };
+
+    const RUSTY: Syntax = Syntax { raw_strings: true, ..C_LIKE };
+
+    #[test]
+    fn comments_and_strings_are_blanked() {
+        let src = "run(\"run\") // run\n/* run\nrun */ run('x');\n";
+        assert_eq!(mask(src, &C_LIKE), "run(\"   \")       \n      \n       run(' ');\n");
+    }
+
+    #[test]
+    fn escaped_quotes_stay_inside_the_literal() {
+        assert_eq!(mask(r#"f("a\"b") + g"#, &C_LIKE), r#"f("    ") + g"#);
+    }
+
+    #[test]
+    fn char_literals_and_lifetimes() {
+        assert_eq!(mask("fn f<'a>(x: &'a str) -> char { '\\'' }", &RUSTY), "fn f<'a>(x: &'a str) -> char { '  ' }");
+        assert_eq!(mask("let c = 'é'; run()", &RUSTY), "let c = '  '; run()");
+    }
+
+    #[test]
+    fn raw_strings() {
+        assert_eq!(mask("r#\"say \"run\"\"# + run", &RUSTY), "r#\"         \"# + run");
+        assert_eq!(mask("for r in rows", &RUSTY), "for r in rows");
+    }
+
+    #[test]
+    fn line_start_block_comments_and_multiline_strings() {
+        let src = "=begin\nrun\n=end\nx = \"\"\"\nrun\n\"\"\" # run\nrun 'it'\n";
+        assert_eq!(mask(src, &SCRIPT), "      \n   \n    \nx = \"\"\"\n   \n\"\"\"      \nrun '  '\n");
+    }
+
+    #[test]
+    fn unterminated_single_line_string_stops_at_line_end() {
+        assert_eq!(mask("it's\nrun\n", &SCRIPT), "it' \nrun\n");
+    }
+
+    #[test]
+    fn import_statements_follow_open_brackets() {
+        let syntax = Syntax { imports: &["use ", "import ", "using "], ..C_LIKE };
+        let code = "import {\n  a,\n  b\n} from './x'\nrun()\nuse a::{b, c};\nimport (\n\t\"fmt\"\n)\nusing var f = g;\nimport (\n";
+        assert_eq!(import_statements(code, &syntax), vec![0..4, 5..6, 6..9, 10..11]);
+    }
+
+    // ── interpolation ──
+
+    fn masked(path: &str, src: &str) -> String {
+        mask(src, crate::lang::syntax_for(path).unwrap())
+    }
+
+    #[test]
+    fn template_literals_keep_interpolated_code() {
+        assert_eq!(masked("a.ts", "`run ${run(\"x\")} {run}`"), "`      run(\" \")       `");
+        assert_eq!(masked("a.ts", "`a ${`b ${run()}`}` + \"${run()}\""), "`    `    run() ` ` + \"        \"");
+        assert_eq!(masked("a.ts", "`\\${run()}`"), "`         `");
+    }
+
+    #[test]
+    fn ruby_double_quotes_keep_interpolated_code() {
+        assert_eq!(masked("a.rb", "\"run #{job.run} run\" + 'run #{run}'"), "\"      job.run     \" + '          '");
+    }
+
+    #[test]
+    fn python_f_strings_keep_interpolated_code() {
+        assert_eq!(masked("a.py", "f\"run {run(x)} {{run}}\""), "f\"     run(x)         \"");
+        assert_eq!(masked("a.py", "rf'{run()}' + '{run()}'"), "rf' run() ' + '       '");
+        assert_eq!(masked("a.py", "f\"\"\"\n{run()}\n\"\"\""), "f\"\"\"\n run() \n\"\"\"");
+    }
+
+    #[test]
+    fn kotlin_and_csharp_templates_keep_interpolated_code() {
+        assert_eq!(masked("a.kt", "\"run ${run()}\""), "\"      run() \"");
+        assert_eq!(masked("a.cs", "$\"run {Run()}\" + \"{Run()}\""), "$\"     Run() \" + \"       \"");
+    }
+}
@@ -1,4 +1,5 @@
 pub mod common;
+pub mod lexer;
 mod rust;
 mod typescript;
 mod csharp;
@@ -8,13 +9,88 @@ mod kotlin;
 mod ruby;
 mod python;
 
+use std::collections::HashSet;
 use std::path::Path;
 
+pub use typescript::JS_PREFIX;
+
 pub trait LangImports: Sync {
     fn extensions(&self) -> &[&str];
     fn extract_imports(&self, content: &str, file_path: &Path) -> Vec<String>;
+
+    /// Third-party packages `content` imports, as `external::marker`s.
+    /// Standard library imports are left out.
+    fn extract_external(&self, content: &str, file_path: &Path) -> Vec<String> {
+        let _ = (content, file_path);
+        Vec::new()
+    }
+
+    /// The candidates of `extract_imports` that only the whole file explains
+    /// (a `package` line, an enclosing `module`), each with the 0-based line
+    /// it comes from. Locating an import reads one statement at a time and
+    /// would miss them.
+    fn contextual_imports(&self, content: &str, file_path: &Path) -> Vec<(usize, String)> {
+        let _ = (content, file_path);
+        Vec::new()
+    }
+}
+
+/// Project-wide knowledge some languages need to resolve imports, loaded
+/// once per graph from the root and the scanned files.
+#[derive(Default)]
+pub struct Layout {
+    csharp: csharp::CSharpProjects,
+    go: go::GoModules,
+    js: typescript::NodePackages,
+    jvm: java::JvmSources,
+    python: python::PythonRoots,
+    ruby: ruby::RubyPaths,
+    rust: rust::CargoCrates,
+}
+
+impl Layout {
+    pub fn load(root: &Path, files: &HashSet<String>) -> Self {
+        Self {
+            csharp: csharp::CSharpProjects::load(root, files),
+            go: go::GoModules::load(root, files),
+            js: typescript::NodePackages::load(root, files),
+            jvm: java::JvmSources::load(root, files),
+            python: python::PythonRoots::load(root, files),
+            ruby: ruby::RubyPaths::load(root, files),
+            rust: rust::CargoCrates::load(root, files),
+        }
+    }
+
+    /// The project files a layout-relative raw candidate of `from` stands
+    /// for, or `None` when it is a plain path or nothing in the project
+    /// provides it.
+    pub fn resolve(&self, from: &str, candidate: &str) -> Option<Vec<String>> {
+        if let Some(ns) = candidate.strip_prefix(csharp::CS_PREFIX) {
+            return self.csharp.resolve(from, ns);
+        }
+        if let Some(import) = candidate.strip_prefix(go::GO_PREFIX) {
+            return self.go.resolve(import);
+        }
+        if let Some(specifier) = candidate.strip_prefix(typescript::JS_PREFIX) {
+            return self.js.resolve(from, specifier);
+        }
+        if let Some(path) = candidate.strip_prefix(java::JVM_PREFIX) {
+            return self.jvm.resolve(path);
+        }
+        if let Some(path) = candidate.strip_prefix(python::PY_PREFIX) {
+            return self.python.resolve(from, path);
+        }
+        if let Some(path) = candidate.strip_prefix(ruby::RB_PREFIX) {
+            return self.ruby.resolve(from, path);
+        }
+        if let Some(path) = candidate.strip_prefix(rust::RUST_PREFIX) {
+            return self.rust.resolve(from, path);
+        }
+        None
+    }
 }
 
+#[derive(Clone)]
 pub struct SymbolInfo {
     pub kind: &'static str,
     pub name: String,
@@ -34,6 +110,10 @@ pub trait LangSymbols: Sync {
         let _ = include_tests;
         self.extract_symbols(content)
     }
+
+    fn syntax(&self) -> &'static lexer::Syntax {
+        &lexer::C_LIKE
+    }
 }
 
 static HANDLERS: &[&dyn LangImports] = &[
@@ -82,6 +162,12 @@ pub fn get_symbol_handler(extension: &str) -> Option<&'static dyn LangSymbols> {
     None
 }
 
+/// The comment and string syntax for `path`, by extension.
+pub fn syntax_for(path: &str) -> Option<&'static lexer::Syntax> {
+    let ext = Path::new(path).extension()?.to_str()?;
+    get_symbol_handler(ext).map(|h| h.syntax())
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -1,6 +1,14 @@
+use std::collections::{BTreeSet, HashSet};
 use std::path::Path;
+use std::sync::OnceLock;
+
+use regex::Regex;
 
 use super::{LangImports, LangSymbols, SymbolInfo};
+use super::common;
+use super::lexer::{quote, Syntax};
+use crate::external;
+use crate::path_helper;
 
 pub struct PythonImports;
 
@@ -8,6 +16,8 @@ struct ImportStatement {
     module: String,
     is_relative: bool,
     dot_count: usize,
+    /// What `from module import ...` names; any of them may be a submodule.
+    names: Vec<String>,
 }
 
 impl LangImports for PythonImports {
@@ -22,23 +32,320 @@ impl LangImports for PythonImports {
         let mut candidates = Vec::new();
 
         for stmt in &statements {
+            let top = stmt.module.split('.').next().unwrap_or("");
+            let submodules = stmt.names.iter().filter(|n| n.starts_with(|c: char| c.is_ascii_lowercase() || c == '_'));
             if stmt.is_relative {
                 let resolved = resolve_relative_import(file_dir, stmt.dot_count, &stmt.module);
                 candidates.extend(resolved);
+                for name in submodules {
+                    candidates.extend(resolve_relative_import(file_dir, stmt.dot_count, &join_module(&stmt.module, name)));
+                }
             } else {
                 let resolved = resolve_absolute_import(&stmt.module);
-                candidates.extend(resolved);
+                candidates.extend(resolved.into_iter().map(|c| format!("{}{}", PY_PREFIX, c)));
+                if !is_python_stdlib(top) {
+                    for name in submodules {
+                        let path = join_module(&stmt.module, name).replace('.', "/");
+                        candidates.push(format!("{}{}.py", PY_PREFIX, path));
+                        candidates.push(format!("{}{}/__init__.py", PY_PREFIX, path));
+                    }
+                }
             }
         }
 
         candidates
     }
+
+    fn extract_external(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        let mut external = Vec::new();
+
+        for stmt in parse_python_imports(content).iter().filter(|s| !s.is_relative) {
+            let top = stmt.module.split('.').next().unwrap_or("");
+            if top.is_empty() || is_python_stdlib(top) {
+                continue;
+            }
+            // A project module or package of the same name wins, namespace
+            // packages included.
+            let mut local: Vec<String> = resolve_absolute_import(&stmt.module)
+                .into_iter()
+                .map(|c| format!("{}{}", PY_PREFIX, c))
+                .collect();
+            local.push(format!("{}{}/", PY_PREFIX, top));
+            external.push(external::marker("pypi", top, &local));
+        }
+
+        external
+    }
+}
+
+fn join_module(module: &str, name: &str) -> String {
+    if module.is_empty() { name.to_owned() } else { format!("{}.{}", module, name) }
+}
+
+/// Raw candidates for absolute imports carry a module path after this
+/// prefix; `PythonRoots` looks it up under every import root. A path ending
+/// in `/` names a package directory, which may be a namespace package.
+pub const PY_PREFIX: &str = "py:";
+
+/// The directories Python imports start from: the project root, the parent
+/// of every top-level package, `src` directories, roots that
+/// `pyproject.toml`, `setup.cfg`, `setup.py` or `pytest.ini` configure next
+/// to the code, and `PYTHONPATH` entries inside the project. A root
+/// `pyproject.toml` with `[tool.src] python-roots = [...]` replaces them all.
+#[derive(Default)]
+pub struct PythonRoots {
+    /// Deepest first.
+    roots: Vec<String>,
+    files: HashSet<String>,
+    /// Every directory holding Python files at any depth.
+    dirs: HashSet<String>,
+}
+
+impl PythonRoots {
+    pub fn load(root: &Path, files: &HashSet<String>) -> Self {
+        let files: HashSet<String> = files.iter().filter(|f| f.ends_with(".py")).cloned().collect();
+        if files.is_empty() {
+            return Self::default();
+        }
+        let mut dirs: HashSet<String> = HashSet::new();
+        for file in &files {
+            let mut dir = path_helper::parent_dir(file);
+            while !dir.is_empty() && dirs.insert(dir.to_owned()) {
+                dir = path_helper::parent_dir(dir);
+            }
+        }
+
+        let mut roots: BTreeSet<String> = BTreeSet::new();
+        let root_config = PyConfig::read(root);
+        match root_config.python_roots {
+            Some(configured) => roots.extend(configured.iter().filter_map(|r| common::join_dir("", r))),
+            None => {
+                roots.insert(String::new());
+                roots.extend(root_config.roots.iter().filter_map(|r| common::join_dir("", r)));
+                for file in files.iter().filter(|f| f.ends_with("__init__.py")) {
+                    let package = path_helper::parent_dir(file);
+                    let parent = path_helper::parent_dir(package);
+                    if !package.is_empty() && !files.contains(&common::under(parent, "__init__.py")) {
+                        roots.insert(parent.to_owned());
+                    }
+                }
+                for dir in &dirs {
+                    if dir == "src" || dir.ends_with("/src") {
+                        roots.insert(dir.clone());
+                    }
+                    if !files.contains(&common::under(dir, "__init__.py")) {
+                        let config = PyConfig::read(&root.join(dir));
+                        if !config.roots.is_empty() {
+                            roots.insert(dir.clone());
+                            roots.extend(config.roots.iter().filter_map(|r| common::join_dir(dir, r)));
+                        }
+                    }
+                }
+                roots.extend(python_path_roots(root));
+            }
+        }
+
+        let mut roots: Vec<String> = roots.into_iter().collect();
+        roots.sort_by(|a, b| b.len().cmp(&a.len()).then_with(|| a.cmp(b)));
+        Self { roots, files, dirs }
+    }
+
+    /// The files a `py:` candidate of `from` names. A script outside any
+    /// package imports from its own directory first, then roots holding
+    /// `from` are tried, deepest first, so each service in a monorepo finds
+    /// its own `app` package; the others count only when none of those has
+    /// the module.
+    pub fn resolve(&self, from: &str, path: &str) -> Option<Vec<String>> {
+        let lookup = |root: &String| -> Option<Vec<String>> {
+            match path.strip_suffix('/') {
+                Some(dir) => self.dirs.contains(&common::under(root, dir)).then(Vec::new),
+                None => {
+                    let file = common::under(root, path);
+                    self.files.contains(&file).then(|| vec![file])
+                }
+            }
+        };
+        let script_dir = path_helper::parent_dir(from).to_owned();
+        if !self.files.contains(&common::under(&script_dir, "__init__.py")) {
+            if let Some(found) = lookup(&script_dir) {
+                return Some(found);
+            }
+        }
+        let (own, other): (Vec<&String>, Vec<&String>) =
+            self.roots.iter().partition(|r| r.is_empty() || from.starts_with(&format!("{}/", r)));
+        if let Some(found) = own.into_iter().find_map(lookup) {
+            return Some(found);
+        }
+        let found: Vec<Vec<String>> = other.into_iter().filter_map(lookup).collect();
+        if found.is_empty() { None } else { Some(found.concat()) }
+    }
+}
+
+/// `PYTHONPATH` entries that lie inside the project, relative to its root.
+fn python_path_roots(root: &Path) -> Vec<String> {
+    let value = match std::env::var_os("PYTHONPATH") {
+        Some(value) => value,
+        None => return Vec::new(),
+    };
+    let base = root.canonicalize().unwrap_or_else(|_| root.to_path_buf());
+    std::env::split_paths(&value)
+        .filter_map(|entry| {
+            let entry = if entry.is_absolute() { entry } else { base.join(entry) };
+            let entry = entry.canonicalize().ok()?;
+            let relative = entry.strip_prefix(&base).ok()?;
+            Some(relative.to_string_lossy().replace('\\', "/"))
+        })
+        .collect()
+}
+
+/// Import roots a directory's Python packaging and test configuration
+/// declare, relative to that directory.
+#[derive(Default)]
+struct PyConfig {
+    roots: Vec<String>,
+    /// `[tool.src] python-roots`, which replaces discovery.
+    python_roots: Option<Vec<String>>,
+}
+
+impl PyConfig {
+    fn read(dir: &Path) -> Self {
+        let mut config = PyConfig::default();
+        if let Ok(text) = std::fs::read_to_string(dir.join("pyproject.toml")) {
+            config.add_pyproject(&text);
+        }
+        for name in ["setup.cfg", "pytest.ini"] {
+            if let Ok(text) = std::fs::read_to_string(dir.join(name)) {
+                config.add_ini(&text);
+            }
+        }
+        if let Ok(text) = std::fs::read_to_string(dir.join("setup.py")) {
+            static PACKAGE_DIR: OnceLock<Regex> = OnceLock::new();
+            let package_dir = PACKAGE_DIR.get_or_init(|| Regex::new(r#"package_dir\s*=\s*\{\s*['"]{2}\s*:\s*['"]([^'"]+)['"]"#).unwrap());
+            config.roots.extend(package_dir.captures_iter(&text).map(|c| c[1].to_owned()));
+        }
+        config
+    }
+
+    fn add_pyproject(&mut self, text: &str) {
+        static QUOTED: OnceLock<Regex> = OnceLock::new();
+        let quoted = QUOTED.get_or_init(|| Regex::new(r#""([^"]*)""#).unwrap());
+        static EMPTY_KEY: OnceLock<Regex> = OnceLock::new();
+        let empty_key = EMPTY_KEY.get_or_init(|| Regex::new(r#"""\s*=\s*"([^"]+)""#).unwrap());
+        static FROM: OnceLock<Regex> = OnceLock::new();
+        let from = FROM.get_or_init(|| Regex::new(r#"\bfrom\s*=\s*"([^"]+)""#).unwrap());
+        let mut section = String::new();
+        let mut lines = text.lines();
+
+        while let Some(line) = lines.next() {
+            let line = line.split(" #").next().unwrap_or("").trim();
+            if line.starts_with('[') && !line.contains('=') {
+                section = line.trim_matches(|c| c == '[' || c == ']').trim().to_owned();
+                continue;
+            }
+            let (key, value) = match line.split_once('=') {
+                Some((key, value)) => (key.trim(), value.trim()),
+                None => continue,
+            };
+            // Arrays and inline tables may span lines until they close.
+            let mut value = value.to_owned();
+            let opens = |v: &str| v.matches(['[', '{']).count() > v.matches([']', '}']).count();
+            while opens(&value) {
+                match lines.next() {
+                    Some(next) => {
+                        value.push(' ');
+                        value.push_str(next.split(" #").next().unwrap_or("").trim());
+                    }
+                    None => break,
+                }
+            }
+            let strings = || quoted.captures_iter(&value).map(|c| c[1].to_owned()).collect::<Vec<_>>();
+
+            match (section.as_str(), key) {
+                ("tool.src", "python-roots") => self.python_roots = Some(strings()),
+                ("tool.setuptools", "package-dir") => self.roots.extend(empty_key.captures_iter(&value).map(|c| c[1].to_owned())),
+                ("tool.setuptools.package-dir", "\"\"") => self.roots.extend(strings()),
+                ("tool.setuptools.packages.find", "where") => self.roots.extend(strings()),
+                ("tool.poetry", "packages") => self.roots.extend(from.captures_iter(&value).map(|c| c[1].to_owned())),
+                ("tool.pytest.ini_options", "pythonpath") => self.roots.extend(strings()),
+                ("tool.hatch.build.targets.wheel", "packages") => {
+                    self.roots.extend(strings().iter().map(|p| path_helper::parent_dir(p.trim_end_matches('/')).to_owned()));
+                }
+                _ => {}
+            }
+        }
+    }
+
+    /// `setup.cfg` and `pytest.ini`, where values continue on indented lines.
+    fn add_ini(&mut self, text: &str) {
+        let mut section = String::new();
+        let mut entries: Vec<(String, String, String)> = Vec::new();
+        for line in text.lines() {
+            let trimmed = line.trim();
+            if trimmed.is_empty() || trimmed.starts_with('#') || trimmed.starts_with(';') {
+                continue;
+            }
+            if trimmed.starts_with('[') {
+                section = trimmed.trim_matches(|c| c == '[' || c == ']').trim().to_owned();
+            } else if line.starts_with(char::is_whitespace) {
+                if let Some(last) = entries.last_mut() {
+                    last.2.push('\n');
+                    last.2.push_str(trimmed);
+                }
+            } else if let Some((key, value)) = trimmed.split_once('=') {
+                entries.push((section.clone(), key.trim().to_owned(), value.trim().to_owned()));
+            }
+        }
+
+        for (section, key, value) in entries {
+            match (section.as_str(), key.as_str()) {
+                ("options", "package_dir") => {
+                    // `=src` maps the root package; named entries map one package each.
+                    for entry in value.lines() {
+                        if let Some((name, dir)) = entry.split_once('=') {
+                            if name.trim().is_empty() {
+                                self.roots.push(dir.trim().to_owned());
+                            }
+                        }
+                    }
+                }
+                ("options.packages.find", "where") => self.roots.push(value),
+                ("tool:pytest" | "pytest", "pythonpath") => self.roots.extend(value.split_whitespace().map(str::to_owned)),
+                _ => {}
+            }
+        }
+    }
+}
+
+fn is_python_stdlib(module: &str) -> bool {
+    const STDLIB: &[&str] = &[
+        "__future__", "abc", "argparse", "array", "ast", "asyncio", "atexit", "base64", "bisect",
+        "builtins", "bz2", "calendar", "cmath", "codecs", "collections", "colorsys", "concurrent",
+        "configparser", "contextlib", "contextvars", "copy", "copyreg", "cProfile", "csv", "ctypes",
+        "curses", "dataclasses", "datetime", "dbm", "decimal", "difflib", "dis", "doctest", "email",
+        "encodings", "enum", "errno", "faulthandler", "fcntl", "filecmp", "fileinput", "fnmatch",
+        "fractions", "ftplib", "functools", "gc", "getopt", "getpass", "gettext", "glob", "graphlib",
+        "grp", "gzip", "hashlib", "heapq", "hmac", "html", "http", "imaplib", "importlib", "inspect",
+        "io", "ipaddress", "itertools", "json", "keyword", "linecache", "locale", "logging", "lzma",
+        "mailbox", "marshal", "math", "mimetypes", "mmap", "multiprocessing", "netrc", "numbers",
+        "operator", "optparse", "os", "pathlib", "pdb", "pickle", "pkgutil", "platform", "plistlib",
+        "poplib", "posixpath", "pprint", "profile", "pstats", "pty", "pwd", "queue", "quopri",
+        "random", "re", "readline", "reprlib", "resource", "runpy", "sched", "secrets", "select",
+        "selectors", "shelve", "shlex", "shutil", "signal", "site", "smtplib", "socket",
+        "socketserver", "sqlite3", "ssl", "stat", "statistics", "string", "stringprep", "struct",
+        "subprocess", "symtable", "sys", "sysconfig", "syslog", "tarfile", "tempfile", "termios",
+        "textwrap", "threading", "time", "timeit", "tkinter", "token", "tokenize", "tomllib", "trace",
+        "traceback", "tracemalloc", "tty", "turtle", "types", "typing", "unicodedata", "unittest",
+        "urllib", "uuid", "venv", "warnings", "wave", "weakref", "webbrowser", "winreg", "wsgiref",
+        "xml", "xmlrpc", "zipapp", "zipfile", "zipimport", "zlib", "zoneinfo",
+    ];
+    STDLIB.contains(&module)
 }
 
 fn parse_python_imports(content: &str) -> Vec<ImportStatement> {
-    let mut statements = Vec::new();
+    let mut statements: Vec<ImportStatement> = Vec::new();
     let mut in_triple_double = false;
     let mut in_triple_single = false;
+    let mut in_name_list = false;
 
     for line in content.lines() {
         let trimmed = line.trim();
@@ -75,11 +382,22 @@ fn parse_python_imports(content: &str) -> Vec<ImportStatement> {
             continue;
         }
 
+        if in_name_list {
+            if let Some(stmt) = statements.last_mut() {
+                stmt.names.extend(imported_names(trimmed));
+            }
+            in_name_list = !trimmed.contains(')');
+            continue;
+        }
+
         if let Some(rest) = trimmed.strip_prefix("from ") {
             let rest = rest.trim();
             if let Some(import_idx) = rest.find(" import ") {
                 let module_part = rest[..import_idx].trim();
                 let dot_count = module_part.chars().take_while(|&c| c == '.').count();
+                let name_list = rest[import_idx + " import ".len()..].trim();
+                let names = imported_names(name_list);
+                in_name_list = name_list.starts_with('(') && !name_list.contains(')');
 
                 if dot_count > 0 {
                     let sub_module = &module_part[dot_count..];
@@ -87,12 +405,14 @@ fn parse_python_imports(content: &str) -> Vec<ImportStatement> {
                         module: sub_module.to_owned(),
                         is_relative: true,
                         dot_count,
+                        names,
                     });
                 } else {
                     statements.push(ImportStatement {
                         module: module_part.to_owned(),
                         is_relative: false,
                         dot_count: 0,
+                        names,
                     });
                 }
             }
@@ -109,6 +429,7 @@ fn parse_python_imports(content: &str) -> Vec<ImportStatement> {
                     module: module.to_owned(),
                     is_relative: false,
                     dot_count: 0,
+                    names: Vec::new(),
                 });
             }
         }
@@ -117,6 +438,18 @@ fn parse_python_imports(content: &str) -> Vec<ImportStatement> {
     statements
 }
 
+/// The names of a `from ... import` list, `as` aliases and `*` dropped.
+fn imported_names(list: &str) -> Vec<String> {
+    list.split('#')
+        .next()
+        .unwrap_or("")
+        .split(',')
+        .filter_map(|item| item.trim().trim_matches(|c| c == '(' || c == ')' || c == '\\').split_whitespace().next())
+        .filter(|name| *name != "*")
+        .map(str::to_owned)
+        .collect()
+}
+
 fn resolve_relative_import(file_dir: &Path, dot_count: usize, sub_module: &str) -> Vec<String> {
     let mut base = file_dir.to_path_buf();
     for _ in 0..(dot_count - 1) {
@@ -163,11 +496,34 @@ fn normalize(path: &Path) -> String {
     }
 }
 
+/// `f"..."`, `rf"..."` and the like interpolate.
+const F_STRING: &[u8] = b"fF";
+
+const SYNTAX: Syntax = Syntax {
+    line_comment: "#",
+    block_comment: None,
+    block_at_line_start: false,
+    quotes: &[
+        quote("\"\"\"", true, true).interpolated("{", F_STRING),
+        quote("'''", true, true).interpolated("{", F_STRING),
+        quote("\"", true, false).interpolated("{", F_STRING),
+        quote("'", true, false).interpolated("{", F_STRING),
+    ],
+    char_literals: false,
+    raw_strings: false,
+    imports: &["import ", "from "],
+    paren_free_calls: false,
+};
+
 impl LangSymbols for PythonImports {
     fn extensions(&self) -> &[&str] {
         &["py"]
     }
 
+    fn syntax(&self) -> &'static Syntax {
+        &SYNTAX
+    }
+
     fn extract_symbols(&self, content: &str) -> Vec<SymbolInfo> {
         let all_lines: Vec<&str> = content.lines().collect();
         let mut symbols = Vec::new();
@@ -328,6 +684,7 @@ fn make_python_signature(trimmed: &str) -> String {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::test_support::temp_project;
 
     fn extract_imports(content: &str, file_path: &str) -> Vec<String> {
         PythonImports.extract_imports(content, Path::new(file_path))
@@ -424,6 +781,66 @@ mod tests {
         assert!(imports.iter().any(|i| i.contains("a.py") || i.contains("a/")));
     }
 
+    // ── External Tests ──
+
+    #[test]
+    fn external_uses_top_level_package_and_skips_stdlib() {
+        let content = "import os\nimport numpy as np\nfrom requests.adapters import HTTPAdapter\nfrom . import sibling\nfrom __future__ import annotations\n";
+        let external = PythonImports.extract_external(content, Path::new("app/main.py"));
+        assert_eq!(external, vec![
+            "external:pypi:numpy|py:numpy.py|py:numpy/__init__.py|py:numpy/",
+            "external:pypi:requests|py:requests/adapters.py|py:requests/adapters/__init__.py|py:requests.py|py:requests/__init__.py|py:requests/",
+        ]);
+    }
+
+    // ── Import roots ──
+
+    #[test]
+    fn from_import_names_may_be_submodules() {
+        let content = "from app.api import (\n    routes,  # HTTP\n    Schema,\n)\nfrom . import views as v, forms\nfrom os import path\n";
+        let imports = extract_imports(content, "svc/app/urls.py");
+        for expected in ["py:app/api/routes.py", "py:app/api/routes/__init__.py", "svc/app/views.py", "svc/app/forms/__init__.py"] {
+            assert!(imports.contains(&expected.to_owned()), "{} in {:?}", expected, imports);
+        }
+        assert!(!imports.iter().any(|i| i.contains("Schema") || i.contains("os/path")));
+    }
+
+    #[test]
+    fn config_names_roots_in_pyproject_and_setup_cfg() {
+        let mut config = PyConfig::default();
+        config.add_pyproject("[tool.setuptools]\npackage-dir = {\"\" = \"src\"}\n\n[tool.poetry]\npackages = [\n    { include = \"billing\", from = \"lib\" },\n]\n\n[tool.pytest.ini_options]\npythonpath = [\"tests/helpers\"]\n");
+        config.add_ini("[options]\npackage_dir =\n    =pkgs\n    extra = vendor/extra\n\n[tool:pytest]\npythonpath = scripts tools\n");
+        assert_eq!(config.roots, vec!["src", "lib", "tests/helpers", "pkgs", "scripts", "tools"]);
+        assert!(config.python_roots.is_none());
+
+        config.add_pyproject("[tool.src]\npython-roots = [\"services/api\", \".\"]\n");
+        assert_eq!(config.python_roots, Some(vec!["services/api".to_owned(), ".".to_owned()]));
+    }
+
+    #[test]
+    fn roots_prefer_the_importing_service() {
+        let files = [
+            ("lib/pyproject.toml", "[tool.setuptools.packages.find]\nwhere = [\"src\"]\n"),
+            ("lib/src/shared/money.py", ""),
+            ("lib/src/company/plugins/audit.py", ""),
+            ("svc/api/manage.py", ""),
+            ("svc/api/app/__init__.py", ""),
+            ("svc/api/app/models.py", ""),
+            ("svc/worker/app/__init__.py", ""),
+            ("svc/worker/app/models.py", ""),
+        ];
+        let root = temp_project("py_roots", &files);
+        let scanned = files.iter().map(|(f, _)| (*f).to_owned()).filter(|f| f.ends_with(".py")).collect();
+        let roots = PythonRoots::load(&root, &scanned);
+
+        assert_eq!(roots.resolve("svc/api/manage.py", "app/models.py"), Some(vec!["svc/api/app/models.py".to_owned()]));
+        assert_eq!(roots.resolve("svc/worker/app/models.py", "app/models.py"), Some(vec!["svc/worker/app/models.py".to_owned()]));
+        assert_eq!(roots.resolve("svc/api/app/models.py", "shared/money.py"), Some(vec!["lib/src/shared/money.py".to_owned()]));
+        assert_eq!(roots.resolve("svc/api/app/models.py", "company/plugins/"), Some(vec![]));
+        assert_eq!(roots.resolve("svc/api/app/models.py", "requests/__init__.py"), None);
+        std::fs::remove_dir_all(&root).ok();
+    }
+
     // ── Symbol Tests ──
 
     #[test]
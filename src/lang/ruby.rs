@@ -1,6 +1,10 @@
+use std::collections::HashSet;
 use std::path::Path;
 use super::{LangImports, LangSymbols, SymbolInfo};
-use super::common::CommentTracker;
+use super::common::{self, CommentTracker};
+use super::lexer::{quote, Syntax};
+use crate::external;
+use crate::path_helper;
 
 pub struct RubyImports;
 
@@ -9,30 +13,339 @@ impl LangImports for RubyImports {
         &["rb", "rake"]
     }
 
-    fn extract_imports(&self, content: &str, _file_path: &Path) -> Vec<String> {
+    fn extract_imports(&self, content: &str, file_path: &Path) -> Vec<String> {
         let mut imports = Vec::new();
+        let file_dir = file_path.parent().map(|d| d.to_string_lossy().replace('\\', "/")).unwrap_or_default();
 
         for line in content.lines() {
             let trimmed = line.trim();
 
             if let Some(path) = extract_require(trimmed, "require_relative ") {
-                imports.push(normalize_ruby_path(&path, true));
+                if let Some(path) = common::join_dir(&file_dir, &normalize_ruby_path(&path)) {
+                    imports.push(path);
+                }
             } else if let Some(path) = extract_require(trimmed, "require ") {
                 if !is_ruby_stdlib(&path) {
-                    imports.push(normalize_ruby_path(&path, false));
+                    imports.push(format!("{}{}", RB_PREFIX, normalize_ruby_path(&path)));
                 }
             }
         }
 
+        for (_, candidate) in constant_candidates(content) {
+            if !imports.contains(&candidate) {
+                imports.push(candidate);
+            }
+        }
+
         imports
     }
+
+    fn contextual_imports(&self, content: &str, _file_path: &Path) -> Vec<(usize, String)> {
+        constant_candidates(content)
+    }
+
+    fn extract_external(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        let mut external = Vec::new();
+
+        for line in content.lines() {
+            let trimmed = line.trim();
+            if trimmed.starts_with("require_relative ") {
+                continue;
+            }
+            if let Some(path) = extract_require(trimmed, "require ") {
+                if is_ruby_stdlib(&path) {
+                    continue;
+                }
+                // `require 'models/user'` may load `lib/models/user.rb`.
+                let gem = path.split('/').next().unwrap_or(&path);
+                external.push(external::marker("rubygems", gem, &[format!("{}{}", RB_PREFIX, normalize_ruby_path(&path))]));
+            }
+        }
+
+        external
+    }
+}
+
+/// Raw candidates resolved by `RubyPaths` carry this prefix. `rb:a/b.rb` is
+/// a `require` looked up on the load path; `rb:Billing::Invoice@Admin::Reports,Admin`
+/// is a constant reference followed by the lexical scopes it appears in,
+/// innermost first, looked up by Zeitwerk's naming rules.
+pub const RB_PREFIX: &str = "rb:";
+
+/// `app/` subdirectories Rails does not autoload.
+const NOT_AUTOLOADED: &[&str] = &["assets", "javascript", "views"];
+
+/// Where Ruby finds code: `lib` directories make up the load path, and
+/// every `app/*` directory (with its `concerns`) plus `lib` is an autoload
+/// root, so `Billing::InvoiceMailer` lives in `app/mailers/billing/invoice_mailer.rb`.
+/// Each path belongs to the application or gem directory above it, and
+/// the importing file's own wins over the rest.
+#[derive(Default)]
+pub struct RubyPaths {
+    /// `(root, owner)` pairs, deepest first.
+    load_paths: Vec<(String, String)>,
+    autoload: Vec<(String, String)>,
+    files: HashSet<String>,
+    /// Every directory holding Ruby files at any depth.
+    dirs: HashSet<String>,
+}
+
+impl RubyPaths {
+    pub fn load(_root: &Path, files: &HashSet<String>) -> Self {
+        let files: HashSet<String> = files.iter().filter(|f| f.ends_with(".rb")).cloned().collect();
+        if files.is_empty() {
+            return Self::default();
+        }
+        let mut dirs: HashSet<String> = HashSet::new();
+        for file in &files {
+            let mut dir = path_helper::parent_dir(file);
+            while !dir.is_empty() && dirs.insert(dir.to_owned()) {
+                dir = path_helper::parent_dir(dir);
+            }
+        }
+
+        let mut load_paths = vec![(String::new(), String::new())];
+        let mut autoload = Vec::new();
+        for dir in &dirs {
+            let (parent, name) = match dir.rfind('/') {
+                Some(i) => (&dir[..i], &dir[i + 1..]),
+                None => ("", dir.as_str()),
+            };
+            if name == "lib" {
+                load_paths.push((dir.clone(), parent.to_owned()));
+                autoload.push((dir.clone(), parent.to_owned()));
+            }
+            let app = path_helper::parent_dir(parent);
+            if (parent == "app" || parent.ends_with("/app")) && !NOT_AUTOLOADED.contains(&name) {
+                autoload.push((dir.clone(), app.to_owned()));
+                let concerns = format!("{}/concerns", dir);
+                if dirs.contains(&concerns) {
+                    autoload.push((concerns, app.to_owned()));
+                }
+            }
+        }
+        let deepest_first = |a: &(String, String), b: &(String, String)| b.0.len().cmp(&a.0.len()).then_with(|| a.0.cmp(&b.0));
+        load_paths.sort_by(deepest_first);
+        autoload.sort_by(deepest_first);
+        Self { load_paths, autoload, files, dirs }
+    }
+
+    /// The files an `rb:` candidate of `from` names. Paths owned by the
+    /// application or gem holding `from` are tried first; the others count
+    /// only when none of those has the file or constant.
+    pub fn resolve(&self, from: &str, path: &str) -> Option<Vec<String>> {
+        let constant = path.split_once('@');
+        let roots = if constant.is_some() { &self.autoload } else { &self.load_paths };
+        let (own, other): (Vec<&(String, String)>, Vec<&(String, String)>) =
+            roots.iter().partition(|(_, owner)| owner.is_empty() || from.starts_with(&format!("{}/", owner)));
+        let own: Vec<&str> = own.into_iter().map(|(r, _)| r.as_str()).collect();
+        let other: Vec<&str> = other.into_iter().map(|(r, _)| r.as_str()).collect();
+
+        if let Some((reference, scopes)) = constant {
+            return self
+                .resolve_constant(&own, reference, scopes)
+                .or_else(|| self.resolve_constant(&other, reference, scopes));
+        }
+        if let Some(found) = own.iter().map(|r| common::under(r, path)).find(|f| self.files.contains(f)) {
+            return Some(vec![found]);
+        }
+        let found: Vec<String> = other.iter().map(|r| common::under(r, path)).filter(|f| self.files.contains(f)).collect();
+        if found.is_empty() { None } else { Some(found) }
+    }
+
+    /// Looks `reference` up the way Ruby does: in each enclosing scope,
+    /// innermost first, then at the top level. The first scope where its
+    /// leading constant exists wins, and the reference points at the
+    /// deepest file it names there. A namespace that is only a directory
+    /// is provided without a file.
+    fn resolve_constant(&self, roots: &[&str], reference: &str, scopes: &str) -> Option<Vec<String>> {
+        let segments: Vec<String> = reference.split("::").map(underscore).collect();
+        for scope in scopes.split(',').filter(|s| !s.is_empty()).chain(std::iter::once("")) {
+            let mut path: Vec<String> = scope.split("::").filter(|s| !s.is_empty()).map(underscore).collect();
+            let start = path.len() + 1;
+            path.extend(segments.iter().cloned());
+
+            let first = path[..start].join("/");
+            if !roots.iter().any(|r| self.files.contains(&common::under(r, &format!("{}.rb", first))) || self.dirs.contains(&common::under(r, &first))) {
+                continue;
+            }
+            let mut found: Vec<String> = Vec::new();
+            for end in (start..=path.len()).rev() {
+                let file = format!("{}.rb", path[..end].join("/"));
+                found.extend(roots.iter().map(|r| common::under(r, &file)).filter(|f| self.files.contains(f)));
+                if !found.is_empty() {
+                    break;
+                }
+            }
+            return Some(found);
+        }
+        None
+    }
+}
+
+/// A constant name as Zeitwerk expects it in a file name:
+/// `InvoiceMailer` is `invoice_mailer`, `HTMLParser` is `html_parser`.
+fn underscore(name: &str) -> String {
+    let chars: Vec<char> = name.chars().collect();
+    let mut out = String::with_capacity(name.len() + 4);
+    for (i, &c) in chars.iter().enumerate() {
+        if c.is_ascii_uppercase() && i > 0 {
+            let prev = chars[i - 1];
+            let next_lower = chars.get(i + 1).map_or(false, |n| n.is_ascii_lowercase());
+            if prev.is_ascii_lowercase() || prev.is_ascii_digit() || (prev.is_ascii_uppercase() && next_lower) {
+                out.push('_');
+            }
+        }
+        out.push(c.to_ascii_lowercase());
+    }
+    out
 }
 
+/// `rb:` candidates for the constants `content` refers to, each with the
+/// 0-based line of its first mention.
+fn constant_candidates(content: &str) -> Vec<(usize, String)> {
+    constant_references(content)
+        .into_iter()
+        .map(|(idx, reference, scopes)| (idx, format!("{}{}@{}", RB_PREFIX, reference, scopes.join(","))))
+        .collect()
+}
+
+/// Constant paths `content` refers to, each with the 0-based line it first
+/// appears on and the lexical scopes of that line, innermost first.
+/// `class A::B` opens the single scope `A::B`, as in Ruby. The names being
+/// defined are left out.
+fn constant_references(content: &str) -> Vec<(usize, String, Vec<String>)> {
+    let lines: Vec<&str> = content.lines().collect();
+    let mut references: Vec<(usize, String, Vec<String>)> = Vec::new();
+    // Open scopes with the 0-based index of their `end` line.
+    let mut scopes: Vec<(String, usize)> = Vec::new();
+    let mut in_block_comment = false;
+
+    for (idx, line) in lines.iter().enumerate() {
+        let trimmed = line.trim();
+        if in_block_comment {
+            in_block_comment = trimmed != "=end";
+            continue;
+        }
+        if trimmed == "=begin" {
+            in_block_comment = true;
+            continue;
+        }
+        while scopes.last().map_or(false, |(_, end)| *end <= idx) {
+            scopes.pop();
+        }
+        if trimmed.is_empty() || trimmed.starts_with('#') {
+            continue;
+        }
+
+        let code = strip_ruby_strings(trimmed);
+        let code = code.split('#').next().unwrap_or("");
+        let (_, rest) = extract_ruby_visibility(code);
+        let mut scan = rest;
+        let mut opened = None;
+        for keyword in ["module ", "class "] {
+            if let Some(after) = rest.strip_prefix(keyword) {
+                let after = after.trim_start();
+                if after.starts_with("<<") {
+                    break;
+                }
+                let name_end = after.find(|c: char| !c.is_alphanumeric() && c != '_' && c != ':').unwrap_or(after.len());
+                opened = Some(after[..name_end].to_owned());
+                scan = &after[name_end..];
+            }
+        }
+
+        let enclosing: Vec<String> = scopes.iter().rev().map(|(s, _)| s.clone()).collect();
+        for reference in scan_constants(scan) {
+            let (reference, scopes) = match reference.strip_prefix("::") {
+                Some(top) => (top.to_owned(), Vec::new()),
+                None => (reference, enclosing.clone()),
+            };
+            if !references.iter().any(|(_, r, s)| *r == reference && *s == scopes) {
+                references.push((idx, reference, scopes));
+            }
+        }
+
+        if let Some(name) = opened.filter(|n| !n.is_empty()) {
+            let full = match (name.strip_prefix("::"), scopes.last()) {
+                (Some(top), _) => top.to_owned(),
+                (None, Some((outer, _))) => format!("{}::{}", outer, name),
+                (None, None) => name,
+            };
+            let end = find_ruby_end(&lines, idx).saturating_sub(1).max(idx + 1);
+            scopes.push((full, end));
+        }
+    }
+
+    references
+}
+
+/// Constant paths such as `Billing::InvoiceMailer` or `::Config` in a line
+/// of code. Method calls (`x.Foo`), symbols, instance and global variables
+/// are not constants.
+fn scan_constants(code: &str) -> Vec<String> {
+    let bytes = code.as_bytes();
+    let is_word = |b: u8| b.is_ascii_alphanumeric() || b == b'_';
+    let mut found = Vec::new();
+    let mut i = 0;
+    while i < bytes.len() {
+        if !bytes[i].is_ascii_uppercase() {
+            i += 1;
+            continue;
+        }
+        let mut start = i;
+        let prev = if i > 0 { bytes[i - 1] } else { b' ' };
+        if prev == b':' && i >= 2 && bytes[i - 2] == b':' {
+            if i >= 3 && (is_word(bytes[i - 3]) || bytes[i - 3] == b')') {
+                // The tail of an expression such as `foo::Bar`.
+                i += 1;
+                continue;
+            }
+            start = i - 2;
+        } else if is_word(prev) || matches!(prev, b'.' | b':' | b'@' | b'$') {
+            while i < bytes.len() && is_word(bytes[i]) {
+                i += 1;
+            }
+            continue;
+        }
+
+        let mut end = i;
+        loop {
+            while end < bytes.len() && is_word(bytes[end]) {
+                end += 1;
+            }
+            if code[end..].starts_with("::") && bytes.get(end + 2).map_or(false, |b| b.is_ascii_uppercase()) {
+                end += 2;
+            } else {
+                break;
+            }
+        }
+        found.push(code[start..end].to_owned());
+        i = end;
+    }
+    found
+}
+
+const SYNTAX: Syntax = Syntax {
+    line_comment: "#",
+    block_comment: Some(("=begin", "=end")),
+    block_at_line_start: true,
+    quotes: &[quote("\"", true, true).interpolated("#{", b""), quote("'", true, true)],
+    char_literals: false,
+    raw_strings: false,
+    imports: &["require ", "require_relative ", "load "],
+    paren_free_calls: true,
+};
+
 impl LangSymbols for RubyImports {
     fn extensions(&self) -> &[&str] {
         &["rb", "rake"]
     }
 
+    fn syntax(&self) -> &'static Syntax {
+        &SYNTAX
+    }
+
     fn extract_symbols(&self, content: &str) -> Vec<SymbolInfo> {
         let all_lines: Vec<&str> = content.lines().collect();
         let mut symbols = Vec::new();
@@ -186,17 +499,11 @@ fn extract_require(trimmed: &str, prefix: &str) -> Option<String> {
     Some(path.to_owned())
 }
 
-fn normalize_ruby_path(path: &str, is_relative: bool) -> String {
-    let with_ext = if path.ends_with(".rb") {
+fn normalize_ruby_path(path: &str) -> String {
+    if path.ends_with(".rb") {
         path.to_owned()
     } else {
         format!("{}.rb", path)
-    };
-
-    if is_relative {
-        with_ext
-    } else {
-        with_ext
     }
 }
 
@@ -353,19 +660,19 @@ mod tests {
     fn require_relative_single_quotes() {
         let imports = extract_imports("require_relative 'models/user'");
         assert_eq!(imports.len(), 1);
-        assert_eq!(imports[0], "models/user.rb");
+        assert_eq!(imports[0], "lib/models/user.rb");
     }
 
     #[test]
     fn require_relative_double_quotes() {
         let imports = extract_imports("require_relative \"models/user\"");
         assert_eq!(imports.len(), 1);
-        assert_eq!(imports[0], "models/user.rb");
+        assert_eq!(imports[0], "lib/models/user.rb");
     }
 
     #[test]
     fn require_relative_with_rb_extension() {
-        let imports = extract_imports("require_relative 'models/user.rb'");
+        let imports = extract_imports("require_relative '../models/user.rb'");
         assert_eq!(imports.len(), 1);
         assert_eq!(imports[0], "models/user.rb");
     }
@@ -374,8 +681,8 @@ mod tests {
     fn require_gem() {
         let imports = extract_imports("require 'rails'\nrequire 'sinatra'");
         assert_eq!(imports.len(), 2);
-        assert_eq!(imports[0], "rails.rb");
-        assert_eq!(imports[1], "sinatra.rb");
+        assert_eq!(imports[0], "rb:rails.rb");
+        assert_eq!(imports[1], "rb:sinatra.rb");
     }
 
     #[test]
@@ -395,8 +702,8 @@ mod tests {
         let content = "require 'json'\nrequire_relative 'lib/helper'\nrequire 'nokogiri'\n";
         let imports = extract_imports(content);
         assert_eq!(imports.len(), 2);
-        assert!(imports.iter().any(|i| i == "lib/helper.rb"));
-        assert!(imports.iter().any(|i| i == "nokogiri.rb"));
+        assert!(imports.iter().any(|i| i == "lib/lib/helper.rb"));
+        assert!(imports.iter().any(|i| i == "rb:nokogiri.rb"));
     }
 
     #[test]
@@ -405,6 +712,98 @@ mod tests {
         assert!(imports.is_empty());
     }
 
+    #[test]
+    fn external_gems_use_first_path_segment() {
+        let content = "require 'json'\nrequire 'rails/all'\nrequire \"sidekiq\"\nrequire_relative 'models/user'\n";
+        let external = RubyImports.extract_external(content, Path::new("lib/main.rb"));
+        assert_eq!(external, vec!["external:rubygems:rails|rb:rails/all.rb", "external:rubygems:sidekiq|rb:sidekiq.rb"]);
+    }
+
+    // ── Constant references ──
+
+    #[test]
+    fn constant_references_carry_lexical_scopes() {
+        let content = "module Billing\n  class InvoiceMailer < ApplicationMailer\n    def deliver\n      Reports::Monthly.new(::Config.fetch)\n      @x = Foo.Bar\n    end\n  end\nend\nclass Admin::Panel\n  Audit\nend\n";
+        let refs = constant_references(content);
+        let scoped: Vec<(usize, &str, String)> = refs.iter().map(|(i, r, s)| (*i, r.as_str(), s.join(","))).collect();
+        assert_eq!(scoped, vec![
+            (1, "ApplicationMailer", "Billing".to_owned()),
+            (3, "Reports::Monthly", "Billing::InvoiceMailer,Billing".to_owned()),
+            (3, "Config", String::new()),
+            (4, "Foo", "Billing::InvoiceMailer,Billing".to_owned()),
+            (9, "Audit", "Admin::Panel".to_owned()),
+        ]);
+    }
+
+    #[test]
+    fn constants_in_strings_and_comments_are_ignored() {
+        let content = "# uses Legacy::Thing\nputs \"Hello World\"\nx = :Symbol # Other\n=begin\nHidden\n=end\n";
+        assert!(constant_references(content).is_empty());
+    }
+
+    #[test]
+    fn constant_candidates() {
+        let imports = extract_imports("module Billing\n  InvoiceMailer.deliver\nend\n");
+        assert_eq!(imports, vec!["rb:InvoiceMailer@Billing"]);
+    }
+
+    #[test]
+    fn zeitwerk_file_names() {
+        assert_eq!(underscore("InvoiceMailer"), "invoice_mailer");
+        assert_eq!(underscore("HTMLParser"), "html_parser");
+        assert_eq!(underscore("API"), "api");
+        assert_eq!(underscore("OAuth2Client"), "o_auth2_client");
+    }
+
+    // ── Load and autoload paths ──
+
+    fn ruby_paths(files: &[&str]) -> RubyPaths {
+        let files: HashSet<String> = files.iter().map(|f| (*f).to_owned()).collect();
+        RubyPaths::load(Path::new("."), &files)
+    }
+
+    #[test]
+    fn constants_resolve_through_autoload_roots() {
+        let paths = ruby_paths(&[
+            "app/mailers/application_mailer.rb",
+            "app/mailers/billing/invoice_mailer.rb",
+            "app/models/billing/invoice.rb",
+            "app/models/concerns/archivable.rb",
+            "app/models/user.rb",
+            "app/controllers/billing/invoices_controller.rb",
+            "app/views/users/show.rb",
+            "lib/payments/gateway.rb",
+        ]);
+        let from = "app/controllers/billing/invoices_controller.rb";
+        assert_eq!(paths.resolve(from, "Billing::InvoiceMailer@"), Some(vec!["app/mailers/billing/invoice_mailer.rb".to_owned()]));
+        assert_eq!(paths.resolve(from, "Invoice@Billing::InvoicesController,Billing"), Some(vec!["app/models/billing/invoice.rb".to_owned()]));
+        assert_eq!(paths.resolve(from, "Archivable@"), Some(vec!["app/models/concerns/archivable.rb".to_owned()]));
+        assert_eq!(paths.resolve(from, "Payments::Gateway@"), Some(vec!["lib/payments/gateway.rb".to_owned()]));
+        assert_eq!(paths.resolve(from, "Billing@"), Some(vec![]));
+        assert_eq!(paths.resolve(from, "Users::Show@"), None);
+        assert_eq!(paths.resolve(from, "ActiveRecord::Base@"), None);
+    }
+
+    #[test]
+    fn nested_constants_fall_back_to_the_deepest_file() {
+        let paths = ruby_paths(&["app/models/user.rb", "app/models/order.rb"]);
+        assert_eq!(paths.resolve("app/models/order.rb", "User::Role@Order"), Some(vec!["app/models/user.rb".to_owned()]));
+    }
+
+    #[test]
+    fn requires_resolve_against_own_lib_first() {
+        let paths = ruby_paths(&[
+            "lib/tasks/seed.rb",
+            "gems/billing/lib/billing/client.rb",
+            "gems/billing/lib/tasks/seed.rb",
+            "gems/billing/test/client_test.rb",
+        ]);
+        assert_eq!(paths.resolve("gems/billing/test/client_test.rb", "tasks/seed.rb"), Some(vec!["gems/billing/lib/tasks/seed.rb".to_owned()]));
+        assert_eq!(paths.resolve("app/models/user.rb", "tasks/seed.rb"), Some(vec!["lib/tasks/seed.rb".to_owned()]));
+        assert_eq!(paths.resolve("lib/tasks/seed.rb", "billing/client.rb"), Some(vec!["gems/billing/lib/billing/client.rb".to_owned()]));
+        assert_eq!(paths.resolve("lib/tasks/seed.rb", "sidekiq.rb"), None);
+    }
+
     // ── Symbol: class ──
 
     #[test]
@@ -633,9 +1032,9 @@ end
         let content = "require_relative 'models/user'\nrequire_relative 'services/auth_service'\nrequire 'json'\nrequire 'nokogiri'\n";
         let imports = extract_imports(content);
         assert_eq!(imports.len(), 3);
-        assert!(imports.iter().any(|i| i == "models/user.rb"));
-        assert!(imports.iter().any(|i| i == "services/auth_service.rb"));
-        assert!(imports.iter().any(|i| i == "nokogiri.rb"));
+        assert!(imports.iter().any(|i| i == "lib/models/user.rb"));
+        assert!(imports.iter().any(|i| i == "lib/services/auth_service.rb"));
+        assert!(imports.iter().any(|i| i == "rb:nokogiri.rb"));
     }
 
     // ── Helper tests ──
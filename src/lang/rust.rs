@@ -1,6 +1,15 @@
+use std::collections::{HashMap, HashSet};
 use std::path::Path;
+use std::sync::OnceLock;
+
+use regex::Regex;
+
 use super::{LangImports, LangSymbols, SymbolInfo};
 use super::common::{self, CommentTracker};
+use super::lexer::{self, quote, Syntax};
+use crate::external;
+use crate::glob;
+use crate::path_helper;
 
 pub struct RustImports;
 
@@ -12,48 +21,527 @@ impl LangImports for RustImports {
     fn extract_imports(&self, content: &str, file_path: &Path) -> Vec<String> {
         let mut imports = Vec::new();
         let file_dir = file_path.parent().unwrap_or_else(|| Path::new(""));
+        let module_dir = module_dir(file_path);
+        let mut path_attr: Option<&str> = None;
+        let mut pending_use: Option<String> = None;
+        let mut depth: i32 = 0;
+        // Brace depth outside each open inline `mod name { }` block.
+        let mut inline_mods: Vec<i32> = Vec::new();
 
         for line in content.lines() {
             let trimmed = line.trim();
+            let depth_before = depth;
+            common::update_brace_depth(trimmed.split("//").next().unwrap_or(""), &mut depth);
+            while inline_mods.last().map_or(false, |&d| depth <= d) {
+                inline_mods.pop();
+            }
+
+            if let Some(mut stmt) = pending_use.take() {
+                stmt.push(' ');
+                stmt.push_str(trimmed.split("//").next().unwrap_or(""));
+                if stmt.contains(';') {
+                    imports.extend(use_candidates(&stmt, inline_mods.len()));
+                } else {
+                    pending_use = Some(stmt);
+                }
+                continue;
+            }
 
-            if let Some(rest) = trimmed.strip_prefix("mod ") {
-                if let Some(module_name) = parse_mod_decl(rest) {
-                    if module_name != "tests" {
-                        let sibling = file_dir.join(format!("{}.rs", module_name));
-                        imports.push(normalize(sibling));
-                        let subdir = file_dir.join(&module_name).join("mod.rs");
-                        imports.push(normalize(subdir));
+            if let Some(path) = parse_path_attr(trimmed) {
+                path_attr = Some(path);
+                continue;
+            }
+            let (_, rest) = extract_visibility(trimmed);
+
+            if let Some(rest) = rest.strip_prefix("mod ") {
+                if rest.contains('{') && depth > depth_before {
+                    inline_mods.push(depth_before);
+                } else if let Some(module_name) = parse_mod_decl(rest) {
+                    if let Some(path) = path_attr {
+                        imports.push(normalize(file_dir.join(path)));
+                    } else if module_name != "tests" {
+                        imports.push(normalize(module_dir.join(format!("{}.rs", module_name))));
+                        imports.push(normalize(module_dir.join(module_name).join("mod.rs")));
                     }
                 }
+            } else if let Some(tree) = rest.strip_prefix("use ") {
+                let tree = tree.split("//").next().unwrap_or("");
+                if tree.contains(';') || !tree.contains('{') {
+                    imports.extend(use_candidates(tree, inline_mods.len()));
+                } else {
+                    pending_use = Some(tree.to_owned());
+                }
+            } else if let Some(name) = rest.strip_prefix("extern crate ") {
+                let name = name.split(|c: char| c == ';' || c.is_whitespace()).next().unwrap_or("");
+                if !name.is_empty() && !is_builtin_crate(name) {
+                    imports.push(format!("{}{}", RUST_PREFIX, name));
+                }
+            }
+
+            if !trimmed.starts_with("#[") {
+                path_attr = None;
             }
+        }
+        if let Some(stmt) = pending_use {
+            imports.extend(use_candidates(&stmt, inline_mods.len()));
+        }
 
-            if let Some(rest) = trimmed.strip_prefix("use crate::") {
-                if let Some(path_part) = extract_use_path(rest) {
-                    let segments: Vec<&str> = path_part.split("::").collect();
-                    let resolved = resolve_crate_path(&segments);
-                    imports.extend(resolved);
+        imports
+    }
+
+    fn extract_external(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        let mut external = Vec::new();
+
+        for line in content.lines() {
+            let (_, trimmed) = extract_visibility(line.trim());
+            let rest = match trimmed.strip_prefix("use ").or_else(|| trimmed.strip_prefix("extern crate ")) {
+                Some(rest) => rest.trim_start_matches("::"),
+                None => continue,
+            };
+            let end = rest.find(|c: char| !(c.is_ascii_alphanumeric() || c == '_')).unwrap_or(rest.len());
+            let name = &rest[..end];
+            if name.is_empty() || !name.starts_with(|c: char| c.is_ascii_lowercase()) || is_builtin_crate(name) {
+                continue;
+            }
+            // `use name::` also reaches a child module or a workspace crate.
+            external.push(external::marker("cargo", name, &[format!("{}{}", RUST_PREFIX, name)]));
+        }
+
+        external
+    }
+}
+
+/// Raw candidates for `use` paths carry the path after this prefix;
+/// `CargoCrates` resolves them from the importing file once the crates in
+/// the project are known.
+pub const RUST_PREFIX: &str = "rust:";
+
+/// The Cargo crates in a project: every `Cargo.toml` above a scanned file,
+/// the members of a `[workspace]`, and the targets of `path` dependencies.
+/// Without a manifest at the root, `src/` there still counts as a crate.
+#[derive(Default)]
+pub struct CargoCrates {
+    /// Crates, deepest directory first.
+    crates: Vec<Crate>,
+    /// The crate a leading `use` path segment names: package and `[lib]`
+    /// names and `path` dependency keys, with `-` read as `_`.
+    names: HashMap<String, usize>,
+    /// Every scanned `.rs` file.
+    files: HashSet<String>,
+}
+
+struct Crate {
+    /// The crate's directory relative to the root.
+    dir: String,
+    /// The directory the crate's top-level modules live in, usually `src`.
+    src: String,
+    /// The library root, then the binary root.
+    roots: Vec<String>,
+}
+
+/// Where a `use` path starts: the directory top-level modules live in, the
+/// crate root files and the module path below them.
+struct Scope {
+    src: String,
+    roots: Vec<String>,
+    module: Vec<String>,
+}
+
+impl CargoCrates {
+    pub fn load(root: &Path, files: &HashSet<String>) -> Self {
+        let files: HashSet<String> = files.iter().filter(|f| f.ends_with(".rs")).cloned().collect();
+        if files.is_empty() {
+            return Self::default();
+        }
+
+        let mut pending: Vec<String> = Vec::new();
+        let mut checked = HashSet::new();
+        for file in &files {
+            let mut dir = path_helper::parent_dir(file);
+            while checked.insert(dir) {
+                if root.join(dir).join("Cargo.toml").is_file() {
+                    pending.push(dir.to_owned());
+                    break;
                 }
+                if dir.is_empty() {
+                    break;
+                }
+                dir = path_helper::parent_dir(dir);
             }
+        }
 
-            if let Some(rest) = trimmed.strip_prefix("use super::") {
-                if let Some(path_part) = extract_use_path(rest) {
-                    let parent = file_dir.parent().unwrap_or_else(|| Path::new(""));
-                    let segments: Vec<&str> = path_part.split("::").collect();
-                    let resolved = resolve_relative_path(parent, &segments);
-                    imports.extend(resolved);
+        let mut crates: Vec<Crate> = Vec::new();
+        let mut named: Vec<(String, String)> = Vec::new();
+        let mut read = HashSet::new();
+        while let Some(dir) = pending.pop() {
+            if !read.insert(dir.clone()) {
+                continue;
+            }
+            let text = match std::fs::read_to_string(root.join(&dir).join("Cargo.toml")) {
+                Ok(text) => text,
+                Err(_) => continue,
+            };
+            let manifest = Manifest::parse(&text);
+            for member in &manifest.members {
+                pending.extend(expand_member(root, &dir, member));
+            }
+            for (name, path) in &manifest.path_deps {
+                if let Some(target) = common::join_dir(&dir, path) {
+                    named.push((crate_name(name), target.clone()));
+                    pending.push(target);
                 }
             }
+            if let Some(package) = &manifest.package {
+                let lib = manifest.lib_path.as_deref().unwrap_or("src/lib.rs");
+                let lib = match common::join_dir(&dir, lib) {
+                    Some(lib) => lib,
+                    None => continue,
+                };
+                let main = common::join_dir(&dir, "src/main.rs").unwrap_or_default();
+                named.push((crate_name(manifest.lib_name.as_ref().unwrap_or(package)), dir.clone()));
+                crates.push(Crate { dir, src: path_helper::parent_dir(&lib).to_owned(), roots: vec![lib, main] });
+            }
+        }
+        if !crates.iter().any(|c| c.dir.is_empty()) {
+            crates.push(Crate { dir: String::new(), src: "src".into(), roots: vec!["src/lib.rs".into(), "src/main.rs".into()] });
         }
+        crates.sort_by(|a, b| b.dir.len().cmp(&a.dir.len()).then_with(|| a.dir.cmp(&b.dir)));
 
-        imports
+        let mut names = HashMap::new();
+        for (name, dir) in named {
+            if let Some(i) = crates.iter().position(|c| c.dir == dir) {
+                names.entry(name).or_insert(i);
+            }
+        }
+        Self { crates, names, files }
+    }
+
+    /// The file holding the deepest module `path` reaches from `from`, none
+    /// when the path stays in the project but no file matches, or `None`
+    /// when it names a crate outside the project.
+    pub fn resolve(&self, from: &str, path: &str) -> Option<Vec<String>> {
+        let here = self.scope(from)?;
+        let mut segments = path.split("::").filter(|s| !s.is_empty()).peekable();
+        let first = segments.next()?;
+        let mut scope = match first {
+            "crate" => Scope { module: Vec::new(), ..here },
+            "self" => here,
+            "super" => {
+                let mut scope = here;
+                scope.module.pop();
+                while segments.next_if_eq(&"super").is_some() {
+                    scope.module.pop();
+                }
+                scope
+            }
+            name => {
+                let mut local = here.module.clone();
+                local.push(name.to_owned());
+                if self.module_file(&here.src, &local).is_some() {
+                    Scope { module: local, ..here }
+                } else {
+                    let krate = &self.crates[*self.names.get(name)?];
+                    Scope { src: krate.src.clone(), roots: krate.roots.clone(), module: Vec::new() }
+                }
+            }
+        };
+
+        let mut found = if scope.module.is_empty() {
+            scope.roots.iter().find(|r| self.files.contains(*r)).cloned()
+        } else {
+            self.module_file(&scope.src, &scope.module)
+        };
+        for segment in segments {
+            scope.module.push(segment.to_owned());
+            match self.module_file(&scope.src, &scope.module) {
+                Some(file) => found = Some(file),
+                None => break,
+            }
+        }
+        Some(found.into_iter().collect())
+    }
+
+    /// The scope of a file. Binaries under `src/bin`, tests, examples and
+    /// other files outside the crate's module directory are roots of their
+    /// own.
+    fn scope(&self, file: &str) -> Option<Scope> {
+        let krate = self.crates.iter().find(|c| c.dir.is_empty() || file.starts_with(&format!("{}/", c.dir)))?;
+        let in_src = krate.src.is_empty() || file.starts_with(&format!("{}/", krate.src));
+        if !in_src || file.starts_with(&format!("{}/bin/", krate.src)) {
+            return Some(Scope { src: path_helper::parent_dir(file).to_owned(), roots: vec![file.to_owned()], module: Vec::new() });
+        }
+        if krate.roots.iter().any(|r| r == file) {
+            return Some(Scope { src: krate.src.clone(), roots: vec![file.to_owned()], module: Vec::new() });
+        }
+        let rel = file[krate.src.len()..].trim_start_matches('/');
+        let mut module: Vec<String> = rel.strip_suffix(".rs").unwrap_or(rel).split('/').map(str::to_owned).collect();
+        if module.last().map_or(false, |m| m == "mod") {
+            module.pop();
+        }
+        Some(Scope { src: krate.src.clone(), roots: krate.roots.clone(), module })
+    }
+
+    fn module_file(&self, src: &str, module: &[String]) -> Option<String> {
+        let base = if src.is_empty() { module.join("/") } else { format!("{}/{}", src, module.join("/")) };
+        [format!("{}.rs", base), format!("{}/mod.rs", base)].into_iter().find(|f| self.files.contains(f))
+    }
+}
+
+/// The `Cargo.toml` keys that locate crates.
+#[derive(Default)]
+struct Manifest {
+    package: Option<String>,
+    lib_name: Option<String>,
+    lib_path: Option<String>,
+    members: Vec<String>,
+    /// Dependency key and the `path` it points at.
+    path_deps: Vec<(String, String)>,
+}
+
+impl Manifest {
+    fn parse(text: &str) -> Self {
+        static QUOTED: OnceLock<Regex> = OnceLock::new();
+        let quoted = QUOTED.get_or_init(|| Regex::new(r#""([^"]*)""#).unwrap());
+        static INLINE_PATH: OnceLock<Regex> = OnceLock::new();
+        let inline_path = INLINE_PATH.get_or_init(|| Regex::new(r#"\bpath\s*=\s*"([^"]+)""#).unwrap());
+        let mut manifest = Manifest::default();
+        let mut section = String::new();
+        let mut lines = text.lines();
+
+        while let Some(line) = lines.next() {
+            let line = line.split('#').next().unwrap_or("").trim();
+            if line.starts_with('[') {
+                section = line.trim_matches(|c| c == '[' || c == ']').trim().to_owned();
+                continue;
+            }
+            let (key, value) = match line.split_once('=') {
+                Some((key, value)) => (key.trim().trim_matches('"'), value.trim()),
+                None => continue,
+            };
+            // Arrays may span lines until the closing bracket.
+            let mut value = value.to_owned();
+            if value.starts_with('[') {
+                while !value.ends_with(']') {
+                    match lines.next() {
+                        Some(next) => {
+                            value.push(' ');
+                            value.push_str(next.split('#').next().unwrap_or("").trim());
+                        }
+                        None => break,
+                    }
+                }
+            }
+            let string = || quoted.captures(&value).map(|c| c[1].to_owned());
+
+            match (section.as_str(), key) {
+                ("package", "name") => manifest.package = string(),
+                ("lib", "name") => manifest.lib_name = string(),
+                ("lib", "path") => manifest.lib_path = string(),
+                ("workspace", "members") => manifest.members = quoted.captures_iter(&value).map(|c| c[1].to_owned()).collect(),
+                (table, key) if is_dependency_table(table) => {
+                    let path = match key.strip_suffix(".path") {
+                        Some(_) => string(),
+                        None => inline_path.captures(&value).map(|c| c[1].to_owned()),
+                    };
+                    if let Some(path) = path {
+                        manifest.path_deps.push((key.trim_end_matches(".path").to_owned(), path));
+                    }
+                }
+                (table, "path") => {
+                    // `[dependencies.name]` with the path on its own line.
+                    if let Some((deps, name)) = table.rsplit_once('.') {
+                        if is_dependency_table(deps) {
+                            if let Some(path) = string() {
+                                manifest.path_deps.push((name.trim_matches('"').to_owned(), path));
+                            }
+                        }
+                    }
+                }
+                _ => {}
+            }
+        }
+        manifest
     }
 }
 
+/// `[dependencies]`, `[dev-dependencies]`, `[build-dependencies]`,
+/// `[workspace.dependencies]` and their `[target.*]` forms.
+fn is_dependency_table(table: &str) -> bool {
+    table == "dependencies" || table.ends_with("-dependencies") || table.ends_with(".dependencies")
+}
+
+/// The directories a workspace `members` entry names, expanding `*` and `?`
+/// one directory level at a time.
+fn expand_member(root: &Path, dir: &str, member: &str) -> Vec<String> {
+    let pattern = match common::join_dir(dir, member) {
+        Some(pattern) => pattern,
+        None => return Vec::new(),
+    };
+    let mut found = vec![String::new()];
+    for segment in pattern.split('/').filter(|s| !s.is_empty()) {
+        let mut next = Vec::new();
+        for base in &found {
+            if !segment.contains(['*', '?']) {
+                next.push(common::under(base, segment));
+                continue;
+            }
+            if let Ok(entries) = std::fs::read_dir(root.join(base)) {
+                for entry in entries.flatten().filter(|e| e.path().is_dir()) {
+                    let name = entry.file_name().to_string_lossy().into_owned();
+                    if glob::matches(&name, segment) {
+                        next.push(common::under(base, &name));
+                    }
+                }
+            }
+        }
+        found = next;
+    }
+    found
+}
+
+/// Crate names are written with `_` in code where manifests may use `-`.
+fn crate_name(name: &str) -> String {
+    name.replace('-', "_")
+}
+
+/// Where `mod name;` in `file_path` looks for `name`: beside crate roots and
+/// `mod.rs` files, in a directory named after the file otherwise.
+fn module_dir(file_path: &Path) -> std::path::PathBuf {
+    let file_dir = file_path.parent().unwrap_or_else(|| Path::new(""));
+    let name = file_path.file_name().and_then(|s| s.to_str()).unwrap_or("");
+    let dir_name = file_dir.file_name().and_then(|s| s.to_str()).unwrap_or("");
+    let is_root = matches!(name, "mod.rs" | "lib.rs" | "main.rs" | "build.rs")
+        || matches!(dir_name, "bin" | "tests" | "examples" | "benches");
+    match file_path.file_stem().and_then(|s| s.to_str()) {
+        Some(stem) if !is_root => file_dir.join(stem),
+        _ => file_dir.to_path_buf(),
+    }
+}
+
+/// The value of a `#[path = "..."]` attribute.
+fn parse_path_attr(trimmed: &str) -> Option<&str> {
+    let rest = trimmed.strip_prefix("#[path")?.trim_start().strip_prefix('=')?.trim_start();
+    let rest = rest.strip_prefix('"')?;
+    Some(&rest[..rest.find('"')?])
+}
+
+/// The raw candidates of a `use` tree found `nesting` inline modules deep,
+/// standard library paths left out.
+fn use_candidates(tree: &str, nesting: usize) -> Vec<String> {
+    let tree = tree.split(';').next().unwrap_or("").trim().trim_start_matches("::");
+    let mut paths = Vec::new();
+    expand_use_tree("", tree, &mut paths);
+    paths
+        .into_iter()
+        .filter(|p| !is_std_crate(p.split("::").next().unwrap_or("")))
+        .map(|p| format!("{}{}", RUST_PREFIX, file_relative(&p, nesting)))
+        .collect()
+}
+
+/// Inline modules have no file of their own, so inside `nesting` of them the
+/// `super::` steps that stay within the file read as `self::`.
+fn file_relative(path: &str, nesting: usize) -> String {
+    let mut rest = path;
+    let mut lifted = 0;
+    while lifted < nesting {
+        match rest.strip_prefix("super") {
+            Some(after) if after.is_empty() || after.starts_with("::") => {
+                rest = after.trim_start_matches("::");
+                lifted += 1;
+            }
+            _ => break,
+        }
+    }
+    match (lifted, rest) {
+        (0, _) => path.to_owned(),
+        (_, "") => "self".to_owned(),
+        (_, rest) if rest.starts_with("super") => rest.to_owned(),
+        (_, rest) => format!("self::{}", rest),
+    }
+}
+
+/// Flattens a `use` tree: `a::{b, c::{d as e, self}}` gives `a::b`,
+/// `a::c::d` and `a::c`. A tree cut off before its closing brace keeps the
+/// part before it.
+fn expand_use_tree(prefix: &str, tree: &str, out: &mut Vec<String>) {
+    let join = |path: &str| match (prefix.is_empty(), path.is_empty()) {
+        (true, _) => path.to_owned(),
+        (false, true) => prefix.to_owned(),
+        (false, false) => format!("{}::{}", prefix, path),
+    };
+    let tree = tree.trim();
+    match tree.find('{') {
+        Some(open) => {
+            let base = join(tree[..open].trim().trim_end_matches("::"));
+            let inner = &tree[open + 1..];
+            match inner.rfind('}') {
+                Some(close) => {
+                    for item in split_top_level(&inner[..close]) {
+                        expand_use_tree(&base, item, out);
+                    }
+                }
+                None if !base.is_empty() => out.push(base),
+                None => {}
+            }
+        }
+        None => {
+            let path = tree.split(" as ").next().unwrap_or(tree).trim();
+            let path = match path {
+                "*" | "self" => "",
+                _ => path.trim_end_matches("::*"),
+            };
+            let full = join(path);
+            if !full.is_empty() && !out.contains(&full) {
+                out.push(full);
+            }
+        }
+    }
+}
+
+/// Splits on commas outside braces.
+fn split_top_level(list: &str) -> Vec<&str> {
+    let mut items = Vec::new();
+    let (mut depth, mut start) = (0, 0);
+    for (i, c) in list.char_indices() {
+        match c {
+            '{' => depth += 1,
+            '}' => depth -= 1,
+            ',' if depth == 0 => {
+                items.push(&list[start..i]);
+                start = i + 1;
+            }
+            _ => {}
+        }
+    }
+    items.push(&list[start..]);
+    items.into_iter().filter(|s| !s.trim().is_empty()).collect()
+}
+
+fn is_builtin_crate(name: &str) -> bool {
+    matches!(name, "crate" | "self" | "super") || is_std_crate(name)
+}
+
+fn is_std_crate(name: &str) -> bool {
+    matches!(name, "std" | "core" | "alloc" | "proc_macro" | "test")
+}
+
+/// Rust strings may span lines; `'` is also a lifetime.
+const SYNTAX: Syntax = Syntax {
+    quotes: &[quote("\"", true, true)],
+    raw_strings: true,
+    imports: &["use ", "pub use ", "pub(crate) use ", "extern crate "],
+    ..lexer::C_LIKE
+};
+
 impl LangSymbols for RustImports {
     fn extensions(&self) -> &[&str] {
         &["rs"]
     }
 
+    fn syntax(&self) -> &'static Syntax {
+        &SYNTAX
+    }
+
     fn extract_symbols(&self, content: &str) -> Vec<SymbolInfo> {
         extract_symbols_internal(content, true)
     }
@@ -363,51 +851,6 @@ fn parse_mod_decl(rest: &str) -> Option<&str> {
     Some(name)
 }
 
-fn extract_use_path(rest: &str) -> Option<&str> {
-    let end = rest.find(|c: char| c == ';' || c == '{' || c == ' ')?;
-    let path = &rest[..end];
-    if path.is_empty() { None } else { Some(path) }
-}
-
-fn resolve_crate_path(segments: &[&str]) -> Vec<String> {
-    if segments.is_empty() {
-        return Vec::new();
-    }
-    let mut results = Vec::new();
-    let dir_path: String = segments.iter()
-        .map(|s| s.to_string())
-        .collect::<Vec<_>>()
-        .join("/");
-
-    results.push(format!("src/{}.rs", dir_path));
-    if segments.len() > 1 {
-        let parent: String = segments[..segments.len() - 1].join("/");
-        results.push(format!("src/{}.rs", parent));
-    }
-    results
-}
-
-fn resolve_relative_path(base: &Path, segments: &[&str]) -> Vec<String> {
-    if segments.is_empty() {
-        return Vec::new();
-    }
-    let mut results = Vec::new();
-    let dir_path: String = segments.iter()
-        .map(|s| s.to_string())
-        .collect::<Vec<_>>()
-        .join("/");
-
-    let full = base.join(format!("{}.rs", dir_path));
-    results.push(normalize(full));
-
-    if segments.len() > 1 {
-        let parent: String = segments[..segments.len() - 1].join("/");
-        let full = base.join(format!("{}.rs", parent));
-        results.push(normalize(full));
-    }
-    results
-}
-
 fn normalize(path: std::path::PathBuf) -> String {
     let s = path.to_string_lossy();
     if cfg!(windows) {
@@ -420,6 +863,7 @@ fn normalize(path: std::path::PathBuf) -> String {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::test_support::temp_project;
 
     fn extract_imports(content: &str, file_path: &str) -> Vec<String> {
         RustImports.extract_imports(content, Path::new(file_path))
@@ -460,7 +904,55 @@ mod tests {
     fn use_crate_import() {
         let content = "use crate::models::FileEntry;";
         let imports = extract_imports(content, "src/searcher.rs");
-        assert!(imports.iter().any(|i| i.contains("src/models")));
+        assert_eq!(imports, vec!["rust:crate::models::FileEntry"]);
+    }
+
+    #[test]
+    fn use_trees_expand_to_full_paths() {
+        let content = "pub(crate) use crate::{graph, models::{self, GraphEntry as Entry}, lang::*};\nuse std::path::Path;\nuse super::LangImports;";
+        let imports = extract_imports(content, "src/lang/rust.rs");
+        assert_eq!(imports, vec![
+            "rust:crate::graph",
+            "rust:crate::models",
+            "rust:crate::models::GraphEntry",
+            "rust:crate::lang",
+            "rust:super::LangImports",
+        ]);
+    }
+
+    #[test]
+    fn super_inside_inline_mod_stays_in_the_file() {
+        let content = "use super::LangImports;\n\n#[cfg(test)]\nmod tests {\n    use super::*;\n    use super::super::models::GraphEntry;\n    mod deeper {\n        use super::super::helpers;\n    }\n}\nuse super::common;\n";
+        let imports = extract_imports(content, "src/lang/rust.rs");
+        assert_eq!(imports, vec![
+            "rust:super::LangImports",
+            "rust:self",
+            "rust:super::models::GraphEntry",
+            "rust:self::helpers",
+            "rust:super::common",
+        ]);
+    }
+
+    #[test]
+    fn multi_line_use_is_read_to_the_semicolon() {
+        let content = "use shared_types::{\n    Invoice, // billing\n    tax::Rate,\n};\nfn main() {}";
+        let imports = extract_imports(content, "src/main.rs");
+        assert_eq!(imports, vec!["rust:shared_types::Invoice", "rust:shared_types::tax::Rate"]);
+    }
+
+    #[test]
+    fn mod_in_non_mod_rs_file_looks_in_its_own_directory() {
+        let imports = extract_imports("pub mod rust;", "src/lang.rs");
+        assert_eq!(imports, vec!["src/lang/rust.rs", "src/lang/rust/mod.rs"]);
+        let imports = extract_imports("mod helpers;", "tests/cli.rs");
+        assert_eq!(imports, vec!["tests/helpers.rs", "tests/helpers/mod.rs"]);
+    }
+
+    #[test]
+    fn path_attribute_overrides_mod_location() {
+        let content = "#[path = \"generated/schema.rs\"]\n#[allow(dead_code)]\nmod schema;\nmod cli;";
+        let imports = extract_imports(content, "src/main.rs");
+        assert_eq!(imports, vec!["src/generated/schema.rs", "src/cli.rs", "src/cli/mod.rs"]);
     }
 
     #[test]
@@ -484,6 +976,88 @@ mod tests {
         assert!(imports.len() >= 6); // 2 candidates per mod (file + subdir)
     }
 
+    #[test]
+    fn external_crates_skip_std_and_local_paths() {
+        let content = "use std::path::Path;\nuse rayon::prelude::*;\npub use regex::Regex;\nextern crate memchr;\nuse crate::models::GraphEntry;\nuse super::LangImports;\nuse Kind::*;";
+        let external = RustImports.extract_external(content, Path::new("src/graph.rs"));
+        assert_eq!(external, vec![
+            "external:cargo:rayon|rust:rayon",
+            "external:cargo:regex|rust:regex",
+            "external:cargo:memchr|rust:memchr",
+        ]);
+    }
+
+    // ── Cargo crates ──
+
+    #[test]
+    fn manifest_reads_workspace_lib_and_path_dependencies() {
+        let text = r#"
+[package]
+name = "billing-api"
+
+[lib]
+name = "billing"
+path = "lib/billing.rs"
+
+[workspace]
+members = [
+    "crates/*",  # every crate
+    "tools/gen",
+]
+
+[dependencies]
+serde = "1"
+shared-types = { path = "../shared-types", version = "0.1" }
+util.path = "../util"
+
+[dev-dependencies.fixtures]
+path = "tests/fixtures"
+"#;
+        let manifest = Manifest::parse(text);
+        assert_eq!(manifest.package.as_deref(), Some("billing-api"));
+        assert_eq!(manifest.lib_name.as_deref(), Some("billing"));
+        assert_eq!(manifest.lib_path.as_deref(), Some("lib/billing.rs"));
+        assert_eq!(manifest.members, vec!["crates/*", "tools/gen"]);
+        assert_eq!(manifest.path_deps, vec![
+            ("shared-types".to_owned(), "../shared-types".to_owned()),
+            ("util".to_owned(), "../util".to_owned()),
+            ("fixtures".to_owned(), "tests/fixtures".to_owned()),
+        ]);
+    }
+
+    #[test]
+    fn crates_resolve_across_workspace_members() {
+        let files = [
+            ("Cargo.toml", "[workspace]\nmembers = [\"crates/*\"]\n"),
+            ("crates/app/Cargo.toml", "[package]\nname = \"app\"\n\n[dependencies]\ntypes = { package = \"shared-types\", path = \"../shared\" }\n"),
+            ("crates/app/src/main.rs", ""),
+            ("crates/app/src/billing.rs", ""),
+            ("crates/app/src/billing/tax.rs", ""),
+            ("crates/app/src/bin/report.rs", ""),
+            ("crates/app/src/bin/helpers.rs", ""),
+            ("crates/shared/Cargo.toml", "[package]\nname = \"shared-types\"\n"),
+            ("crates/shared/src/lib.rs", ""),
+            ("crates/shared/src/invoice/mod.rs", ""),
+        ];
+        let root = temp_project("cargo_crates", &files);
+        let scanned = files.iter().map(|(f, _)| (*f).to_owned()).filter(|f| f.ends_with(".rs")).collect();
+        let crates = CargoCrates::load(&root, &scanned);
+        let resolve = |from: &str, path: &str| crates.resolve(from, path);
+
+        let main = "crates/app/src/main.rs";
+        let billing = "crates/app/src/billing.rs";
+        assert_eq!(resolve(main, "shared_types::invoice::Invoice"), Some(vec!["crates/shared/src/invoice/mod.rs".to_owned()]));
+        assert_eq!(resolve(main, "types::Invoice"), Some(vec!["crates/shared/src/lib.rs".to_owned()]));
+        assert_eq!(resolve(billing, "crate::billing::tax::rate"), Some(vec!["crates/app/src/billing/tax.rs".to_owned()]));
+        assert_eq!(resolve(billing, "self::tax"), Some(vec!["crates/app/src/billing/tax.rs".to_owned()]));
+        assert_eq!(resolve("crates/app/src/billing/tax.rs", "super::super::Config"), Some(vec![main.to_owned()]));
+        assert_eq!(resolve(main, "billing::Invoice"), Some(vec![billing.to_owned()]));
+        assert_eq!(resolve("crates/app/src/bin/report.rs", "crate::helpers"), Some(vec!["crates/app/src/bin/helpers.rs".to_owned()]));
+        assert_eq!(resolve(main, "crate::missing::Thing"), Some(vec![main.to_owned()]));
+        assert_eq!(resolve(main, "serde::Serialize"), None);
+        std::fs::remove_dir_all(&root).ok();
+    }
+
     // ── Symbol Tests ──
 
     #[test]
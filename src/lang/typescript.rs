@@ -1,7 +1,14 @@
+use std::collections::{BTreeSet, HashMap, HashSet};
 use std::path::Path;
 use super::{LangImports, LangSymbols, SymbolInfo};
 use super::common::{self, CommentTracker};
+use super::lexer::{self, quote, Syntax};
 use crate::alias;
+use crate::external;
+use crate::file_reader;
+use crate::glob;
+use crate::json::{self, JsonValue};
+use crate::path_helper;
 
 pub struct TypeScriptImports;
 
@@ -16,35 +23,284 @@ impl LangImports for TypeScriptImports {
 
         for line in content.lines() {
             let trimmed = line.trim();
+            let paths = [extract_import_path(trimmed), extract_require_path(trimmed)];
 
-            if let Some(path) = extract_import_path(trimmed) {
+            for path in paths.into_iter().flatten() {
                 if path.starts_with("./") || path.starts_with("../") {
                     let resolved = resolve_relative(file_dir, path);
                     imports.extend(resolved);
                 } else if alias::is_potential_alias(path) {
                     imports.push(format!("{}{}", alias::ALIAS_PREFIX, path));
+                } else if is_bare_specifier(path) {
+                    imports.push(format!("{}{}", JS_PREFIX, path));
                 }
             }
+        }
 
-            if let Some(path) = extract_require_path(trimmed) {
-                if path.starts_with("./") || path.starts_with("../") {
-                    let resolved = resolve_relative(file_dir, path);
-                    imports.extend(resolved);
-                } else if alias::is_potential_alias(path) {
-                    imports.push(format!("{}{}", alias::ALIAS_PREFIX, path));
+        imports
+    }
+
+    fn extract_external(&self, content: &str, _file_path: &Path) -> Vec<String> {
+        let mut external = Vec::new();
+
+        for line in content.lines() {
+            let trimmed = line.trim();
+            let paths = [extract_import_path(trimmed), extract_require_path(trimmed)];
+            for path in paths.into_iter().flatten() {
+                if alias::is_potential_alias(path) || !is_bare_specifier(path) || path.starts_with('#') {
+                    continue;
                 }
+                // A tsconfig or vite alias such as `@app/`, or a workspace
+                // package, looks like a package from the registry.
+                let shadow = [format!("{}{}", alias::ALIAS_PREFIX, path), format!("{}{}", JS_PREFIX, path)];
+                external.push(external::marker("npm", npm_package_name(path), &shadow));
             }
         }
 
-        imports
+        external
+    }
+}
+
+/// `lodash/fp` belongs to `lodash`; `@scope/pkg/sub` to `@scope/pkg`.
+fn npm_package_name(specifier: &str) -> &str {
+    let segments = if specifier.starts_with('@') { 2 } else { 1 };
+    match specifier.match_indices('/').nth(segments - 1) {
+        Some((i, _)) => &specifier[..i],
+        None => specifier,
+    }
+}
+
+/// A package name, maybe with a subpath, or a `#` subpath import, as
+/// opposed to a relative path, a URL or a Node built-in.
+fn is_bare_specifier(specifier: &str) -> bool {
+    !specifier.starts_with('.') && !specifier.starts_with('/') && !specifier.contains("://") && !is_node_builtin(specifier)
+}
+
+fn is_node_builtin(specifier: &str) -> bool {
+    const BUILTINS: &[&str] = &[
+        "assert", "async_hooks", "buffer", "child_process", "cluster", "console", "constants",
+        "crypto", "dgram", "diagnostics_channel", "dns", "domain", "events", "fs", "http",
+        "http2", "https", "inspector", "module", "net", "os", "path", "perf_hooks", "process",
+        "punycode", "querystring", "readline", "repl", "stream", "string_decoder", "timers",
+        "tls", "trace_events", "tty", "url", "util", "v8", "vm", "wasi", "worker_threads", "zlib",
+    ];
+    if specifier.starts_with("node:") || specifier.starts_with("bun:") {
+        return true;
+    }
+    BUILTINS.contains(&specifier.split('/').next().unwrap_or(specifier))
+}
+
+/// Raw candidates for bare and `#` specifiers carry this prefix;
+/// `NodePackages` resolves them when a package of the project provides
+/// them.
+pub const JS_PREFIX: &str = "js:";
+
+/// The project's own npm packages. Packages the root `package.json`
+/// `workspaces` or `pnpm-workspace.yaml` lists can be imported by name, or
+/// every package when there is no workspace. A specifier resolves through
+/// the package's `exports`, or through `types`, `module` and `main` when it
+/// has none; `#` specifiers go through the `imports` of the package
+/// holding the importing file.
+#[derive(Default)]
+pub struct NodePackages {
+    /// Deepest first.
+    packages: Vec<Package>,
+    names: HashMap<String, usize>,
+    files: HashSet<String>,
+}
+
+struct Package {
+    dir: String,
+    /// `types`, `typings`, `module` and `main`, in that order.
+    entries: Vec<String>,
+    exports: Option<JsonValue>,
+    imports: Option<JsonValue>,
+}
+
+impl NodePackages {
+    pub fn load(root: &Path, files: &HashSet<String>) -> Self {
+        let extensions = <TypeScriptImports as LangImports>::extensions(&TypeScriptImports);
+        let files: HashSet<String> = files
+            .iter()
+            .filter(|f| f.rsplit_once('.').map_or(false, |(_, ext)| extensions.contains(&ext)))
+            .cloned()
+            .collect();
+        if files.is_empty() {
+            return Self::default();
+        }
+        let mut dirs: BTreeSet<&str> = BTreeSet::new();
+        for file in &files {
+            let mut dir = path_helper::parent_dir(file);
+            while dirs.insert(dir) && !dir.is_empty() {
+                dir = path_helper::parent_dir(dir);
+            }
+        }
+
+        let mut manifests: Vec<(&str, JsonValue)> = dirs
+            .into_iter()
+            .filter_map(|dir| {
+                let text = file_reader::read_file(&root.join(dir).join("package.json")).ok().flatten()?;
+                Some((dir, json::parse(&text).ok()?))
+            })
+            .collect();
+        manifests.sort_by(|a, b| b.0.len().cmp(&a.0.len()).then_with(|| a.0.cmp(b.0)));
+
+        let workspaces = workspace_patterns(root);
+        let mut packages = Vec::with_capacity(manifests.len());
+        let mut names = HashMap::new();
+        for (dir, manifest) in manifests {
+            let member = workspaces.is_empty() || dir.is_empty() || workspaces.iter().any(|p| glob::matches_path(dir, p));
+            if let Some(name) = manifest.get("name").and_then(JsonValue::as_str).filter(|_| member) {
+                names.insert(name.to_owned(), packages.len());
+            }
+            let entries = ["types", "typings", "module", "main"]
+                .iter()
+                .filter_map(|field| manifest.get(field).and_then(JsonValue::as_str).map(str::to_owned))
+                .collect();
+            packages.push(Package {
+                dir: dir.to_owned(),
+                entries,
+                exports: manifest.get("exports").cloned(),
+                imports: manifest.get("imports").cloned(),
+            });
+        }
+        Self { packages, names, files }
+    }
+
+    /// The files a `js:` specifier of `from` names, or `None` when no
+    /// package of the project provides it. A package that does not export
+    /// the subpath still provides it, with no files.
+    pub fn resolve(&self, from: &str, specifier: &str) -> Option<Vec<String>> {
+        if specifier.starts_with('#') {
+            let package = self.packages.iter().find(|p| p.dir.is_empty() || from.starts_with(&format!("{}/", p.dir)))?;
+            let targets = subpath_targets(package.imports.as_ref()?, specifier)?;
+            return Some(self.existing(&package.dir, &targets));
+        }
+        let name = npm_package_name(specifier);
+        let package = &self.packages[*self.names.get(name)?];
+        let subpath = format!(".{}", &specifier[name.len()..]);
+        let targets = match &package.exports {
+            Some(exports) => subpath_targets(exports, &subpath).unwrap_or_default(),
+            None if subpath == "." && !package.entries.is_empty() => package.entries.clone(),
+            None => vec![subpath],
+        };
+        Some(self.existing(&package.dir, &targets))
+    }
+
+    fn existing(&self, dir: &str, targets: &[String]) -> Vec<String> {
+        let mut found: Vec<String> = Vec::new();
+        for path in targets.iter().filter_map(|t| common::join_dir(dir, t)) {
+            if let Some(file) = module_candidates(&path).into_iter().find(|c| self.files.contains(c)) {
+                if !found.contains(&file) {
+                    found.push(file);
+                }
+            }
+        }
+        found
+    }
+}
+
+/// The targets an `exports` or `imports` map gives `subpath`: an exact key,
+/// else the `*` pattern or legacy `/` folder key with the longest match.
+/// A plain string, array or conditions object stands for the `.` subpath.
+fn subpath_targets(map: &JsonValue, subpath: &str) -> Option<Vec<String>> {
+    let fields = match map.as_object() {
+        Some(fields) if fields.iter().any(|(k, _)| k.starts_with('.') || k.starts_with('#')) => fields,
+        _ => return (subpath == ".").then(|| condition_targets(map)),
+    };
+    if let Some((_, value)) = fields.iter().find(|(k, _)| k == subpath) {
+        return Some(condition_targets(value));
+    }
+
+    let mut best: Option<(usize, Vec<String>)> = None;
+    for (key, value) in fields {
+        let targets: Option<Vec<String>> = match key.split_once('*') {
+            Some((prefix, suffix)) => subpath
+                .strip_prefix(prefix)
+                .and_then(|rest| rest.strip_suffix(suffix))
+                .map(|star| condition_targets(value).iter().map(|t| t.replace('*', star)).collect()),
+            None if key.ends_with('/') => subpath
+                .strip_prefix(key.as_str())
+                .map(|rest| condition_targets(value).iter().map(|t| format!("{}{}", t, rest)).collect()),
+            None => None,
+        };
+        if let Some(targets) = targets {
+            if best.as_ref().map_or(true, |(len, _)| key.len() > *len) {
+                best = Some((key.len(), targets));
+            }
+        }
+    }
+    best.map(|(_, targets)| targets)
+}
+
+/// Every relative target under a value, whatever the condition (`types`,
+/// `import`, `require`, `default`, ...).
+fn condition_targets(value: &JsonValue) -> Vec<String> {
+    match value {
+        JsonValue::String(s) if s.starts_with("./") => vec![s.clone()],
+        JsonValue::Array(items) => items.iter().flat_map(condition_targets).collect(),
+        JsonValue::Object(fields) => fields.iter().flat_map(|(_, v)| condition_targets(v)).collect(),
+        _ => Vec::new(),
+    }
+}
+
+/// A package target often names build output or leaves out the extension;
+/// `dist/button.js` is also looked up as `src/button.ts` and friends.
+fn module_candidates(path: &str) -> Vec<String> {
+    let mut candidates = Vec::new();
+    for base in path_helper::source_candidates(path) {
+        let has_extension = base.rsplit('/').next().map_or(false, |name| name.contains('.'));
+        candidates.push(base.clone());
+        if !has_extension {
+            candidates.extend(resolve_relative(Path::new(""), &base));
+        }
+    }
+    candidates
+}
+
+/// Directory globs of the workspace members, from the root `package.json`
+/// (`workspaces` as an array or under `packages`) or `pnpm-workspace.yaml`.
+fn workspace_patterns(root: &Path) -> Vec<String> {
+    let mut patterns: Vec<String> = Vec::new();
+    if let Some(manifest) = file_reader::read_file(&root.join("package.json")).ok().flatten().and_then(|t| json::parse(&t).ok()) {
+        let workspaces = manifest.get("workspaces");
+        let list = workspaces.and_then(|w| w.as_array().or_else(|| w.get("packages").and_then(JsonValue::as_array)));
+        patterns.extend(list.unwrap_or_default().iter().filter_map(JsonValue::as_str).map(str::to_owned));
+    }
+    if let Some(text) = file_reader::read_file(&root.join("pnpm-workspace.yaml")).ok().flatten() {
+        let mut in_packages = false;
+        for line in text.lines() {
+            let trimmed = line.trim();
+            if !line.starts_with(' ') && !line.starts_with('-') && !trimmed.is_empty() {
+                in_packages = trimmed == "packages:";
+            } else if let Some(item) = trimmed.strip_prefix('-').filter(|_| in_packages) {
+                patterns.push(item.trim().trim_matches(|c| c == '"' || c == '\'').to_owned());
+            }
+        }
     }
+    patterns
+        .into_iter()
+        .filter(|p| !p.starts_with('!'))
+        .map(|p| p.trim_start_matches("./").trim_end_matches('/').to_owned())
+        .collect()
 }
 
+const SYNTAX: Syntax = Syntax {
+    quotes: &[quote("\"", true, false), quote("'", true, false), quote("`", true, true).interpolated("${", b"")],
+    char_literals: false,
+    imports: &["import ", "export {", "export *", "export type {"],
+    ..lexer::C_LIKE
+};
+
 impl LangSymbols for TypeScriptImports {
     fn extensions(&self) -> &[&str] {
         &["ts", "tsx", "js", "jsx", "mjs", "mts"]
     }
 
+    fn syntax(&self) -> &'static Syntax {
+        &SYNTAX
+    }
+
     fn extract_symbols(&self, content: &str) -> Vec<SymbolInfo> {
         let all_lines: Vec<&str> = content.lines().collect();
         let mut symbols = Vec::new();
@@ -401,6 +657,7 @@ fn normalize(path: &Path) -> String {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::test_support::temp_project;
 
     fn extract_imports(content: &str, file_path: &str) -> Vec<String> {
         TypeScriptImports.extract_imports(content, Path::new(file_path))
@@ -428,10 +685,10 @@ mod tests {
     }
 
     #[test]
-    fn import_npm_package_ignored() {
+    fn import_npm_package_is_left_to_workspace_packages() {
         let content = "import React from 'react';";
         let imports = extract_imports(content, "src/index.ts");
-        assert!(imports.is_empty());
+        assert_eq!(imports, vec!["js:react"]);
     }
 
     #[test]
@@ -442,10 +699,10 @@ mod tests {
     }
 
     #[test]
-    fn require_npm_package_ignored() {
-        let content = "const express = require('express');";
+    fn require_npm_package_is_left_to_workspace_packages() {
+        let content = "const express = require('express');\nconst fs = require('node:fs');";
         let imports = extract_imports(content, "src/index.ts");
-        assert!(imports.is_empty());
+        assert_eq!(imports, vec!["js:express"]);
     }
 
     #[test]
@@ -476,6 +733,82 @@ mod tests {
         assert!(has_index);
     }
 
+    // ── External Tests ──
+
+    #[test]
+    fn external_packages_are_named_by_package() {
+        let content = "import React from 'react';\nimport fp from 'lodash/fp';\nimport { x } from '@scope/pkg/sub';\nconst fs = require('fs');\nimport { y } from 'node:path';\nimport { z } from './local';\nimport { w } from '@/util';\nimport { t } from '#internal/theme';";
+        let external = TypeScriptImports.extract_external(content, Path::new("src/index.ts"));
+        assert_eq!(external, vec![
+            "external:npm:react|alias:react|js:react",
+            "external:npm:lodash|alias:lodash/fp|js:lodash/fp",
+            "external:npm:@scope/pkg|alias:@scope/pkg/sub|js:@scope/pkg/sub",
+        ]);
+    }
+
+    // ── Workspace packages ──
+
+    #[test]
+    fn exports_subpaths_and_conditions() {
+        let exports = json::parse(r#"{
+            ".": { "types": "./dist/index.d.ts", "import": "./dist/index.mjs" },
+            "./button": "./src/button.tsx",
+            "./icons/*": { "default": "./src/icons/*.tsx" },
+            "./icons/internal/*": null,
+            "./legacy/": "./lib/"
+        }"#).unwrap();
+        assert_eq!(subpath_targets(&exports, "."), Some(vec!["./dist/index.d.ts".to_owned(), "./dist/index.mjs".to_owned()]));
+        assert_eq!(subpath_targets(&exports, "./button"), Some(vec!["./src/button.tsx".to_owned()]));
+        assert_eq!(subpath_targets(&exports, "./icons/star"), Some(vec!["./src/icons/star.tsx".to_owned()]));
+        assert_eq!(subpath_targets(&exports, "./icons/internal/x"), Some(vec![]));
+        assert_eq!(subpath_targets(&exports, "./legacy/old.js"), Some(vec!["./lib/old.js".to_owned()]));
+        assert_eq!(subpath_targets(&exports, "./missing"), None);
+
+        let sugar = json::parse(r#"{ "require": "./index.cjs", "import": "./index.mjs" }"#).unwrap();
+        assert_eq!(subpath_targets(&sugar, ".").map(|t| t.len()), Some(2));
+        assert_eq!(subpath_targets(&sugar, "./x"), None);
+    }
+
+    #[test]
+    fn workspace_members_from_package_json_and_pnpm() {
+        let root = temp_project("ts_workspaces", &[
+            ("package.json", r#"{ "workspaces": { "packages": ["packages/*", "!packages/ignored"] } }"#),
+            ("pnpm-workspace.yaml", "packages:\n  - 'apps/*'\n  - \"tools/cli/\"\ncatalog:\n  - react\n"),
+        ]);
+        assert_eq!(workspace_patterns(&root), vec!["packages/*", "apps/*", "tools/cli"]);
+        std::fs::remove_dir_all(&root).ok();
+    }
+
+    #[test]
+    fn node_packages_resolve_names_exports_and_imports() {
+        let root = temp_project("ts_packages", &[
+            ("package.json", r#"{ "name": "root", "workspaces": ["packages/*"] }"#),
+            ("packages/ui/package.json", r##"{ "name": "@acme/ui", "exports": { ".": "./dist/index.js", "./*": "./src/*.tsx" }, "imports": { "#internal/*": "./src/internal/*.ts" } }"##),
+            ("packages/utils/package.json", r#"{ "name": "utils", "main": "lib/main.js" }"#),
+            ("examples/demo/package.json", r#"{ "name": "demo" }"#),
+        ]);
+        let files: HashSet<String> = [
+            "packages/ui/src/index.ts",
+            "packages/ui/src/button.tsx",
+            "packages/ui/src/internal/theme.ts",
+            "packages/utils/src/main.ts",
+            "packages/utils/src/format.ts",
+            "examples/demo/index.ts",
+            "apps/web/page.tsx",
+        ].iter().map(|f| (*f).to_owned()).collect();
+        let packages = NodePackages::load(&root, &files);
+        let from = "apps/web/page.tsx";
+        assert_eq!(packages.resolve(from, "@acme/ui"), Some(vec!["packages/ui/src/index.ts".to_owned()]));
+        assert_eq!(packages.resolve(from, "@acme/ui/button"), Some(vec!["packages/ui/src/button.tsx".to_owned()]));
+        assert_eq!(packages.resolve(from, "utils"), Some(vec!["packages/utils/src/main.ts".to_owned()]));
+        assert_eq!(packages.resolve(from, "utils/src/format"), Some(vec!["packages/utils/src/format.ts".to_owned()]));
+        assert_eq!(packages.resolve("packages/ui/src/button.tsx", "#internal/theme"), Some(vec!["packages/ui/src/internal/theme.ts".to_owned()]));
+        assert_eq!(packages.resolve(from, "#internal/theme"), None);
+        assert_eq!(packages.resolve(from, "demo"), None);
+        assert_eq!(packages.resolve(from, "react"), None);
+        std::fs::remove_dir_all(&root).ok();
+    }
+
     // ── Symbol Tests ──
 
     #[test]
@@ -723,8 +1056,8 @@ import type { User } from './types';
         assert!(imports.iter().any(|i| i.contains("services")));
         assert!(imports.iter().any(|i| i.contains("logger")));
         assert!(imports.iter().any(|i| i.contains("types")));
-        // npm 'express' is not relative, so ignored
-        assert!(!imports.iter().any(|i| i.contains("express")));
+        // npm 'express' is not relative, so only a workspace package could provide it
+        assert!(!imports.iter().any(|i| i.contains("express") && !i.starts_with(JS_PREFIX)));
     }
 
     // ── Deep: getter/setter class members ──
@@ -1038,7 +1371,7 @@ export default UserProfile;
         let imports = extract_imports(content, "src/components/UserProfile.tsx");
         assert!(imports.iter().any(|i| i.contains("Button")));
         assert!(imports.iter().any(|i| i.contains("types")));
-        assert!(!imports.iter().any(|i| i.contains("react")));
+        assert!(!imports.iter().any(|i| i.contains("react") && !i.starts_with(JS_PREFIX)));
     }
 
     // ── Deep: nested require ──
@@ -10,6 +10,7 @@ use crate::lang::common;
 use crate::models::FileEntry;
 use crate::path_helper;
 use crate::searcher;
+use crate::symbols;
 
 #[derive(Debug)]
 pub struct LineSpec {
@@ -72,9 +73,7 @@ pub fn expand_line_specs(
 
             let symbols = handler.extract_symbols_with_tests(&content, true);
 
-            let enclosing = symbols.iter().find(|sym| {
-                spec.start >= sym.line && spec.start <= sym.end_line
-            });
+            let enclosing = symbols::enclosing_symbols(&symbols, spec.start).next().map(|(_, sym)| sym);
 
             match enclosing {
                 Some(sym) => {
@@ -1,28 +1,42 @@
 mod alias;
+mod budget;
 mod callers;
 mod cli;
 mod count;
+mod diagram;
 mod exclusion;
+mod external;
 mod file_reader;
 mod glob;
 mod graph;
+mod index;
+mod json;
 mod lang;
 mod lines;
+mod mcp;
 mod models;
+mod orphans;
+mod ndjson;
 mod path_helper;
+mod query;
+mod rollup;
+mod rules;
 mod scanner;
 mod searcher;
+mod server;
 mod stats;
 mod symbols;
+#[cfg(test)]
+mod test_support;
+mod watch;
 mod yaml_output;
 
+use std::io::{self, BufWriter, Write};
 use std::path::Path;
-use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::atomic::AtomicBool;
 use std::sync::Arc;
-use std::time::Instant;
 
-use models::{FileEntry, MetaInfo, OutputEnvelope, OutputPayload};
-use searcher::Matcher;
+use models::OutputEnvelope;
 use yaml_output::OutputFormat;
 
 fn main() {
@@ -50,17 +64,42 @@ fn run() -> i32 {
             println!("0.1.0");
             0
         }
+        cli::CliAction::Run(args) if args.watch => execute_watch(args),
         cli::CliAction::Run(args) => execute(args),
+        cli::CliAction::Index(command, args) => execute_index(command, args),
+        cli::CliAction::Serve(args) => server::serve_stdio(&args),
+        cli::CliAction::Mcp(args) => mcp::serve_mcp(&args),
+        cli::CliAction::CheckDeps(args) => execute_check_deps(args),
     }
 }
 
+/// Format for whole-envelope output. NDJSON callers that do not stream
+/// (index commands) get the envelope as a single JSON line.
 fn resolve_format(args: &cli::CliArgs) -> OutputFormat {
+    let diagram = diagram::DiagramOptions { collapse_depth: args.collapse_depth, edge_labels: args.edge_labels };
     match args.format {
-        cli::OutputFormatArg::Json => OutputFormat::Json,
+        cli::OutputFormatArg::Json | cli::OutputFormatArg::Ndjson => OutputFormat::Json,
         cli::OutputFormatArg::Yaml => OutputFormat::Yaml,
+        cli::OutputFormatArg::Dot => OutputFormat::Dot(diagram),
+        cli::OutputFormatArg::Mermaid => OutputFormat::Mermaid(diagram),
     }
 }
 
+/// The `--output` file, created once, or stdout. Streaming and watch runs
+/// keep writing to it for as long as they last.
+fn open_output(args: &cli::CliArgs) -> Result<Box<dyn Write + Send>, String> {
+    match args.output {
+        Some(ref path) => std::fs::File::create(path)
+            .map(|file| Box::new(BufWriter::new(file)) as Box<dyn Write + Send>)
+            .map_err(|e| format!("Failed to write output to {}: {}", path, e)),
+        None => Ok(Box::new(io::stdout())),
+    }
+}
+
+fn open_sink(args: &cli::CliArgs) -> Result<ndjson::NdjsonSink, String> {
+    open_output(args).map(|out| ndjson::NdjsonSink::new(out, args.limit, args.compact))
+}
+
 fn emit(envelope: &OutputEnvelope, format: OutputFormat, output_path: &Option<String>) {
     if let Some(ref path) = output_path {
         if let Err(e) = yaml_output::write_output_to(envelope, format, path) {
@@ -71,411 +110,119 @@ fn emit(envelope: &OutputEnvelope, format: OutputFormat, output_path: &Option<St
     }
 }
 
-fn make_meta(
-    elapsed: u128,
-    timed_out: bool,
-    scanned: usize,
-    matched: usize,
-    total: Option<usize>,
-) -> MetaInfo {
-    MetaInfo {
-        elapsed_ms: elapsed,
-        timeout: timed_out,
-        files_scanned: scanned,
-        files_matched: matched,
-        files_errored: 0,
-        total_matches: total,
-    }
-}
-
-fn apply_limit<T>(items: Vec<T>, limit: Option<usize>) -> Vec<T> {
-    match limit {
-        Some(n) if n < items.len() => items.into_iter().take(n).collect(),
-        _ => items,
-    }
-}
-
-fn collect_file_errors(entries: &[FileEntry]) -> Vec<String> {
-    entries
-        .iter()
-        .filter_map(|e| e.error.as_ref().map(|err| format!("{}: {}", e.path, err)))
-        .collect()
-}
+fn start_cancellation(args: &cli::CliArgs) -> Arc<AtomicBool> {
+    let cancelled = Arc::new(AtomicBool::new(false));
 
-fn error_envelope(msg: String) -> OutputEnvelope {
-    OutputEnvelope {
-        error: Some(msg),
-        ..Default::default()
+    {
+        let cancelled = cancelled.clone();
+        ctrlc_handler(cancelled);
     }
-}
-
-fn finish(
-    meta: MetaInfo,
-    payload: OutputPayload,
-    errors: Vec<String>,
-    timed_out: bool,
-    args: &cli::CliArgs,
-    format: OutputFormat,
-) -> i32 {
-    let envelope = OutputEnvelope {
-        meta: Some(meta),
-        payload,
-        errors: if errors.is_empty() { None } else { Some(errors) },
-        error: if timed_out {
-            Some("Operation timed out — partial results may be incomplete".into())
-        } else {
-            None
-        },
-    };
-    emit(&envelope, format, &args.output);
-    if timed_out { 2 } else { 0 }
-}
 
-fn resolve_globs(args: &cli::CliArgs) -> Vec<String> {
-    if args.globs.is_empty() { vec!["*.*".to_owned()] } else { args.globs.clone() }
+    query::arm_timeout(&cancelled, args.timeout);
+    cancelled
 }
 
-fn find_or_bail(
-    args: &cli::CliArgs,
-    root: &Path,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> Result<(Vec<String>, usize), i32> {
-    let globs = resolve_globs(args);
-    let files = scanner::find_files_filtered(root, &globs, filter, cancelled, args.with_tests);
-    let scanned = files.len();
-    if cancelled.load(Ordering::Relaxed) {
-        let elapsed = start.elapsed().as_millis();
-        let code = finish(make_meta(elapsed, true, scanned, 0, None), OutputPayload::None, vec![], true, args, format);
-        return Err(code);
+fn execute(args: cli::CliArgs) -> i32 {
+    let format = resolve_format(&args);
+    let cancelled = start_cancellation(&args);
+    if args.format == cli::OutputFormatArg::Ndjson {
+        return match open_sink(&args) {
+            Ok(sink) => query::execute_streaming(&args, &cancelled, None, &sink).code,
+            Err(e) => {
+                eprintln!("{}", e);
+                1
+            }
+        };
     }
-    Ok((files, scanned))
+    let outcome = query::execute(&args, &cancelled, None);
+    emit(&outcome.envelope, format, &args.output);
+    outcome.code
 }
 
-fn execute(args: cli::CliArgs) -> i32 {
-    let root = Path::new(&args.root);
+/// Runs the query, then re-runs it after every settled change below the root
+/// until interrupted. YAML results are separated by `---`; JSON results are
+/// one envelope per line; NDJSON runs each end with their meta record. All
+/// runs go to the same output, stdout or the `--output` file.
+fn execute_watch(args: cli::CliArgs) -> i32 {
     let format = resolve_format(&args);
-
+    let root = Path::new(&args.root);
     if !root.is_dir() {
-        emit(&error_envelope(format!("Directory not found: {}", args.root)), format, &args.output);
+        emit(&query::Outcome::error(format!("Directory not found: {}", args.root)).envelope, format, &args.output);
         return 1;
     }
-
-    let cancelled = Arc::new(AtomicBool::new(false));
-
-    {
-        let cancelled = cancelled.clone();
-        ctrlc_handler(cancelled);
-    }
-
-    if let Some(secs) = args.timeout {
-        let cancelled = cancelled.clone();
-        std::thread::spawn(move || {
-            std::thread::sleep(std::time::Duration::from_secs(secs));
-            cancelled.store(true, Ordering::Relaxed);
-        });
-    }
-
-    let filter = exclusion::ExclusionFilter::new(&args.excludes, args.no_defaults);
-    let start = Instant::now();
-
-    if !args.lines.is_empty() {
-        execute_lines(&args, root, &cancelled, start, format)
-    } else if args.graph {
-        execute_graph(&args, root, &filter, &cancelled, start, format)
-    } else if args.callers.is_some() {
-        execute_callers(&args, root, &filter, &cancelled, start, format)
-    } else if args.symbols {
-        execute_symbols(&args, root, &filter, &cancelled, start, format)
-    } else if args.stats {
-        execute_stats(&args, root, &filter, &cancelled, start, format)
-    } else if args.count && args.find.is_some() {
-        execute_count(&args, root, &filter, &cancelled, start, format)
-    } else if let Some(ref find_pattern) = args.find {
-        execute_search(&args, root, find_pattern, &filter, &cancelled, start, format)
-    } else if !args.globs.is_empty() {
-        execute_file_listing(&args, root, &filter, &cancelled, start, format)
-    } else {
-        execute_directory_hierarchy(&args, root, &filter, &cancelled, start, format)
-    }
-}
-
-fn execute_directory_hierarchy(
-    args: &cli::CliArgs,
-    root: &Path,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let tree = scanner::scan_directories(root, filter, cancelled, args.with_tests);
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
-    finish(make_meta(elapsed, timed_out, 0, 0, None), OutputPayload::Tree(tree), vec![], timed_out, args, format)
-}
-
-fn execute_file_listing(
-    args: &cli::CliArgs,
-    root: &Path,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let files = scanner::find_files_filtered(root, &args.globs, filter, cancelled, args.with_tests);
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
-
-    let entries: Vec<FileEntry> = files
-        .iter()
-        .map(|f| FileEntry {
-            path: path_helper::normalized_relative(root, Path::new(f)),
-            contents: None,
-            error: None,
-            chunks: None,
-        })
-        .collect();
-
-    let total = entries.len();
-    let entries = apply_limit(entries, args.limit);
-    let matched = entries.len();
-
-    finish(make_meta(elapsed, timed_out, total, matched, None), OutputPayload::Files(entries), vec![], timed_out, args, format)
-}
-
-fn execute_search(
-    args: &cli::CliArgs,
-    root: &Path,
-    pattern: &str,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let matcher = match Matcher::build(pattern, args.is_regex) {
-        Ok(m) => m,
+    let mut out = match open_output(&args) {
+        Ok(out) => out,
         Err(e) => {
-            emit(&error_envelope(e), format, &args.output);
+            eprintln!("{}", e);
             return 1;
         }
     };
 
-    let (candidate_files, scanned) = match find_or_bail(args, root, filter, cancelled, start, format) {
-        Ok(v) => v,
-        Err(code) => return code,
-    };
-
-    let entries = searcher::search_files(&candidate_files, root, &matcher, args.line_numbers, args.context, cancelled);
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
-
-    let file_errors = collect_file_errors(&entries);
-    let errored = file_errors.len();
-    let entries = apply_limit(entries, args.limit);
-    let matched = entries.len();
-
-    let mut meta = make_meta(elapsed, timed_out, scanned, matched, None);
-    meta.files_errored = errored;
-    finish(meta, OutputPayload::Files(entries), file_errors, timed_out, args, format)
-}
-
-fn execute_lines(
-    args: &cli::CliArgs,
-    root: &Path,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let specs = match lines::parse_line_specs(&args.lines, root) {
-        Ok(s) => s,
-        Err(e) => {
-            emit(&error_envelope(e), format, &args.output);
-            return 1;
+    if args.format == cli::OutputFormatArg::Ndjson {
+        let sink = ndjson::NdjsonSink::new(out, args.limit, args.compact);
+        return watch_loop(&args, root, |cancelled, session| {
+            sink.restart();
+            query::execute_streaming(&args, cancelled, Some(session), &sink);
+        });
+    }
+    let mut first = true;
+    watch_loop(&args, root, |cancelled, session| {
+        let outcome = query::execute(&args, cancelled, Some(session));
+        let mut written = Ok(());
+        if !first && format == OutputFormat::Yaml {
+            written = writeln!(out, "---");
         }
-    };
-
-    let specs = if args.auto_expand {
-        lines::expand_line_specs(&specs, root, args.with_comments)
-    } else {
-        specs
-    };
-
-    let entries = lines::extract_lines(&specs, root, args.line_numbers, cancelled);
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
-
-    let file_errors = collect_file_errors(&entries);
-    let errored = file_errors.len();
-    let entries = apply_limit(entries, args.limit);
-    let matched = entries.len();
-
-    let mut meta = make_meta(elapsed, timed_out, 0, matched, None);
-    meta.files_errored = errored;
-    finish(meta, OutputPayload::Files(entries), file_errors, timed_out, args, format)
-}
-
-fn execute_graph(
-    args: &cli::CliArgs,
-    root: &Path,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start, format) {
-        Ok(v) => v,
-        Err(code) => return code,
-    };
-
-    let aliases = alias::load_aliases(root);
-    let graph_entries = graph::build_graph(&files, root, cancelled, &aliases);
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
-
-    let graph_entries = apply_limit(graph_entries, args.limit);
-    let matched = graph_entries.len();
-
-    finish(make_meta(elapsed, timed_out, scanned, matched, None), OutputPayload::Graph(graph_entries), vec![], timed_out, args, format)
-}
-
-fn execute_callers(
-    args: &cli::CliArgs,
-    root: &Path,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let name = args.callers.as_ref().unwrap();
-    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start, format) {
-        Ok(v) => v,
-        Err(code) => return code,
-    };
-
-    let callers_output = match callers::find_callers(&files, root, name, args.is_regex, args.with_tests, cancelled) {
-        Ok(c) => c,
-        Err(e) => {
-            emit(&error_envelope(e), format, &args.output);
-            return 1;
+        first = false;
+        if let Err(e) = written.and_then(|_| yaml_output::write_output_into(&mut out, &outcome.envelope, format)) {
+            eprintln!("Failed to write output: {}", e);
         }
-    };
-
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
+    })
+}
 
-    let total_refs: usize = callers_output.files.iter().map(|f| f.sites.len()).sum();
-    let callers_output = if let Some(limit) = args.limit {
-        models::CallersOutput {
-            declarations: callers_output.declarations,
-            files: callers_output.files.into_iter().take(limit).collect(),
+fn watch_loop(args: &cli::CliArgs, root: &Path, mut run: impl FnMut(&AtomicBool, &mut query::Session)) -> i32 {
+    let stop = Arc::new(AtomicBool::new(false));
+    ctrlc_handler(stop.clone());
+    file_reader::enable_cache(server::CONTENT_CACHE_BYTES);
+
+    // Writing the results must not count as a change.
+    let mut excludes = args.excludes.clone();
+    excludes.extend(args.output.as_deref().and_then(|output| anchored_below(root, output)));
+    let filter = exclusion::ExclusionFilter::new(&excludes, args.no_defaults)
+        .with_ignore_files(!args.no_ignore);
+    let mut watcher = watch::Watcher::new(root, filter);
+    let mut session = query::Session::default();
+
+    loop {
+        let cancelled = Arc::new(AtomicBool::new(false));
+        query::arm_timeout(&cancelled, args.timeout);
+        watch::run_until_stopped(&stop, &cancelled, || run(&cancelled, &mut session));
+        if !watcher.wait(&stop) {
+            return 0;
         }
-    } else {
-        callers_output
-    };
-    let matched = callers_output.files.len();
-
-    finish(make_meta(elapsed, timed_out, scanned, matched, Some(total_refs)), OutputPayload::Callers(callers_output), vec![], timed_out, args, format)
+    }
 }
 
-fn execute_symbols(
-    args: &cli::CliArgs,
-    root: &Path,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start, format) {
-        Ok(v) => v,
-        Err(code) => return code,
-    };
-
-    let symbol_files = symbols::extract_symbols(&files, root, cancelled, args.with_comments, args.with_tests);
-
-    let (symbol_files, total_matches) = if let Some(ref find_pattern) = args.find {
-        let matcher = match Matcher::build(find_pattern, args.is_regex) {
-            Ok(m) => m,
-            Err(e) => {
-                emit(&error_envelope(e), format, &args.output);
-                return 1;
-            }
-        };
-        symbols::filter_symbols(symbol_files, &matcher)
-    } else {
-        let count = symbol_files.iter().map(|sf| sf.symbols.len()).sum();
-        (symbol_files, count)
-    };
-
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
-
-    let sym_errors: Vec<String> = symbol_files
-        .iter()
-        .filter_map(|sf| sf.error.as_ref().map(|err| format!("{}: {}", sf.path, err)))
-        .collect();
-    let errored = sym_errors.len();
-    let symbol_files = apply_limit(symbol_files, args.limit);
-    let matched = symbol_files.len();
-
-    let total = if args.find.is_some() { Some(total_matches) } else { None };
-    let mut meta = make_meta(elapsed, timed_out, scanned, matched, total);
-    meta.files_errored = errored;
-
-    finish(meta, OutputPayload::Symbols { files: symbol_files, compact: args.compact }, sym_errors, timed_out, args, format)
+/// `path` as a pattern anchored at `root`, if it lies below it.
+fn anchored_below(root: &Path, path: &str) -> Option<String> {
+    let root = root.canonicalize().ok()?;
+    let path = Path::new(path).canonicalize().ok()?;
+    path.strip_prefix(&root).ok().map(|rel| format!("/{}", rel.to_string_lossy().replace('\\', "/")))
 }
 
-fn execute_stats(
-    args: &cli::CliArgs,
-    root: &Path,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start, format) {
-        Ok(v) => v,
-        Err(code) => return code,
-    };
-
-    let stats_output = stats::compute_stats(&files, root, cancelled);
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
-
-    finish(make_meta(elapsed, timed_out, scanned, scanned, None), OutputPayload::Stats(stats_output), vec![], timed_out, args, format)
+fn execute_index(command: cli::IndexCommand, args: cli::CliArgs) -> i32 {
+    let format = resolve_format(&args);
+    let cancelled = start_cancellation(&args);
+    let outcome = query::execute_index(command, &args, &cancelled);
+    emit(&outcome.envelope, format, &args.output);
+    outcome.code
 }
 
-fn execute_count(
-    args: &cli::CliArgs,
-    root: &Path,
-    filter: &exclusion::ExclusionFilter,
-    cancelled: &AtomicBool,
-    start: Instant,
-    format: OutputFormat,
-) -> i32 {
-    let pattern = args.find.as_ref().unwrap();
-    let matcher = match Matcher::build(pattern, args.is_regex) {
-        Ok(m) => m,
-        Err(e) => {
-            emit(&error_envelope(e), format, &args.output);
-            return 1;
-        }
-    };
-
-    let (candidate_files, scanned) = match find_or_bail(args, root, filter, cancelled, start, format) {
-        Ok(v) => v,
-        Err(code) => return code,
-    };
-
-    let (count_entries, total) = count::count_matches(&candidate_files, root, &matcher, cancelled);
-    let elapsed = start.elapsed().as_millis();
-    let timed_out = cancelled.load(Ordering::Relaxed);
-
-    let count_entries = apply_limit(count_entries, args.limit);
-    let matched = count_entries.len();
-
-    finish(make_meta(elapsed, timed_out, scanned, matched, Some(total)), OutputPayload::Counts(count_entries), vec![], timed_out, args, format)
+fn execute_check_deps(args: cli::CliArgs) -> i32 {
+    let format = resolve_format(&args);
+    let cancelled = start_cancellation(&args);
+    let outcome = query::execute_check_deps(&args, &cancelled);
+    emit(&outcome.envelope, format, &args.output);
+    outcome.code
 }
 
 #[cfg(unix)]
@@ -492,6 +239,7 @@ fn ctrlc_handler(cancelled: Arc<AtomicBool>) {
 
 #[cfg(windows)]
 fn ctrlc_handler(cancelled: Arc<AtomicBool>) {
+    use std::sync::atomic::Ordering;
     use std::sync::OnceLock;
     static CANCELLED: OnceLock<Arc<AtomicBool>> = OnceLock::new();
     let _ = CANCELLED.set(Arc::clone(&cancelled));
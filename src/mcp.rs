@@ -0,0 +1,499 @@
+use std::path::{Component, Path};
+use std::sync::atomic::AtomicBool;
+use std::sync::Arc;
+
+use crate::cli::{self, OptionKind};
+use crate::file_reader;
+use crate::json::JsonValue;
+use crate::lines;
+use crate::query::{self, Session};
+use crate::server::{self, INVALID_PARAMS, METHOD_NOT_FOUND};
+use crate::yaml_output;
+
+const SERVER_NAME: &str = "src";
+const SERVER_VERSION: &str = env!("CARGO_PKG_VERSION");
+
+/// Protocol revisions we can speak, newest first.
+const PROTOCOL_VERSIONS: &[&str] = &["2025-06-18", "2025-03-26", "2024-11-05"];
+
+/// A tool-specific parameter that is not one of `cli::QUERY_OPTIONS`.
+struct Param {
+    name: &'static str,
+    kind: OptionKind,
+    description: &'static str,
+    required: bool,
+}
+
+struct Tool {
+    name: &'static str,
+    /// The `src serve` method the tool runs.
+    method: &'static str,
+    description: &'static str,
+    params: &'static [Param],
+    /// Names from `cli::QUERY_OPTIONS` the tool accepts.
+    options: &'static [&'static str],
+}
+
+const SCAN_OPTIONS: &[&str] = &["dir", "glob", "exclude", "with-tests", "no-defaults", "no-ignore", "timeout"];
+
+const TOOLS: &[Tool] = &[
+    Tool {
+        name: "tree",
+        method: "tree",
+        description: "Directory hierarchy of the folders that contain source files.",
+        params: &[],
+        options: &["dir", "exclude", "with-tests", "no-defaults", "no-ignore", "timeout"],
+    },
+    Tool {
+        name: "find",
+        method: "search",
+        description: "Search file contents. Returns whole files, or match windows when context is set.",
+        params: &[Param { name: "pattern", kind: OptionKind::Text, description: "Search pattern; | separates literal alternatives", required: true }],
+        options: &["dir", "glob", "exclude", "with-tests", "no-defaults", "no-ignore", "timeout", "regex", "context", "limit", "line-numbers", "max-tokens"],
+    },
+    Tool {
+        name: "lines",
+        method: "lines",
+        description: "Extract exact line ranges from files.",
+        params: &[Param { name: "specs", kind: OptionKind::TextList, description: "Line specs as path:start:end", required: true }],
+        options: &["dir", "timeout", "auto-expand", "limit", "line-numbers", "max-tokens"],
+    },
+    Tool {
+        name: "symbols",
+        method: "symbols",
+        description: "Extract symbol declarations (functions, types, constants) with line ranges.",
+        params: &[Param { name: "pattern", kind: OptionKind::Text, description: "Only symbols whose name matches", required: false }],
+        options: &["dir", "glob", "exclude", "with-tests", "no-defaults", "no-ignore", "timeout", "regex", "compact", "with-comments", "limit", "max-tokens"],
+    },
+    Tool {
+        name: "callers",
+        method: "callers",
+        description: "Find the declarations of a symbol and every line of code that references it, each classified as call, reference, import or type. Matches whole identifiers, case-sensitively, outside comments and strings.",
+        params: &[
+            Param { name: "name", kind: OptionKind::Text, description: "Symbol name", required: true },
+            Param { name: "ignore-case", kind: OptionKind::Flag, description: "Match the name regardless of case", required: false },
+        ],
+        options: &["dir", "glob", "exclude", "with-tests", "no-defaults", "no-ignore", "timeout", "regex", "limit"],
+    },
+    Tool {
+        name: "call-tree",
+        method: "call-tree",
+        description: "Walk the callers of a symbol recursively: the symbols whose bodies reference it, then their callers, each with file and line.",
+        params: &[
+            Param { name: "name", kind: OptionKind::Text, description: "Symbol name", required: true },
+            Param { name: "depth", kind: OptionKind::Integer, description: "Levels to walk (default 3)", required: false },
+        ],
+        options: SCAN_OPTIONS,
+    },
+    Tool {
+        name: "callees",
+        method: "callees",
+        description: "List the declared symbols referenced inside a symbol's body, each with file and line.",
+        params: &[
+            Param { name: "name", kind: OptionKind::Text, description: "Symbol name", required: true },
+            Param { name: "depth", kind: OptionKind::Integer, description: "Levels to walk (default 1)", required: false },
+        ],
+        options: SCAN_OPTIONS,
+    },
+    Tool {
+        name: "graph",
+        method: "graph",
+        description: "Project-internal dependency graph: which files import which. With dependents or impact, the files that import the given files, grouped by depth; with cycles, the circular imports; with by, weighted edges between directories or packages; with external, the third-party packages each file uses and where each package is used; with orphans, files nothing imports that are not entrypoints.",
+        params: &[
+            Param { name: "dependents", kind: OptionKind::Text, description: "List the files that import this file, directly or transitively", required: false },
+            Param { name: "impact", kind: OptionKind::TextList, description: "Changed files; list every file affected by them", required: false },
+            Param { name: "cycles", kind: OptionKind::Flag, description: "Report import cycles instead of the graph", required: false },
+            Param { name: "by", kind: OptionKind::Text, description: "Roll imports up into edges between containers: dir or package", required: false },
+            Param { name: "external", kind: OptionKind::Flag, description: "Also list third-party packages per file and an inventory of their users", required: false },
+            Param { name: "orphans", kind: OptionKind::Flag, description: "List files no other file imports, excluding entrypoints", required: false },
+        ],
+        options: &["dir", "glob", "exclude", "with-tests", "no-defaults", "no-ignore", "timeout", "limit"],
+    },
+    Tool {
+        name: "stats",
+        method: "stats",
+        description: "File, line and byte counts by extension, plus the largest files.",
+        params: &[],
+        options: SCAN_OPTIONS,
+    },
+];
+
+/// Runs a Model Context Protocol server over stdio. Every tool call is
+/// confined to `base.root`.
+pub fn serve_mcp(base: &cli::CliArgs) -> i32 {
+    let sandbox = match Path::new(&base.root).canonicalize() {
+        Ok(p) => p,
+        Err(_) => {
+            eprintln!("Directory not found: {}", base.root);
+            return 1;
+        }
+    };
+
+    file_reader::enable_cache(server::CONTENT_CACHE_BYTES);
+    let mut session = Session::default();
+    server::run_stdio(|line| (handle_message(line, base, &sandbox, &mut session), false));
+    0
+}
+
+fn handle_message(line: &str, base: &cli::CliArgs, sandbox: &Path, session: &mut Session) -> Option<String> {
+    let (request, id) = match server::parse_request(line) {
+        Ok(r) => r,
+        Err(response) => return Some(response),
+    };
+    let id = id?;
+    let params = request.get("params");
+
+    let result = match request.get("method").and_then(JsonValue::as_str).unwrap_or("") {
+        "initialize" => Ok(initialize_result(params)),
+        "ping" => Ok(JsonValue::Object(Vec::new())),
+        "tools/list" => Ok(tools_list()),
+        "tools/call" => call_tool(params, base, sandbox, session),
+        other => Err((METHOD_NOT_FOUND, format!("Unknown method: {}", other))),
+    };
+
+    Some(match result {
+        Ok(value) => server::result_response(&id, &value.to_string()),
+        Err((code, msg)) => server::error_response(&id, code, &msg),
+    })
+}
+
+fn text(s: &str) -> JsonValue {
+    JsonValue::String(s.to_owned())
+}
+
+fn object(fields: Vec<(&str, JsonValue)>) -> JsonValue {
+    JsonValue::Object(fields.into_iter().map(|(k, v)| (k.to_owned(), v)).collect())
+}
+
+fn initialize_result(params: Option<&JsonValue>) -> JsonValue {
+    let requested = params.and_then(|p| p.get("protocolVersion")).and_then(JsonValue::as_str);
+    let version = match requested {
+        Some(v) if PROTOCOL_VERSIONS.contains(&v) => v,
+        _ => PROTOCOL_VERSIONS[0],
+    };
+    object(vec![
+        ("protocolVersion", text(version)),
+        ("capabilities", object(vec![("tools", object(vec![]))])),
+        ("serverInfo", object(vec![("name", text(SERVER_NAME)), ("version", text(SERVER_VERSION))])),
+    ])
+}
+
+fn property_schema(kind: OptionKind, description: &str) -> JsonValue {
+    let mut fields = match kind {
+        OptionKind::Flag => vec![("type", text("boolean"))],
+        OptionKind::Integer => vec![("type", text("integer")), ("minimum", JsonValue::Number(0.0))],
+        OptionKind::Text => vec![("type", text("string"))],
+        OptionKind::TextList => vec![("type", text("array")), ("items", object(vec![("type", text("string"))]))],
+    };
+    fields.push(("description", text(description)));
+    object(fields)
+}
+
+fn input_schema(tool: &Tool) -> JsonValue {
+    let mut properties = Vec::new();
+    let mut required = Vec::new();
+    for param in tool.params {
+        properties.push((param.name.to_owned(), property_schema(param.kind, param.description)));
+        if param.required {
+            required.push(text(param.name));
+        }
+    }
+    for name in tool.options {
+        if let Some(opt) = cli::query_option(name) {
+            properties.push((opt.name.to_owned(), property_schema(opt.kind, opt.description)));
+        }
+    }
+    object(vec![
+        ("type", text("object")),
+        ("properties", JsonValue::Object(properties)),
+        ("required", JsonValue::Array(required)),
+        ("additionalProperties", JsonValue::Bool(false)),
+    ])
+}
+
+fn tools_list() -> JsonValue {
+    let tools = TOOLS
+        .iter()
+        .map(|tool| {
+            object(vec![
+                ("name", text(tool.name)),
+                ("description", text(tool.description)),
+                ("inputSchema", input_schema(tool)),
+            ])
+        })
+        .collect();
+    object(vec![("tools", JsonValue::Array(tools))])
+}
+
+fn tool_result(body: String, is_error: bool) -> JsonValue {
+    object(vec![
+        ("content", JsonValue::Array(vec![object(vec![("type", text("text")), ("text", JsonValue::String(body))])])),
+        ("isError", JsonValue::Bool(is_error)),
+    ])
+}
+
+fn call_tool(
+    params: Option<&JsonValue>,
+    base: &cli::CliArgs,
+    sandbox: &Path,
+    session: &mut Session,
+) -> Result<JsonValue, (i32, String)> {
+    let name = params
+        .and_then(|p| p.get("name"))
+        .and_then(JsonValue::as_str)
+        .ok_or((INVALID_PARAMS, "tools/call requires a tool name".to_owned()))?;
+    let tool = TOOLS
+        .iter()
+        .find(|t| t.name == name)
+        .ok_or_else(|| (INVALID_PARAMS, format!("Unknown tool: {}", name)))?;
+    let arguments = params.and_then(|p| p.get("arguments"));
+
+    let args = match check_arguments(tool, arguments)
+        .and_then(|_| server::method_args(tool.method, arguments, base))
+        .and_then(|args| confine(&args, sandbox).map(|_| args))
+    {
+        Ok(args) => args,
+        Err(e) => return Ok(tool_result(e, true)),
+    };
+
+    let cancelled = Arc::new(AtomicBool::new(false));
+    query::arm_timeout(&cancelled, args.timeout);
+    let outcome = query::execute(&args, &cancelled, Some(session));
+    Ok(tool_result(yaml_output::envelope_to_json(&outcome.envelope), outcome.code == 1))
+}
+
+/// Rejects arguments the tool's schema does not declare, so a tool cannot be
+/// steered into another mode through `server::method_args`.
+fn check_arguments(tool: &Tool, arguments: Option<&JsonValue>) -> Result<(), String> {
+    let fields = match arguments {
+        None | Some(JsonValue::Null) => return Ok(()),
+        Some(v) => v.as_object().ok_or("arguments must be an object")?,
+    };
+    for (key, _) in fields {
+        let known = tool.params.iter().any(|p| p.name == key) || tool.options.contains(&key.as_str());
+        if !known {
+            return Err(format!("Unsupported argument for {}: {}", tool.name, key));
+        }
+    }
+    Ok(())
+}
+
+/// Ensures the scan root and every `lines` target stay inside `sandbox`,
+/// following symlinks for paths that exist.
+fn confine(args: &cli::CliArgs, sandbox: &Path) -> Result<(), String> {
+    let root = Path::new(&args.root)
+        .canonicalize()
+        .map_err(|_| format!("Directory not found: {}", args.root))?;
+    if !root.starts_with(sandbox) {
+        return Err(format!("{} is outside the project root", args.root));
+    }
+
+    for spec in lines::parse_line_specs(&args.lines, &root)? {
+        let inside = match root.join(&spec.path).canonicalize() {
+            Ok(real) => real.starts_with(sandbox),
+            Err(_) => lexically_inside(Path::new(&spec.path)),
+        };
+        if !inside {
+            return Err(format!("{} is outside the project root", spec.path));
+        }
+    }
+    Ok(())
+}
+
+/// True when a relative path never climbs above its starting directory.
+fn lexically_inside(path: &Path) -> bool {
+    let mut depth: usize = 0;
+    for component in path.components() {
+        match component {
+            Component::Normal(_) => depth += 1,
+            Component::CurDir => {}
+            Component::ParentDir => {
+                if depth == 0 {
+                    return false;
+                }
+                depth -= 1;
+            }
+            Component::RootDir | Component::Prefix(_) => return false,
+        }
+    }
+    true
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::json;
+    use crate::test_support::temp_project;
+    use std::path::PathBuf;
+
+    fn temp_root(name: &str) -> PathBuf {
+        temp_project(&format!("mcp_{}", name), &[
+            ("project/src/main.rs", "fn main() {}\n"),
+            ("secret.rs", "fn secret() {}\n"),
+        ])
+    }
+
+    fn base(root: &Path) -> cli::CliArgs {
+        match cli::parse_args(&["--dir".to_owned(), root.to_string_lossy().into_owned()]).unwrap() {
+            cli::CliAction::Run(a) => a,
+            _ => unreachable!(),
+        }
+    }
+
+    fn call(root: &Path, request: &str) -> JsonValue {
+        let sandbox = root.canonicalize().unwrap();
+        let mut session = Session::default();
+        let response = handle_message(request, &base(root), &sandbox, &mut session).unwrap();
+        json::parse(&response).unwrap()
+    }
+
+    fn tool_text(response: &JsonValue) -> (String, bool) {
+        let result = response.get("result").unwrap();
+        let content = &result.get("content").unwrap().as_array().unwrap()[0];
+        (
+            content.get("text").unwrap().as_str().unwrap().to_owned(),
+            result.get("isError").unwrap().as_bool().unwrap(),
+        )
+    }
+
+    // ── protocol ──
+
+    #[test]
+    fn initialize_negotiates_version() {
+        let root = temp_root("init");
+        let resp = call(&root, r#"{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}"#);
+        let result = resp.get("result").unwrap();
+        assert_eq!(result.get("protocolVersion").unwrap().as_str(), Some("2024-11-05"));
+        assert!(result.get("capabilities").unwrap().get("tools").is_some());
+
+        let resp = call(&root, r#"{"jsonrpc":"2.0","id":2,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}"#);
+        assert_eq!(resp.get("result").unwrap().get("protocolVersion").unwrap().as_str(), Some(PROTOCOL_VERSIONS[0]));
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn notifications_are_not_answered() {
+        let root = temp_root("notify");
+        let sandbox = root.canonicalize().unwrap();
+        let mut session = Session::default();
+        let line = r#"{"jsonrpc":"2.0","method":"notifications/initialized"}"#;
+        assert!(handle_message(line, &base(&root), &sandbox, &mut session).is_none());
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn tools_list_describes_every_tool() {
+        let list = tools_list();
+        let tools = list.get("tools").unwrap().as_array().unwrap();
+        assert_eq!(tools.len(), TOOLS.len());
+        let find = tools.iter().find(|t| t.get("name").unwrap().as_str() == Some("find")).unwrap();
+        let schema = find.get("inputSchema").unwrap();
+        assert_eq!(schema.get("required").unwrap().as_array().unwrap(), &[text("pattern")]);
+        let props = schema.get("properties").unwrap();
+        assert_eq!(props.get("context").unwrap().get("type").unwrap().as_str(), Some("integer"));
+        assert_eq!(props.get("glob").unwrap().get("type").unwrap().as_str(), Some("array"));
+    }
+
+    #[test]
+    fn tool_options_exist_in_cli() {
+        for tool in TOOLS {
+            for name in tool.options {
+                assert!(cli::query_option(name).is_some(), "{} lists unknown option {}", tool.name, name);
+            }
+        }
+    }
+
+    // ── tools/call ──
+
+    #[test]
+    fn call_returns_envelope_json() {
+        let root = temp_root("call");
+        let project = root.join("project");
+        let resp = call(&project, r#"{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"symbols","arguments":{"glob":["*.rs"]}}}"#);
+        let (body, is_error) = tool_text(&resp);
+        assert!(!is_error);
+        let envelope = json::parse(&body).unwrap();
+        assert!(envelope.get("symbols").is_some());
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn unknown_tool_is_protocol_error() {
+        let root = temp_root("unknown");
+        let resp = call(&root, r#"{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"rm"}}"#);
+        assert!(resp.get("error").is_some());
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn undeclared_arguments_rejected() {
+        let root = temp_root("undeclared");
+        let project = root.join("project");
+        let resp = call(&project, r#"{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"stats","arguments":{"specs":"x:1:1"}}}"#);
+        let (body, is_error) = tool_text(&resp);
+        assert!(is_error);
+        assert!(body.contains("Unsupported argument"));
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    // ── sandbox ──
+
+    #[test]
+    fn dir_outside_root_rejected() {
+        let root = temp_root("dir_escape");
+        let project = root.join("project");
+        let resp = call(&project, r#"{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"tree","arguments":{"dir":".."}}}"#);
+        let (body, is_error) = tool_text(&resp);
+        assert!(is_error);
+        assert!(body.contains("outside the project root"));
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn lines_outside_root_rejected() {
+        let root = temp_root("lines_escape");
+        let project = root.join("project");
+        let secret = root.join("secret.rs").to_string_lossy().into_owned();
+        for spec in ["../secret.rs:1:1".to_owned(), format!("{}:1:1", secret), "../missing.rs:1:1".to_owned()] {
+            let request = format!(
+                r#"{{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{{"name":"lines","arguments":{{"specs":[{}]}}}}}}"#,
+                JsonValue::String(spec.clone())
+            );
+            let (body, is_error) = tool_text(&call(&project, &request));
+            assert!(is_error, "{} was allowed", spec);
+            assert!(!body.contains("fn secret"));
+        }
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn lines_inside_root_allowed() {
+        let root = temp_root("lines_inside");
+        let project = root.join("project");
+        let resp = call(&project, r#"{"jsonrpc":"2.0","id":8,"method":"tools/call","params":{"name":"lines","arguments":{"specs":["src/main.rs:1:1"]}}}"#);
+        let (body, is_error) = tool_text(&resp);
+        assert!(!is_error);
+        assert!(body.contains("fn main"));
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn symlink_escape_rejected() {
+        let root = temp_root("symlink");
+        let project = root.join("project");
+        std::os::unix::fs::symlink(root.join("secret.rs"), project.join("link.rs")).unwrap();
+        let resp = call(&project, r#"{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"name":"lines","arguments":{"specs":["link.rs:1:1"]}}}"#);
+        let (body, is_error) = tool_text(&resp);
+        assert!(is_error);
+        assert!(!body.contains("fn secret"));
+        let _ = std::fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn lexical_check() {
+        assert!(lexically_inside(Path::new("a/b/../c.rs")));
+        assert!(!lexically_inside(Path::new("a/../../c.rs")));
+        assert!(!lexically_inside(Path::new("/etc/passwd")));
+    }
+}
@@ -9,6 +9,18 @@ pub struct MetaInfo {
     pub files_matched: usize,
     pub files_errored: usize,
     pub total_matches: Option<usize>,
+    pub budget: Option<BudgetReport>,
+}
+
+/// What `--max-tokens` kept and cut. Token counts are estimates.
+pub struct BudgetReport {
+    pub max_tokens: usize,
+    pub used_tokens: usize,
+    pub truncated: bool,
+    /// Files left out entirely.
+    pub dropped: Vec<String>,
+    /// Files cut short at a line or symbol boundary.
+    pub partial: Vec<String>,
 }
 
 pub struct FileChunk {
@@ -33,8 +45,107 @@ pub struct ScanResult {
 pub struct GraphEntry {
     pub file: String,
     pub imports: Vec<String>,
+    /// Raw third-party import markers, classified by `external::report`.
+    pub external: Vec<String>,
+}
+
+/// A file's project imports next to the third-party packages it uses.
+pub struct ExternalFile {
+    pub file: String,
+    pub imports: Vec<String>,
+    pub packages: Vec<String>,
+}
+
+/// A third-party package and the files that import it.
+pub struct ExternalPackage {
+    pub name: String,
+    pub ecosystem: String,
+    pub files: Vec<String>,
+}
+
+/// Files `--orphans` found no importer for, next to the entrypoints that
+/// were exempted.
+pub struct OrphansOutput {
+    pub entrypoints: Vec<String>,
+    pub orphans: Vec<String>,
+}
+
+pub struct ExternalOutput {
+    pub files: Vec<ExternalFile>,
+    pub packages: Vec<ExternalPackage>,
+}
+
+/// Files at one distance from the targets of `--dependents` or `--impact`:
+/// depth 1 imports a target directly, depth 2 imports a depth-1 file, and so on.
+pub struct DependentLevel {
+    pub depth: usize,
+    pub files: Vec<String>,
+}
+
+pub struct DependentsOutput {
+    pub targets: Vec<String>,
+    pub levels: Vec<DependentLevel>,
+}
+
+/// One import edge of a cycle and, when it can be located, the line in
+/// `from` that creates it.
+pub struct ImportEdge {
+    pub from: String,
+    pub to: String,
+    pub line: Option<usize>,
+    pub content: Option<String>,
+}
+
+/// A strongly connected group of files. `path` is the shortest cycle through
+/// the group, starting and ending at its first file.
+pub struct ImportCycle {
+    pub files: Vec<String>,
+    pub path: Vec<ImportEdge>,
+}
+
+/// A directory or package in a `--by` rollup and how many scanned files it
+/// holds.
+pub struct Container {
+    pub name: String,
+    pub files: usize,
+}
+
+pub struct FilePair {
+    pub from: String,
+    pub to: String,
+}
+
+/// All file imports from one container into another. `top` holds a few of
+/// the file pairs behind the edge, most-imported target files first.
+pub struct ContainerEdge {
+    pub from: String,
+    pub to: String,
+    pub count: usize,
+    pub top: Vec<FilePair>,
+}
+
+pub struct RollupOutput {
+    pub by: &'static str,
+    pub containers: Vec<Container>,
+    pub edges: Vec<ContainerEdge>,
+}
+
+/// An import that breaks a `check-deps` rule.
+pub struct DepViolation {
+    pub file: String,
+    pub line: Option<usize>,
+    pub import: Option<String>,
+    pub target: String,
+    pub rule: String,
+    pub reason: Option<String>,
+}
+
+pub struct CheckReport {
+    pub rules: String,
+    pub violations: Vec<DepViolation>,
 }
 
+#[derive(Clone)]
 pub struct SymbolFile {
     pub path: String,
     pub symbols: Vec<SymbolInfo>,
@@ -73,6 +184,8 @@ pub struct StatsOutput {
 
 pub struct CallerEntry {
     pub line: usize,
+    /// How the line uses the name: `call`, `reference`, `import` or `type`.
+    pub kind: &'static str,
     pub content: String,
 }
 
@@ -92,15 +205,55 @@ pub struct CallersOutput {
     pub files: Vec<CallerFile>,
 }
 
+/// A symbol in a call tree. `sites` are the lines where it meets its parent:
+/// in its own file when walking callers, in the parent's file when walking
+/// callees. Code outside every symbol shows up as a `file` node.
+pub struct CallNode {
+    pub name: String,
+    pub kind: &'static str,
+    pub path: String,
+    pub line: usize,
+    pub sites: Vec<usize>,
+    /// Already on the path from the root; not expanded again.
+    pub recursive: bool,
+    pub children: Vec<CallNode>,
+}
+
+/// One tree per declaration of the requested symbol.
+pub struct CallTreeOutput {
+    pub direction: &'static str,
+    pub depth: usize,
+    pub roots: Vec<CallNode>,
+}
+
+pub struct IndexReport {
+    pub path: String,
+    pub state: &'static str,
+    pub version: u32,
+    pub files: usize,
+    pub symbols: usize,
+    pub changed: usize,
+    pub added: usize,
+    pub removed: usize,
+}
+
 pub enum OutputPayload {
     None,
     Tree(ScanResult),
     Files(Vec<FileEntry>),
     Graph(Vec<GraphEntry>),
+    Dependents(DependentsOutput),
+    Cycles(Vec<ImportCycle>),
+    Rollup(RollupOutput),
+    External(ExternalOutput),
+    Orphans(OrphansOutput),
+    Check(CheckReport),
     Symbols { files: Vec<SymbolFile>, compact: bool },
     Counts(Vec<CountEntry>),
     Stats(StatsOutput),
     Callers(CallersOutput),
+    CallTree(CallTreeOutput),
+    Index(IndexReport),
 }
 
 impl Default for OutputPayload {
@@ -153,6 +306,7 @@ mod tests {
             files_matched: 5,
             files_errored: 0,
             total_matches: None,
+            budget: None,
         };
         let output = format!("{}", meta);
         assert!(output.contains("meta:"));
@@ -172,6 +326,7 @@ mod tests {
             files_matched: 0,
             files_errored: 0,
             total_matches: None,
+            budget: None,
         };
         let output = format!("{}", meta);
         assert!(output.contains("timeout: true"));
@@ -188,6 +343,7 @@ mod tests {
             files_matched: 3,
             files_errored: 0,
             total_matches: Some(15),
+            budget: None,
         };
         let output = format!("{}", meta);
         assert!(output.contains("totalMatches: 15"));
@@ -202,6 +358,7 @@ mod tests {
             files_matched: 1,
             files_errored: 0,
             total_matches: None,
+            budget: None,
         };
         let output = format!("{}", meta);
         assert!(!output.contains("elapsedMs"));
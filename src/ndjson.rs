@@ -0,0 +1,189 @@
+use std::io::Write;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Mutex;
+
+use crate::models::{CallerDeclaration, CallerFile, FileEntry, GraphEntry, OutputEnvelope, OutputPayload, SymbolFile};
+use crate::yaml_output::{self, Record};
+
+/// Per-item callback used by the scanning modes to hand results out as soon
+/// as a worker produces them. Called concurrently and in completion order.
+pub type OnItem<'a, T> = Option<&'a (dyn Fn(&T) + Sync)>;
+
+/// Writes `--format ndjson` output: one JSON object per file as it becomes
+/// ready, then a closing record with the envelope's meta and errors. Shared
+/// by reference across rayon workers; each record is written and flushed
+/// under a lock so lines never interleave.
+pub struct NdjsonSink {
+    out: Mutex<Box<dyn Write + Send>>,
+    limit: Option<usize>,
+    emitted: AtomicUsize,
+    compact: bool,
+}
+
+impl NdjsonSink {
+    pub fn new(out: Box<dyn Write + Send>, limit: Option<usize>, compact: bool) -> Self {
+        Self { out: Mutex::new(out), limit, emitted: AtomicUsize::new(0), compact }
+    }
+
+    /// Starts another run on the same output, as `--watch` does after each
+    /// change: `--limit` counts from zero again.
+    pub fn restart(&self) {
+        self.emitted.store(0, Ordering::Relaxed);
+    }
+
+    pub fn file(&self, entry: &FileEntry) {
+        self.counted(&Record::File(entry));
+    }
+
+    pub fn symbols(&self, sf: &SymbolFile) {
+        self.counted(&Record::Symbols(sf, self.compact));
+    }
+
+    pub fn graph(&self, entry: &GraphEntry) {
+        self.counted(&Record::Graph(entry));
+    }
+
+    pub fn caller(&self, cf: &CallerFile) {
+        self.counted(&Record::Caller(cf));
+    }
+
+    /// Declarations are not files and do not count toward `--limit`.
+    pub fn declaration(&self, d: &CallerDeclaration) {
+        self.write(&Record::Declaration(d));
+    }
+
+    /// Writes whatever part of `envelope` was not streamed, then the closing
+    /// meta record. Results without per-file records (tree, dependents,
+    /// cycles, rollups, external packages, orphans, dependency checks, call
+    /// trees, stats, index) get a single payload record.
+    pub fn finish(&self, envelope: &OutputEnvelope) {
+        match &envelope.payload {
+            OutputPayload::None => {}
+            OutputPayload::Files(files) => files.iter().for_each(|f| self.file(f)),
+            OutputPayload::Counts(counts) => counts.iter().for_each(|c| self.counted(&Record::Count(c))),
+            OutputPayload::Graph(graph) => graph.iter().for_each(|g| self.graph(g)),
+            OutputPayload::Symbols { files, .. } => files.iter().for_each(|sf| self.symbols(sf)),
+            OutputPayload::Callers(output) => {
+                output.declarations.iter().for_each(|d| self.declaration(d));
+                output.files.iter().for_each(|cf| self.caller(cf));
+            }
+            payload @ (OutputPayload::Tree(_)
+            | OutputPayload::Dependents(_)
+            | OutputPayload::Cycles(_)
+            | OutputPayload::Rollup(_)
+            | OutputPayload::External(_)
+            | OutputPayload::Orphans(_)
+            | OutputPayload::Check(_)
+            | OutputPayload::CallTree(_)
+            | OutputPayload::Stats(_)
+            | OutputPayload::Index(_)) => {
+                self.write(&Record::Payload(payload));
+            }
+        }
+        self.write(&Record::Summary(envelope));
+    }
+
+    fn counted(&self, record: &Record) {
+        let n = self.emitted.fetch_add(1, Ordering::Relaxed);
+        if self.limit.map_or(true, |l| n < l) {
+            self.write(record);
+        }
+    }
+
+    fn write(&self, record: &Record) {
+        let mut out = self.out.lock().unwrap_or_else(|e| e.into_inner());
+        if yaml_output::write_record(&mut *out, record).is_ok() {
+            out.flush().ok();
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::models::{MetaInfo, ScanResult};
+    use std::io;
+    use std::sync::Arc;
+
+    #[derive(Clone, Default)]
+    struct Shared(Arc<Mutex<Vec<u8>>>);
+
+    impl Write for Shared {
+        fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+            self.0.lock().unwrap().write(buf)
+        }
+        fn flush(&mut self) -> io::Result<()> {
+            Ok(())
+        }
+    }
+
+    impl Shared {
+        fn lines(&self) -> Vec<String> {
+            String::from_utf8(self.0.lock().unwrap().clone()).unwrap().lines().map(str::to_owned).collect()
+        }
+    }
+
+    fn entry(path: &str) -> FileEntry {
+        FileEntry { path: path.into(), contents: Some("a\nb\n".into()), error: None, chunks: None }
+    }
+
+    fn meta() -> MetaInfo {
+        MetaInfo { elapsed_ms: 5, timeout: true, files_scanned: 3, files_matched: 2, files_errored: 0, total_matches: None, budget: None }
+    }
+
+    #[test]
+    fn records_are_single_lines() {
+        let out = Shared::default();
+        let sink = NdjsonSink::new(Box::new(out.clone()), None, false);
+        sink.file(&entry("a.rs"));
+        sink.file(&entry("b.rs"));
+        let lines = out.lines();
+        assert_eq!(lines, vec![
+            r#"{"path":"a.rs","contents":"a\nb\n"}"#.to_owned(),
+            r#"{"path":"b.rs","contents":"a\nb\n"}"#.to_owned(),
+        ]);
+    }
+
+    #[test]
+    fn limit_caps_file_records_but_not_declarations() {
+        let out = Shared::default();
+        let sink = NdjsonSink::new(Box::new(out.clone()), Some(1), false);
+        sink.declaration(&CallerDeclaration { path: "a.rs".into(), line: 1, signature: "fn a()".into() });
+        sink.caller(&CallerFile { path: "a.rs".into(), sites: vec![] });
+        sink.caller(&CallerFile { path: "b.rs".into(), sites: vec![] });
+        let lines = out.lines();
+        assert_eq!(lines.len(), 2);
+        assert!(lines[0].starts_with(r#"{"declaration":{"path":"a.rs""#));
+        assert!(lines[1].contains("a.rs"));
+    }
+
+    #[test]
+    fn finish_writes_unstreamed_payload_then_meta() {
+        let out = Shared::default();
+        let sink = NdjsonSink::new(Box::new(out.clone()), None, false);
+        let envelope = OutputEnvelope {
+            meta: Some(meta()),
+            payload: OutputPayload::Tree(ScanResult { name: "root".into(), children: None, files: None }),
+            errors: Some(vec!["x.rs: unreadable".into()]),
+            error: None,
+        };
+        sink.finish(&envelope);
+        let lines = out.lines();
+        assert_eq!(lines[0], r#"{"tree":{"name":"root"}}"#);
+        assert_eq!(
+            lines[1],
+            r#"{"meta":{"elapsedMs":5,"timeout":true,"filesScanned":3,"filesMatched":2},"errors":["x.rs: unreadable"]}"#
+        );
+    }
+
+    #[test]
+    fn finish_after_streaming_writes_only_meta() {
+        let out = Shared::default();
+        let sink = NdjsonSink::new(Box::new(out.clone()), None, false);
+        sink.file(&entry("a.rs"));
+        sink.finish(&OutputEnvelope { meta: Some(meta()), ..Default::default() });
+        let lines = out.lines();
+        assert_eq!(lines.len(), 2);
+        assert!(lines[1].starts_with(r#"{"meta":"#));
+    }
+}
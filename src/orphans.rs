@@ -0,0 +1,182 @@
+use std::collections::{BTreeSet, HashSet};
+use std::path::Path;
+use std::sync::OnceLock;
+
+use regex::Regex;
+
+use crate::graph;
+use crate::models::{GraphEntry, OrphansOutput};
+use crate::path_helper;
+
+/// File names that start a program or library wherever they appear.
+const ENTRYPOINT_NAMES: &[&str] = &[
+    "main.rs", "lib.rs", "build.rs",
+    "main.go",
+    "index.ts", "index.tsx", "index.js", "index.jsx", "index.mjs", "index.mts",
+    "__main__.py",
+    "Program.cs",
+];
+
+/// Cargo target sections whose `path` names an entrypoint.
+const CARGO_TARGETS: &[&str] = &["[lib]", "[[bin]]", "[[example]]", "[[bench]]", "[[test]]"];
+
+/// Splits the graph's files into recognized entrypoints and orphans: files
+/// no other file imports that are not entrypoints either. Go compiles a
+/// directory as one package, so a Go file is in use when any file beside it
+/// is imported or is an entrypoint.
+pub fn orphans(graph: &[GraphEntry], root: &Path) -> OrphansOutput {
+    let files: HashSet<&str> = graph.iter().map(|e| e.file.as_str()).collect();
+    let declared = declared_entrypoints(&files, root);
+    let entrypoints: BTreeSet<&str> = files
+        .iter()
+        .copied()
+        .filter(|f| is_named_entrypoint(f) || declared.contains(*f))
+        .collect();
+
+    let mut used: HashSet<&str> = entrypoints.iter().copied().collect();
+    for entry in graph {
+        used.extend(entry.imports.iter().filter(|imp| **imp != entry.file).map(String::as_str));
+    }
+    let used_go_dirs: HashSet<&str> = used.iter().filter(|f| f.ends_with(".go")).map(|f| path_helper::parent_dir(f)).collect();
+
+    let mut orphans: Vec<String> = graph
+        .iter()
+        .map(|e| e.file.as_str())
+        .filter(|f| !used.contains(f))
+        .filter(|f| !(f.ends_with(".go") && used_go_dirs.contains(path_helper::parent_dir(f))))
+        .map(str::to_owned)
+        .collect();
+    orphans.sort_unstable_by(|a, b| a.to_ascii_lowercase().cmp(&b.to_ascii_lowercase()));
+
+    OrphansOutput { entrypoints: entrypoints.into_iter().map(str::to_owned).collect(), orphans }
+}
+
+/// Entrypoints by name, plus Cargo's auto-discovered `src/bin`, `examples`
+/// and `benches` targets.
+fn is_named_entrypoint(file: &str) -> bool {
+    let name = file.rsplit('/').next().unwrap_or(file);
+    if ENTRYPOINT_NAMES.contains(&name) {
+        return true;
+    }
+    let dir = path_helper::parent_dir(file);
+    file.ends_with(".rs")
+        && (dir == "src/bin" || dir.ends_with("/src/bin") || ["examples", "benches"].contains(&dir.rsplit('/').next().unwrap_or(dir)))
+}
+
+/// Files named by a `package.json` `main`, `module` or `bin`, or a
+/// `Cargo.toml` target `path`, in any directory holding project files.
+fn declared_entrypoints(files: &HashSet<&str>, root: &Path) -> HashSet<String> {
+    let mut dirs: BTreeSet<&str> = BTreeSet::new();
+    for file in files {
+        let mut dir = path_helper::parent_dir(file);
+        while dirs.insert(dir) && !dir.is_empty() {
+            dir = path_helper::parent_dir(dir);
+        }
+    }
+
+    let mut declared = HashSet::new();
+    for dir in dirs {
+        let base = root.join(dir);
+        let mut targets = Vec::new();
+        if let Ok(text) = std::fs::read_to_string(base.join("package.json")) {
+            targets.extend(package_json_targets(&text));
+        }
+        if let Ok(text) = std::fs::read_to_string(base.join("Cargo.toml")) {
+            targets.extend(cargo_targets(&text));
+        }
+        for target in targets {
+            let path = if dir.is_empty() { target } else { format!("{}/{}", dir, target) };
+            let path = graph::normalize_candidate(&path);
+            declared.extend(path_helper::source_candidates(&path).into_iter().filter(|c| files.contains(c.as_str())));
+        }
+    }
+    declared
+}
+
+fn package_json_targets(text: &str) -> Vec<String> {
+    static FIELD: OnceLock<Regex> = OnceLock::new();
+    let field = FIELD.get_or_init(|| Regex::new(r#""(?:main|module|bin)"\s*:\s*"([^"]+)""#).unwrap());
+    static BIN_MAP: OnceLock<Regex> = OnceLock::new();
+    let bin_map = BIN_MAP.get_or_init(|| Regex::new(r#""bin"\s*:\s*\{([^}]*)\}"#).unwrap());
+    static VALUE: OnceLock<Regex> = OnceLock::new();
+    let value = VALUE.get_or_init(|| Regex::new(r#""[^"]*"\s*:\s*"([^"]+)""#).unwrap());
+
+    let mut targets: Vec<String> = field.captures_iter(text).map(|c| c[1].to_owned()).collect();
+    if let Some(map) = bin_map.captures(text) {
+        targets.extend(value.captures_iter(&map[1]).map(|c| c[1].to_owned()));
+    }
+    targets
+}
+
+fn cargo_targets(text: &str) -> Vec<String> {
+    static PATH: OnceLock<Regex> = OnceLock::new();
+    let path = PATH.get_or_init(|| Regex::new(r#"^path\s*=\s*"([^"]+)""#).unwrap());
+    let mut in_target = false;
+    let mut targets = Vec::new();
+    for line in text.lines().map(str::trim) {
+        if line.starts_with('[') {
+            in_target = CARGO_TARGETS.contains(&line);
+        } else if in_target {
+            if let Some(c) = path.captures(line) {
+                targets.push(c[1].to_owned());
+            }
+        }
+    }
+    targets
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{graph_entry, temp_project};
+
+    // ── entrypoints ──
+
+    #[test]
+    fn named_entrypoints() {
+        assert!(is_named_entrypoint("src/main.rs"));
+        assert!(is_named_entrypoint("cmd/api/main.go"));
+        assert!(is_named_entrypoint("web/src/index.tsx"));
+        assert!(is_named_entrypoint("app/__main__.py"));
+        assert!(is_named_entrypoint("Api/Program.cs"));
+        assert!(is_named_entrypoint("crates/cli/src/bin/tool.rs"));
+        assert!(is_named_entrypoint("examples/demo.rs"));
+        assert!(!is_named_entrypoint("src/util.rs"));
+        assert!(!is_named_entrypoint("src/bin/notes.txt"));
+    }
+
+    #[test]
+    fn manifest_targets() {
+        let pkg = r#"{ "name": "tool", "main": "./dist/index.js", "bin": { "tool": "bin/tool.js", "t": "bin/t.js" } }"#;
+        assert_eq!(package_json_targets(pkg), vec!["./dist/index.js", "bin/tool.js", "bin/t.js"]);
+        assert_eq!(package_json_targets(r#"{"bin": "cli.js"}"#), vec!["cli.js"]);
+
+        let cargo = "[package]\nname = \"x\"\npath = \"ignored.rs\"\n\n[[bin]]\nname = \"gen\"\npath = \"tools/gen.rs\"\n\n[lib]\npath = \"src/core.rs\"\n";
+        assert_eq!(cargo_targets(cargo), vec!["tools/gen.rs", "src/core.rs"]);
+    }
+
+    // ── orphans ──
+
+    #[test]
+    fn orphans_exclude_imported_files_and_entrypoints() {
+        let root = temp_project("orphans_mixed", &[
+            ("web/package.json", r#"{"bin": {"web": "./dist/serve.js"}}"#),
+            ("Cargo.toml", "[[bin]]\npath = \"tools/gen.rs\"\n"),
+        ]);
+        let graph = vec![
+            graph_entry("src/main.rs", &["src/cli.rs"]),
+            graph_entry("src/cli.rs", &[]),
+            graph_entry("src/old.rs", &["src/old.rs", "src/cli.rs"]),
+            graph_entry("tools/gen.rs", &[]),
+            graph_entry("web/src/serve.ts", &[]),
+            graph_entry("web/src/legacy.ts", &[]),
+            graph_entry("svc/db/conn.go", &[]),
+            graph_entry("svc/db/pool.go", &[]),
+            graph_entry("svc/api/handler.go", &["svc/db/conn.go"]),
+        ];
+        let out = orphans(&graph, &root);
+        assert_eq!(out.entrypoints, vec!["src/main.rs", "tools/gen.rs", "web/src/serve.ts"]);
+        assert_eq!(out.orphans, vec!["src/old.rs", "svc/api/handler.go", "web/src/legacy.ts"]);
+        std::fs::remove_dir_all(&root).ok();
+    }
+}
@@ -14,6 +14,39 @@ pub fn normalized_relative(root: &Path, full: &Path) -> String {
     }
 }
 
+/// The directory part of a root-relative path, empty at the root. A
+/// directory given with a trailing `/` (`src/lang/`) has its parent taken.
+pub fn parent_dir(path: &str) -> &str {
+    let path = path.strip_suffix('/').unwrap_or(path);
+    path.rfind('/').map_or("", |i| &path[..i])
+}
+
+/// Paths a build output file may come from, itself included: package
+/// entrypoints and type declarations often name build output, so
+/// `dist/cli.js` is also looked up as `src/cli.ts` and friends.
+pub fn source_candidates(path: &str) -> Vec<String> {
+    let mut bases = vec![path.to_owned()];
+    for out_dir in ["dist/", "build/", "lib/", "out/"] {
+        if let Some(rest) = path.strip_prefix(out_dir) {
+            bases.push(format!("src/{}", rest));
+        } else if let Some(i) = path.find(&format!("/{}", out_dir)) {
+            bases.push(format!("{}/src/{}", &path[..i], &path[i + out_dir.len() + 1..]));
+        }
+    }
+
+    let mut candidates = Vec::new();
+    for base in bases {
+        let stem = [".d.ts", ".js", ".mjs", ".cjs"].iter().find_map(|ext| base.strip_suffix(ext));
+        if let Some(stem) = stem {
+            for ext in ["ts", "tsx", "mts", "js", "jsx", "mjs"] {
+                candidates.push(format!("{}.{}", stem, ext));
+            }
+        }
+        candidates.push(base);
+    }
+    candidates
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -50,4 +83,20 @@ mod tests {
         let result = normalized_relative(root, full);
         assert_eq!(result, "src/lang/rust.rs");
     }
+
+    #[test]
+    fn parent_dir_of_files_and_directories() {
+        assert_eq!(parent_dir("crates/core/Cargo.toml"), "crates/core");
+        assert_eq!(parent_dir("src/lang/"), "src");
+        assert_eq!(parent_dir("Cargo.toml"), "");
+        assert_eq!(parent_dir("src/"), "");
+    }
+
+    #[test]
+    fn build_output_maps_back_to_sources() {
+        let candidates = source_candidates("web/dist/cli.js");
+        assert!(candidates.contains(&"web/src/cli.ts".to_owned()));
+        assert!(candidates.contains(&"web/dist/cli.js".to_owned()));
+        assert!(source_candidates("dist/index.d.ts").contains(&"src/index.ts".to_owned()));
+    }
 }
@@ -0,0 +1,823 @@
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::time::{Instant, SystemTime};
+
+use crate::alias::{self, AliasMapping};
+use crate::budget::{self, Budget};
+use crate::callers;
+use crate::cli;
+use crate::count;
+use crate::exclusion;
+use crate::external;
+use crate::graph;
+use crate::index::{self, Index};
+use crate::lines;
+use crate::models::{
+    self, BudgetReport, CallerDeclaration, CallerFile, CheckReport, DependentLevel, DependentsOutput, FileEntry, GraphEntry, IndexReport, MetaInfo, OutputEnvelope, OutputPayload,
+    SymbolFile,
+};
+use crate::ndjson::{NdjsonSink, OnItem};
+use crate::orphans;
+use crate::path_helper;
+use crate::rollup;
+use crate::rules::{self, Rules};
+use crate::scanner;
+use crate::searcher::{self, Matcher};
+use crate::stats;
+use crate::symbols;
+
+/// The envelope a query produced plus the process exit code it maps to.
+pub struct Outcome {
+    pub envelope: OutputEnvelope,
+    pub code: i32,
+}
+
+impl Outcome {
+    pub fn error(msg: String) -> Self {
+        Self {
+            envelope: OutputEnvelope {
+                error: Some(msg),
+                ..Default::default()
+            },
+            code: 1,
+        }
+    }
+}
+
+/// Warm state kept by long-running modes between queries: an in-memory index
+/// per root (seeded from `.src/index` when present) and the loaded aliases.
+#[derive(Default)]
+pub struct Session {
+    indexes: HashMap<PathBuf, Index>,
+    aliases: HashMap<PathBuf, (Vec<Option<SystemTime>>, Arc<Vec<AliasMapping>>)>,
+}
+
+impl Session {
+    fn aliases(&mut self, root: &Path) -> Arc<Vec<AliasMapping>> {
+        let stamps = alias::config_stamps(root);
+        if let Some((cached_stamps, aliases)) = self.aliases.get(root) {
+            if *cached_stamps == stamps {
+                return aliases.clone();
+            }
+        }
+        let aliases = Arc::new(alias::load_aliases(root));
+        self.aliases.insert(root.to_path_buf(), (stamps, aliases.clone()));
+        aliases
+    }
+
+    fn index(&mut self, root: &Path, files: &[String], cancelled: &AtomicBool) -> &Index {
+        let index = self
+            .indexes
+            .entry(root.to_path_buf())
+            .or_insert_with(|| Index::load(root).unwrap_or_else(|_| Index::new(root)));
+        index.refresh(files, cancelled);
+        index
+    }
+}
+
+/// Sets `cancelled` once `timeout` seconds have passed.
+pub fn arm_timeout(cancelled: &Arc<AtomicBool>, timeout: Option<u64>) {
+    if let Some(secs) = timeout {
+        let cancelled = cancelled.clone();
+        std::thread::spawn(move || {
+            std::thread::sleep(std::time::Duration::from_secs(secs));
+            cancelled.store(true, Ordering::Relaxed);
+        });
+    }
+}
+
+fn make_meta(
+    elapsed: u128,
+    timed_out: bool,
+    scanned: usize,
+    matched: usize,
+    total: Option<usize>,
+) -> MetaInfo {
+    MetaInfo {
+        elapsed_ms: elapsed,
+        timeout: timed_out,
+        files_scanned: scanned,
+        files_matched: matched,
+        files_errored: 0,
+        total_matches: total,
+        budget: None,
+    }
+}
+
+fn apply_limit<T>(items: Vec<T>, limit: Option<usize>) -> Vec<T> {
+    match limit {
+        Some(n) if n < items.len() => items.into_iter().take(n).collect(),
+        _ => items,
+    }
+}
+
+fn collect_file_errors(entries: &[FileEntry]) -> Vec<String> {
+    entries
+        .iter()
+        .filter_map(|e| e.error.as_ref().map(|err| format!("{}: {}", e.path, err)))
+        .collect()
+}
+
+fn finish(
+    meta: MetaInfo,
+    payload: OutputPayload,
+    errors: Vec<String>,
+    timed_out: bool,
+) -> Outcome {
+    let envelope = OutputEnvelope {
+        meta: Some(meta),
+        payload,
+        errors: if errors.is_empty() { None } else { Some(errors) },
+        error: if timed_out {
+            Some("Operation timed out — partial results may be incomplete".into())
+        } else {
+            None
+        },
+    };
+    Outcome { envelope, code: if timed_out { 2 } else { 0 } }
+}
+
+/// Hands `emit` to a scanning function only when output is being streamed.
+fn streaming<'a, T>(sink: Option<&NdjsonSink>, emit: &'a (dyn Fn(&T) + Sync)) -> OnItem<'a, T> {
+    sink.map(|_| emit)
+}
+
+/// Applies `--max-tokens`, if set, to results already in output order.
+fn fit_budget<T>(
+    args: &cli::CliArgs,
+    items: Vec<T>,
+    fit: impl FnOnce(&mut Budget, Vec<T>) -> Vec<T>,
+) -> Result<(Vec<T>, Option<BudgetReport>), String> {
+    let max = match args.max_tokens {
+        Some(max) => max,
+        None => return Ok((items, None)),
+    };
+    let estimator = budget::estimator(args.tokenizer.as_deref())?;
+    let mut budget = Budget::new(max, estimator.as_ref());
+    let kept = fit(&mut budget, items);
+    Ok((kept, Some(budget.report())))
+}
+
+/// Streamed results were already written; keep them out of the envelope.
+fn unless_streamed(sink: Option<&NdjsonSink>, payload: OutputPayload) -> OutputPayload {
+    if sink.is_some() { OutputPayload::None } else { payload }
+}
+
+fn resolve_globs(args: &cli::CliArgs) -> Vec<String> {
+    if args.globs.is_empty() { vec!["*.*".to_owned()] } else { args.globs.clone() }
+}
+
+fn find_or_bail(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+) -> Result<(Vec<String>, usize), Outcome> {
+    let globs = resolve_globs(args);
+    let files = scanner::find_files_filtered(root, &globs, filter, cancelled, args.with_tests);
+    let scanned = files.len();
+    if cancelled.load(Ordering::Relaxed) {
+        let elapsed = start.elapsed().as_millis();
+        return Err(finish(make_meta(elapsed, true, scanned, 0, None), OutputPayload::None, vec![], true));
+    }
+    Ok((files, scanned))
+}
+
+/// Hands `f` the session's warm index, or the on-disk index when one exists.
+fn with_index<R>(
+    session: Option<&mut Session>,
+    root: &Path,
+    files: &[String],
+    cancelled: &AtomicBool,
+    f: impl FnOnce(Option<&Index>) -> R,
+) -> R {
+    match session {
+        Some(session) => f(Some(session.index(root, files, cancelled))),
+        None => f(Index::open(root, files, cancelled).as_ref()),
+    }
+}
+
+/// Runs the mode selected by `args`. `session` carries warm state for
+/// long-running modes; one-shot runs pass `None` and use the on-disk index
+/// when it exists.
+pub fn execute(args: &cli::CliArgs, cancelled: &AtomicBool, session: Option<&mut Session>) -> Outcome {
+    run(args, cancelled, session, None)
+}
+
+/// Like `execute`, but writes results to `sink` as NDJSON while the scan is
+/// running. Search, symbols, graph and callers emit one record per file as
+/// workers finish it; other modes emit their result at the end. The returned
+/// envelope carries no payload.
+pub fn execute_streaming(
+    args: &cli::CliArgs,
+    cancelled: &AtomicBool,
+    session: Option<&mut Session>,
+    sink: &NdjsonSink,
+) -> Outcome {
+    let mut outcome = run(args, cancelled, session, Some(sink));
+    sink.finish(&outcome.envelope);
+    outcome.envelope.payload = OutputPayload::None;
+    outcome
+}
+
+fn run(
+    args: &cli::CliArgs,
+    cancelled: &AtomicBool,
+    session: Option<&mut Session>,
+    sink: Option<&NdjsonSink>,
+) -> Outcome {
+    // A token budget needs the whole result before choosing what to keep.
+    let sink = sink.filter(|_| args.max_tokens.is_none());
+    let root = Path::new(&args.root);
+
+    if !root.is_dir() {
+        return Outcome::error(format!("Directory not found: {}", args.root));
+    }
+
+    let filter = exclusion::ExclusionFilter::new(&args.excludes, args.no_defaults)
+        .with_ignore_files(!args.no_ignore);
+    let start = Instant::now();
+
+    if !args.lines.is_empty() {
+        execute_lines(args, root, cancelled, start)
+    } else if args.graph {
+        execute_graph(args, root, &filter, cancelled, start, session, sink)
+    } else if args.callers.is_some() {
+        execute_callers(args, root, &filter, cancelled, start, session, sink)
+    } else if args.call_tree.is_some() {
+        execute_call_tree(args, root, &filter, cancelled, start, session)
+    } else if args.symbols {
+        execute_symbols(args, root, &filter, cancelled, start, session, sink)
+    } else if args.stats {
+        execute_stats(args, root, &filter, cancelled, start)
+    } else if args.count && args.find.is_some() {
+        execute_count(args, root, &filter, cancelled, start)
+    } else if let Some(ref find_pattern) = args.find {
+        execute_search(args, root, find_pattern, &filter, cancelled, start, sink)
+    } else if !args.globs.is_empty() {
+        execute_file_listing(args, root, &filter, cancelled, start)
+    } else {
+        execute_directory_hierarchy(args, root, &filter, cancelled, start)
+    }
+}
+
+fn execute_directory_hierarchy(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+) -> Outcome {
+    let tree = scanner::scan_directories(root, filter, cancelled, args.with_tests);
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+    finish(make_meta(elapsed, timed_out, 0, 0, None), OutputPayload::Tree(tree), vec![], timed_out)
+}
+
+fn execute_file_listing(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+) -> Outcome {
+    let files = scanner::find_files_filtered(root, &args.globs, filter, cancelled, args.with_tests);
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    let entries: Vec<FileEntry> = files
+        .iter()
+        .map(|f| FileEntry {
+            path: path_helper::normalized_relative(root, Path::new(f)),
+            contents: None,
+            error: None,
+            chunks: None,
+        })
+        .collect();
+
+    let total = entries.len();
+    let entries = apply_limit(entries, args.limit);
+    let matched = entries.len();
+
+    finish(make_meta(elapsed, timed_out, total, matched, None), OutputPayload::Files(entries), vec![], timed_out)
+}
+
+fn execute_search(
+    args: &cli::CliArgs,
+    root: &Path,
+    pattern: &str,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+    sink: Option<&NdjsonSink>,
+) -> Outcome {
+    let matcher = match Matcher::build(pattern, args.is_regex) {
+        Ok(m) => m,
+        Err(e) => {
+            return Outcome::error(e);
+        }
+    };
+
+    let (candidate_files, scanned) = match find_or_bail(args, root, filter, cancelled, start) {
+        Ok(v) => v,
+        Err(outcome) => return outcome,
+    };
+
+    let emit = |e: &FileEntry| if let Some(s) = sink { s.file(e) };
+    let entries = searcher::search_files(
+        &candidate_files, root, &matcher, args.line_numbers, args.context, cancelled, streaming(sink, &emit),
+    );
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    let file_errors = collect_file_errors(&entries);
+    let errored = file_errors.len();
+    let entries = apply_limit(entries, args.limit);
+    let (entries, budget) = match fit_budget(args, entries, |b, e| b.fit_files(e)) {
+        Ok(v) => v,
+        Err(e) => return Outcome::error(e),
+    };
+    let matched = entries.len();
+
+    let mut meta = make_meta(elapsed, timed_out, scanned, matched, None);
+    meta.files_errored = errored;
+    meta.budget = budget;
+    finish(meta, unless_streamed(sink, OutputPayload::Files(entries)), file_errors, timed_out)
+}
+
+fn execute_lines(
+    args: &cli::CliArgs,
+    root: &Path,
+    cancelled: &AtomicBool,
+    start: Instant,
+) -> Outcome {
+    let specs = match lines::parse_line_specs(&args.lines, root) {
+        Ok(s) => s,
+        Err(e) => {
+            return Outcome::error(e);
+        }
+    };
+
+    let specs = if args.auto_expand {
+        lines::expand_line_specs(&specs, root, args.with_comments)
+    } else {
+        specs
+    };
+
+    let entries = lines::extract_lines(&specs, root, args.line_numbers, cancelled);
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    let file_errors = collect_file_errors(&entries);
+    let errored = file_errors.len();
+    let entries = apply_limit(entries, args.limit);
+    let (entries, budget) = match fit_budget(args, entries, |b, e| b.fit_files(e)) {
+        Ok(v) => v,
+        Err(e) => return Outcome::error(e),
+    };
+    let matched = entries.len();
+
+    let mut meta = make_meta(elapsed, timed_out, 0, matched, None);
+    meta.files_errored = errored;
+    meta.budget = budget;
+    finish(meta, OutputPayload::Files(entries), file_errors, timed_out)
+}
+
+fn execute_graph(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+    session: Option<&mut Session>,
+    sink: Option<&NdjsonSink>,
+) -> Outcome {
+    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start) {
+        Ok(v) => v,
+        Err(outcome) => return outcome,
+    };
+
+    let mut session = session;
+    let aliases = match session.as_deref_mut() {
+        Some(session) => session.aliases(root),
+        None => Arc::new(alias::load_aliases(root)),
+    };
+    let targets = graph_targets(args, root);
+    // Dependents, cycles, rollups, the package inventory and orphans are only
+    // known once the whole graph is built.
+    let sink = sink.filter(|_| targets.is_none() && !args.cycles && args.by.is_none() && !args.external && !args.orphans);
+    let emit = |e: &GraphEntry| if let Some(s) = sink { s.graph(e) };
+    let on_file = streaming(sink, &emit);
+    let project = graph::Project::scanned(root, &files, &aliases);
+    let graph_entries = with_index(session, root, &files, cancelled, |index| match index {
+        Some(index) => graph::build_graph_indexed(&files, root, cancelled, &project, index, on_file),
+        None => graph::build_graph(&files, root, cancelled, &project, on_file),
+    });
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    if args.cycles {
+        let cycles = graph::cycles(&graph_entries, root, &project);
+        let cycles = apply_limit(cycles, args.limit);
+        let matched = cycles.len();
+        return finish(make_meta(elapsed, timed_out, scanned, matched, None), OutputPayload::Cycles(cycles), vec![], timed_out);
+    }
+
+    if let Some(grouping) = args.by {
+        let mut output = rollup::rollup(&graph_entries, root, grouping);
+        output.edges = apply_limit(output.edges, args.limit);
+        let matched = output.edges.len();
+        return finish(make_meta(elapsed, timed_out, scanned, matched, None), OutputPayload::Rollup(output), vec![], timed_out);
+    }
+
+    if args.external {
+        let mut output = external::report(graph_entries, &project);
+        output.files = apply_limit(output.files, args.limit);
+        let matched = output.files.len();
+        return finish(make_meta(elapsed, timed_out, scanned, matched, None), OutputPayload::External(output), vec![], timed_out);
+    }
+
+    if args.orphans {
+        let mut output = orphans::orphans(&graph_entries, root);
+        output.orphans = apply_limit(output.orphans, args.limit);
+        let matched = output.orphans.len();
+        return finish(make_meta(elapsed, timed_out, scanned, matched, None), OutputPayload::Orphans(output), vec![], timed_out);
+    }
+
+    if let Some(targets) = targets {
+        let errors: Vec<String> = targets
+            .iter()
+            .filter(|t| !graph_entries.iter().any(|e| e.file == **t))
+            .map(|t| format!("{}: not in the dependency graph", t))
+            .collect();
+        let levels = limit_levels(graph::dependents(&graph_entries, &targets), args.limit);
+        let matched = levels.iter().map(|l| l.files.len()).sum();
+        let payload = OutputPayload::Dependents(DependentsOutput { targets, levels });
+        return finish(make_meta(elapsed, timed_out, scanned, matched, None), payload, errors, timed_out);
+    }
+
+    let graph_entries = apply_limit(graph_entries, args.limit);
+    let matched = graph_entries.len();
+
+    finish(make_meta(elapsed, timed_out, scanned, matched, None), unless_streamed(sink, OutputPayload::Graph(graph_entries)), vec![], timed_out)
+}
+
+/// The `--dependents` or `--impact` files as graph keys, relative to `root`.
+fn graph_targets(args: &cli::CliArgs, root: &Path) -> Option<Vec<String>> {
+    let files: Vec<&String> = args.dependents.iter().chain(&args.impact).collect();
+    if files.is_empty() {
+        return None;
+    }
+    let mut targets: Vec<String> = Vec::new();
+    for file in files {
+        let target = graph::normalize_candidate(&path_helper::normalized_relative(root, &root.join(file)));
+        if !targets.contains(&target) {
+            targets.push(target);
+        }
+    }
+    Some(targets)
+}
+
+/// Applies `--limit` to the total number of dependents, nearest first.
+fn limit_levels(levels: Vec<DependentLevel>, limit: Option<usize>) -> Vec<DependentLevel> {
+    let mut remaining = match limit {
+        Some(n) => n,
+        None => return levels,
+    };
+    let mut kept = Vec::new();
+    for mut level in levels {
+        if remaining == 0 {
+            break;
+        }
+        level.files.truncate(remaining);
+        remaining -= level.files.len();
+        kept.push(level);
+    }
+    kept
+}
+
+fn execute_callers(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+    session: Option<&mut Session>,
+    sink: Option<&NdjsonSink>,
+) -> Outcome {
+    let name = args.callers.as_ref().unwrap();
+    let matcher = match callers::NameMatcher::build(name, args.is_regex, args.ignore_case) {
+        Ok(m) => m,
+        Err(e) => {
+            return Outcome::error(e);
+        }
+    };
+    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start) {
+        Ok(v) => v,
+        Err(outcome) => return outcome,
+    };
+
+    let emit_declaration = |d: &CallerDeclaration| if let Some(s) = sink { s.declaration(d) };
+    let emit_file = |f: &CallerFile| if let Some(s) = sink { s.caller(f) };
+    let stream = callers::CallerStream {
+        on_declaration: streaming(sink, &emit_declaration),
+        on_file: streaming(sink, &emit_file),
+    };
+    let callers_output = with_index(session, root, &files, cancelled, |index| match index {
+        Some(index) => callers::find_callers_indexed(&files, root, &matcher, args.with_tests, cancelled, index, stream),
+        None => callers::find_callers(&files, root, &matcher, args.with_tests, cancelled, stream),
+    });
+
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    let total_refs: usize = callers_output.files.iter().map(|f| f.sites.len()).sum();
+    let callers_output = if let Some(limit) = args.limit {
+        models::CallersOutput {
+            declarations: callers_output.declarations,
+            files: callers_output.files.into_iter().take(limit).collect(),
+        }
+    } else {
+        callers_output
+    };
+    let matched = callers_output.files.len();
+
+    finish(make_meta(elapsed, timed_out, scanned, matched, Some(total_refs)), unless_streamed(sink, OutputPayload::Callers(callers_output)), vec![], timed_out)
+}
+
+fn execute_call_tree(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+    session: Option<&mut Session>,
+) -> Outcome {
+    let (name, direction) = args.call_tree.as_ref().unwrap();
+    let depth = args.depth.unwrap_or_else(|| direction.default_depth());
+    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start) {
+        Ok(v) => v,
+        Err(outcome) => return outcome,
+    };
+
+    let output = with_index(session, root, &files, cancelled, |index| match index {
+        Some(index) => callers::call_tree_indexed(&files, root, name, *direction, depth, args.with_tests, cancelled, index),
+        None => callers::call_tree(&files, root, name, *direction, depth, args.with_tests, cancelled),
+    });
+
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+    let matched = output.roots.len();
+    finish(make_meta(elapsed, timed_out, scanned, matched, None), OutputPayload::CallTree(output), vec![], timed_out)
+}
+
+fn execute_symbols(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+    session: Option<&mut Session>,
+    sink: Option<&NdjsonSink>,
+) -> Outcome {
+    let matcher = match args.find.as_ref().map(|p| Matcher::build(p, args.is_regex)).transpose() {
+        Ok(m) => m,
+        Err(e) => {
+            return Outcome::error(e);
+        }
+    };
+
+    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start) {
+        Ok(v) => v,
+        Err(outcome) => return outcome,
+    };
+
+    let emit = |sf: &SymbolFile| if let Some(s) = sink {
+        match matcher {
+            Some(ref m) => {
+                if let Some(filtered) = symbols::filter_symbol_file(sf.clone(), m) {
+                    s.symbols(&filtered);
+                }
+            }
+            None => s.symbols(sf),
+        }
+    };
+    let on_file = streaming(sink, &emit);
+    let symbol_files = with_index(session, root, &files, cancelled, |index| match index {
+        Some(index) => {
+            let found = index.symbol_files(&files, root, args.with_comments, args.with_tests);
+            if let Some(emit) = on_file {
+                found.iter().for_each(emit);
+            }
+            found
+        }
+        None => symbols::extract_symbols(&files, root, cancelled, args.with_comments, args.with_tests, on_file),
+    });
+
+    let (symbol_files, total_matches) = if let Some(ref matcher) = matcher {
+        symbols::filter_symbols(symbol_files, matcher)
+    } else {
+        let count = symbol_files.iter().map(|sf| sf.symbols.len()).sum();
+        (symbol_files, count)
+    };
+
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    let sym_errors: Vec<String> = symbol_files
+        .iter()
+        .filter_map(|sf| sf.error.as_ref().map(|err| format!("{}: {}", sf.path, err)))
+        .collect();
+    let errored = sym_errors.len();
+    let symbol_files = apply_limit(symbol_files, args.limit);
+    let (symbol_files, budget) = match fit_budget(args, symbol_files, |b, f| b.fit_symbols(f, args.compact)) {
+        Ok(v) => v,
+        Err(e) => return Outcome::error(e),
+    };
+    let matched = symbol_files.len();
+
+    let total = if args.find.is_some() { Some(total_matches) } else { None };
+    let mut meta = make_meta(elapsed, timed_out, scanned, matched, total);
+    meta.files_errored = errored;
+    meta.budget = budget;
+
+    finish(meta, unless_streamed(sink, OutputPayload::Symbols { files: symbol_files, compact: args.compact }), sym_errors, timed_out)
+}
+
+fn execute_stats(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+) -> Outcome {
+    let (files, scanned) = match find_or_bail(args, root, filter, cancelled, start) {
+        Ok(v) => v,
+        Err(outcome) => return outcome,
+    };
+
+    let stats_output = stats::compute_stats(&files, root, cancelled);
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    finish(make_meta(elapsed, timed_out, scanned, scanned, None), OutputPayload::Stats(stats_output), vec![], timed_out)
+}
+
+fn execute_count(
+    args: &cli::CliArgs,
+    root: &Path,
+    filter: &exclusion::ExclusionFilter,
+    cancelled: &AtomicBool,
+    start: Instant,
+) -> Outcome {
+    let pattern = args.find.as_ref().unwrap();
+    let matcher = match Matcher::build(pattern, args.is_regex) {
+        Ok(m) => m,
+        Err(e) => {
+            return Outcome::error(e);
+        }
+    };
+
+    let (candidate_files, scanned) = match find_or_bail(args, root, filter, cancelled, start) {
+        Ok(v) => v,
+        Err(outcome) => return outcome,
+    };
+
+    let (count_entries, total) = count::count_matches(&candidate_files, root, &matcher, cancelled);
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    let count_entries = apply_limit(count_entries, args.limit);
+    let matched = count_entries.len();
+
+    finish(make_meta(elapsed, timed_out, scanned, matched, Some(total)), OutputPayload::Counts(count_entries), vec![], timed_out)
+}
+
+/// Builds the import graph and checks it against the layering rules. Exits
+/// with code 3 when any import breaks a rule.
+pub fn execute_check_deps(args: &cli::CliArgs, cancelled: &AtomicBool) -> Outcome {
+    let root = Path::new(&args.root);
+
+    if !root.is_dir() {
+        return Outcome::error(format!("Directory not found: {}", args.root));
+    }
+
+    let rules_path = match args.rules {
+        Some(ref path) => PathBuf::from(path),
+        None => root.join(rules::DEFAULT_PATH),
+    };
+    let rules = match Rules::load(&rules_path) {
+        Ok(rules) => rules,
+        Err(e) => return Outcome::error(e),
+    };
+
+    let filter = exclusion::ExclusionFilter::new(&args.excludes, args.no_defaults)
+        .with_ignore_files(!args.no_ignore);
+    let start = Instant::now();
+    let (files, scanned) = match find_or_bail(args, root, &filter, cancelled, start) {
+        Ok(v) => v,
+        Err(outcome) => return outcome,
+    };
+
+    let aliases = alias::load_aliases(root);
+    let project = graph::Project::scanned(root, &files, &aliases);
+    let graph_entries = with_index(None, root, &files, cancelled, |index| match index {
+        Some(index) => graph::build_graph_indexed(&files, root, cancelled, &project, index, None),
+        None => graph::build_graph(&files, root, cancelled, &project, None),
+    });
+    let mut locator = graph::ImportLocator::new(root, &project);
+    let violations = rules.check(&graph_entries, |from, to| locator.find(from, to));
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+
+    let failed = !violations.is_empty();
+    let violations = apply_limit(violations, args.limit);
+    let report = CheckReport { rules: path_helper::normalized_relative(root, &rules_path), violations };
+    let matched = report.violations.len();
+    let mut outcome = finish(make_meta(elapsed, timed_out, scanned, matched, None), OutputPayload::Check(report), vec![], timed_out);
+    if failed && outcome.code == 0 {
+        outcome.code = 3;
+    }
+    outcome
+}
+
+pub fn execute_index(command: cli::IndexCommand, args: &cli::CliArgs, cancelled: &AtomicBool) -> Outcome {
+    let root = Path::new(&args.root);
+
+    if !root.is_dir() {
+        return Outcome::error(format!("Directory not found: {}", args.root));
+    }
+
+    let filter = exclusion::ExclusionFilter::new(&args.excludes, args.no_defaults)
+        .with_ignore_files(!args.no_ignore);
+    let start = Instant::now();
+    let all_files = || scanner::find_files_filtered(root, &["*.*".to_owned()], &filter, cancelled, true);
+
+    let mut report = IndexReport {
+        path: path_helper::normalized_relative(root, &Index::path(root)),
+        state: "missing",
+        version: index::INDEX_VERSION,
+        files: 0,
+        symbols: 0,
+        changed: 0,
+        added: 0,
+        removed: 0,
+    };
+    let mut errors = Vec::new();
+    let mut scanned = 0;
+
+    match command {
+        cli::IndexCommand::Build => {
+            let files = all_files();
+            scanned = files.len();
+            let mut index = Index::load(root).unwrap_or_else(|_| Index::new(root));
+            let mut stats = index.refresh(&files, cancelled);
+            if !cancelled.load(Ordering::Relaxed) {
+                stats.removed += index.retain(&files);
+                if let Err(e) = index.save() {
+                    return Outcome::error(format!("Failed to write index: {}", e));
+                }
+                report.state = "fresh";
+            }
+            report.files = index.len();
+            report.symbols = index.symbol_count();
+            report.changed = stats.changed;
+            report.added = stats.added;
+            report.removed = stats.removed;
+        }
+        cli::IndexCommand::Status => match Index::load(root) {
+            Ok(index) => {
+                let files = all_files();
+                scanned = files.len();
+                let stats = index.diff(&files);
+                report.state = if stats.is_fresh() { "fresh" } else { "stale" };
+                report.files = index.len();
+                report.symbols = index.symbol_count();
+                report.changed = stats.changed;
+                report.added = stats.added;
+                report.removed = stats.removed;
+            }
+            Err(e) => {
+                if Index::path(root).is_file() {
+                    report.state = "unusable";
+                    errors.push(format!("{}: {}", report.path, e));
+                }
+            }
+        },
+        cli::IndexCommand::Clear => match Index::clear(root) {
+            Ok(true) => report.state = "cleared",
+            Ok(false) => {}
+            Err(e) => {
+                return Outcome::error(format!("Failed to remove index: {}", e));
+            }
+        },
+    }
+
+    let elapsed = start.elapsed().as_millis();
+    let timed_out = cancelled.load(Ordering::Relaxed);
+    finish(make_meta(elapsed, timed_out, scanned, 0, None), OutputPayload::Index(report), errors, timed_out)
+}
+
@@ -0,0 +1,181 @@
+use std::collections::{BTreeMap, HashMap};
+use std::path::Path;
+
+use crate::models::{Container, ContainerEdge, FilePair, GraphEntry, RollupOutput};
+use crate::path_helper;
+
+/// File pairs listed under each container edge.
+const TOP_PAIRS: usize = 5;
+
+/// Files that make their directory the root of a package.
+const PACKAGE_MANIFESTS: &[&str] = &["go.mod", "Cargo.toml", "package.json", "pyproject.toml", "setup.py"];
+
+/// The name used for the project root as a container.
+const ROOT: &str = ".";
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Grouping {
+    /// The file's own directory.
+    Dir,
+    /// The nearest enclosing Go module, Cargo crate, npm package or
+    /// workspace, C# project or top-level Python package.
+    Package,
+}
+
+impl Grouping {
+    pub fn name(self) -> &'static str {
+        match self {
+            Grouping::Dir => "dir",
+            Grouping::Package => "package",
+        }
+    }
+}
+
+/// Aggregates file-level imports into weighted edges between containers.
+/// Imports within a container are not reported.
+pub fn rollup(graph: &[GraphEntry], root: &Path, grouping: Grouping) -> RollupOutput {
+    let mut resolver = Resolver { root, grouping, packages: HashMap::new() };
+
+    let mut containers: BTreeMap<String, usize> = BTreeMap::new();
+    let mut pairs: BTreeMap<(String, String), Vec<(&str, &str)>> = BTreeMap::new();
+    for entry in graph {
+        let from = resolver.container(&entry.file);
+        *containers.entry(from.clone()).or_default() += 1;
+        for imp in &entry.imports {
+            let to = resolver.container(imp);
+            if to != from {
+                pairs.entry((from.clone(), to)).or_default().push((&entry.file, imp));
+            }
+        }
+    }
+
+    let mut edges: Vec<ContainerEdge> = pairs
+        .into_iter()
+        .map(|((from, to), pairs)| ContainerEdge { from, to, count: pairs.len(), top: top_pairs(pairs) })
+        .collect();
+    edges.sort_by(|a, b| b.count.cmp(&a.count).then_with(|| a.from.cmp(&b.from)).then_with(|| a.to.cmp(&b.to)));
+
+    RollupOutput {
+        by: grouping.name(),
+        containers: containers.into_iter().map(|(name, files)| Container { name, files }).collect(),
+        edges,
+    }
+}
+
+/// The pairs whose target is imported most often across the edge come first:
+/// those files are where the coupling concentrates.
+fn top_pairs(mut pairs: Vec<(&str, &str)>) -> Vec<FilePair> {
+    let mut imported: HashMap<&str, usize> = HashMap::new();
+    for (_, to) in &pairs {
+        *imported.entry(to).or_default() += 1;
+    }
+    pairs.sort_by(|a, b| imported[b.1].cmp(&imported[a.1]).then_with(|| a.1.cmp(b.1)).then_with(|| a.0.cmp(b.0)));
+    pairs
+        .into_iter()
+        .take(TOP_PAIRS)
+        .map(|(from, to)| FilePair { from: from.to_owned(), to: to.to_owned() })
+        .collect()
+}
+
+struct Resolver<'a> {
+    root: &'a Path,
+    grouping: Grouping,
+    /// Whether a directory (relative to the root) starts a package.
+    packages: HashMap<String, bool>,
+}
+
+impl Resolver<'_> {
+    fn container(&mut self, file: &str) -> String {
+        let dir = path_helper::parent_dir(file);
+        match self.grouping {
+            Grouping::Dir => if dir.is_empty() { ROOT.to_owned() } else { dir.to_owned() },
+            Grouping::Package => {
+                let mut dir = dir;
+                while !dir.is_empty() {
+                    if self.is_package(dir) {
+                        return dir.to_owned();
+                    }
+                    dir = path_helper::parent_dir(dir);
+                }
+                ROOT.to_owned()
+            }
+        }
+    }
+
+    fn is_package(&mut self, dir: &str) -> bool {
+        if let Some(&known) = self.packages.get(dir) {
+            return known;
+        }
+        let path = self.root.join(dir);
+        let is_package = PACKAGE_MANIFESTS.iter().any(|m| path.join(m).is_file())
+            || has_csproj(&path)
+            || (path.join("__init__.py").is_file() && !self.root.join(path_helper::parent_dir(dir)).join("__init__.py").is_file());
+        self.packages.insert(dir.to_owned(), is_package);
+        is_package
+    }
+}
+
+fn has_csproj(dir: &Path) -> bool {
+    std::fs::read_dir(dir)
+        .map(|entries| entries.flatten().any(|e| e.path().extension().map_or(false, |ext| ext == "csproj")))
+        .unwrap_or(false)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::{graph_entry, temp_project};
+
+    // ── by dir ──
+
+    #[test]
+    fn dir_rollup_counts_cross_directory_imports() {
+        let graph = vec![
+            graph_entry("main.rs", &["src/cli.rs"]),
+            graph_entry("src/cli.rs", &["src/lang/mod.rs", "src/lang/rust.rs", "src/models.rs"]),
+            graph_entry("src/query.rs", &["src/lang/mod.rs"]),
+            graph_entry("src/lang/mod.rs", &["src/lang/rust.rs"]),
+            graph_entry("src/lang/rust.rs", &[]),
+            graph_entry("src/models.rs", &[]),
+        ];
+        let out = rollup(&graph, Path::new("."), Grouping::Dir);
+        assert_eq!(out.by, "dir");
+        let containers: Vec<(&str, usize)> = out.containers.iter().map(|c| (c.name.as_str(), c.files)).collect();
+        assert_eq!(containers, vec![(".", 1), ("src", 3), ("src/lang", 2)]);
+
+        let edges: Vec<(&str, &str, usize)> = out.edges.iter().map(|e| (e.from.as_str(), e.to.as_str(), e.count)).collect();
+        assert_eq!(edges, vec![("src", "src/lang", 3), (".", "src", 1)]);
+        let top: Vec<(&str, &str)> = out.edges[0].top.iter().map(|p| (p.from.as_str(), p.to.as_str())).collect();
+        assert_eq!(top, vec![("src/cli.rs", "src/lang/mod.rs"), ("src/query.rs", "src/lang/mod.rs"), ("src/cli.rs", "src/lang/rust.rs")]);
+    }
+
+    #[test]
+    fn top_pairs_are_capped() {
+        let pairs: Vec<(String, String)> = (0..8).map(|i| (format!("a/{}.rs", i), "b/x.rs".to_owned())).collect();
+        let pairs: Vec<(&str, &str)> = pairs.iter().map(|(f, t)| (f.as_str(), t.as_str())).collect();
+        assert_eq!(top_pairs(pairs).len(), TOP_PAIRS);
+    }
+
+    // ── by package ──
+
+    #[test]
+    fn package_rollup_uses_nearest_manifest() {
+        let root = temp_project("rollup_packages", &[
+            ("Cargo.toml", ""),
+            ("crates/core/Cargo.toml", ""),
+            ("web/package.json", ""),
+            ("svc/go.mod", ""),
+            ("api/Api.csproj", ""),
+            ("py/app/__init__.py", ""),
+            ("py/app/sub/__init__.py", ""),
+        ]);
+        let mut resolver = Resolver { root: &root, grouping: Grouping::Package, packages: HashMap::new() };
+        assert_eq!(resolver.container("crates/core/src/lib.rs"), "crates/core");
+        assert_eq!(resolver.container("src/main.rs"), ".");
+        assert_eq!(resolver.container("web/src/app.ts"), "web");
+        assert_eq!(resolver.container("svc/internal/db/db.go"), "svc");
+        assert_eq!(resolver.container("api/Controllers/Home.cs"), "api");
+        assert_eq!(resolver.container("py/app/sub/models.py"), "py/app");
+        std::fs::remove_dir_all(&root).ok();
+    }
+}
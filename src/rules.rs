@@ -0,0 +1,318 @@
+use std::path::Path;
+
+use crate::glob;
+use crate::models::{DepViolation, GraphEntry};
+
+/// Where `src check-deps` looks for rules unless `--rules` says otherwise.
+pub const DEFAULT_PATH: &str = ".src/layers.toml";
+
+/// Dependency rules read from a small subset of TOML:
+///
+/// ```toml
+/// forbid = ["src/ui/** -> src/db/**"]
+///
+/// [[layer]]
+/// name = "ui"
+/// paths = ["src/ui/**"]
+///
+/// [[forbid]]
+/// from = "src/api/**"
+/// to = "src/cli/**"
+/// reason = "the API must not depend on the CLI"
+/// ```
+///
+/// Layers are listed highest first. A file may import its own layer or any
+/// layer below it; importing a higher layer breaks the rules. Files outside
+/// every layer are unconstrained. Forbidden edges apply regardless of layers.
+#[derive(Debug, Default)]
+pub struct Rules {
+    layers: Vec<Layer>,
+    forbidden: Vec<Forbidden>,
+}
+
+#[derive(Debug, Default)]
+struct Layer {
+    name: String,
+    paths: Vec<String>,
+}
+
+#[derive(Debug, Default)]
+struct Forbidden {
+    from: String,
+    to: String,
+    reason: Option<String>,
+}
+
+enum Section {
+    Top,
+    Layer,
+    Forbid,
+}
+
+enum Value {
+    Str(String),
+    List(Vec<String>),
+}
+
+impl Rules {
+    pub fn load(path: &Path) -> Result<Self, String> {
+        let text = std::fs::read_to_string(path)
+            .map_err(|e| format!("Failed to read rules {}: {}", path.display(), e))?;
+        Self::parse(&text).map_err(|e| format!("Invalid rules {}: {}", path.display(), e))
+    }
+
+    fn parse(text: &str) -> Result<Self, String> {
+        let mut rules = Rules::default();
+        let mut section = Section::Top;
+        let mut lines = text.lines().enumerate();
+
+        while let Some((n, raw)) = lines.next() {
+            let line = strip_comment(raw).trim();
+            if line.is_empty() {
+                continue;
+            }
+            let at = |msg: String| format!("line {}: {}", n + 1, msg);
+            match line {
+                "[[layer]]" => {
+                    rules.layers.push(Layer::default());
+                    section = Section::Layer;
+                    continue;
+                }
+                "[[forbid]]" => {
+                    rules.forbidden.push(Forbidden::default());
+                    section = Section::Forbid;
+                    continue;
+                }
+                _ if line.starts_with('[') => return Err(at(format!("unknown section {}", line))),
+                _ => {}
+            }
+
+            let (key, value) = line.split_once('=').ok_or_else(|| at(format!("expected key = value, found {}", line)))?;
+            let key = key.trim();
+            let mut value = value.trim().to_owned();
+            // Arrays may span lines until the closing bracket.
+            if value.starts_with('[') {
+                while !strip_comment(&value).trim_end().ends_with(']') {
+                    let (_, next) = lines.next().ok_or_else(|| at(format!("unterminated array for {}", key)))?;
+                    value.push(' ');
+                    value.push_str(strip_comment(next).trim());
+                }
+            }
+            let value = parse_value(strip_comment(&value).trim()).map_err(at)?;
+
+            match (&section, key, value) {
+                (Section::Top, "forbid", Value::List(edges)) => {
+                    for edge in edges {
+                        let (from, to) = edge
+                            .split_once("->")
+                            .ok_or_else(|| at(format!("forbidden edge must look like 'from -> to': {}", edge)))?;
+                        rules.forbidden.push(Forbidden { from: from.trim().to_owned(), to: to.trim().to_owned(), reason: None });
+                    }
+                }
+                (Section::Layer, "name", Value::Str(name)) => rules.layers.last_mut().unwrap().name = name,
+                (Section::Layer, "paths", Value::List(paths)) => rules.layers.last_mut().unwrap().paths = paths,
+                (Section::Layer, "paths", Value::Str(path)) => rules.layers.last_mut().unwrap().paths = vec![path],
+                (Section::Forbid, "from", Value::Str(from)) => rules.forbidden.last_mut().unwrap().from = from,
+                (Section::Forbid, "to", Value::Str(to)) => rules.forbidden.last_mut().unwrap().to = to,
+                (Section::Forbid, "reason", Value::Str(reason)) => rules.forbidden.last_mut().unwrap().reason = Some(reason),
+                _ => return Err(at(format!("unexpected key {}", key))),
+            }
+        }
+
+        for (i, layer) in rules.layers.iter().enumerate() {
+            if layer.name.is_empty() || layer.paths.is_empty() {
+                return Err(format!("layer {} needs a name and paths", i + 1));
+            }
+        }
+        if let Some(f) = rules.forbidden.iter().find(|f| f.from.is_empty() || f.to.is_empty()) {
+            return Err(format!("forbidden edge needs both from and to (from = '{}', to = '{}')", f.from, f.to));
+        }
+        if rules.layers.is_empty() && rules.forbidden.is_empty() {
+            return Err("no layers or forbidden edges defined".into());
+        }
+        Ok(rules)
+    }
+
+    /// Every import in `graph` that breaks a rule, traced back to its import
+    /// statement by `locate(file, target)`.
+    pub fn check(
+        &self,
+        graph: &[GraphEntry],
+        mut locate: impl FnMut(&str, &str) -> Option<(usize, String)>,
+    ) -> Vec<DepViolation> {
+        let mut violations = Vec::new();
+        for entry in graph {
+            let from_layer = self.layer_of(&entry.file);
+            for imp in &entry.imports {
+                let mut broken = |rule: String, reason: Option<&str>| violations.push(DepViolation {
+                    file: entry.file.clone(),
+                    line: None,
+                    import: None,
+                    target: imp.clone(),
+                    rule,
+                    reason: reason.map(str::to_owned),
+                });
+                for f in &self.forbidden {
+                    if glob::matches_path(&entry.file, &f.from) && glob::matches_path(imp, &f.to) {
+                        broken(format!("{} -> {}", f.from, f.to), f.reason.as_deref());
+                    }
+                }
+                if let (Some(from), Some(to)) = (from_layer, self.layer_of(imp)) {
+                    if to < from {
+                        let (from, to) = (&self.layers[from].name, &self.layers[to].name);
+                        broken(format!("layer {} -> {}", from, to), Some(&format!("{} may not import the higher layer {}", from, to)));
+                    }
+                }
+            }
+        }
+        for v in &mut violations {
+            if let Some((line, import)) = locate(&v.file, &v.target) {
+                v.line = Some(line);
+                v.import = Some(import);
+            }
+        }
+        violations
+    }
+
+    fn layer_of(&self, file: &str) -> Option<usize> {
+        self.layers.iter().position(|l| l.paths.iter().any(|p| glob::matches_path(file, p)))
+    }
+}
+
+/// Drops a `#` comment that is not inside a string.
+fn strip_comment(line: &str) -> &str {
+    let mut quote: Option<char> = None;
+    let mut escaped = false;
+    for (i, c) in line.char_indices() {
+        if escaped {
+            escaped = false;
+            continue;
+        }
+        match (quote, c) {
+            (Some('"'), '\\') => escaped = true,
+            (Some(q), c) if c == q => quote = None,
+            (None, '"' | '\'') => quote = Some(c),
+            (None, '#') => return &line[..i],
+            _ => {}
+        }
+    }
+    line
+}
+
+fn parse_value(text: &str) -> Result<Value, String> {
+    if let Some(inner) = text.strip_prefix('[') {
+        let inner = inner.strip_suffix(']').ok_or("unterminated array")?;
+        let mut items = Vec::new();
+        let mut rest = inner.trim();
+        while !rest.is_empty() {
+            let (item, after) = parse_string(rest)?;
+            items.push(item);
+            rest = after.trim_start();
+            rest = rest.strip_prefix(',').unwrap_or(rest).trim_start();
+        }
+        return Ok(Value::List(items));
+    }
+    match parse_string(text)? {
+        (s, "") => Ok(Value::Str(s)),
+        (_, after) => Err(format!("unexpected text after string: {}", after)),
+    }
+}
+
+/// Parses a leading double- or single-quoted string and returns the rest.
+fn parse_string(text: &str) -> Result<(String, &str), String> {
+    let quote = match text.chars().next() {
+        Some(q @ ('"' | '\'')) => q,
+        _ => return Err(format!("expected a quoted string, found {}", text)),
+    };
+    let mut out = String::new();
+    let mut chars = text[1..].char_indices();
+    while let Some((i, c)) = chars.next() {
+        match c {
+            c if c == quote => return Ok((out, &text[i + 2..])),
+            '\\' if quote == '"' => match chars.next() {
+                Some((_, 'n')) => out.push('\n'),
+                Some((_, 't')) => out.push('\t'),
+                Some((_, e)) => out.push(e),
+                None => break,
+            },
+            c => out.push(c),
+        }
+    }
+    Err(format!("unterminated string: {}", text))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::graph_entry;
+
+    const RULES: &str = r#"
+# Architecture rules
+forbid = ["src/ui/** -> src/db/**"]
+
+[[layer]]
+name = "ui"
+paths = ["src/ui/**"]
+
+[[layer]]
+name = "service"
+paths = [
+  "src/services/**",  # business logic
+  "src/jobs/**",
+]
+
+[[layer]]
+name = "db"
+paths = "src/db/**"
+
+[[forbid]]
+from = "src/**"
+to = "scripts/**"
+reason = "scripts are not part of the app # really"
+"#;
+
+    // ── parsing ──
+
+    #[test]
+    fn parses_layers_and_forbidden_edges() {
+        let rules = Rules::parse(RULES).unwrap();
+        let layers: Vec<(&str, usize)> = rules.layers.iter().map(|l| (l.name.as_str(), l.paths.len())).collect();
+        assert_eq!(layers, vec![("ui", 1), ("service", 2), ("db", 1)]);
+        assert_eq!(rules.forbidden.len(), 2);
+        assert_eq!(rules.forbidden[0].from, "src/ui/**");
+        assert_eq!(rules.forbidden[0].to, "src/db/**");
+        assert_eq!(rules.forbidden[1].reason.as_deref(), Some("scripts are not part of the app # really"));
+    }
+
+    #[test]
+    fn parse_errors_name_the_line() {
+        assert_eq!(Rules::parse("[[layer]]\nname = ui\n").unwrap_err(), "line 2: expected a quoted string, found ui");
+        assert!(Rules::parse("[rules]\n").unwrap_err().contains("unknown section"));
+        assert!(Rules::parse("forbid = [\"a/** b/**\"]\n").unwrap_err().contains("from -> to"));
+        assert!(Rules::parse("[[layer]]\nname = \"ui\"\n").unwrap_err().contains("needs a name and paths"));
+        assert!(Rules::parse("# nothing\n").unwrap_err().contains("no layers"));
+        assert!(Rules::parse("forbid = [\"a -> b\",\n").unwrap_err().contains("unterminated array"));
+    }
+
+    // ── checking ──
+
+    #[test]
+    fn upward_layer_imports_and_forbidden_edges_are_reported() {
+        let rules = Rules::parse(RULES).unwrap();
+        let graph = vec![
+            graph_entry("src/ui/page.ts", &["src/services/orders.ts", "src/db/client.ts"]),
+            graph_entry("src/db/client.ts", &["src/jobs/sync.ts"]),
+            graph_entry("src/services/orders.ts", &["src/db/client.ts", "src/util.ts"]),
+            graph_entry("src/util.ts", &["src/ui/page.ts"]),
+        ];
+        let found: Vec<String> = rules
+            .check(&graph, |_, _| None)
+            .iter()
+            .map(|v| format!("{} -> {} [{}]", v.file, v.target, v.rule))
+            .collect();
+        assert_eq!(found, vec![
+            "src/ui/page.ts -> src/db/client.ts [src/ui/** -> src/db/**]",
+            "src/db/client.ts -> src/jobs/sync.ts [layer db -> service]",
+        ]);
+    }
+}
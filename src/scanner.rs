@@ -4,9 +4,10 @@ use std::sync::atomic::{AtomicBool, Ordering};
 
 use rayon::prelude::*;
 
-use crate::exclusion::ExclusionFilter;
-use crate::glob;
+use crate::exclusion::{ExclusionFilter, IgnoreStack};
+use crate::glob::GlobSet;
 use crate::models::ScanResult;
+use crate::path_helper;
 
 const SOURCE_EXTENSIONS: &[&str] = &[
     "cs", "ts", "tsx", "js", "jsx", "py", "rb", "go", "rs",
@@ -38,19 +39,31 @@ pub fn scan_directories(
     filter: &ExclusionFilter,
     cancelled: &AtomicBool,
     include_tests: bool,
+) -> ScanResult {
+    let stack = filter.root_stack(root);
+    scan_directory(root, root, &stack, filter, cancelled, include_tests)
+}
+
+fn scan_directory(
+    root: &Path,
+    dir: &Path,
+    stack: &IgnoreStack,
+    filter: &ExclusionFilter,
+    cancelled: &AtomicBool,
+    include_tests: bool,
 ) -> ScanResult {
     if cancelled.load(Ordering::Relaxed) {
         return ScanResult { name: String::new(), children: None, files: None };
     }
 
-    let dir_name = root.file_name()
+    let dir_name = dir.file_name()
         .map(|n| n.to_string_lossy().into_owned())
-        .unwrap_or_else(|| root.to_string_lossy().into_owned());
+        .unwrap_or_else(|| dir.to_string_lossy().into_owned());
 
     let mut files = Vec::new();
     let mut subdirs = Vec::new();
 
-    let entries = match fs::read_dir(root) {
+    let entries = match fs::read_dir(dir) {
         Ok(rd) => rd,
         Err(_) => return ScanResult { name: dir_name, children: None, files: None },
     };
@@ -66,24 +79,31 @@ pub fn scan_directories(
         if ft.is_file() {
             if is_source_file(&name_str) {
                 if include_tests || !is_test_file(&name_str) {
-                    files.push(name_str.into_owned());
+                    let rel = path_helper::normalized_relative(root, &entry.path());
+                    if !filter.is_ignored(stack, &rel, false) {
+                        files.push(name_str.into_owned());
+                    }
                 }
             }
         } else if ft.is_dir() && !filter.is_excluded(&name_str) {
             if include_tests || !matches!(name_str.to_ascii_lowercase().as_str(),
                 "tests" | "test" | "__tests__" | "spec" | "specs") {
-                subdirs.push(entry.path());
+                let rel = path_helper::normalized_relative(root, &entry.path());
+                if !filter.is_ignored(stack, &rel, true) {
+                    subdirs.push((entry.path(), rel));
+                }
             }
         }
     }
 
     let children: Vec<ScanResult> = subdirs
         .par_iter()
-        .filter_map(|subdir| {
+        .filter_map(|(subdir, rel)| {
             if cancelled.load(Ordering::Relaxed) {
                 return None;
             }
-            let child = scan_directories(subdir, filter, cancelled, include_tests);
+            let child_stack = filter.enter_dir(stack, subdir, rel);
+            let child = scan_directory(root, subdir, &child_stack, filter, cancelled, include_tests);
             if child.files.is_some() || child.children.is_some() {
                 Some(child)
             } else {
@@ -151,7 +171,7 @@ pub fn find_files_filtered(
         files
     } else {
         files.into_iter().filter(|f| {
-            let rel = crate::path_helper::normalized_relative(root, std::path::Path::new(f));
+            let rel = path_helper::normalized_relative(root, Path::new(f));
             !is_test_file(&rel)
         }).collect()
     }
@@ -163,8 +183,9 @@ pub fn find_files(
     filter: &ExclusionFilter,
     cancelled: &AtomicBool,
 ) -> Vec<String> {
-    let has_path_glob = globs.iter().any(|g| g.contains('/') || g.contains('\\'));
-    let mut results = find_files_parallel(root, root, globs, has_path_glob, filter, cancelled);
+    let glob_set = GlobSet::new(globs);
+    let stack = filter.root_stack(root);
+    let mut results = find_files_parallel(root, root, &stack, &glob_set, filter, cancelled);
     results.sort_unstable_by(|a, b| a.to_ascii_lowercase().cmp(&b.to_ascii_lowercase()));
     results
 }
@@ -172,8 +193,8 @@ pub fn find_files(
 fn find_files_parallel(
     root: &Path,
     dir: &Path,
-    globs: &[String],
-    has_path_glob: bool,
+    stack: &IgnoreStack,
+    glob_set: &GlobSet,
     filter: &ExclusionFilter,
     cancelled: &AtomicBool,
 ) -> Vec<String> {
@@ -198,24 +219,25 @@ fn find_files_parallel(
         let name_str = name.to_string_lossy();
 
         if ft.is_file() {
-            let matched = if has_path_glob {
-                let abs = entry.path();
-                let rel = crate::path_helper::normalized_relative(root, &abs);
-                glob::matches_any(&name_str, globs) || glob::matches_any(&rel, globs)
-            } else {
-                glob::matches_any(&name_str, globs)
-            };
-            if matched {
-                files.push(entry.path().to_string_lossy().into_owned());
+            let abs = entry.path();
+            let rel = path_helper::normalized_relative(root, &abs);
+            if glob_set.is_match(&name_str, &rel) && !filter.is_ignored(stack, &rel, false) {
+                files.push(abs.to_string_lossy().into_owned());
             }
         } else if ft.is_dir() && !filter.is_excluded(&name_str) {
-            subdirs.push(entry.path());
+            let rel = path_helper::normalized_relative(root, &entry.path());
+            if !filter.is_ignored(stack, &rel, true) {
+                subdirs.push((entry.path(), rel));
+            }
         }
     }
 
     let sub_results: Vec<Vec<String>> = subdirs
         .par_iter()
-        .map(|subdir| find_files_parallel(root, subdir, globs, has_path_glob, filter, cancelled))
+        .map(|(subdir, rel)| {
+            let child_stack = filter.enter_dir(stack, subdir, rel);
+            find_files_parallel(root, subdir, &child_stack, glob_set, filter, cancelled)
+        })
         .collect();
 
     for sub in sub_results {
@@ -7,6 +7,7 @@ use regex::Regex;
 
 use crate::file_reader;
 use crate::models::{FileChunk, FileEntry};
+use crate::ndjson::OnItem;
 use crate::path_helper;
 
 pub enum Matcher {
@@ -86,6 +87,7 @@ pub fn search_files(
     line_numbers: bool,
     context: Option<usize>,
     cancelled: &AtomicBool,
+    on_file: OnItem<FileEntry>,
 ) -> Vec<FileEntry> {
     let mut results: Vec<FileEntry> = file_paths
         .par_iter()
@@ -93,7 +95,11 @@ pub fn search_files(
             if cancelled.load(Ordering::Relaxed) {
                 return None;
             }
-            process_file(file_path, root, matcher, line_numbers, context)
+            let entry = process_file(file_path, root, matcher, line_numbers, context)?;
+            if let Some(emit) = on_file {
+                emit(&entry);
+            }
+            Some(entry)
         })
         .collect();
 
@@ -0,0 +1,501 @@
+use std::io::{self, BufRead, Write};
+use std::path::Path;
+use std::sync::atomic::AtomicBool;
+use std::sync::Arc;
+
+use crate::cli;
+use crate::file_reader;
+use crate::json::{self, JsonValue};
+use crate::query::{self, Session};
+use crate::yaml_output;
+
+/// Upper bound on file contents kept warm between requests.
+pub const CONTENT_CACHE_BYTES: usize = 256 * 1024 * 1024;
+
+const PARSE_ERROR: i32 = -32700;
+const INVALID_REQUEST: i32 = -32600;
+pub const METHOD_NOT_FOUND: i32 = -32601;
+pub const INVALID_PARAMS: i32 = -32602;
+
+/// Methods accepted by the server, each mapping to one CLI mode.
+pub const METHODS: &[&str] = &["tree", "files", "search", "count", "lines", "symbols", "graph", "callers", "call-tree", "callees", "stats"];
+
+/// Serves line-delimited JSON-RPC 2.0 over stdin/stdout until EOF or a
+/// `shutdown` request. `base` supplies the default root and scan options.
+pub fn serve_stdio(base: &cli::CliArgs) -> i32 {
+    file_reader::enable_cache(CONTENT_CACHE_BYTES);
+    let mut session = Session::default();
+    run_stdio(|line| handle_message(line, base, &mut session));
+    0
+}
+
+/// Feeds each non-empty stdin line to `handle` and writes back the response
+/// it returns, until EOF or until `handle` asks to stop.
+pub fn run_stdio(mut handle: impl FnMut(&str) -> (Option<String>, bool)) {
+    let stdin = io::stdin();
+    let stdout = io::stdout();
+    for line in stdin.lock().lines() {
+        let line = match line {
+            Ok(l) => l,
+            Err(_) => break,
+        };
+        if line.trim().is_empty() {
+            continue;
+        }
+        let (response, stop) = handle(&line);
+        if let Some(response) = response {
+            let mut out = stdout.lock();
+            if writeln!(out, "{}", response).and_then(|_| out.flush()).is_err() {
+                break;
+            }
+        }
+        if stop {
+            break;
+        }
+    }
+}
+
+/// Handles one JSON-RPC message. Returns the response line (none for
+/// notifications) and whether the server should stop.
+fn handle_message(line: &str, base: &cli::CliArgs, session: &mut Session) -> (Option<String>, bool) {
+    let (request, id) = match parse_request(line) {
+        Ok(r) => r,
+        Err(response) => return (Some(response), false),
+    };
+    let method = request.get("method").and_then(JsonValue::as_str).unwrap_or("");
+
+    let (result, stop) = match method {
+        "shutdown" => (Ok("null".to_owned()), true),
+        m if METHODS.contains(&m) => (run_method(m, request.get("params"), base, session), false),
+        other => (Err((METHOD_NOT_FOUND, format!("Unknown method: {}", other))), false),
+    };
+
+    let id = match id {
+        Some(id) => id,
+        None => return (None, stop),
+    };
+    let response = match result {
+        Ok(body) => result_response(&id, &body),
+        Err((code, msg)) => error_response(&id, code, &msg),
+    };
+    (Some(response), stop)
+}
+
+/// Parses a request line into the message and its id (`None` for
+/// notifications), or the error response to send back.
+pub fn parse_request(line: &str) -> Result<(JsonValue, Option<JsonValue>), String> {
+    let request = json::parse(line).map_err(|e| error_response(&JsonValue::Null, PARSE_ERROR, &e))?;
+    let id = request.get("id").cloned();
+    let has_method = request.get("method").and_then(JsonValue::as_str).is_some();
+    if request.as_object().is_none() || !has_method {
+        let reply_id = id.unwrap_or(JsonValue::Null);
+        return Err(error_response(&reply_id, INVALID_REQUEST, "Expected an object with a method"));
+    }
+    Ok((request, id))
+}
+
+/// `body` must already be serialized JSON.
+pub fn result_response(id: &JsonValue, body: &str) -> String {
+    format!("{{\"jsonrpc\":\"2.0\",\"id\":{},\"result\":{}}}", id, body)
+}
+
+pub fn error_response(id: &JsonValue, code: i32, message: &str) -> String {
+    format!(
+        "{{\"jsonrpc\":\"2.0\",\"id\":{},\"error\":{{\"code\":{},\"message\":{}}}}}",
+        id,
+        code,
+        JsonValue::String(message.to_owned())
+    )
+}
+
+fn run_method(
+    method: &str,
+    params: Option<&JsonValue>,
+    base: &cli::CliArgs,
+    session: &mut Session,
+) -> Result<String, (i32, String)> {
+    let args = method_args(method, params, base).map_err(|e| (INVALID_PARAMS, e))?;
+    let cancelled = Arc::new(AtomicBool::new(false));
+    query::arm_timeout(&cancelled, args.timeout);
+    let outcome = query::execute(&args, &cancelled, Some(session));
+    Ok(yaml_output::envelope_to_json(&outcome.envelope))
+}
+
+fn string_list(value: &JsonValue, name: &str) -> Result<Vec<String>, String> {
+    match value {
+        JsonValue::String(s) => Ok(vec![s.clone()]),
+        v => v
+            .as_array()
+            .ok_or_else(|| format!("{} must be a string or an array of strings", name))?
+            .iter()
+            .map(|i| i.as_str().map(str::to_owned).ok_or_else(|| format!("{} must be strings", name)))
+            .collect(),
+    }
+}
+
+/// Turns a method and its params into `CliArgs` by building the equivalent
+/// command line, so requests get exactly the CLI's validation.
+pub fn method_args(method: &str, params: Option<&JsonValue>, base: &cli::CliArgs) -> Result<cli::CliArgs, String> {
+    let empty = Vec::new();
+    let fields = match params {
+        None | Some(JsonValue::Null) => &empty[..],
+        Some(v) => v.as_object().ok_or("params must be an object")?,
+    };
+    let param = |name: &str| fields.iter().find(|(k, _)| flag_name(k) == name).map(|(_, v)| v);
+    let required_str = |name: &str| -> Result<String, String> {
+        param(name)
+            .and_then(JsonValue::as_str)
+            .map(str::to_owned)
+            .ok_or_else(|| format!("{} requires a string '{}' param", method, name))
+    };
+
+    let mut argv: Vec<String> = Vec::new();
+    let mut consumed: Vec<&str> = Vec::new();
+    match method {
+        "search" | "count" => {
+            argv.push("--find".into());
+            argv.push(required_str("pattern")?);
+            consumed.push("pattern");
+            if method == "count" {
+                argv.push("--count".into());
+            }
+        }
+        "lines" => {
+            let specs = match param("specs") {
+                Some(v) => string_list(v, "specs")?,
+                None => return Err("lines requires a 'specs' param".into()),
+            };
+            argv.push("--lines".into());
+            argv.extend(specs);
+            consumed.push("specs");
+        }
+        "callers" => {
+            argv.push("--callers".into());
+            argv.push(required_str("name")?);
+            consumed.push("name");
+            if let Some(v) = param("ignore-case") {
+                if v.as_bool().ok_or("ignore-case must be a boolean")? {
+                    argv.push("--ignore-case".into());
+                }
+                consumed.push("ignore-case");
+            }
+        }
+        "call-tree" | "callees" => {
+            argv.push(format!("--{}", method));
+            argv.push(required_str("name")?);
+            consumed.push("name");
+            if let Some(v) = param("depth") {
+                let n = v.as_u64().ok_or("depth must be a positive integer")?;
+                argv.push("--depth".into());
+                argv.push(n.to_string());
+                consumed.push("depth");
+            }
+        }
+        "symbols" => {
+            argv.push("--symbols".into());
+            if param("pattern").is_some() {
+                argv.push("--find".into());
+                argv.push(required_str("pattern")?);
+                consumed.push("pattern");
+            }
+        }
+        "graph" => {
+            argv.push("--graph".into());
+            if param("dependents").is_some() {
+                argv.push("--dependents".into());
+                argv.push(required_str("dependents")?);
+                consumed.push("dependents");
+            }
+            if let Some(v) = param("impact") {
+                argv.push("--impact".into());
+                argv.extend(string_list(v, "impact")?);
+                consumed.push("impact");
+            }
+            if param("by").is_some() {
+                argv.push("--by".into());
+                argv.push(required_str("by")?);
+                consumed.push("by");
+            }
+            if let Some(v) = param("cycles") {
+                if v.as_bool().ok_or("cycles must be a boolean")? {
+                    argv.push("--cycles".into());
+                }
+                consumed.push("cycles");
+            }
+            if let Some(v) = param("external") {
+                if v.as_bool().ok_or("external must be a boolean")? {
+                    argv.push("--external".into());
+                }
+                consumed.push("external");
+            }
+            if let Some(v) = param("orphans") {
+                if v.as_bool().ok_or("orphans must be a boolean")? {
+                    argv.push("--orphans".into());
+                }
+                consumed.push("orphans");
+            }
+        }
+        "stats" => argv.push("--stats".into()),
+        "files" => {
+            if param("glob").is_none() {
+                return Err("files requires a 'glob' param".into());
+            }
+        }
+        _ => {}
+    }
+
+    let mut has_dir = false;
+    for (key, value) in fields {
+        let flag = flag_name(key);
+        if consumed.contains(&flag.as_str()) {
+            continue;
+        }
+        match (flag.as_str(), value) {
+            ("dir", JsonValue::String(dir)) => {
+                has_dir = true;
+                argv.push("--dir".into());
+                argv.push(Path::new(&base.root).join(dir).to_string_lossy().into_owned());
+            }
+            ("glob", JsonValue::String(g)) => {
+                argv.push("--glob".into());
+                argv.push(g.clone());
+            }
+            ("glob", JsonValue::Array(items)) | ("exclude", JsonValue::Array(items)) => {
+                for item in items {
+                    let s = item.as_str().ok_or_else(|| format!("{} must contain strings", key))?;
+                    argv.push(format!("--{}", flag));
+                    argv.push(s.to_owned());
+                }
+            }
+            ("exclude", JsonValue::String(s)) => {
+                argv.push("--exclude".into());
+                argv.push(s.clone());
+            }
+            ("line-numbers", JsonValue::Bool(enabled)) => {
+                if !enabled {
+                    argv.push("--no-line-numbers".into());
+                }
+            }
+            ("context" | "limit" | "timeout" | "max-tokens", v) => {
+                let n = v.as_u64().ok_or_else(|| format!("{} must be a non-negative integer", key))?;
+                argv.push(format!("--{}", flag));
+                argv.push(n.to_string());
+            }
+            ("regex" | "compact" | "with-comments" | "with-tests" | "auto-expand" | "no-defaults" | "no-ignore", v) => {
+                if v.as_bool().ok_or_else(|| format!("{} must be a boolean", key))? {
+                    argv.push(format!("--{}", flag));
+                }
+            }
+            _ => return Err(format!("Unsupported param for {}: {}", method, key)),
+        }
+    }
+
+    if !has_dir {
+        argv.push("--dir".into());
+        argv.push(base.root.clone());
+    }
+    for exclude in &base.excludes {
+        argv.push("--exclude".into());
+        argv.push(exclude.clone());
+    }
+    if base.no_defaults {
+        argv.push("--no-defaults".into());
+    }
+    if base.no_ignore {
+        argv.push("--no-ignore".into());
+    }
+    if let (Some(path), Some(_)) = (&base.tokenizer, param("max-tokens")) {
+        argv.push("--tokenizer".into());
+        argv.push(path.clone());
+    }
+    if let (Some(secs), None) = (base.timeout, param("timeout")) {
+        argv.push("--timeout".into());
+        argv.push(secs.to_string());
+    }
+
+    match cli::parse_args(&argv)? {
+        cli::CliAction::Run(args) => Ok(args),
+        _ => Err("Unsupported params".into()),
+    }
+}
+
+/// Accepts both CLI spelling (`with-tests`) and camelCase (`withTests`).
+fn flag_name(key: &str) -> String {
+    let mut out = String::with_capacity(key.len() + 2);
+    for c in key.chars() {
+        if c.is_ascii_uppercase() {
+            out.push('-');
+            out.push(c.to_ascii_lowercase());
+        } else {
+            out.push(c);
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn base() -> cli::CliArgs {
+        match cli::parse_args(&["--dir".to_owned(), "/repo".to_owned()]).unwrap() {
+            cli::CliAction::Run(a) => a,
+            _ => unreachable!(),
+        }
+    }
+
+    fn params(src: &str) -> JsonValue {
+        json::parse(src).unwrap()
+    }
+
+    // ── method_args ──
+
+    #[test]
+    fn search_maps_to_find() {
+        let a = method_args("search", Some(&params(r#"{"pattern":"TODO","glob":["*.rs","*.ts"],"context":2}"#)), &base()).unwrap();
+        assert_eq!(a.find.as_deref(), Some("TODO"));
+        assert_eq!(a.globs, vec!["*.rs", "*.ts"]);
+        assert_eq!(a.context, Some(2));
+        assert_eq!(a.root, "/repo");
+    }
+
+    #[test]
+    fn symbols_accepts_optional_pattern() {
+        let a = method_args("symbols", Some(&params(r#"{"pattern":"handle"}"#)), &base()).unwrap();
+        assert!(a.symbols);
+        assert_eq!(a.find.as_deref(), Some("handle"));
+        let a = method_args("symbols", None, &base()).unwrap();
+        assert!(a.find.is_none());
+    }
+
+    #[test]
+    fn count_sets_count_flag() {
+        let a = method_args("count", Some(&params(r#"{"pattern":"x","regex":true}"#)), &base()).unwrap();
+        assert!(a.count);
+        assert!(a.is_regex);
+    }
+
+    #[test]
+    fn camel_case_params_accepted() {
+        let a = method_args("symbols", Some(&params(r#"{"withTests":true,"withComments":true,"lineNumbers":false}"#)), &base()).unwrap();
+        assert!(a.symbols && a.with_tests && a.with_comments);
+        assert!(!a.line_numbers);
+    }
+
+    #[test]
+    fn lines_accepts_string_or_array() {
+        let a = method_args("lines", Some(&params(r#"{"specs":"a.rs:1:2"}"#)), &base()).unwrap();
+        assert_eq!(a.lines, vec!["a.rs:1:2"]);
+        let a = method_args("lines", Some(&params(r#"{"specs":["a.rs:1:2","b.rs:3:4"],"autoExpand":true}"#)), &base()).unwrap();
+        assert_eq!(a.lines.len(), 2);
+        assert!(a.auto_expand);
+    }
+
+    #[test]
+    fn dir_is_relative_to_server_root() {
+        let a = method_args("graph", Some(&params(r#"{"dir":"pkg"}"#)), &base()).unwrap();
+        assert!(a.graph);
+        assert_eq!(Path::new(&a.root), Path::new("/repo/pkg"));
+    }
+
+    #[test]
+    fn graph_query_params() {
+        let a = method_args("graph", Some(&params(r#"{"dependents":"src/models.rs"}"#)), &base()).unwrap();
+        assert_eq!(a.dependents.as_deref(), Some("src/models.rs"));
+        let a = method_args("graph", Some(&params(r#"{"impact":["a.rs","b.rs"],"limit":3}"#)), &base()).unwrap();
+        assert_eq!(a.impact, vec!["a.rs", "b.rs"]);
+        assert_eq!(a.limit, Some(3));
+        assert!(method_args("graph", Some(&params(r#"{"impact":[1]}"#)), &base()).unwrap_err().contains("impact"));
+        assert!(method_args("graph", Some(&params(r#"{"cycles":true}"#)), &base()).unwrap().cycles);
+        assert!(method_args("graph", Some(&params(r#"{"external":true}"#)), &base()).unwrap().external);
+        assert!(method_args("graph", Some(&params(r#"{"orphans":true}"#)), &base()).unwrap().orphans);
+        let a = method_args("graph", Some(&params(r#"{"by":"package"}"#)), &base()).unwrap();
+        assert_eq!(a.by, Some(crate::rollup::Grouping::Package));
+    }
+
+    #[test]
+    fn callers_params() {
+        let a = method_args("callers", Some(&params(r#"{"name":"run","ignore-case":true}"#)), &base()).unwrap();
+        assert_eq!(a.callers.as_deref(), Some("run"));
+        assert!(a.ignore_case);
+        assert!(!method_args("callers", Some(&params(r#"{"name":"run"}"#)), &base()).unwrap().ignore_case);
+        assert!(method_args("callers", Some(&params(r#"{"name":"run","ignore-case":1}"#)), &base()).unwrap_err().contains("boolean"));
+    }
+
+    #[test]
+    fn call_tree_params() {
+        let a = method_args("call-tree", Some(&params(r#"{"name":"save","depth":2}"#)), &base()).unwrap();
+        assert_eq!(a.call_tree, Some(("save".to_owned(), crate::callers::Direction::Callers)));
+        assert_eq!(a.depth, Some(2));
+        let a = method_args("callees", Some(&params(r#"{"name":"run"}"#)), &base()).unwrap();
+        assert_eq!(a.call_tree, Some(("run".to_owned(), crate::callers::Direction::Callees)));
+        assert!(method_args("callees", Some(&params(r#"{"name":"run","depth":0}"#)), &base()).unwrap_err().contains("--depth"));
+    }
+
+    #[test]
+    fn missing_required_params_rejected() {
+        assert!(method_args("search", None, &base()).is_err());
+        assert!(method_args("callers", Some(&params("{}")), &base()).is_err());
+        assert!(method_args("files", None, &base()).is_err());
+        assert!(method_args("lines", None, &base()).is_err());
+    }
+
+    #[test]
+    fn unsupported_params_rejected() {
+        assert!(method_args("tree", Some(&params(r#"{"output":"x.yaml"}"#)), &base()).is_err());
+        assert!(method_args("tree", Some(&params(r#"{"limit":-1}"#)), &base()).is_err());
+        assert!(method_args("tree", Some(&params("[1]")), &base()).is_err());
+    }
+
+    #[test]
+    fn cli_validation_still_applies() {
+        let err = method_args("search", Some(&params(r#"{"pattern":"x","compact":true}"#)), &base()).unwrap_err();
+        assert!(err.contains("--compact requires --symbols"));
+    }
+
+    #[test]
+    fn base_options_are_inherited() {
+        let mut b = base();
+        b.excludes = vec!["vendor".into()];
+        b.no_ignore = true;
+        b.timeout = Some(5);
+        let a = method_args("tree", None, &b).unwrap();
+        assert_eq!(a.excludes, vec!["vendor"]);
+        assert!(a.no_ignore);
+        assert_eq!(a.timeout, Some(5));
+    }
+
+    // ── handle_message ──
+
+    #[test]
+    fn parse_errors_reported() {
+        let mut session = Session::default();
+        let (resp, stop) = handle_message("{nope", &base(), &mut session);
+        assert!(resp.unwrap().contains("-32700"));
+        assert!(!stop);
+    }
+
+    #[test]
+    fn unknown_method_reported() {
+        let mut session = Session::default();
+        let (resp, _) = handle_message(r#"{"jsonrpc":"2.0","id":"a","method":"frobnicate"}"#, &base(), &mut session);
+        let resp = resp.unwrap();
+        assert!(resp.contains("\"id\":\"a\""));
+        assert!(resp.contains("-32601"));
+    }
+
+    #[test]
+    fn notifications_get_no_response() {
+        let mut session = Session::default();
+        let (resp, stop) = handle_message(r#"{"jsonrpc":"2.0","method":"shutdown"}"#, &base(), &mut session);
+        assert!(resp.is_none());
+        assert!(stop);
+    }
+
+    #[test]
+    fn flag_name_converts_camel_case() {
+        assert_eq!(flag_name("withTests"), "with-tests");
+        assert_eq!(flag_name("no-ignore"), "no-ignore");
+        assert_eq!(flag_name("glob"), "glob");
+    }
+}
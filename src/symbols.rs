@@ -7,7 +7,9 @@ use rayon::prelude::*;
 use crate::file_reader;
 use crate::lang;
 use crate::lang::common;
+use crate::lang::{LangSymbols, SymbolInfo};
 use crate::models::SymbolFile;
+use crate::ndjson::OnItem;
 use crate::path_helper;
 use crate::searcher::Matcher;
 
@@ -17,6 +19,7 @@ pub fn extract_symbols(
     cancelled: &AtomicBool,
     with_comments: bool,
     include_tests: bool,
+    on_file: OnItem<SymbolFile>,
 ) -> Vec<SymbolFile> {
     let mut results: Vec<SymbolFile> = file_paths
         .par_iter()
@@ -24,7 +27,11 @@ pub fn extract_symbols(
             if cancelled.load(Ordering::Relaxed) {
                 return None;
             }
-            process_file(file_path, root, with_comments, include_tests)
+            let sf = process_file(file_path, root, with_comments, include_tests)?;
+            if let Some(emit) = on_file {
+                emit(&sf);
+            }
+            Some(sf)
         })
         .collect();
 
@@ -89,7 +96,27 @@ fn process_file(file_path: &str, root: &Path, with_comments: bool, include_tests
         }
     };
 
-    let mut symbols = handler.extract_symbols_with_tests(&content, include_tests);
+    let symbols = symbols_from_content(handler, ext, &content, with_comments, include_tests);
+    if symbols.is_empty() {
+        return None;
+    }
+
+    Some(SymbolFile {
+        path: relative,
+        symbols,
+        error: None,
+    })
+}
+
+/// Runs `handler` over already-loaded content, attaching doc comments when asked.
+pub fn symbols_from_content(
+    handler: &dyn LangSymbols,
+    ext: &str,
+    content: &str,
+    with_comments: bool,
+    include_tests: bool,
+) -> Vec<SymbolInfo> {
+    let mut symbols = handler.extract_symbols_with_tests(content, include_tests);
 
     if with_comments && !symbols.is_empty() {
         let lines: Vec<&str> = content.lines().collect();
@@ -106,15 +133,7 @@ fn process_file(file_path: &str, root: &Path, with_comments: bool, include_tests
         }
     }
 
-    if symbols.is_empty() {
-        return None;
-    }
-
-    Some(SymbolFile {
-        path: relative,
-        symbols,
-        error: None,
-    })
+    symbols
 }
 
 pub fn filter_symbols(
@@ -124,14 +143,68 @@ pub fn filter_symbols(
     let mut total_matches = 0usize;
     let filtered: Vec<SymbolFile> = symbol_files
         .into_iter()
-        .filter_map(|mut sf| {
-            sf.symbols.retain(|sym| matcher.is_match(&sym.name));
-            if sf.symbols.is_empty() && sf.error.is_none() {
-                return None;
-            }
+        .filter_map(|sf| {
+            let sf = filter_symbol_file(sf, matcher)?;
             total_matches += sf.symbols.len();
             Some(sf)
         })
         .collect();
     (filtered, total_matches)
 }
+
+/// Keeps the symbols whose name matches; `None` when nothing is left to report.
+pub fn filter_symbol_file(mut sf: SymbolFile, matcher: &Matcher) -> Option<SymbolFile> {
+    sf.symbols.retain(|sym| matcher.is_match(&sym.name));
+    if sf.symbols.is_empty() && sf.error.is_none() {
+        return None;
+    }
+    Some(sf)
+}
+
+/// The symbols whose range holds `line`, with their indices, in extraction
+/// order.
+pub fn enclosing_symbols(symbols: &[SymbolInfo], line: usize) -> impl Iterator<Item = (usize, &SymbolInfo)> {
+    symbols.iter().enumerate().filter(move |(_, s)| s.line <= line && line <= s.end_line)
+}
+
+/// The narrowest symbol whose range holds `line`, the way a method is picked
+/// over the class around it.
+pub fn innermost_symbol(symbols: &[SymbolInfo], line: usize) -> Option<usize> {
+    enclosing_symbols(symbols, line).min_by_key(|(_, s)| s.end_line - s.line).map(|(i, _)| i)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn sym(kind: &'static str, name: &str, line: usize, end_line: usize) -> SymbolInfo {
+        SymbolInfo {
+            kind,
+            name: name.to_owned(),
+            line,
+            end_line,
+            visibility: None,
+            parent: None,
+            signature: String::new(),
+            comment: None,
+        }
+    }
+
+    // ── enclosing symbols ──
+
+    #[test]
+    fn enclosing_symbols_keep_extraction_order() {
+        let symbols = vec![sym("class", "A", 1, 10), sym("method", "m", 3, 5)];
+        let found: Vec<usize> = enclosing_symbols(&symbols, 4).map(|(i, _)| i).collect();
+        assert_eq!(found, vec![0, 1]);
+        assert_eq!(enclosing_symbols(&symbols, 12).count(), 0);
+    }
+
+    #[test]
+    fn innermost_symbol_prefers_narrowest_range() {
+        let symbols = vec![sym("class", "A", 1, 10), sym("method", "m", 3, 5)];
+        assert_eq!(innermost_symbol(&symbols, 4), Some(1));
+        assert_eq!(innermost_symbol(&symbols, 8), Some(0));
+        assert_eq!(innermost_symbol(&symbols, 12), None);
+    }
+}
@@ -0,0 +1,26 @@
+//! Fixtures shared by unit tests.
+
+use std::path::PathBuf;
+
+use crate::models::GraphEntry;
+
+/// Creates an empty directory under the system temp dir and writes `files`
+/// into it as `(relative path, contents)` pairs. `name` must be unique
+/// across the test suite, since tests run in parallel.
+pub fn temp_project(name: &str, files: &[(&str, &str)]) -> PathBuf {
+    let dir = std::env::temp_dir().join(format!("src_{}_{}", name, std::process::id()));
+    let _ = std::fs::remove_dir_all(&dir);
+    std::fs::create_dir_all(&dir).unwrap();
+    for (rel, content) in files {
+        let path = dir.join(rel);
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(path, content).unwrap();
+    }
+    dir
+}
+
+/// A dependency graph node for `file` with the given resolved imports and
+/// no third-party packages.
+pub fn graph_entry(file: &str, imports: &[&str]) -> GraphEntry {
+    GraphEntry { file: file.to_owned(), imports: imports.iter().map(|s| (*s).to_owned()).collect(), external: vec![] }
+}
@@ -0,0 +1,490 @@
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, SystemTime};
+
+use crate::exclusion::{ExclusionFilter, IgnoreStack};
+use crate::path_helper;
+
+/// How long the tree must stay quiet before a burst of events counts as one
+/// change, so a multi-file save triggers a single re-run.
+const DEBOUNCE: Duration = Duration::from_millis(150);
+
+/// Interval between stop-flag checks, and between scans when polling.
+const TICK: Duration = Duration::from_millis(400);
+
+/// How quickly a stop reaches a run in progress.
+const STOP_CHECK: Duration = Duration::from_millis(20);
+
+/// Watches the files a scan would visit: excluded directories and paths
+/// removed by ignore files are not watched and never trigger a change.
+pub struct Watcher {
+    root: PathBuf,
+    filter: ExclusionFilter,
+    backend: Backend,
+}
+
+enum Backend {
+    #[cfg(target_os = "linux")]
+    Inotify(inotify::Inotify),
+    Poll(HashMap<String, (Option<SystemTime>, u64)>),
+}
+
+impl Watcher {
+    /// Uses inotify where available and falls back to polling file stamps.
+    pub fn new(root: &Path, filter: ExclusionFilter) -> Self {
+        let mut watcher = Self::polling(root, filter);
+        #[cfg(target_os = "linux")]
+        if let Some(mut notify) = inotify::Inotify::new(root) {
+            let stack = watcher.filter.root_stack(root);
+            if notify.add_tree(root, "", &stack, &watcher.filter) {
+                watcher.backend = Backend::Inotify(notify);
+            }
+        }
+        watcher
+    }
+
+    pub fn polling(root: &Path, filter: ExclusionFilter) -> Self {
+        let mut watcher = Self { root: root.to_path_buf(), filter, backend: Backend::Poll(HashMap::new()) };
+        let stamps = watcher.snapshot();
+        watcher.backend = Backend::Poll(stamps);
+        watcher
+    }
+
+    /// Blocks until a watched file changes and the tree settles. Returns
+    /// false if `stop` was set first.
+    pub fn wait(&mut self, stop: &AtomicBool) -> bool {
+        loop {
+            if stop.load(Ordering::Relaxed) {
+                return false;
+            }
+            if self.changed_within(TICK) {
+                break;
+            }
+        }
+        while !stop.load(Ordering::Relaxed) && self.changed_within(DEBOUNCE) {}
+        !stop.load(Ordering::Relaxed)
+    }
+
+    /// Reports whether anything relevant changed during the next `window`.
+    fn changed_within(&mut self, window: Duration) -> bool {
+        match self.backend {
+            #[cfg(target_os = "linux")]
+            Backend::Inotify(ref mut notify) => notify.changed_within(window, &self.filter),
+            Backend::Poll(_) => {
+                std::thread::sleep(window);
+                let stamps = self.snapshot();
+                match self.backend {
+                    Backend::Poll(ref mut previous) if *previous != stamps => {
+                        *previous = stamps;
+                        true
+                    }
+                    _ => false,
+                }
+            }
+        }
+    }
+
+    fn snapshot(&self) -> HashMap<String, (Option<SystemTime>, u64)> {
+        let mut stamps = HashMap::new();
+        let stack = self.filter.root_stack(&self.root);
+        walk(&self.root, &self.root, &stack, &self.filter, &mut |path, rel, _, is_dir| {
+            if !is_dir {
+                if let Ok(meta) = fs::metadata(path) {
+                    stamps.insert(rel.to_owned(), (meta.modified().ok(), meta.len()));
+                }
+            }
+        });
+        stamps
+    }
+}
+
+/// Runs `run` while passing a `stop` on to `cancelled`, so Ctrl-C cuts a
+/// long re-run short instead of waiting for it to finish.
+pub fn run_until_stopped<T>(stop: &AtomicBool, cancelled: &AtomicBool, run: impl FnOnce() -> T) -> T {
+    let done = AtomicBool::new(false);
+    std::thread::scope(|scope| {
+        scope.spawn(|| {
+            while !done.load(Ordering::Relaxed) {
+                if stop.load(Ordering::Relaxed) {
+                    cancelled.store(true, Ordering::Relaxed);
+                    return;
+                }
+                std::thread::sleep(STOP_CHECK);
+            }
+        });
+        let result = run();
+        done.store(true, Ordering::Relaxed);
+        result
+    })
+}
+
+/// Visits every directory and file below `dir` that the filter keeps.
+/// Directories are reported before their contents, with the stack that
+/// applies inside them.
+fn walk(
+    root: &Path,
+    dir: &Path,
+    stack: &IgnoreStack,
+    filter: &ExclusionFilter,
+    visit: &mut dyn FnMut(&Path, &str, &IgnoreStack, bool),
+) {
+    let entries = match fs::read_dir(dir) {
+        Ok(rd) => rd,
+        Err(_) => return,
+    };
+    for entry in entries.flatten() {
+        let ft = match entry.file_type() {
+            Ok(ft) => ft,
+            Err(_) => continue,
+        };
+        let path = entry.path();
+        let rel = path_helper::normalized_relative(root, &path);
+        if ft.is_dir() {
+            if filter.is_excluded(&entry.file_name().to_string_lossy()) || filter.is_ignored(stack, &rel, true) {
+                continue;
+            }
+            let child = filter.enter_dir(stack, &path, &rel);
+            visit(&path, &rel, &child, true);
+            walk(root, &path, &child, filter, visit);
+        } else if ft.is_file() && !filter.is_ignored(stack, &rel, false) {
+            visit(&path, &rel, stack, false);
+        }
+    }
+}
+
+#[cfg(target_os = "linux")]
+mod inotify {
+    use std::collections::HashMap;
+    use std::ffi::CString;
+    use std::os::raw::{c_char, c_int, c_ulong, c_void};
+    use std::os::unix::ffi::OsStrExt;
+    use std::path::{Path, PathBuf};
+    use std::time::{Duration, Instant};
+
+    use crate::exclusion::{ExclusionFilter, IgnoreStack};
+
+    const IN_NONBLOCK: c_int = 0o4000;
+    const IN_CLOEXEC: c_int = 0o2000000;
+
+    const IN_MODIFY: u32 = 0x0000_0002;
+    const IN_ATTRIB: u32 = 0x0000_0004;
+    const IN_CLOSE_WRITE: u32 = 0x0000_0008;
+    const IN_MOVED_FROM: u32 = 0x0000_0040;
+    const IN_MOVED_TO: u32 = 0x0000_0080;
+    const IN_CREATE: u32 = 0x0000_0100;
+    const IN_DELETE: u32 = 0x0000_0200;
+    const IN_DELETE_SELF: u32 = 0x0000_0400;
+    const IN_Q_OVERFLOW: u32 = 0x0000_4000;
+    const IN_IGNORED: u32 = 0x0000_8000;
+    const IN_ISDIR: u32 = 0x4000_0000;
+
+    const WATCH_MASK: u32 = IN_MODIFY | IN_ATTRIB | IN_CLOSE_WRITE | IN_MOVED_FROM | IN_MOVED_TO
+        | IN_CREATE | IN_DELETE | IN_DELETE_SELF;
+
+    const POLLIN: i16 = 0x1;
+    const EVENT_HEADER: usize = 16;
+
+    #[repr(C)]
+    struct PollFd {
+        fd: c_int,
+        events: i16,
+        revents: i16,
+    }
+
+    extern "C" {
+        fn inotify_init1(flags: c_int) -> c_int;
+        fn inotify_add_watch(fd: c_int, path: *const c_char, mask: u32) -> c_int;
+        fn poll(fds: *mut PollFd, nfds: c_ulong, timeout: c_int) -> c_int;
+        fn read(fd: c_int, buf: *mut c_void, count: usize) -> isize;
+        fn close(fd: c_int) -> c_int;
+    }
+
+    /// A watched directory: its path, scan-relative name and ignore rules.
+    struct Dir {
+        path: PathBuf,
+        rel: String,
+        stack: IgnoreStack,
+    }
+
+    pub struct Inotify {
+        fd: c_int,
+        root: PathBuf,
+        dirs: HashMap<c_int, Dir>,
+    }
+
+    impl Drop for Inotify {
+        fn drop(&mut self) {
+            unsafe { close(self.fd) };
+        }
+    }
+
+    impl Inotify {
+        pub fn new(root: &Path) -> Option<Self> {
+            let fd = unsafe { inotify_init1(IN_NONBLOCK | IN_CLOEXEC) };
+            if fd < 0 {
+                return None;
+            }
+            Some(Self { fd, root: root.to_path_buf(), dirs: HashMap::new() })
+        }
+
+        fn add_dir(&mut self, path: &Path, rel: &str, stack: &IgnoreStack) -> bool {
+            let c_path = match CString::new(path.as_os_str().as_bytes()) {
+                Ok(p) => p,
+                Err(_) => return false,
+            };
+            let wd = unsafe { inotify_add_watch(self.fd, c_path.as_ptr(), WATCH_MASK) };
+            if wd < 0 {
+                return false;
+            }
+            self.dirs.insert(wd, Dir { path: path.to_path_buf(), rel: rel.to_owned(), stack: stack.clone() });
+            true
+        }
+
+        /// Watches `dir` and every kept directory below it. Returns false if
+        /// `dir` itself could not be watched (for example when the per-user
+        /// watch limit is exhausted).
+        pub fn add_tree(&mut self, dir: &Path, rel: &str, stack: &IgnoreStack, filter: &ExclusionFilter) -> bool {
+            if !self.add_dir(dir, rel, stack) {
+                return false;
+            }
+            let root = self.root.clone();
+            let mut complete = true;
+            super::walk(&root, dir, stack, filter, &mut |path, child_rel, child_stack, is_dir| {
+                if is_dir && !self.add_dir(path, child_rel, child_stack) {
+                    complete = false;
+                }
+            });
+            complete
+        }
+
+        /// Rebuilds every directory's ignore rules after an ignore file
+        /// changed. Directories that are now ignored drop out of the map, so
+        /// their leftover watches report nothing; newly kept ones are added.
+        fn reload(&mut self, filter: &ExclusionFilter) {
+            self.dirs.clear();
+            let root = self.root.clone();
+            let stack = filter.root_stack(&root);
+            self.add_tree(&root, "", &stack, filter);
+        }
+
+        pub fn changed_within(&mut self, window: Duration, filter: &ExclusionFilter) -> bool {
+            let deadline = Instant::now() + window;
+            let mut changed = false;
+            loop {
+                let remaining = deadline.saturating_duration_since(Instant::now());
+                let mut pfd = PollFd { fd: self.fd, events: POLLIN, revents: 0 };
+                let ready = unsafe { poll(&mut pfd, 1, remaining.as_millis() as c_int) };
+                if ready <= 0 {
+                    return changed;
+                }
+                changed |= self.drain(filter);
+                if changed {
+                    return true;
+                }
+            }
+        }
+
+        /// Reads all queued events and reports whether any touched a path the
+        /// scan would visit. New directories are watched as they appear.
+        fn drain(&mut self, filter: &ExclusionFilter) -> bool {
+            let mut buf = [0u8; 16 * 1024];
+            let mut changed = false;
+            loop {
+                let n = unsafe { read(self.fd, buf.as_mut_ptr() as *mut c_void, buf.len()) };
+                if n <= 0 {
+                    return changed;
+                }
+                let mut offset = 0;
+                while offset + EVENT_HEADER <= n as usize {
+                    let field = |at: usize| u32::from_ne_bytes(buf[offset + at..offset + at + 4].try_into().unwrap());
+                    let wd = field(0) as c_int;
+                    let mask = field(4);
+                    let len = field(12) as usize;
+                    let name_bytes = &buf[offset + EVENT_HEADER..offset + EVENT_HEADER + len];
+                    let name = String::from_utf8_lossy(name_bytes.split(|&b| b == 0).next().unwrap_or(&[])).into_owned();
+                    offset += EVENT_HEADER + len;
+                    changed |= self.handle(wd, mask, &name, filter);
+                }
+            }
+        }
+
+        fn handle(&mut self, wd: c_int, mask: u32, name: &str, filter: &ExclusionFilter) -> bool {
+            if mask & IN_Q_OVERFLOW != 0 {
+                return true;
+            }
+            if mask & IN_IGNORED != 0 {
+                self.dirs.remove(&wd);
+                return false;
+            }
+            let dir = match self.dirs.get(&wd) {
+                Some(d) => d,
+                None => return false,
+            };
+            if name.is_empty() {
+                return mask & IN_DELETE_SELF != 0;
+            }
+
+            let is_dir = mask & IN_ISDIR != 0;
+            let rel = if dir.rel.is_empty() { name.to_owned() } else { format!("{}/{}", dir.rel, name) };
+            if is_dir && filter.is_excluded(name) {
+                return false;
+            }
+            if filter.is_ignored(&dir.stack, &rel, is_dir) {
+                return false;
+            }
+            if !is_dir && crate::exclusion::is_ignore_file(name) {
+                self.reload(filter);
+                return true;
+            }
+            if is_dir && mask & (IN_CREATE | IN_MOVED_TO) != 0 {
+                let path = dir.path.join(name);
+                let stack = filter.enter_dir(&dir.stack, &path, &rel);
+                self.add_tree(&path, &rel, &stack, filter);
+            }
+            true
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Arc;
+
+    use crate::test_support::temp_project;
+
+    fn temp_tree(name: &str) -> PathBuf {
+        let dir = temp_project(&format!("watch_{}", name), &[("src/main.rs", "fn main() {}\n"), (".gitignore", "*.log\n")]);
+        fs::create_dir_all(dir.join("node_modules/pkg")).unwrap();
+        dir
+    }
+
+    fn filter() -> ExclusionFilter {
+        ExclusionFilter::new(&[], false).with_ignore_files(true)
+    }
+
+    fn assert_detects(mut watcher: Watcher, root: &Path) {
+        fs::write(root.join("node_modules/pkg/index.js"), "x").unwrap();
+        fs::write(root.join("build.log"), "x").unwrap();
+        assert!(!watcher.changed_within(TICK), "excluded or ignored change was reported");
+
+        fs::write(root.join("src/main.rs"), "fn main() { run(); }\n").unwrap();
+        assert!(watcher.changed_within(TICK * 3));
+    }
+
+    // ── polling ──
+
+    #[test]
+    fn polling_detects_edits_and_skips_excluded() {
+        let root = temp_tree("poll");
+        let watcher = Watcher::polling(&root, filter());
+        assert_detects(watcher, &root);
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn polling_detects_removal() {
+        let root = temp_tree("poll_remove");
+        let mut watcher = Watcher::polling(&root, filter());
+        fs::remove_file(root.join("src/main.rs")).unwrap();
+        assert!(watcher.changed_within(Duration::from_millis(10)));
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    // ── inotify ──
+
+    #[cfg(target_os = "linux")]
+    #[test]
+    fn inotify_detects_edits_and_skips_excluded() {
+        let root = temp_tree("inotify");
+        let watcher = Watcher::new(&root, filter());
+        assert!(matches!(watcher.backend, Backend::Inotify(_)));
+        assert_detects(watcher, &root);
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[cfg(target_os = "linux")]
+    #[test]
+    fn inotify_watches_new_directories() {
+        let root = temp_tree("inotify_newdir");
+        let mut watcher = Watcher::new(&root, filter());
+        fs::create_dir_all(root.join("src/feature")).unwrap();
+        assert!(watcher.changed_within(TICK));
+        while watcher.changed_within(DEBOUNCE) {}
+
+        fs::write(root.join("src/feature/mod.rs"), "pub fn f() {}\n").unwrap();
+        assert!(watcher.changed_within(TICK));
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[cfg(target_os = "linux")]
+    #[test]
+    fn inotify_reloads_edited_ignore_files() {
+        let root = temp_tree("inotify_reload");
+        let mut watcher = Watcher::new(&root, filter());
+        fs::write(root.join("src/.srcignore"), "*.tmp\n").unwrap();
+        assert!(watcher.changed_within(TICK));
+        while watcher.changed_within(DEBOUNCE) {}
+
+        fs::write(root.join("src/notes.tmp"), "x").unwrap();
+        fs::write(root.join("build.log"), "x").unwrap();
+        assert!(!watcher.changed_within(TICK), "change to a newly ignored file was reported");
+
+        fs::write(root.join(".gitignore"), "").unwrap();
+        assert!(watcher.changed_within(TICK));
+        while watcher.changed_within(DEBOUNCE) {}
+        fs::write(root.join("build.log"), "y").unwrap();
+        assert!(watcher.changed_within(TICK), "change to a no longer ignored file was missed");
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    #[test]
+    fn polling_rereads_ignore_files() {
+        let root = temp_tree("poll_reload");
+        let mut watcher = Watcher::polling(&root, filter());
+        fs::write(root.join(".gitignore"), "*.log\n*.tmp\n").unwrap();
+        assert!(watcher.changed_within(Duration::from_millis(10)));
+        fs::write(root.join("notes.tmp"), "x").unwrap();
+        assert!(!watcher.changed_within(Duration::from_millis(10)));
+        let _ = fs::remove_dir_all(&root);
+    }
+
+    // ── wait ──
+
+    #[test]
+    fn stop_cancels_the_run_in_progress() {
+        let stop = Arc::new(AtomicBool::new(false));
+        let cancelled = AtomicBool::new(false);
+        let raiser = {
+            let stop = stop.clone();
+            std::thread::spawn(move || {
+                std::thread::sleep(Duration::from_millis(50));
+                stop.store(true, Ordering::Relaxed);
+            })
+        };
+        let start = std::time::Instant::now();
+        let spins = run_until_stopped(&stop, &cancelled, || {
+            let mut spins = 0;
+            while !cancelled.load(Ordering::Relaxed) && start.elapsed() < Duration::from_secs(10) {
+                std::thread::sleep(Duration::from_millis(5));
+                spins += 1;
+            }
+            spins
+        });
+        raiser.join().unwrap();
+        assert!(cancelled.load(Ordering::Relaxed));
+        assert!(spins > 0);
+        assert!(start.elapsed() < Duration::from_secs(2), "took {:?}", start.elapsed());
+    }
+
+    #[test]
+    fn wait_returns_false_when_stopped() {
+        let root = temp_tree("stop");
+        let mut watcher = Watcher::new(&root, filter());
+        let stop = Arc::new(AtomicBool::new(true));
+        assert!(!watcher.wait(&stop));
+        let _ = fs::remove_dir_all(&root);
+    }
+}
@@ -1,36 +1,106 @@
 use std::io::{self, Write, BufWriter};
 
+use crate::diagram::{self, DiagramOptions};
 use crate::models::{
-    CallersOutput, CountEntry, FileChunk, FileEntry, GraphEntry, LangStats, LargestFile,
-    MetaInfo, OutputEnvelope, OutputPayload, ScanResult, StatsOutput, SymbolFile, SymbolInfo,
+    CallNode, CallTreeOutput, CallerDeclaration, CallerFile, CallersOutput, CheckReport, CountEntry, DependentsOutput, ExternalOutput, FileChunk, FileEntry, GraphEntry, ImportCycle,
+    IndexReport, LangStats, LargestFile, MetaInfo, RollupOutput, OrphansOutput, OutputEnvelope, OutputPayload, ScanResult, StatsOutput, SymbolFile, SymbolInfo,
 };
 
 #[derive(Clone, Copy, PartialEq)]
 pub enum OutputFormat {
     Yaml,
     Json,
+    /// Graph diagrams. Envelopes without a graph (errors) fall back to YAML.
+    Dot(DiagramOptions),
+    Mermaid(DiagramOptions),
 }
 
 pub fn write_output(envelope: &OutputEnvelope, format: OutputFormat) {
     let stdout = io::stdout();
     let mut w = BufWriter::with_capacity(64 * 1024, stdout.lock());
-    match format {
-        OutputFormat::Yaml => { write_envelope_yaml(&mut w, envelope).ok(); }
-        OutputFormat::Json => { write_envelope_json(&mut w, envelope).ok(); }
-    }
+    write_envelope(&mut w, envelope, format).ok();
     w.flush().ok();
 }
 
+/// Writes one envelope to an already open output and flushes it, so a
+/// long-running `--watch` delivers each result as soon as it is ready.
+pub fn write_output_into(w: &mut impl Write, envelope: &OutputEnvelope, format: OutputFormat) -> io::Result<()> {
+    write_envelope(w, envelope, format)?;
+    w.flush()
+}
+
 pub fn write_output_to(envelope: &OutputEnvelope, format: OutputFormat, path: &str) -> io::Result<()> {
     let file = std::fs::File::create(path)?;
     let mut w = BufWriter::with_capacity(64 * 1024, file);
-    match format {
-        OutputFormat::Yaml => write_envelope_yaml(&mut w, envelope)?,
-        OutputFormat::Json => write_envelope_json(&mut w, envelope)?,
-    }
+    write_envelope(&mut w, envelope, format)?;
     w.flush()
 }
 
+fn write_envelope(w: &mut impl Write, envelope: &OutputEnvelope, format: OutputFormat) -> io::Result<()> {
+    match (format, &envelope.payload) {
+        (OutputFormat::Json, _) => write_envelope_json(w, envelope),
+        (OutputFormat::Dot(options), OutputPayload::Graph(graph)) => diagram::write_dot(w, graph, options),
+        (OutputFormat::Mermaid(options), OutputPayload::Graph(graph)) => diagram::write_mermaid(w, graph, options),
+        _ => write_envelope_yaml(w, envelope),
+    }
+}
+
+/// One line of `--format ndjson` output.
+pub enum Record<'a> {
+    File(&'a FileEntry),
+    Symbols(&'a SymbolFile, bool),
+    Graph(&'a GraphEntry),
+    Caller(&'a CallerFile),
+    Declaration(&'a CallerDeclaration),
+    Count(&'a CountEntry),
+    /// A whole-result payload (tree, stats, index) as one object.
+    Payload(&'a OutputPayload),
+    /// The closing record: an envelope's meta, error and errors.
+    Summary(&'a OutputEnvelope),
+}
+
+/// Writes `record` as a single-line JSON object followed by a newline.
+/// Declarations are wrapped as `{"declaration": {...}}` so they can be told
+/// apart from caller files.
+pub fn write_record(w: &mut impl Write, record: &Record) -> io::Result<()> {
+    let mut j = Jw::new(w);
+    match *record {
+        Record::File(file) => write_file_entry_json(&mut j, file)?,
+        Record::Symbols(sf, compact) => write_symbol_file_json(&mut j, sf, compact)?,
+        Record::Graph(entry) => write_graph_entry_json(&mut j, entry)?,
+        Record::Caller(cf) => write_caller_file_json(&mut j, cf)?,
+        Record::Declaration(d) => {
+            j.obj_start()?;
+            j.key("declaration")?;
+            write_declaration_json(&mut j, d)?;
+            j.obj_end()?;
+        }
+        Record::Count(entry) => write_count_entry_json(&mut j, entry)?,
+        Record::Payload(payload) => {
+            j.obj_start()?;
+            write_payload_json(&mut j, payload)?;
+            j.obj_end()?;
+        }
+        Record::Summary(envelope) => {
+            j.obj_start()?;
+            write_summary_json(&mut j, envelope)?;
+            j.obj_end()?;
+        }
+    }
+    write!(j.w, "\n")
+}
+
+/// Serializes an envelope as a single-line JSON object (no trailing newline),
+/// for embedding in protocol messages.
+pub fn envelope_to_json(envelope: &OutputEnvelope) -> String {
+    let mut buf = Vec::new();
+    write_envelope_json(&mut buf, envelope).ok();
+    while buf.last() == Some(&b'\n') {
+        buf.pop();
+    }
+    String::from_utf8(buf).unwrap_or_default()
+}
+
 // ── YAML output ──
 
 fn write_envelope_yaml(w: &mut impl Write, envelope: &OutputEnvelope) -> io::Result<()> {
@@ -56,10 +126,18 @@ fn write_envelope_yaml(w: &mut impl Write, envelope: &OutputEnvelope) -> io::Res
             write_tree_node(w, tree, 2)?;
         }
         OutputPayload::Graph(graph) => write_graph(w, graph)?,
+        OutputPayload::Dependents(output) => write_dependents(w, output)?,
+        OutputPayload::Cycles(cycles) => write_cycles(w, cycles)?,
+        OutputPayload::Rollup(output) => write_rollup(w, output)?,
+        OutputPayload::External(output) => write_external(w, output)?,
+        OutputPayload::Orphans(output) => write_orphans(w, output)?,
+        OutputPayload::Check(report) => write_check_report(w, report)?,
         OutputPayload::Symbols { files, compact } => write_symbols(w, files, *compact)?,
         OutputPayload::Callers(callers_output) => write_callers(w, callers_output)?,
+        OutputPayload::CallTree(output) => write_call_tree(w, output)?,
         OutputPayload::Counts(counts) => write_counts(w, counts)?,
         OutputPayload::Stats(stats) => write_stats(w, stats)?,
+        OutputPayload::Index(report) => write_index_report(w, report)?,
         OutputPayload::Files(files) if !files.is_empty() => write_files(w, files)?,
         _ => {}
     }
@@ -86,6 +164,26 @@ fn write_meta(w: &mut impl Write, meta: &MetaInfo) -> io::Result<()> {
     if let Some(total) = meta.total_matches {
         write!(w, "  totalMatches: {}\n", total)?;
     }
+    if let Some(ref budget) = meta.budget {
+        write!(w, "  truncated: {}\n", budget.truncated)?;
+        write!(w, "  maxTokens: {}\n", budget.max_tokens)?;
+        write!(w, "  estimatedTokens: {}\n", budget.used_tokens)?;
+        write_path_list(w, "dropped", &budget.dropped)?;
+        write_path_list(w, "partial", &budget.partial)?;
+    }
+    Ok(())
+}
+
+fn write_path_list(w: &mut impl Write, key: &str, paths: &[String]) -> io::Result<()> {
+    if paths.is_empty() {
+        return Ok(());
+    }
+    write!(w, "  {}:\n", key)?;
+    for path in paths {
+        write!(w, "    - ")?;
+        write_inline_string(w, path)?;
+        write!(w, "\n")?;
+    }
     Ok(())
 }
 
@@ -208,6 +306,7 @@ fn write_callers(w: &mut impl Write, output: &CallersOutput) -> io::Result<()> {
             write!(w, "  sites:\n")?;
             for site in &cf.sites {
                 write!(w, "  - line: {}\n", site.line)?;
+                write!(w, "    kind: {}\n", site.kind)?;
                 write!(w, "    content: ")?;
                 write_inline_string(w, &site.content)?;
                 write!(w, "\n")?;
@@ -217,6 +316,49 @@ fn write_callers(w: &mut impl Write, output: &CallersOutput) -> io::Result<()> {
     Ok(())
 }
 
+fn write_call_tree(w: &mut impl Write, output: &CallTreeOutput) -> io::Result<()> {
+    write!(w, "direction: {}\n", output.direction)?;
+    write!(w, "depth: {}\n", output.depth)?;
+    if output.roots.is_empty() {
+        return write!(w, "roots: []\n");
+    }
+    write!(w, "roots:\n")?;
+    for node in &output.roots {
+        write_call_node(w, node, 0)?;
+    }
+    Ok(())
+}
+
+/// Writes `node` as a list item at `indent`, its children nested below.
+fn write_call_node(w: &mut impl Write, node: &CallNode, indent: usize) -> io::Result<()> {
+    write_indent(w, indent)?;
+    write!(w, "- name: ")?;
+    write_inline_string(w, &node.name)?;
+    write!(w, "\n")?;
+    write_indent(w, indent)?;
+    write!(w, "  kind: {}\n", node.kind)?;
+    write_scalar(w, "path", &node.path, indent + 2)?;
+    write_indent(w, indent)?;
+    write!(w, "  line: {}\n", node.line)?;
+    if !node.sites.is_empty() {
+        let sites: Vec<String> = node.sites.iter().map(usize::to_string).collect();
+        write_indent(w, indent)?;
+        write!(w, "  sites: [{}]\n", sites.join(", "))?;
+    }
+    if node.recursive {
+        write_indent(w, indent)?;
+        write!(w, "  recursive: true\n")?;
+    }
+    if !node.children.is_empty() {
+        write_indent(w, indent)?;
+        write!(w, "  children:\n")?;
+        for child in &node.children {
+            write_call_node(w, child, indent + 2)?;
+        }
+    }
+    Ok(())
+}
+
 fn write_counts(w: &mut impl Write, counts: &[CountEntry]) -> io::Result<()> {
     write!(w, "files:\n")?;
     for entry in counts {
@@ -412,6 +554,188 @@ fn write_graph(w: &mut impl Write, graph: &[GraphEntry]) -> io::Result<()> {
     Ok(())
 }
 
+fn write_dependents(w: &mut impl Write, output: &DependentsOutput) -> io::Result<()> {
+    write!(w, "targets:\n")?;
+    for target in &output.targets {
+        write!(w, "- ")?;
+        write_inline_string(w, target)?;
+        write!(w, "\n")?;
+    }
+    if output.levels.is_empty() {
+        return write!(w, "dependents: []\n");
+    }
+    write!(w, "dependents:\n")?;
+    for level in &output.levels {
+        write!(w, "- depth: {}\n", level.depth)?;
+        write!(w, "  files:\n")?;
+        for file in &level.files {
+            write!(w, "  - ")?;
+            write_inline_string(w, file)?;
+            write!(w, "\n")?;
+        }
+    }
+    Ok(())
+}
+
+fn write_cycles(w: &mut impl Write, cycles: &[ImportCycle]) -> io::Result<()> {
+    if cycles.is_empty() {
+        return write!(w, "cycles: []\n");
+    }
+    write!(w, "cycles:\n")?;
+    for cycle in cycles {
+        write!(w, "- files:\n")?;
+        for file in &cycle.files {
+            write!(w, "  - ")?;
+            write_inline_string(w, file)?;
+            write!(w, "\n")?;
+        }
+        write!(w, "  path:\n")?;
+        for edge in &cycle.path {
+            write!(w, "  - from: ")?;
+            write_inline_string(w, &edge.from)?;
+            write!(w, "\n    to: ")?;
+            write_inline_string(w, &edge.to)?;
+            write!(w, "\n")?;
+            if let Some(line) = edge.line {
+                write!(w, "    line: {}\n", line)?;
+            }
+            if let Some(ref content) = edge.content {
+                write_scalar(w, "import", content, 4)?;
+            }
+        }
+    }
+    Ok(())
+}
+
+fn write_rollup(w: &mut impl Write, output: &RollupOutput) -> io::Result<()> {
+    write!(w, "rollup:\n")?;
+    write!(w, "  by: {}\n", output.by)?;
+    write!(w, "  containers:\n")?;
+    for container in &output.containers {
+        write!(w, "  - name: ")?;
+        write_inline_string(w, &container.name)?;
+        write!(w, "\n    files: {}\n", container.files)?;
+    }
+    if output.edges.is_empty() {
+        return write!(w, "  edges: []\n");
+    }
+    write!(w, "  edges:\n")?;
+    for edge in &output.edges {
+        write!(w, "  - from: ")?;
+        write_inline_string(w, &edge.from)?;
+        write!(w, "\n    to: ")?;
+        write_inline_string(w, &edge.to)?;
+        write!(w, "\n    count: {}\n", edge.count)?;
+        write!(w, "    top:\n")?;
+        for pair in &edge.top {
+            write!(w, "    - from: ")?;
+            write_inline_string(w, &pair.from)?;
+            write!(w, "\n      to: ")?;
+            write_inline_string(w, &pair.to)?;
+            write!(w, "\n")?;
+        }
+    }
+    Ok(())
+}
+
+fn write_external(w: &mut impl Write, output: &ExternalOutput) -> io::Result<()> {
+    write!(w, "graph:\n")?;
+    for file in &output.files {
+        write!(w, "- file: ")?;
+        write_inline_string(w, &file.file)?;
+        write!(w, "\n")?;
+        if file.imports.is_empty() {
+            write!(w, "  imports: []\n")?;
+        } else {
+            write!(w, "  imports:\n")?;
+            for imp in &file.imports {
+                write!(w, "  - ")?;
+                write_inline_string(w, imp)?;
+                write!(w, "\n")?;
+            }
+        }
+        if !file.packages.is_empty() {
+            write!(w, "  external:\n")?;
+            for package in &file.packages {
+                write!(w, "  - ")?;
+                write_inline_string(w, package)?;
+                write!(w, "\n")?;
+            }
+        }
+    }
+    if output.packages.is_empty() {
+        return write!(w, "packages: []\n");
+    }
+    write!(w, "packages:\n")?;
+    for package in &output.packages {
+        write!(w, "- name: ")?;
+        write_inline_string(w, &package.name)?;
+        write!(w, "\n  ecosystem: {}\n", package.ecosystem)?;
+        write!(w, "  count: {}\n", package.files.len())?;
+        write!(w, "  files:\n")?;
+        for file in &package.files {
+            write!(w, "  - ")?;
+            write_inline_string(w, file)?;
+            write!(w, "\n")?;
+        }
+    }
+    Ok(())
+}
+
+fn write_orphans(w: &mut impl Write, output: &OrphansOutput) -> io::Result<()> {
+    for (key, files) in [("entrypoints", &output.entrypoints), ("orphans", &output.orphans)] {
+        if files.is_empty() {
+            write!(w, "{}: []\n", key)?;
+            continue;
+        }
+        write!(w, "{}:\n", key)?;
+        for file in files {
+            write!(w, "- ")?;
+            write_inline_string(w, file)?;
+            write!(w, "\n")?;
+        }
+    }
+    Ok(())
+}
+
+fn write_check_report(w: &mut impl Write, report: &CheckReport) -> io::Result<()> {
+    write_scalar(w, "rules", &report.rules, 0)?;
+    if report.violations.is_empty() {
+        return write!(w, "violations: []\n");
+    }
+    write!(w, "violations:\n")?;
+    for v in &report.violations {
+        write!(w, "- file: ")?;
+        write_inline_string(w, &v.file)?;
+        write!(w, "\n")?;
+        if let Some(line) = v.line {
+            write!(w, "  line: {}\n", line)?;
+        }
+        if let Some(ref import) = v.import {
+            write_scalar(w, "import", import, 2)?;
+        }
+        write_scalar(w, "target", &v.target, 2)?;
+        write_scalar(w, "rule", &v.rule, 2)?;
+        if let Some(ref reason) = v.reason {
+            write_scalar(w, "reason", reason, 2)?;
+        }
+    }
+    Ok(())
+}
+
+fn write_index_report(w: &mut impl Write, report: &IndexReport) -> io::Result<()> {
+    write!(w, "index:\n")?;
+    write_scalar(w, "path", &report.path, 2)?;
+    write!(w, "  state: {}\n", report.state)?;
+    write!(w, "  version: {}\n", report.version)?;
+    write!(w, "  files: {}\n", report.files)?;
+    write!(w, "  symbols: {}\n", report.symbols)?;
+    write!(w, "  changed: {}\n", report.changed)?;
+    write!(w, "  added: {}\n", report.added)?;
+    write!(w, "  removed: {}\n", report.removed)?;
+    Ok(())
+}
+
 fn write_block_scalar(w: &mut impl Write, key: &str, content: &str, indent: usize) -> io::Result<()> {
     write_indent(w, indent)?;
     write!(w, "{}: |\n", key)?;
@@ -529,9 +853,16 @@ fn write_indent(w: &mut impl Write, n: usize) -> io::Result<()> {
 fn write_envelope_json(w: &mut impl Write, envelope: &OutputEnvelope) -> io::Result<()> {
     let mut j = Jw::new(w);
     j.obj_start()?;
+    write_summary_json(&mut j, envelope)?;
+    write_payload_json(&mut j, &envelope.payload)?;
+    j.obj_end()?;
+    write!(j.w, "\n")
+}
 
+/// Meta, error and errors: everything in an envelope except the payload.
+fn write_summary_json(j: &mut Jw<impl Write>, envelope: &OutputEnvelope) -> io::Result<()> {
     if let Some(ref meta) = envelope.meta {
-        write_meta_json(&mut j, meta)?;
+        write_meta_json(j, meta)?;
     }
     if let Some(ref error) = envelope.error {
         j.key_str("error", error)?;
@@ -543,19 +874,29 @@ fn write_envelope_json(w: &mut impl Write, envelope: &OutputEnvelope) -> io::Res
             j.arr_end()?;
         }
     }
-    match &envelope.payload {
-        OutputPayload::Tree(tree) => { j.key("tree")?; write_tree_json(&mut j, tree)?; }
-        OutputPayload::Graph(graph) => write_graph_json(&mut j, graph)?,
-        OutputPayload::Symbols { files, compact } => write_symbols_json(&mut j, files, *compact)?,
-        OutputPayload::Callers(callers_output) => write_callers_json(&mut j, callers_output)?,
-        OutputPayload::Counts(counts) => write_counts_json(&mut j, counts)?,
-        OutputPayload::Stats(stats) => write_stats_json(&mut j, stats)?,
-        OutputPayload::Files(files) if !files.is_empty() => write_files_json(&mut j, files)?,
+    Ok(())
+}
+
+fn write_payload_json(j: &mut Jw<impl Write>, payload: &OutputPayload) -> io::Result<()> {
+    match payload {
+        OutputPayload::Tree(tree) => { j.key("tree")?; write_tree_json(j, tree)?; }
+        OutputPayload::Graph(graph) => write_graph_json(j, graph)?,
+        OutputPayload::Dependents(output) => write_dependents_json(j, output)?,
+        OutputPayload::Cycles(cycles) => write_cycles_json(j, cycles)?,
+        OutputPayload::Rollup(output) => write_rollup_json(j, output)?,
+        OutputPayload::External(output) => write_external_json(j, output)?,
+        OutputPayload::Orphans(output) => write_orphans_json(j, output)?,
+        OutputPayload::Check(report) => write_check_report_json(j, report)?,
+        OutputPayload::Symbols { files, compact } => write_symbols_json(j, files, *compact)?,
+        OutputPayload::Callers(callers_output) => write_callers_json(j, callers_output)?,
+        OutputPayload::CallTree(output) => write_call_tree_json(j, output)?,
+        OutputPayload::Counts(counts) => write_counts_json(j, counts)?,
+        OutputPayload::Stats(stats) => write_stats_json(j, stats)?,
+        OutputPayload::Index(report) => write_index_report_json(j, report)?,
+        OutputPayload::Files(files) if !files.is_empty() => write_files_json(j, files)?,
         _ => {}
     }
-
-    j.obj_end()?;
-    write!(j.w, "\n")
+    Ok(())
 }
 
 struct Jw<'a, W: Write> {
@@ -616,6 +957,18 @@ fn write_meta_json(j: &mut Jw<impl Write>, meta: &MetaInfo) -> io::Result<()> {
     j.key_int("filesMatched", meta.files_matched)?;
     if meta.files_errored != 0 { j.key_int("filesErrored", meta.files_errored)?; }
     if let Some(total) = meta.total_matches { j.key_int("totalMatches", total)?; }
+    if let Some(ref budget) = meta.budget {
+        j.key_bool("truncated", budget.truncated)?;
+        j.key_int("maxTokens", budget.max_tokens)?;
+        j.key_int("estimatedTokens", budget.used_tokens)?;
+        for (key, paths) in [("dropped", &budget.dropped), ("partial", &budget.partial)] {
+            if !paths.is_empty() {
+                j.key(key)?; j.arr_start()?;
+                for p in paths { j.arr_str(p)?; }
+                j.arr_end()?;
+            }
+        }
+    }
     j.obj_end()
 }
 
@@ -638,40 +991,194 @@ fn write_tree_json(j: &mut Jw<impl Write>, node: &ScanResult) -> io::Result<()>
 fn write_graph_json(j: &mut Jw<impl Write>, graph: &[GraphEntry]) -> io::Result<()> {
     j.key("graph")?; j.arr_start()?;
     for entry in graph {
-        j.arr_obj_start()?;
-        j.key_str("file", &entry.file)?;
+        j.comma()?;
+        write_graph_entry_json(j, entry)?;
+    }
+    j.arr_end()
+}
+
+fn write_graph_entry_json(j: &mut Jw<impl Write>, entry: &GraphEntry) -> io::Result<()> {
+    j.obj_start()?;
+    j.key_str("file", &entry.file)?;
+    j.key("imports")?; j.arr_start()?;
+    for imp in &entry.imports { j.arr_str(imp)?; }
+    j.arr_end()?;
+    j.obj_end()
+}
+
+fn write_dependents_json(j: &mut Jw<impl Write>, output: &DependentsOutput) -> io::Result<()> {
+    j.key("targets")?; j.arr_start()?;
+    for target in &output.targets { j.arr_str(target)?; }
+    j.arr_end()?;
+    j.key("dependents")?; j.arr_start()?;
+    for level in &output.levels {
+        j.comma()?;
+        j.obj_start()?;
+        j.key_int("depth", level.depth)?;
+        j.key("files")?; j.arr_start()?;
+        for file in &level.files { j.arr_str(file)?; }
+        j.arr_end()?;
+        j.obj_end()?;
+    }
+    j.arr_end()
+}
+
+fn write_cycles_json(j: &mut Jw<impl Write>, cycles: &[ImportCycle]) -> io::Result<()> {
+    j.key("cycles")?; j.arr_start()?;
+    for cycle in cycles {
+        j.comma()?;
+        j.obj_start()?;
+        j.key("files")?; j.arr_start()?;
+        for file in &cycle.files { j.arr_str(file)?; }
+        j.arr_end()?;
+        j.key("path")?; j.arr_start()?;
+        for edge in &cycle.path {
+            j.comma()?;
+            j.obj_start()?;
+            j.key_str("from", &edge.from)?;
+            j.key_str("to", &edge.to)?;
+            if let Some(line) = edge.line { j.key_int("line", line)?; }
+            if let Some(ref content) = edge.content { j.key_str("import", content)?; }
+            j.obj_end()?;
+        }
+        j.arr_end()?;
+        j.obj_end()?;
+    }
+    j.arr_end()
+}
+
+fn write_rollup_json(j: &mut Jw<impl Write>, output: &RollupOutput) -> io::Result<()> {
+    j.key("rollup")?; j.obj_start()?;
+    j.key_str("by", output.by)?;
+    j.key("containers")?; j.arr_start()?;
+    for container in &output.containers {
+        j.comma()?;
+        j.obj_start()?;
+        j.key_str("name", &container.name)?;
+        j.key_int("files", container.files)?;
+        j.obj_end()?;
+    }
+    j.arr_end()?;
+    j.key("edges")?; j.arr_start()?;
+    for edge in &output.edges {
+        j.comma()?;
+        j.obj_start()?;
+        j.key_str("from", &edge.from)?;
+        j.key_str("to", &edge.to)?;
+        j.key_int("count", edge.count)?;
+        j.key("top")?; j.arr_start()?;
+        for pair in &edge.top {
+            j.comma()?;
+            j.obj_start()?;
+            j.key_str("from", &pair.from)?;
+            j.key_str("to", &pair.to)?;
+            j.obj_end()?;
+        }
+        j.arr_end()?;
+        j.obj_end()?;
+    }
+    j.arr_end()?;
+    j.obj_end()
+}
+
+fn write_external_json(j: &mut Jw<impl Write>, output: &ExternalOutput) -> io::Result<()> {
+    j.key("graph")?; j.arr_start()?;
+    for file in &output.files {
+        j.comma()?;
+        j.obj_start()?;
+        j.key_str("file", &file.file)?;
         j.key("imports")?; j.arr_start()?;
-        for imp in &entry.imports { j.arr_str(imp)?; }
+        for imp in &file.imports { j.arr_str(imp)?; }
+        j.arr_end()?;
+        j.key("external")?; j.arr_start()?;
+        for package in &file.packages { j.arr_str(package)?; }
+        j.arr_end()?;
+        j.obj_end()?;
+    }
+    j.arr_end()?;
+    j.key("packages")?; j.arr_start()?;
+    for package in &output.packages {
+        j.comma()?;
+        j.obj_start()?;
+        j.key_str("name", &package.name)?;
+        j.key_str("ecosystem", &package.ecosystem)?;
+        j.key_int("count", package.files.len())?;
+        j.key("files")?; j.arr_start()?;
+        for file in &package.files { j.arr_str(file)?; }
         j.arr_end()?;
         j.obj_end()?;
     }
     j.arr_end()
 }
 
+fn write_orphans_json(j: &mut Jw<impl Write>, output: &OrphansOutput) -> io::Result<()> {
+    j.key("entrypoints")?; j.arr_start()?;
+    for file in &output.entrypoints { j.arr_str(file)?; }
+    j.arr_end()?;
+    j.key("orphans")?; j.arr_start()?;
+    for file in &output.orphans { j.arr_str(file)?; }
+    j.arr_end()
+}
+
+fn write_check_report_json(j: &mut Jw<impl Write>, report: &CheckReport) -> io::Result<()> {
+    j.key_str("rules", &report.rules)?;
+    j.key("violations")?; j.arr_start()?;
+    for v in &report.violations {
+        j.comma()?;
+        j.obj_start()?;
+        j.key_str("file", &v.file)?;
+        if let Some(line) = v.line { j.key_int("line", line)?; }
+        if let Some(ref import) = v.import { j.key_str("import", import)?; }
+        j.key_str("target", &v.target)?;
+        j.key_str("rule", &v.rule)?;
+        if let Some(ref reason) = v.reason { j.key_str("reason", reason)?; }
+        j.obj_end()?;
+    }
+    j.arr_end()
+}
+
+fn write_index_report_json(j: &mut Jw<impl Write>, report: &IndexReport) -> io::Result<()> {
+    j.key("index")?; j.obj_start()?;
+    j.key_str("path", &report.path)?;
+    j.key_str("state", report.state)?;
+    j.key_int("version", report.version)?;
+    j.key_int("files", report.files)?;
+    j.key_int("symbols", report.symbols)?;
+    j.key_int("changed", report.changed)?;
+    j.key_int("added", report.added)?;
+    j.key_int("removed", report.removed)?;
+    j.obj_end()
+}
+
 fn write_symbols_json(j: &mut Jw<impl Write>, symbol_files: &[SymbolFile], compact: bool) -> io::Result<()> {
     j.key("symbols")?; j.arr_start()?;
     for sf in symbol_files {
+        j.comma()?;
+        write_symbol_file_json(j, sf, compact)?;
+    }
+    j.arr_end()
+}
+
+fn write_symbol_file_json(j: &mut Jw<impl Write>, sf: &SymbolFile, compact: bool) -> io::Result<()> {
+    j.obj_start()?;
+    j.key_str("path", &sf.path)?;
+    j.key("symbols")?; j.arr_start()?;
+    for sym in &sf.symbols {
         j.arr_obj_start()?;
-        j.key_str("path", &sf.path)?;
-        j.key("symbols")?; j.arr_start()?;
-        for sym in &sf.symbols {
-            j.arr_obj_start()?;
-            j.key_str("kind", sym.kind)?;
-            j.key_str("name", &sym.name)?;
-            j.key_int("line", sym.line)?;
-            j.key_int("endLine", sym.end_line)?;
-            if !compact {
-                if let Some(vis) = sym.visibility { j.key_str("visibility", vis)?; }
-                if let Some(ref parent) = sym.parent { j.key_str("parent", parent)?; }
-                j.key_str("signature", &sym.signature)?;
-            }
-            if let Some(ref comment) = sym.comment { j.key_str("comment", comment)?; }
-            j.obj_end()?;
+        j.key_str("kind", sym.kind)?;
+        j.key_str("name", &sym.name)?;
+        j.key_int("line", sym.line)?;
+        j.key_int("endLine", sym.end_line)?;
+        if !compact {
+            if let Some(vis) = sym.visibility { j.key_str("visibility", vis)?; }
+            if let Some(ref parent) = sym.parent { j.key_str("parent", parent)?; }
+            j.key_str("signature", &sym.signature)?;
         }
-        j.arr_end()?;
+        if let Some(ref comment) = sym.comment { j.key_str("comment", comment)?; }
         j.obj_end()?;
     }
-    j.arr_end()
+    j.arr_end()?;
+    j.obj_end()
 }
 
 fn write_callers_json(j: &mut Jw<impl Write>, output: &CallersOutput) -> io::Result<()> {
@@ -680,42 +1187,88 @@ fn write_callers_json(j: &mut Jw<impl Write>, output: &CallersOutput) -> io::Res
     } else {
         j.key("declarations")?; j.arr_start()?;
         for d in &output.declarations {
-            j.arr_obj_start()?;
-            j.key_str("path", &d.path)?;
-            j.key_int("line", d.line)?;
-            j.key_str("signature", &d.signature)?;
-            j.obj_end()?;
+            j.comma()?;
+            write_declaration_json(j, d)?;
         }
         j.arr_end()?;
     }
     j.key("callers")?; j.arr_start()?;
     for cf in &output.files {
+        j.comma()?;
+        write_caller_file_json(j, cf)?;
+    }
+    j.arr_end()
+}
+
+fn write_call_tree_json(j: &mut Jw<impl Write>, output: &CallTreeOutput) -> io::Result<()> {
+    j.key_str("direction", output.direction)?;
+    j.key_int("depth", output.depth)?;
+    j.key("roots")?; j.arr_start()?;
+    for node in &output.roots {
+        j.comma()?;
+        write_call_node_json(j, node)?;
+    }
+    j.arr_end()
+}
+
+fn write_call_node_json(j: &mut Jw<impl Write>, node: &CallNode) -> io::Result<()> {
+    j.obj_start()?;
+    j.key_str("name", &node.name)?;
+    j.key_str("kind", node.kind)?;
+    j.key_str("path", &node.path)?;
+    j.key_int("line", node.line)?;
+    j.key("sites")?; j.arr_start()?;
+    for site in &node.sites { j.comma()?; j.int(site)?; }
+    j.arr_end()?;
+    if node.recursive { j.key_bool("recursive", true)?; }
+    j.key("children")?; j.arr_start()?;
+    for child in &node.children {
+        j.comma()?;
+        write_call_node_json(j, child)?;
+    }
+    j.arr_end()?;
+    j.obj_end()
+}
+
+fn write_declaration_json(j: &mut Jw<impl Write>, d: &CallerDeclaration) -> io::Result<()> {
+    j.obj_start()?;
+    j.key_str("path", &d.path)?;
+    j.key_int("line", d.line)?;
+    j.key_str("signature", &d.signature)?;
+    j.obj_end()
+}
+
+fn write_caller_file_json(j: &mut Jw<impl Write>, cf: &CallerFile) -> io::Result<()> {
+    j.obj_start()?;
+    j.key_str("path", &cf.path)?;
+    j.key("sites")?; j.arr_start()?;
+    for site in &cf.sites {
         j.arr_obj_start()?;
-        j.key_str("path", &cf.path)?;
-        j.key("sites")?; j.arr_start()?;
-        for site in &cf.sites {
-            j.arr_obj_start()?;
-            j.key_int("line", site.line)?;
-            j.key_str("content", &site.content)?;
-            j.obj_end()?;
-        }
-        j.arr_end()?;
+        j.key_int("line", site.line)?;
+        j.key_str("kind", site.kind)?;
+        j.key_str("content", &site.content)?;
         j.obj_end()?;
     }
-    j.arr_end()
+    j.arr_end()?;
+    j.obj_end()
 }
 
 fn write_counts_json(j: &mut Jw<impl Write>, counts: &[CountEntry]) -> io::Result<()> {
     j.key("files")?; j.arr_start()?;
     for entry in counts {
-        j.arr_obj_start()?;
-        j.key_str("path", &entry.path)?;
-        j.key_int("count", entry.count)?;
-        j.obj_end()?;
+        j.comma()?;
+        write_count_entry_json(j, entry)?;
     }
     j.arr_end()
 }
 
+fn write_count_entry_json(j: &mut Jw<impl Write>, entry: &CountEntry) -> io::Result<()> {
+    j.obj_start()?;
+    j.key_str("path", &entry.path)?;
+    j.key_int("count", entry.count)?;
+    j.obj_end()
+}
+
 fn write_stats_json(j: &mut Jw<impl Write>, stats: &StatsOutput) -> io::Result<()> {
     j.key("languages")?; j.arr_start()?;
     for lang in &stats.languages {
@@ -746,26 +1299,31 @@ fn write_stats_json(j: &mut Jw<impl Write>, stats: &StatsOutput) -> io::Result<(
 fn write_files_json(j: &mut Jw<impl Write>, files: &[FileEntry]) -> io::Result<()> {
     j.key("files")?; j.arr_start()?;
     for file in files {
-        j.arr_obj_start()?;
-        j.key_str("path", &file.path)?;
-        if let Some(ref error) = file.error { j.key_str("error", error)?; }
-        if let Some(ref contents) = file.contents { j.key_str("contents", contents)?; }
-        if let Some(ref chunks) = file.chunks {
-            j.key("chunks")?; j.arr_start()?;
-            for chunk in chunks {
-                j.arr_obj_start()?;
-                j.key_int("startLine", chunk.start_line)?;
-                j.key_int("endLine", chunk.end_line)?;
-                j.key_str("content", &chunk.content)?;
-                j.obj_end()?;
-            }
-            j.arr_end()?;
-        }
-        j.obj_end()?;
+        j.comma()?;
+        write_file_entry_json(j, file)?;
     }
     j.arr_end()
 }
 
+fn write_file_entry_json(j: &mut Jw<impl Write>, file: &FileEntry) -> io::Result<()> {
+    j.obj_start()?;
+    j.key_str("path", &file.path)?;
+    if let Some(ref error) = file.error { j.key_str("error", error)?; }
+    if let Some(ref contents) = file.contents { j.key_str("contents", contents)?; }
+    if let Some(ref chunks) = file.chunks {
+        j.key("chunks")?; j.arr_start()?;
+        for chunk in chunks {
+            j.arr_obj_start()?;
+            j.key_int("startLine", chunk.start_line)?;
+            j.key_int("endLine", chunk.end_line)?;
+            j.key_str("content", &chunk.content)?;
+            j.obj_end()?;
+        }
+        j.arr_end()?;
+    }
+    j.obj_end()
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -793,6 +1351,7 @@ mod tests {
                 files_matched: 5,
                 files_errored: 0,
                 total_matches: None,
+                budget: None,
             }),
             ..Default::default()
         };
@@ -813,6 +1372,7 @@ mod tests {
                 files_matched: 0,
                 files_errored: 0,
                 total_matches: None,
+                budget: None,
             }),
             ..Default::default()
         };
@@ -869,12 +1429,198 @@ mod tests {
         assert!(s.contains("some code"));
     }
 
+    #[test]
+    fn write_dependents_output() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Dependents(DependentsOutput {
+                targets: vec!["m.rs".to_owned()],
+                levels: vec![
+                    DependentLevel { depth: 1, files: vec!["a.rs".to_owned()] },
+                    DependentLevel { depth: 2, files: vec!["main.rs".to_owned()] },
+                ],
+            }),
+            ..Default::default()
+        };
+        let s = output_to_string(&envelope);
+        assert_eq!(s, "targets:\n- m.rs\ndependents:\n- depth: 1\n  files:\n  - a.rs\n- depth: 2\n  files:\n  - main.rs\n");
+    }
+
+    fn sample_call_tree() -> CallTreeOutput {
+        let node = |name: &str, path: &str, line: usize, sites: Vec<usize>, children: Vec<CallNode>| CallNode {
+            name: name.to_owned(),
+            kind: "fn",
+            path: path.to_owned(),
+            line,
+            sites,
+            recursive: false,
+            children,
+        };
+        let mut recursive = node("save", "src/store.rs", 5, vec![6], vec![]);
+        recursive.recursive = true;
+        CallTreeOutput {
+            direction: "callers",
+            depth: 2,
+            roots: vec![node("save", "src/store.rs", 5, vec![], vec![
+                recursive,
+                node("run", "src/main.rs", 5, vec![7, 9], vec![node("main", "src/main.rs", 1, vec![2], vec![])]),
+            ])],
+        }
+    }
+
+    #[test]
+    fn write_call_tree_output() {
+        let envelope = OutputEnvelope { payload: OutputPayload::CallTree(sample_call_tree()), ..Default::default() };
+        let s = output_to_string(&envelope);
+        assert_eq!(s, [
+            "direction: callers",
+            "depth: 2",
+            "roots:",
+            "- name: save",
+            "  kind: fn",
+            "  path: src/store.rs",
+            "  line: 5",
+            "  children:",
+            "  - name: save",
+            "    kind: fn",
+            "    path: src/store.rs",
+            "    line: 5",
+            "    sites: [6]",
+            "    recursive: true",
+            "  - name: run",
+            "    kind: fn",
+            "    path: src/main.rs",
+            "    line: 5",
+            "    sites: [7, 9]",
+            "    children:",
+            "    - name: main",
+            "      kind: fn",
+            "      path: src/main.rs",
+            "      line: 1",
+            "      sites: [2]",
+            "",
+        ].join("\n"));
+
+        let empty = OutputEnvelope {
+            payload: OutputPayload::CallTree(CallTreeOutput { direction: "callees", depth: 1, roots: vec![] }),
+            ..Default::default()
+        };
+        assert_eq!(output_to_string(&empty), "direction: callees\ndepth: 1\nroots: []\n");
+    }
+
+    #[test]
+    fn write_cycles_output() {
+        let edge = |from: &str, to: &str, line: Option<usize>| ImportEdge {
+            from: from.to_owned(),
+            to: to.to_owned(),
+            line,
+            content: line.map(|_| format!("from .{} import x", &to[..1])),
+        };
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Cycles(vec![ImportCycle {
+                files: vec!["a.py".to_owned(), "b.py".to_owned()],
+                path: vec![edge("a.py", "b.py", Some(3)), edge("b.py", "a.py", None)],
+            }]),
+            ..Default::default()
+        };
+        let s = output_to_string(&envelope);
+        assert_eq!(
+            s,
+            "cycles:\n- files:\n  - a.py\n  - b.py\n  path:\n  - from: a.py\n    to: b.py\n    line: 3\n    import: from .b import x\n  - from: b.py\n    to: a.py\n"
+        );
+        let empty = OutputEnvelope { payload: OutputPayload::Cycles(vec![]), ..Default::default() };
+        assert_eq!(output_to_string(&empty), "cycles: []\n");
+    }
+
+    #[test]
+    fn write_rollup_output() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Rollup(RollupOutput {
+                by: "dir",
+                containers: vec![Container { name: ".".to_owned(), files: 1 }, Container { name: "src".to_owned(), files: 2 }],
+                edges: vec![ContainerEdge {
+                    from: ".".to_owned(),
+                    to: "src".to_owned(),
+                    count: 1,
+                    top: vec![FilePair { from: "main.rs".to_owned(), to: "src/cli.rs".to_owned() }],
+                }],
+            }),
+            ..Default::default()
+        };
+        let s = output_to_string(&envelope);
+        assert_eq!(
+            s,
+            "rollup:\n  by: dir\n  containers:\n  - name: \".\"\n    files: 1\n  - name: src\n    files: 2\n  edges:\n  - from: \".\"\n    to: src\n    count: 1\n    top:\n    - from: main.rs\n      to: src/cli.rs\n"
+        );
+        let json = output_to_json(&envelope);
+        assert!(json.contains(r#""rollup":{"by":"dir","containers":[{"name":".","files":1},"#));
+        assert!(json.contains(r#""edges":[{"from":".","to":"src","count":1,"top":[{"from":"main.rs","to":"src/cli.rs"}]}]}"#));
+    }
+
+    #[test]
+    fn write_external_output() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::External(ExternalOutput {
+                files: vec![
+                    ExternalFile { file: "src/a.ts".to_owned(), imports: vec!["src/b.ts".to_owned()], packages: vec!["react".to_owned()] },
+                    ExternalFile { file: "src/b.ts".to_owned(), imports: vec![], packages: vec![] },
+                ],
+                packages: vec![ExternalPackage { name: "react".to_owned(), ecosystem: "npm".to_owned(), files: vec!["src/a.ts".to_owned()] }],
+            }),
+            ..Default::default()
+        };
+        let s = output_to_string(&envelope);
+        assert_eq!(
+            s,
+            "graph:\n- file: src/a.ts\n  imports:\n  - src/b.ts\n  external:\n  - react\n- file: src/b.ts\n  imports: []\npackages:\n- name: react\n  ecosystem: npm\n  count: 1\n  files:\n  - src/a.ts\n"
+        );
+        let json = output_to_json(&envelope);
+        assert!(json.contains(r#""graph":[{"file":"src/a.ts","imports":["src/b.ts"],"external":["react"]},"#));
+        assert!(json.contains(r#""packages":[{"name":"react","ecosystem":"npm","count":1,"files":["src/a.ts"]}]"#));
+    }
+
+    #[test]
+    fn write_orphans_output() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Orphans(OrphansOutput {
+                entrypoints: vec!["src/main.rs".to_owned()],
+                orphans: vec![],
+            }),
+            ..Default::default()
+        };
+        assert_eq!(output_to_string(&envelope), "entrypoints:\n- src/main.rs\norphans: []\n");
+        assert!(output_to_json(&envelope).contains(r#""entrypoints":["src/main.rs"],"orphans":[]"#));
+    }
+
+    #[test]
+    fn write_check_report_output() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Check(CheckReport {
+                rules: ".src/layers.toml".to_owned(),
+                violations: vec![DepViolation {
+                    file: "src/ui/page.ts".to_owned(),
+                    line: Some(3),
+                    import: Some("import { db } from '../db';".to_owned()),
+                    target: "src/db/index.ts".to_owned(),
+                    rule: "src/ui/** -> src/db/**".to_owned(),
+                    reason: None,
+                }],
+            }),
+            ..Default::default()
+        };
+        let s = output_to_string(&envelope);
+        assert!(s.starts_with("rules: .src/layers.toml\nviolations:\n- file: src/ui/page.ts\n  line: 3\n"));
+        assert!(s.contains("  target: src/db/index.ts\n"));
+        assert!(!s.contains("reason"));
+        let json = output_to_json(&envelope);
+        assert!(json.contains(r#""violations":[{"file":"src/ui/page.ts","line":3,"import":"import { db } from '../db';","target":"src/db/index.ts","rule":"src/ui/** -> src/db/**"}]"#));
+    }
+
     #[test]
     fn write_graph_output() {
         let envelope = OutputEnvelope {
             payload: OutputPayload::Graph(vec![
-                GraphEntry { file: "a.rs".to_owned(), imports: vec!["b.rs".to_owned()] },
-                GraphEntry { file: "c.rs".to_owned(), imports: vec![] },
+                GraphEntry { file: "a.rs".to_owned(), imports: vec!["b.rs".to_owned()], external: vec![] },
+                GraphEntry { file: "c.rs".to_owned(), imports: vec![], external: vec![] },
             ]),
             ..Default::default()
         };
@@ -1114,6 +1860,7 @@ mod tests {
                 files_matched: 5,
                 files_errored: 0,
                 total_matches: None,
+                budget: None,
             }),
             ..Default::default()
         };
@@ -1135,11 +1882,33 @@ mod tests {
         assert!(s.contains("\"error\":\"Something went wrong\""));
     }
 
+    #[test]
+    fn json_dependents() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Dependents(DependentsOutput {
+                targets: vec!["m.rs".to_owned()],
+                levels: vec![DependentLevel { depth: 1, files: vec!["a.rs".to_owned(), "b.rs".to_owned()] }],
+            }),
+            ..Default::default()
+        };
+        let s = envelope_to_json(&envelope);
+        assert_eq!(s, r#"{"targets":["m.rs"],"dependents":[{"depth":1,"files":["a.rs","b.rs"]}]}"#);
+    }
+
+    #[test]
+    fn json_call_tree() {
+        let envelope = OutputEnvelope { payload: OutputPayload::CallTree(sample_call_tree()), ..Default::default() };
+        let s = envelope_to_json(&envelope);
+        assert!(s.starts_with(r#"{"direction":"callers","depth":2,"roots":[{"name":"save","kind":"fn","path":"src/store.rs","line":5,"sites":[],"children":["#));
+        assert!(s.contains(r#"{"name":"save","kind":"fn","path":"src/store.rs","line":5,"sites":[6],"recursive":true,"children":[]}"#));
+        assert!(s.contains(r#""sites":[7,9],"children":[{"name":"main""#));
+    }
+
     #[test]
     fn json_graph() {
         let envelope = OutputEnvelope {
             payload: OutputPayload::Graph(vec![
-                GraphEntry { file: "a.rs".to_owned(), imports: vec!["b.rs".to_owned()] },
+                GraphEntry { file: "a.rs".to_owned(), imports: vec!["b.rs".to_owned()], external: vec![] },
             ]),
             ..Default::default()
         };
@@ -1196,4 +1965,51 @@ mod tests {
         assert!(s.contains("\"kind\":\"fn\""));
         assert!(s.contains("\"visibility\":\"pub\""));
     }
+
+    fn sample_index_report() -> IndexReport {
+        IndexReport {
+            path: ".src/index".to_owned(),
+            state: "stale",
+            version: 1,
+            files: 12,
+            symbols: 40,
+            changed: 2,
+            added: 1,
+            removed: 0,
+        }
+    }
+
+    #[test]
+    fn write_index_report_output() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Index(sample_index_report()),
+            ..Default::default()
+        };
+        let s = output_to_string(&envelope);
+        assert!(s.contains("index:\n  path: .src/index\n"));
+        assert!(s.contains("  state: stale\n"));
+        assert!(s.contains("  changed: 2\n"));
+    }
+
+    #[test]
+    fn json_index_report() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Index(sample_index_report()),
+            ..Default::default()
+        };
+        let s = output_to_json(&envelope);
+        assert!(s.contains("\"index\":{\"path\":\".src/index\",\"state\":\"stale\""));
+        assert!(s.contains("\"symbols\":40"));
+    }
+
+    #[test]
+    fn envelope_to_json_is_single_line() {
+        let envelope = OutputEnvelope {
+            payload: OutputPayload::Counts(vec![CountEntry { path: "a\nb.rs".to_owned(), count: 1 }]),
+            ..Default::default()
+        };
+        let s = envelope_to_json(&envelope);
+        assert!(!s.contains('\n'));
+        assert!(s.starts_with('{') && s.ends_with('}'));
+    }
 }
@@ -957,6 +957,72 @@ fn callers_with_limit() {
     assert!(stdout.contains("meta:"));
 }
 
+#[test]
+fn callers_match_code_identifiers_and_classify_sites() {
+    let dir = temp_project("callers_kinds", &[
+        ("jobs/task.py", "class Task:\n    def run(self):\n        pass\n"),
+        ("jobs/main.py", concat!(
+            "from jobs.task import Task, run\n",
+            "# run the runner\n",
+            "def main(t: Task):\n",
+            "    print(\"run\")\n",
+            "    t.run()\n",
+            "    handlers = [run, rerun]\n",
+            "    Run()\n",
+        )),
+        ("app/job.rb", "class Job\n  # run later\n  def perform\n    worker.run\n  end\nend\n"),
+    ]);
+    let root = dir.to_string_lossy().into_owned();
+
+    let (stdout, _, code) = run_src_in(&root, &["--callers", "run", "--json"]);
+    assert_eq!(code, 0);
+    for site in [
+        r#"{"line":1,"kind":"import","content":"from jobs.task import Task, run"}"#,
+        r#"{"line":5,"kind":"call","content":"t.run()"}"#,
+        r#"{"line":6,"kind":"reference","content":"handlers = [run, rerun]"}"#,
+        r#"{"line":4,"kind":"call","content":"worker.run"}"#,
+    ] {
+        assert!(stdout.contains(site), "{}\n{}", site, stdout);
+    }
+    for skipped in ["# run", "print(", "Run()"] {
+        assert!(!stdout.contains(skipped), "{}\n{}", skipped, stdout);
+    }
+
+    let (stdout, _, code) = run_src_in(&root, &["--callers", "run", "-i"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("content: Run()"), "{}", stdout);
+
+    let (stdout, _, code) = run_src_in(&root, &["--callers", "Task"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("kind: type\n    content: \"def main(t: Task):\""), "{}", stdout);
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn call_tree_walks_callers_and_callees() {
+    let dir = temp_project("calltree", &[
+        ("src/main.rs", "fn main() {\n    run();\n}\n\nfn run() {\n    store::load();\n    store::save();\n}\n"),
+        ("src/store.rs", "pub fn load() {\n    parse_row();\n}\n\npub fn save() {\n    save();\n}\n\nfn parse_row() {}\n"),
+    ]);
+    let root = dir.to_string_lossy().into_owned();
+
+    let (stdout, _, code) = run_src_in(&root, &["--call-tree", "parse_row", "--json"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains(r#""direction":"callers","depth":3,"roots":[{"name":"parse_row","kind":"fn","path":"src/store.rs","line":9,"sites":[],"children":[{"name":"load","kind":"fn","path":"src/store.rs","line":1,"sites":[2],"children":[{"name":"run","kind":"fn","path":"src/main.rs","line":5,"sites":[6],"children":[{"name":"main""#), "{}", stdout);
+
+    let (stdout, _, code) = run_src_in(&root, &["--call-tree", "parse_row", "--depth", "1"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("depth: 1"));
+    assert!(!stdout.contains("name: run"));
+
+    let (stdout, _, code) = run_src_in(&root, &["--callees", "run", "--depth", "2"]);
+    assert_eq!(code, 0);
+    for line in ["- name: run", "  - name: load", "      sites: [2]", "  - name: save", "    sites: [7]", "      recursive: true"] {
+        assert!(stdout.contains(line), "{}\n{}", line, stdout);
+    }
+    std::fs::remove_dir_all(&dir).ok();
+}
+
 // ── --auto-expand ──
 
 #[test]
@@ -987,6 +1053,17 @@ fn auto_expand_includes_full_function() {
     assert!(stdout.contains("pub fn add"));
 }
 
+#[test]
+fn auto_expand_picks_the_first_enclosing_symbol() {
+    let dir = temp_project("auto_expand_method", &[
+        ("a.ts", "export class Shop {\n  open() {\n    return 1;\n  }\n\n  close() {\n    return 2;\n  }\n}\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--lines", "a.ts:7:7", "--auto-expand"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("export class Shop") && stdout.contains("return 1;"), "{}", stdout);
+    std::fs::remove_dir_all(&dir).ok();
+}
+
 // ── Dispatch priority ──
 
 #[test]
@@ -1012,6 +1089,393 @@ fn combined_compact_with_find() {
     assert!(stdout.contains("fn add"));
 }
 
+fn dependents_project(name: &str) -> PathBuf {
+    temp_project(name, &[
+        ("src/models.ts", "export interface Invoice {}\n"),
+        ("src/service.ts", "import { Invoice } from './models';\n"),
+        ("src/api.ts", "import { create } from './service';\n"),
+        ("src/main.ts", "import { route } from './api';\nimport { Invoice } from './models';\n"),
+        ("src/util.ts", "export const u = 1;\n"),
+    ])
+}
+
+#[test]
+fn graph_dependents_lists_transitive_importers_by_depth() {
+    let dir = dependents_project("dependents");
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--dependents", "src/models.ts"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("targets:\n- src/models.ts\n"));
+    assert!(stdout.contains("- depth: 1\n  files:\n  - src/main.ts\n  - src/service.ts\n"));
+    assert!(stdout.contains("- depth: 2\n  files:\n  - src/api.ts\n"));
+    assert!(stdout.contains("filesMatched: 3"));
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_impact_unions_changed_files() {
+    let dir = dependents_project("impact");
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--impact", "src/service.ts", "src/util.ts", "src/gone.ts", "--json"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains(r#""targets":["src/service.ts","src/util.ts","src/gone.ts"]"#));
+    assert!(stdout.contains(r#""dependents":[{"depth":1,"files":["src/api.ts"]},{"depth":2,"files":["src/main.ts"]}]"#));
+    assert!(stdout.contains("src/gone.ts: not in the dependency graph"));
+
+    let (_, stderr, code) = run_src_in(&dir.to_string_lossy(), &["--impact", "src/service.ts"]);
+    assert_ne!(code, 0);
+    assert!(stderr.contains("--impact requires --graph"));
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_cycles_reports_shortest_path_with_import_lines() {
+    let dir = temp_project("cycles", &[
+        ("app/__init__.py", ""),
+        ("app/models.py", "from app.service import create\n"),
+        ("app/service.py", "import os\nfrom app.models import Invoice\n"),
+        ("web/a.ts", "import { b } from './b';\n"),
+        ("web/b.ts", "import { c } from './c';\n"),
+        ("web/c.ts", "export const c = 1;\nimport { a } from './a';\n"),
+        ("web/d.ts", "import { a } from './a';\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--cycles", "--json"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains(r#""filesMatched":2"#));
+    assert!(stdout.contains(
+        r#"{"from":"app/service.py","to":"app/models.py","line":2,"import":"from app.models import Invoice"}"#
+    ));
+    assert!(stdout.contains(r#""files":["web/a.ts","web/b.ts","web/c.ts"]"#));
+    assert!(stdout.contains(r#"{"from":"web/c.ts","to":"web/a.ts","line":2,"import":"import { a } from './a';"}"#));
+    assert!(!stdout.contains("web/d.ts"));
+
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--cycles", "-g", "web/d.ts"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("cycles: []"));
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_dot_and_mermaid_render_diagrams() {
+    let (stdout, _, code) = run_src_in(&fixture(), &["--graph", "--format", "dot"]);
+    assert_eq!(code, 0);
+    assert!(stdout.starts_with("digraph dependencies {\n"));
+    assert!(stdout.contains("subgraph \"cluster_lib\""));
+    assert!(stdout.contains("  \"lib/utils.ts\" -> \"lib/config.ts\";\n"));
+
+    let (stdout, _, code) = run_src_in(&fixture(), &["--graph", "-F", "mermaid", "--collapse-depth", "1", "--edge-labels"]);
+    assert_eq!(code, 0);
+    assert!(stdout.starts_with("flowchart LR\n"));
+    assert!(stdout.contains("[\"lib/\"]"));
+    assert!(!stdout.contains("utils.ts"));
+}
+
+#[test]
+fn graph_by_package_rolls_up_weighted_edges() {
+    let dir = temp_project("rollup", &[
+        ("web/package.json", "{}\n"),
+        ("web/src/app.ts", "import { a } from '../../shared/src/a';\nimport { b } from '../../shared/src/b';\n"),
+        ("web/src/page.ts", "import { a } from '../../shared/src/a';\nimport { app } from './app';\n"),
+        ("shared/package.json", "{}\n"),
+        ("shared/src/a.ts", "export const a = 1;\n"),
+        ("shared/src/b.ts", "export const b = 1;\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--by", "package", "--json"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains(r#""containers":[{"name":"shared","files":2},{"name":"web","files":2}]"#));
+    assert!(stdout.contains(r#""edges":[{"from":"web","to":"shared","count":3,"top":[{"from":"web/src/app.ts","to":"shared/src/a.ts"},{"from":"web/src/page.ts","to":"shared/src/a.ts"},{"from":"web/src/app.ts","to":"shared/src/b.ts"}]}]"#));
+
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--by", "dir"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("  - from: web/src\n    to: shared/src\n    count: 3\n"));
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_go_resolves_across_workspace_modules() {
+    let dir = temp_project("gowork", &[
+        ("go.work", "go 1.22\n\nuse (\n\t./api\n\t./billing\n)\n"),
+        ("api/go.mod", "module example.com/api\n\nrequire example.com/money v0.0.0\nreplace example.com/money => ../libs/money\n"),
+        ("api/main.go", "package main\n\nimport (\n\t\"fmt\"\n\t\"example.com/billing/invoice\"\n\t\"example.com/money\"\n)\n"),
+        ("billing/go.mod", "module example.com/billing\n"),
+        ("billing/invoice/invoice.go", "package invoice\n"),
+        ("billing/invoice/total.go", "package invoice\n"),
+        ("billing/invoice/invoice_test.go", "package invoice\n"),
+        ("billing/invoice/pdf/pdf.go", "package pdf\n"),
+        ("libs/money/go.mod", "module example.com/money\n"),
+        ("libs/money/money.go", "package money\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--json"]);
+    assert_eq!(code, 0);
+    assert!(
+        stdout.contains(r#"{"file":"api/main.go","imports":["billing/invoice/invoice.go","billing/invoice/total.go","libs/money/money.go"]}"#),
+        "{}",
+        stdout
+    );
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_rust_resolves_across_workspace_crates() {
+    let dir = temp_project("cargows", &[
+        ("Cargo.toml", "[workspace]\nmembers = [\"crates/*\"]\n"),
+        ("crates/api/Cargo.toml", "[package]\nname = \"api\"\n\n[dependencies]\nshared-types = { path = \"../shared-types\" }\nserde = \"1\"\n"),
+        ("crates/api/src/main.rs", "mod routes;\n\nuse shared_types::Invoice;\nuse serde::Serialize;\n"),
+        ("crates/api/src/routes.rs", "pub mod billing;\npub use self::billing::handle;\n"),
+        ("crates/api/src/routes/billing.rs", "use crate::routes;\nuse shared_types::{tax::Rate, Invoice};\n"),
+        ("crates/shared-types/Cargo.toml", "[package]\nname = \"shared-types\"\n"),
+        ("crates/shared-types/src/lib.rs", "#[path = \"gen/tax_rates.rs\"]\npub mod tax;\n"),
+        ("crates/shared-types/src/gen/tax_rates.rs", "pub struct Rate;\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--json"]);
+    assert_eq!(code, 0);
+    for edge in [
+        r#"{"file":"crates/api/src/main.rs","imports":["crates/api/src/routes.rs","crates/shared-types/src/lib.rs"]}"#,
+        r#"{"file":"crates/api/src/routes.rs","imports":["crates/api/src/routes/billing.rs"]}"#,
+        r#"{"file":"crates/api/src/routes/billing.rs","imports":["crates/api/src/routes.rs","crates/shared-types/src/lib.rs"]}"#,
+        r#"{"file":"crates/shared-types/src/lib.rs","imports":["crates/shared-types/src/gen/tax_rates.rs"]}"#,
+    ] {
+        assert!(stdout.contains(edge), "{}\n{}", edge, stdout);
+    }
+
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--external", "--json"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains(r#""name":"serde","ecosystem":"cargo""#), "{}", stdout);
+    assert!(!stdout.contains(r#""name":"shared_types""#), "{}", stdout);
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_jvm_resolves_through_gradle_source_roots() {
+    let dir = temp_project("gradle", &[
+        ("settings.gradle.kts", "include(\":app\", \":domain\")\n"),
+        ("domain/build.gradle.kts", "sourceSets { main { java.srcDir(\"src/generated/java\") } }\n"),
+        ("domain/src/main/java/com/shop/domain/Order.java", "package com.shop.domain;\n\npublic class Order {\n    private Money total;\n}\n"),
+        ("domain/src/main/java/com/shop/domain/Money.java", "package com.shop.domain;\n\npublic class Money {}\n"),
+        ("domain/src/generated/java/com/shop/domain/OrderDto.java", "package com.shop.domain;\n\npublic class OrderDto {}\n"),
+        ("app/src/main/kotlin/com/shop/app/Main.kt", "package com.shop.app\n\nimport com.shop.domain.*\n\nfun main() = Checkout().run()\n"),
+        ("app/src/main/kotlin/com/shop/app/Checkout.kt", "package com.shop.app\n\nimport com.shop.domain.Order.Status\n\nclass Checkout\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--json"]);
+    assert_eq!(code, 0);
+    for edge in [
+        r#"{"file":"app/src/main/kotlin/com/shop/app/Main.kt","imports":["app/src/main/kotlin/com/shop/app/Checkout.kt","domain/src/generated/java/com/shop/domain/OrderDto.java","domain/src/main/java/com/shop/domain/Money.java","domain/src/main/java/com/shop/domain/Order.java"]}"#,
+        r#"{"file":"app/src/main/kotlin/com/shop/app/Checkout.kt","imports":["domain/src/main/java/com/shop/domain/Order.java"]}"#,
+        r#"{"file":"domain/src/main/java/com/shop/domain/Order.java","imports":["domain/src/main/java/com/shop/domain/Money.java"]}"#,
+    ] {
+        assert!(stdout.contains(edge), "{}\n{}", edge, stdout);
+    }
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_csharp_resolves_namespaces_across_projects() {
+    let dir = temp_project("csproj", &[
+        ("Api/Api.csproj", "<Project Sdk=\"Microsoft.NET.Sdk.Web\">\n  <ItemGroup>\n    <ProjectReference Include=\"..\\Domain\\Domain.csproj\" />\n  </ItemGroup>\n</Project>\n"),
+        ("Api/GlobalUsings.cs", "global using Shop.Domain.Pricing;\n"),
+        ("Api/Controllers/OrdersController.cs", "using Microsoft.AspNetCore.Mvc;\nusing Shop.Domain.Orders;\n\nnamespace Shop.Api.Controllers;\n\npublic class OrdersController { }\n"),
+        ("Domain/Domain.csproj", "<Project Sdk=\"Microsoft.NET.Sdk\" />\n"),
+        ("Domain/Model/Order.cs", "namespace Shop.Domain.Orders\n{\n    public class Order { }\n}\n"),
+        ("Domain/Model/Pricing/TaxRule.cs", "namespace Shop.Domain.Pricing;\n\npublic class TaxRule { }\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--json"]);
+    assert_eq!(code, 0);
+    assert!(
+        stdout.contains(r#"{"file":"Api/Controllers/OrdersController.cs","imports":["Domain/Model/Order.cs","Domain/Model/Pricing/TaxRule.cs"]}"#),
+        "{}",
+        stdout
+    );
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_python_resolves_src_layout_and_service_roots() {
+    let dir = temp_project("pyroots", &[
+        ("libs/billing/pyproject.toml", "[tool.setuptools.package-dir]\n\"\" = \"src\"\n"),
+        ("libs/billing/src/billing/__init__.py", ""),
+        ("libs/billing/src/billing/invoice.py", "from billing.tax import rate\n"),
+        ("libs/billing/src/billing/tax.py", "rate = 1\n"),
+        ("libs/billing/scripts/report.py", "from billing import invoice\n"),
+        ("services/api/manage.py", "from shop import settings\n"),
+        ("services/api/shop/__init__.py", ""),
+        ("services/api/shop/settings.py", "from acme.plugins import audit\n"),
+        ("services/api/acme/plugins/audit.py", ""),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--json"]);
+    assert_eq!(code, 0);
+    for edge in [
+        r#"{"file":"libs/billing/src/billing/invoice.py","imports":["libs/billing/src/billing/__init__.py","libs/billing/src/billing/tax.py"]}"#,
+        r#"{"file":"libs/billing/scripts/report.py","imports":["libs/billing/src/billing/__init__.py","libs/billing/src/billing/invoice.py"]}"#,
+        r#"{"file":"services/api/manage.py","imports":["services/api/shop/__init__.py","services/api/shop/settings.py"]}"#,
+        r#"{"file":"services/api/shop/settings.py","imports":["services/api/acme/plugins/audit.py"]}"#,
+    ] {
+        assert!(stdout.contains(edge), "{}\n{}", edge, stdout);
+    }
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_ruby_resolves_rails_constants_and_requires() {
+    let dir = temp_project("rails", &[
+        ("app/controllers/billing/invoices_controller.rb", "module Billing\n  class InvoicesController < ApplicationController\n    def create\n      InvoiceMailer.paid(Invoice.find(1)).deliver_later\n    end\n  end\nend\n"),
+        ("app/controllers/application_controller.rb", "class ApplicationController\nend\n"),
+        ("app/mailers/billing/invoice_mailer.rb", "class Billing::InvoiceMailer\n  include Archivable\nend\n"),
+        ("app/models/billing/invoice.rb", "require 'payments/gateway'\n\nmodule Billing\n  class Invoice\n  end\nend\n"),
+        ("app/models/concerns/archivable.rb", "module Archivable\nend\n"),
+        ("lib/payments/gateway.rb", "require_relative 'retry'\n"),
+        ("lib/payments/retry.rb", ""),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--json"]);
+    assert_eq!(code, 0);
+    for edge in [
+        r#"{"file":"app/controllers/billing/invoices_controller.rb","imports":["app/controllers/application_controller.rb","app/mailers/billing/invoice_mailer.rb","app/models/billing/invoice.rb"]}"#,
+        r#"{"file":"app/mailers/billing/invoice_mailer.rb","imports":["app/models/concerns/archivable.rb"]}"#,
+        r#"{"file":"app/models/billing/invoice.rb","imports":["lib/payments/gateway.rb"]}"#,
+        r#"{"file":"lib/payments/gateway.rb","imports":["lib/payments/retry.rb"]}"#,
+    ] {
+        assert!(stdout.contains(edge), "{}\n{}", edge, stdout);
+    }
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_typescript_resolves_workspace_packages_and_references() {
+    let dir = temp_project("tsworkspace", &[
+        ("package.json", r#"{ "private": true }"#),
+        ("pnpm-workspace.yaml", "packages:\n  - 'libs/*'\n  - 'apps/*'\n"),
+        ("tsconfig.json", r#"{ "files": [], "references": [{ "path": "./apps/web" }] }"#),
+        ("apps/web/tsconfig.json", r#"{ "compilerOptions": { "paths": { "~/*": ["src/*"] } } }"#),
+        ("apps/web/package.json", r#"{ "name": "web" }"#),
+        ("apps/web/src/page.tsx", "import { Button } from '@acme/ui/button';\nimport { theme } from '@acme/ui';\nimport { nav } from '~/nav';\nimport React from 'react';\n"),
+        ("apps/web/src/nav.ts", "export const nav = 1;\n"),
+        ("libs/ui/package.json", r##"{ "name": "@acme/ui", "exports": { ".": { "types": "./dist/index.d.ts", "import": "./dist/index.js" }, "./*": "./src/*.tsx" }, "imports": { "#tokens": "./src/tokens.ts" } }"##),
+        ("libs/ui/src/index.ts", "export const theme = 1;\n"),
+        ("libs/ui/src/button.tsx", "import { colors } from '#tokens';\n"),
+        ("libs/ui/src/tokens.ts", "export const colors = 1;\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--external", "--json"]);
+    assert_eq!(code, 0);
+    for edge in [
+        r#"{"file":"apps/web/src/page.tsx","imports":["apps/web/src/nav.ts","libs/ui/src/button.tsx","libs/ui/src/index.ts"],"external":["react"]}"#,
+        r#"{"file":"libs/ui/src/button.tsx","imports":["libs/ui/src/tokens.ts"],"external":[]}"#,
+    ] {
+        assert!(stdout.contains(edge), "{}\n{}", edge, stdout);
+    }
+    assert!(!stdout.contains(r#""name":"@acme/ui""#));
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_resolves_webpack_jest_and_babel_aliases() {
+    let dir = temp_project("bundleraliases", &[
+        ("webpack.config.js", "const path = require('path');\nmodule.exports = {\n  resolve: {\n    alias: {\n      Utilities: path.resolve(__dirname, 'src/utilities/'),\n    },\n  },\n};\n"),
+        ("jest.config.js", "module.exports = {\n  moduleNameMapper: {\n    '^@/(.*)$': '<rootDir>/src/$1',\n  },\n};\n"),
+        (".babelrc", r#"{ "plugins": [["module-resolver", { "alias": { "@components": "./src/components" } }]] }"#),
+        ("src/app.js", "import { format } from 'Utilities/format';\nimport Button from '@components/Button';\nimport { api } from '@/api';\nimport React from 'react';\n"),
+        ("src/utilities/format.js", "export const format = 1;\n"),
+        ("src/components/Button.jsx", "export default 1;\n"),
+        ("src/api.js", "export const api = 1;\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--external", "--json"]);
+    assert_eq!(code, 0);
+    let edge = r#"{"file":"src/app.js","imports":["src/api.js","src/components/Button.jsx","src/utilities/format.js"],"external":["react"]}"#;
+    assert!(stdout.contains(edge), "{}\n{}", edge, stdout);
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_external_lists_packages_and_inventory() {
+    let dir = temp_project("external", &[
+        ("src/app.ts", "import React from 'react';\nimport { get } from 'lodash/get';\nimport { util } from './util';\nimport fs from 'fs';\n"),
+        ("src/util.ts", "import { merge } from 'lodash';\n"),
+        ("tools/run.py", "import os\nimport requests\nfrom helpers import go\n"),
+        ("tools/helpers.py", "def go(): pass\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--external", "--json"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains(r#"{"file":"src/app.ts","imports":["src/util.ts"],"external":["lodash","react"]}"#));
+    assert!(stdout.contains(r#"{"file":"tools/run.py","imports":["tools/helpers.py"],"external":["requests"]}"#));
+    assert!(stdout.contains(r#""packages":[{"name":"lodash","ecosystem":"npm","count":2,"files":["src/app.ts","src/util.ts"]},"#));
+    assert!(stdout.contains(r#"{"name":"requests","ecosystem":"pypi","count":1,"files":["tools/run.py"]}"#));
+    assert!(!stdout.contains(r#""name":"helpers""#));
+    assert!(!stdout.contains(r#""name":"fs""#));
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn graph_orphans_skips_imported_files_and_entrypoints() {
+    let dir = temp_project("orphans", &[
+        ("package.json", r#"{"name": "app", "bin": {"app": "./dist/cli.js"}}"#),
+        ("src/index.ts", "import { a } from './a';\n"),
+        ("src/a.ts", "export const a = 1;\n"),
+        ("src/cli.ts", "import { a } from './a';\n"),
+        ("src/unused.ts", "import { a } from './a';\n"),
+    ]);
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["--graph", "--orphans"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("entrypoints:\n- src/cli.ts\n- src/index.ts\norphans:\n- src/unused.ts\n"), "{}", stdout);
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+// ── check-deps ──
+
+fn layered_project(name: &str, rules: &str) -> PathBuf {
+    temp_project(name, &[
+        (".src/layers.toml", rules),
+        ("src/ui/page.ts", "import { orders } from '../services/orders';\nimport { db } from '../db/client';\n"),
+        ("src/services/orders.ts", "import { db } from '../db/client';\n"),
+        ("src/db/client.ts", "export const db = {};\n"),
+    ])
+}
+
+#[test]
+fn check_deps_reports_violations_and_exits_3() {
+    let rules = "forbid = [\"src/ui/** -> src/db/**\"]\n\n[[layer]]\nname = \"ui\"\npaths = [\"src/ui/**\"]\n";
+    let dir = layered_project("check_deps", rules);
+    let (stdout, _, code) = run_src(&["check-deps", "-d", &dir.to_string_lossy()]);
+    assert_eq!(code, 3);
+    assert!(stdout.contains("rules: .src/layers.toml\n"));
+    assert!(stdout.contains("- file: src/ui/page.ts\n  line: 2\n"));
+    assert!(stdout.contains("  target: src/db/client.ts\n  rule: src/ui/** -> src/db/**\n"));
+    assert!(stdout.contains("filesMatched: 1"));
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn check_deps_passes_clean_graph_and_fails_on_bad_rules() {
+    let rules = "[[layer]]\nname = \"ui\"\npaths = [\"src/ui/**\"]\n\n[[layer]]\nname = \"services\"\npaths = [\"src/services/**\"]\n\n[[layer]]\nname = \"db\"\npaths = [\"src/db/**\"]\n";
+    let dir = layered_project("check_deps_clean", rules);
+    let (stdout, _, code) = run_src(&["check-deps", "-d", &dir.to_string_lossy(), "--json"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains(r#""violations":[]"#));
+
+    std::fs::write(dir.join(".src/layers.toml"), "[[layer]]\nname = ui\n").unwrap();
+    let (stdout, _, code) = run_src(&["check-deps", "-d", &dir.to_string_lossy()]);
+    assert_eq!(code, 1);
+    assert!(stdout.contains("Invalid rules"));
+    assert!(stdout.contains("line 2"));
+    std::fs::remove_dir_all(&dir).ok();
+}
+
+#[test]
+fn check_deps_locates_multi_line_imports() {
+    let dir = temp_project("check_deps_multi", &[
+        (".src/layers.toml", "forbid = [\"src/ui/** -> src/db/**\"]\n"),
+        ("Cargo.toml", "[package]\nname = \"app\"\n"),
+        ("src/main.rs", "mod db;\nmod ui;\n"),
+        ("src/db/mod.rs", "pub mod client;\n"),
+        ("src/db/client.rs", "pub fn connect() {}\n"),
+        ("src/ui/mod.rs", "pub mod page;\n"),
+        ("src/ui/page.rs", "use std::fmt;\nuse crate::{\n    db::client::connect,\n};\n"),
+    ]);
+    let (stdout, _, code) = run_src(&["check-deps", "-d", &dir.to_string_lossy()]);
+    assert_eq!(code, 3);
+    assert!(
+        stdout.contains("- file: src/ui/page.rs\n  line: 2\n  import: \"use crate::{ db::client::connect, };\"\n  target: src/db/client.rs\n"),
+        "{}",
+        stdout
+    );
+    std::fs::remove_dir_all(&dir).ok();
+}
+
 // ── Alias resolution in graph mode ──
 
 fn alias_fixture() -> String {
@@ -1075,3 +1539,502 @@ fn graph_no_tsconfig_still_works() {
     assert_eq!(code, 0);
     assert!(stdout.contains("graph:"));
 }
+
+// ── Ignore Files ──
+
+fn temp_project(name: &str, files: &[(&str, &str)]) -> PathBuf {
+    let dir = std::env::temp_dir().join(format!("src_it_{}_{}", name, std::process::id()));
+    let _ = std::fs::remove_dir_all(&dir);
+    for (rel, content) in files {
+        let path = dir.join(rel);
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(&path, content).unwrap();
+    }
+    dir
+}
+
+fn ignore_project() -> PathBuf {
+    temp_project("ignore", &[
+        (".gitignore", "generated/\n*.log.ts\n!keep.log.ts\n"),
+        (".srcignore", "/scratch\n"),
+        ("src/app.ts", "export const a = 1;\n"),
+        ("src/debug.log.ts", "export const d = 1;\n"),
+        ("src/keep.log.ts", "export const k = 1;\n"),
+        ("src/nested/.ignore", "local.ts\n"),
+        ("src/nested/local.ts", "export const l = 1;\n"),
+        ("src/nested/shared.ts", "export const s = 1;\n"),
+        ("generated/api.ts", "export const g = 1;\n"),
+        ("scratch/notes.ts", "export const n = 1;\n"),
+    ])
+}
+
+#[test]
+fn gitignore_rules_hide_files() {
+    let dir = ignore_project();
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["-g", "*.ts"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("src/app.ts"));
+    assert!(stdout.contains("src/keep.log.ts"));
+    assert!(stdout.contains("src/nested/shared.ts"));
+    assert!(!stdout.contains("debug.log.ts"));
+    assert!(!stdout.contains("generated/api.ts"));
+    assert!(!stdout.contains("scratch/notes.ts"));
+    assert!(!stdout.contains("local.ts"));
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+#[test]
+fn gitignore_rules_apply_to_tree() {
+    let dir = ignore_project();
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &[]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("app.ts"));
+    assert!(!stdout.contains("generated"));
+    assert!(!stdout.contains("scratch"));
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+#[test]
+fn no_ignore_includes_ignored_files() {
+    let dir = ignore_project();
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["-g", "*.ts", "--no-ignore"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("generated/api.ts"));
+    assert!(stdout.contains("scratch/notes.ts"));
+    assert!(stdout.contains("src/debug.log.ts"));
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+#[test]
+fn exclude_accepts_glob_patterns() {
+    let dir = ignore_project();
+    let (stdout, _, code) = run_src_in(&dir.to_string_lossy(), &["-g", "*.ts", "--exclude", "src/nested/"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("src/app.ts"));
+    assert!(!stdout.contains("shared.ts"));
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+// ── Glob syntax ──
+
+#[test]
+fn glob_brace_alternation_with_path() {
+    let (stdout, _, code) = run_src_in(&fixture(), &["-g", "lib/*.{py,go}"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("lib/app.py"));
+    assert!(stdout.contains("lib/server.go"));
+    assert!(!stdout.contains("utils.ts"));
+}
+
+#[test]
+fn glob_negated_pattern_excludes_files() {
+    let (stdout, _, code) = run_src_in(&fixture(), &["-g", "*.ts", "!**/*.spec.ts"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("lib/utils.ts"));
+    assert!(!stdout.contains("utils.spec.ts"));
+}
+
+#[test]
+fn glob_double_star_matches_nested_paths() {
+    let (stdout, _, code) = run_src_in(&fixture(), &["-g", "src/**/*.rs"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("src/main.rs"));
+    assert!(stdout.contains("src/lang/mod.rs"));
+    assert!(!stdout.contains("documented.rs"));
+}
+
+#[test]
+fn glob_literal_bracket_path_still_matches() {
+    let (stdout, _, code) = run_src_in(&fixture(), &["-g", "pages/[slug].astro"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("[slug].astro"));
+}
+
+// ── On-disk index ──
+
+fn index_project(name: &str) -> PathBuf {
+    temp_project(name, &[
+        ("src/util.ts", "export function helper() {\n  return 1;\n}\n"),
+        ("src/app.ts", "import { helper } from './util';\n\nexport function run() {\n  return helper();\n}\n"),
+        ("lib/tool.py", "def tool():\n    pass\n"),
+    ])
+}
+
+fn run_index(dir: &str, command: &str) -> (String, String, i32) {
+    run_src(&["index", command, "-d", dir])
+}
+
+fn without_meta(output: &str) -> String {
+    output.lines().filter(|l| !l.contains("elapsedMs")).collect::<Vec<_>>().join("\n")
+}
+
+#[test]
+fn index_build_and_status() {
+    let dir = index_project("index_status");
+    let d = dir.to_string_lossy().into_owned();
+
+    let (stdout, _, code) = run_index(&d, "status");
+    assert_eq!(code, 0);
+    assert!(stdout.contains("state: missing"));
+
+    let (stdout, _, code) = run_index(&d, "build");
+    assert_eq!(code, 0);
+    assert!(stdout.contains("state: fresh"));
+    assert!(stdout.contains("files: 3"));
+    assert!(dir.join(".src/index").is_file());
+
+    let (stdout, _, _) = run_index(&d, "status");
+    assert!(stdout.contains("state: fresh"));
+
+    std::fs::write(dir.join("lib/extra.py"), "def extra():\n    pass\n").unwrap();
+    let (stdout, _, _) = run_index(&d, "status");
+    assert!(stdout.contains("state: stale"));
+    assert!(stdout.contains("added: 1"));
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+#[test]
+fn index_results_match_direct_scan() {
+    let dir = index_project("index_match");
+    let d = dir.to_string_lossy().into_owned();
+
+    let queries: &[&[&str]] = &[&["--graph"], &["-s"], &["--callers", "helper"]];
+    let direct: Vec<String> = queries.iter().map(|q| without_meta(&run_src_in(&d, q).0)).collect();
+
+    run_index(&d, "build");
+    for (query, expected) in queries.iter().zip(&direct) {
+        let (stdout, _, code) = run_src_in(&d, query);
+        assert_eq!(code, 0);
+        assert_eq!(&without_meta(&stdout), expected, "query {:?}", query);
+    }
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+#[test]
+fn index_refreshes_changed_files() {
+    let dir = index_project("index_refresh");
+    let d = dir.to_string_lossy().into_owned();
+    run_index(&d, "build");
+
+    std::fs::write(dir.join("lib/tool.py"), "def tool():\n    pass\n\ndef renamed_tool():\n    pass\n").unwrap();
+    let (stdout, _, code) = run_src_in(&d, &["-s", "-f", "renamed_tool"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("renamed_tool"));
+
+    let (stdout, _, _) = run_index(&d, "status");
+    assert!(stdout.contains("state: fresh"));
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+#[test]
+fn index_falls_back_when_unusable() {
+    let dir = index_project("index_unusable");
+    let d = dir.to_string_lossy().into_owned();
+    std::fs::create_dir_all(dir.join(".src")).unwrap();
+    std::fs::write(dir.join(".src/index"), "not an index\n").unwrap();
+
+    let (stdout, _, code) = run_src_in(&d, &["-s"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("helper"));
+
+    let (stdout, _, _) = run_index(&d, "status");
+    assert!(stdout.contains("state: unusable"));
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+#[test]
+fn index_clear_removes_directory() {
+    let dir = index_project("index_clear");
+    let d = dir.to_string_lossy().into_owned();
+    run_index(&d, "build");
+
+    let (stdout, _, code) = run_index(&d, "clear");
+    assert_eq!(code, 0);
+    assert!(stdout.contains("state: cleared"));
+    assert!(!dir.join(".src").exists());
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+// ── JSON-RPC server ──
+
+fn run_server(args: &[&str], requests: &[&str]) -> (Vec<String>, i32) {
+    use std::io::Write;
+    use std::process::Stdio;
+
+    let mut child = Command::new(binary_path())
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .expect("Failed to spawn server");
+    {
+        let stdin = child.stdin.as_mut().unwrap();
+        for request in requests {
+            writeln!(stdin, "{}", request).unwrap();
+        }
+    }
+    let output = child.wait_with_output().unwrap();
+    let stdout = String::from_utf8_lossy(&output.stdout).to_string();
+    (stdout.lines().map(str::to_owned).collect(), output.status.code().unwrap_or(-1))
+}
+
+#[test]
+fn serve_answers_requests_in_order() {
+    let fixture = fixture();
+    let (lines, code) = run_server(&["serve", "--stdio", "-d", &fixture], &[
+        r#"{"jsonrpc":"2.0","id":1,"method":"symbols","params":{"glob":"*.py"}}"#,
+        r#"{"jsonrpc":"2.0","id":2,"method":"search","params":{"pattern":"Application","context":0}}"#,
+        r#"{"jsonrpc":"2.0","id":3,"method":"graph"}"#,
+        r#"{"jsonrpc":"2.0","id":4,"method":"lines","params":{"specs":"lib/app.py:1:2"}}"#,
+    ]);
+    assert_eq!(code, 0);
+    assert_eq!(lines.len(), 4);
+    assert!(lines[0].starts_with(r#"{"jsonrpc":"2.0","id":1,"result":{"meta":"#));
+    assert!(lines[0].contains("\"symbols\":["));
+    assert!(lines[1].contains("lib/app.py"));
+    assert!(lines[2].contains("\"graph\":["));
+    assert!(lines[3].contains("\"startLine\":1"));
+}
+
+#[test]
+fn serve_reports_protocol_errors_and_keeps_running() {
+    let fixture = fixture();
+    let (lines, code) = run_server(&["serve", "--stdio", "-d", &fixture], &[
+        "not json",
+        r#"{"jsonrpc":"2.0","id":1,"method":"nope"}"#,
+        r#"{"jsonrpc":"2.0","id":2,"method":"search"}"#,
+        r#"{"jsonrpc":"2.0","method":"stats"}"#,
+        r#"{"jsonrpc":"2.0","id":3,"method":"stats"}"#,
+    ]);
+    assert_eq!(code, 0);
+    assert_eq!(lines.len(), 4);
+    assert!(lines[0].contains("-32700"));
+    assert!(lines[1].contains("-32601"));
+    assert!(lines[2].contains("-32602"));
+    assert!(lines[3].contains("\"id\":3") && lines[3].contains("\"languages\""));
+}
+
+#[test]
+fn serve_stops_on_shutdown() {
+    let fixture = fixture();
+    let (lines, code) = run_server(&["serve", "--stdio", "-d", &fixture], &[
+        r#"{"jsonrpc":"2.0","id":1,"method":"shutdown"}"#,
+        r#"{"jsonrpc":"2.0","id":2,"method":"stats"}"#,
+    ]);
+    assert_eq!(code, 0);
+    assert_eq!(lines, vec![r#"{"jsonrpc":"2.0","id":1,"result":null}"#]);
+}
+
+#[test]
+fn serve_sees_file_changes_between_requests() {
+    use std::io::{BufRead, BufReader, Write};
+    use std::process::Stdio;
+
+    let dir = index_project("serve_changes");
+    let mut child = Command::new(binary_path())
+        .args(["serve", "--stdio", "-d", &dir.to_string_lossy()])
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .spawn()
+        .unwrap();
+    let mut stdin = child.stdin.take().unwrap();
+    let mut stdout = BufReader::new(child.stdout.take().unwrap());
+    let mut ask = |request: &str| {
+        writeln!(stdin, "{}", request).unwrap();
+        let mut line = String::new();
+        stdout.read_line(&mut line).unwrap();
+        line
+    };
+
+    let before = ask(r#"{"jsonrpc":"2.0","id":1,"method":"symbols","params":{"glob":"*.py"}}"#);
+    assert!(!before.contains("added_later"));
+    std::fs::write(dir.join("lib/tool.py"), "def tool():\n    pass\n\ndef added_later():\n    pass\n").unwrap();
+    let after = ask(r#"{"jsonrpc":"2.0","id":2,"method":"symbols","params":{"glob":"*.py"}}"#);
+    assert!(after.contains("added_later"));
+
+    drop(stdin);
+    child.wait().unwrap();
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+// ── MCP Server ──
+
+#[test]
+fn mcp_lists_and_calls_tools() {
+    let fixture = fixture();
+    let (lines, code) = run_server(&["mcp", "-d", &fixture], &[
+        r#"{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"0"}}}"#,
+        r#"{"jsonrpc":"2.0","method":"notifications/initialized"}"#,
+        r#"{"jsonrpc":"2.0","id":2,"method":"tools/list"}"#,
+        r#"{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"find","arguments":{"pattern":"Application","context":0}}}"#,
+    ]);
+    assert_eq!(code, 0);
+    assert_eq!(lines.len(), 3);
+    assert!(lines[0].contains(r#""protocolVersion":"2025-03-26""#));
+    for tool in ["tree", "find", "lines", "symbols", "callers", "graph", "stats"] {
+        assert!(lines[1].contains(&format!(r#""name":"{}""#, tool)), "missing tool {}", tool);
+    }
+    assert!(lines[1].contains(r#""inputSchema":{"type":"object""#));
+    assert!(lines[2].contains(r#""isError":false"#));
+    assert!(lines[2].contains("lib/app.py"));
+}
+
+#[test]
+fn mcp_confines_tools_to_root() {
+    let fixture = fixture_dir().join("lib").to_string_lossy().into_owned();
+    let (lines, code) = run_server(&["mcp", "-d", &fixture], &[
+        r#"{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"tree","arguments":{"dir":".."}}}"#,
+        r#"{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"lines","arguments":{"specs":["../../../Cargo.toml:1:3"]}}}"#,
+        r#"{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"lines","arguments":{"specs":["app.py:1:1"]}}}"#,
+    ]);
+    assert_eq!(code, 0);
+    assert_eq!(lines.len(), 3);
+    assert!(lines[0].contains(r#""isError":true"#) && lines[0].contains("outside the project root"));
+    assert!(lines[1].contains(r#""isError":true"#) && !lines[1].contains("[package]"));
+    assert!(lines[2].contains(r#""isError":false"#));
+}
+
+// ── Watch Mode ──
+
+#[test]
+fn watch_reemits_after_change() {
+    use std::io::{BufRead, BufReader};
+    use std::process::Stdio;
+
+    let dir = temp_project("watch", &[
+        ("src/app.py", "def run():\n    pass\n"),
+        ("node_modules/dep/index.js", "module.exports = 1;\n"),
+    ]);
+    let mut child = Command::new(binary_path())
+        .args(["--symbols", "--watch", "--json", "-d", &dir.to_string_lossy()])
+        .stdout(Stdio::piped())
+        .spawn()
+        .unwrap();
+    let mut stdout = BufReader::new(child.stdout.take().unwrap());
+    let mut next = || {
+        let mut line = String::new();
+        stdout.read_line(&mut line).unwrap();
+        line
+    };
+
+    let first = next();
+    assert!(first.contains("\"run\"") && !first.contains("added_later"));
+
+    std::thread::sleep(std::time::Duration::from_millis(200));
+    std::fs::write(dir.join("src/app.py"), "def run():\n    pass\n\ndef added_later():\n    pass\n").unwrap();
+    let second = next();
+    assert!(second.contains("added_later"));
+
+    child.kill().unwrap();
+    child.wait().unwrap();
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+#[test]
+fn watch_writes_every_run_to_output_file() {
+    use std::process::Stdio;
+    use std::time::Duration;
+
+    let dir = temp_project("watch_output", &[("src/app.py", "def run():\n    pass\n")]);
+    let out = dir.join("symbols.ndjson");
+    let mut child = Command::new(binary_path())
+        .args(["--symbols", "--watch", "-F", "ndjson", "-d", &dir.to_string_lossy(), "-o", &out.to_string_lossy()])
+        .stdout(Stdio::null())
+        .spawn()
+        .unwrap();
+    let wait_for = |needle: &str| {
+        (0..50).any(|_| {
+            std::thread::sleep(Duration::from_millis(100));
+            std::fs::read_to_string(&out).unwrap_or_default().contains(needle)
+        })
+    };
+
+    let first = wait_for(r#""name":"run""#);
+    std::fs::write(dir.join("src/app.py"), "def run():\n    pass\n\ndef added_later():\n    pass\n").unwrap();
+    let second = wait_for("added_later");
+    // Writing the output file inside the root must not trigger more runs.
+    std::thread::sleep(Duration::from_millis(800));
+    child.kill().unwrap();
+    child.wait().unwrap();
+
+    let text = std::fs::read_to_string(&out).unwrap_or_default();
+    assert!(first && second, "{}", text);
+    assert_eq!(text.lines().filter(|l| l.starts_with(r#"{"meta""#)).count(), 2, "{}", text);
+    let _ = std::fs::remove_dir_all(&dir);
+}
+
+// ── NDJSON Output ──
+
+fn ndjson_lines(args: &[&str]) -> Vec<String> {
+    let (stdout, _, code) = run_src_in(&fixture(), args);
+    assert_eq!(code, 0);
+    stdout.lines().map(str::to_owned).collect()
+}
+
+#[test]
+fn ndjson_search_streams_files_then_meta() {
+    let lines = ndjson_lines(&["-f", "Application", "-C", "0", "--format", "ndjson"]);
+    assert!(lines.len() >= 2);
+    let (last, files) = lines.split_last().unwrap();
+    assert!(files.iter().all(|l| l.starts_with(r#"{"path":"#)));
+    assert!(files.iter().any(|l| l.contains("lib/app.py")));
+    assert!(last.starts_with(r#"{"meta":{"elapsedMs":"#));
+    assert!(last.contains(r#""timeout":false"#) && last.contains(r#""filesScanned":"#));
+}
+
+#[test]
+fn ndjson_symbols_graph_and_callers_stream_records() {
+    let symbols = ndjson_lines(&["-s", "-g", "*.py", "-F", "ndjson"]);
+    assert!(symbols[..symbols.len() - 1].iter().all(|l| l.contains(r#""symbols":["#)));
+
+    let graph = ndjson_lines(&["--graph", "-F", "ndjson"]);
+    assert!(graph[..graph.len() - 1].iter().all(|l| l.starts_with(r#"{"file":"#)));
+
+    let callers = ndjson_lines(&["--callers", "create_app", "-F", "ndjson"]);
+    assert!(callers.iter().any(|l| l.starts_with(r#"{"declaration":{"path":"lib/app.py""#)));
+    assert!(callers.last().unwrap().contains(r#""totalMatches":"#));
+}
+
+#[test]
+fn ndjson_respects_limit_and_whole_result_modes() {
+    let limited = ndjson_lines(&["-f", "import", "-F", "ndjson", "-L", "1"]);
+    assert_eq!(limited.len(), 2);
+
+    let stats = ndjson_lines(&["--stats", "-F", "ndjson"]);
+    assert_eq!(stats.len(), 2);
+    assert!(stats[0].starts_with(r#"{"languages":["#));
+}
+
+#[test]
+fn max_tokens_truncates_search_and_reports_dropped() {
+    let (stdout, _, code) = run_src_in(&fixture(), &["-f", "Application", "-C", "0", "--max-tokens", "30", "--json"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains(r#""truncated":true"#));
+    assert!(stdout.contains(r#""maxTokens":30"#));
+    assert!(stdout.contains(r#""dropped":["#) || stdout.contains(r#""partial":["#));
+
+    let (stdout, _, code) = run_src_in(&fixture(), &["-f", "Application", "--max-tokens", "100000"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("truncated: false"));
+    assert!(!stdout.contains("dropped:"));
+}
+
+#[test]
+fn max_tokens_on_symbols_and_rejected_for_graph() {
+    let (stdout, _, code) = run_src_in(&fixture(), &["-s", "--compact", "--max-tokens", "40"]);
+    assert_eq!(code, 0);
+    assert!(stdout.contains("truncated: true"));
+    assert!(stdout.contains("estimatedTokens:"));
+
+    let (_, stderr, code) = run_src_in(&fixture(), &["--graph", "--max-tokens", "40"]);
+    assert_ne!(code, 0);
+    assert!(stderr.contains("--max-tokens requires"));
+}
+
+#[test]
+fn max_tokens_with_missing_tokenizer_fails() {
+    let (stdout, _, code) = run_src_in(&fixture(), &["-f", "fn", "--max-tokens", "40", "--tokenizer", "no-such.tiktoken"]);
+    assert_ne!(code, 0);
+    assert!(stdout.contains("Failed to read tokenizer vocabulary no-such.tiktoken"));
+}